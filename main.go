@@ -3,9 +3,13 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"math"
 	"math/big"
 	"math/rand"
 	"time"
@@ -18,24 +22,59 @@ import (
 // in a [0,1)x[0,1) square.
 // InCircle (only output) is the number of the randomly picked points that
 // where inside the circle of radius 1 cented in (0,0).
+// Seed (input) seeds the random number generator picking the points, so
+// that computing the same data again gives the same result. If it is zero,
+// piProcessor derives it from its seed and the job's ID, and Compute from the clock.
+// Offset (input) is the number of points of the seed's streams to skip before
+// picking Total ones, which is how the shards of a job pick its points.
 type piComputeData struct {
 	InCircle uint64 `json:"i"`
 	Total    uint64 `json:"t"`
+	Seed     int64  `json:"s,omitempty"`
+	Offset   uint64 `json:"o,omitempty"`
 }
 
-// piProcessor is a processor that can work out pi processing jobs
-type piProcessor struct{}
+// pointsPerStream is the number of points picked from each random stream:
+// the points of a job are picked from streams each seeded from its Seed and
+// their index, so that shards starting at any Offset can pick their points
+// without going through all the ones before, by skipping at most as many.
+const pointsPerStream = 1 << 16
+
+// piProcessor is a processor that can work out pi processing jobs.
+// If pointsPerSecond is not zero, it is the rate at which the processor
+// expects to pick points, and it is used to fit jobs to the budget
+// of contexts with a deadline. seed is the base seed from which the
+// seeds of the jobs without one are derived.
+type piProcessor struct {
+	pointsPerSecond uint64
+	seed            int64
+}
 
 // Process processes a pi processing job. To do so, it extracts piComputeData from
 // the given job, computes it and stores it back into the job. It returns an error
 // if any of the three operations fail.
+// When the processor knows its pointsPerSecond rate and the context has a deadline,
+// the job's Total is capped to the points that can be picked before that deadline.
+// Jobs without a Seed get one derived from the processor's seed and their ID, so that
+// every job picks an independent stream of points, which is stored with the result.
 func (pp piProcessor) Process(ctx context.Context, j queue.JobProcessingAccess) error {
 	pcd := &piComputeData{}
 	err := j.GetData(pcd)
 	if err != nil {
 		return err
 	}
-	err = pcd.Compute(ctx)
+	if pcd.Seed == 0 {
+		pcd.Seed = jobSeed(pp.seed, j.ID())
+	}
+	if budget, ok := queue.Budget(ctx); ok && pp.pointsPerSecond > 0 {
+		maxPoints := uint64(budget.Seconds() * float64(pp.pointsPerSecond))
+		if pcd.Total > maxPoints {
+			pcd.Total = maxPoints
+		}
+	}
+	err = pcd.Compute(ctx, func(picked uint64) {
+		j.SetProgress(ctx, picked, pcd.Total) // Progress is informative, so failing to report it is fine
+	})
 	if err != nil {
 		return err
 	}
@@ -50,9 +89,34 @@ func (pp piProcessor) Process(ctx context.Context, j queue.JobProcessingAccess)
 // and checks for each of one if they are inside the circle of radius 1 cented in (0,0).
 // Specifically, given a (x,y) point, it checks whether x²+y² <= 1.
 // It updates InCircle with the number of points that were inside.
-func (pcd *piComputeData) Compute(ctx context.Context) error {
-	r := rand.New(rand.NewSource(time.Now().UTC().UnixNano()))
+// Every ctxCheckInterval points it checks whether ctx is done, and in that case
+// it returns ctx's error, leaving in InCircle the count of the points picked so far.
+// It also reports then the number of points picked so far to progress, if not nil.
+// The points are picked from the streams of pointsPerStream points seeded from
+// Seed, unless it is zero: then it is taken from the clock. They start at Offset.
+func (pcd *piComputeData) Compute(ctx context.Context, progress func(picked uint64)) error {
+	const ctxCheckInterval = 4096
+	seed := pcd.Seed
+	if seed == 0 {
+		seed = time.Now().UTC().UnixNano()
+	}
+	var r *rand.Rand
 	for i := uint64(0); i < pcd.Total; i++ {
+		if point := pcd.Offset + i; r == nil || point%pointsPerStream == 0 {
+			r = rand.New(rand.NewSource(streamSeed(seed, point/pointsPerStream)))
+			for skipped := point % pointsPerStream; skipped > 0; skipped-- {
+				r.Float64()
+				r.Float64()
+			}
+		}
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(i)
+			}
+		}
 		x, y := r.Float64(), r.Float64()
 		if (x*x)+(y*y) <= 1 {
 			pcd.InCircle++
@@ -61,6 +125,66 @@ func (pcd *piComputeData) Compute(ctx context.Context) error {
 	return nil
 }
 
+// jobSeed derives the seed of a job from a base seed and its ID, hashing
+// them with FNV-1a. Unlike seeds taken from the clock, which can be close
+// or even equal for jobs started at once, hashes of different IDs are
+// unrelated, so the jobs get independent streams. It is never zero.
+func jobSeed(base int64, id string) int64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, base)
+	h.Write([]byte(id))
+	if seed := int64(h.Sum64()); seed != 0 {
+		return seed
+	}
+	return 1
+}
+
+// streamSeed derives the seed of the given stream of the points
+// of a job from the job's seed, hashing them with FNV-1a.
+func streamSeed(seed int64, stream uint64) int64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.LittleEndian, seed)
+	binary.Write(h, binary.LittleEndian, stream)
+	return int64(h.Sum64())
+}
+
+// splitPi splits a pi processing job, as given to Client.Shard, into n
+// shards picking its Total points in turn, a whole number of streams each
+// but for the last one, so that the shards have the same work to do. With
+// the same Seed, they pick the very same points as the job would, so that
+// merged by mergePi they give the same result, unless Seed is zero: then
+// each shard gets its own one, as any job does.
+func splitPi(data queue.MarshalUnmarshaler, n int) []queue.MarshalUnmarshaler {
+	pcd := data.(*piComputeData)
+	streams := (pcd.Total + pointsPerStream - 1) / pointsPerStream
+	end := pcd.Offset + pcd.Total
+	parts := make([]queue.MarshalUnmarshaler, n)
+	offset := pcd.Offset
+	for i := range parts {
+		share := streams / uint64(n)
+		if uint64(i) < streams%uint64(n) {
+			share++
+		}
+		next := min(offset+share*pointsPerStream, end)
+		parts[i] = &piComputeData{Total: next - offset, Seed: pcd.Seed, Offset: offset}
+		offset = next
+	}
+	return parts
+}
+
+// mergePi merges the results of the shards of a pi processing job, as
+// split by splitPi, into the result of the job: it sums up their points.
+func mergePi(parts []queue.MarshalUnmarshaler) queue.MarshalUnmarshaler {
+	first := parts[0].(*piComputeData)
+	merged := &piComputeData{Seed: first.Seed, Offset: first.Offset}
+	for _, part := range parts {
+		pcd := part.(*piComputeData)
+		merged.InCircle += pcd.InCircle
+		merged.Total += pcd.Total
+	}
+	return merged
+}
+
 func (pcd *piComputeData) String() string {
 	return fmt.Sprintf("%d/%d", pcd.InCircle, pcd.Total)
 }
@@ -82,18 +206,80 @@ func (pcd *piComputeData) Unmarshal(b []byte) error {
 	return json.NewDecoder(bytes.NewReader(b)).Decode(pcd)
 }
 
+// piAggregation aggregates the results of pi processing jobs into an approximation
+// of pi: the mean of the approximations of the aggregated jobs. Jobs that picked no
+// points at all (Total == 0) carry no information about pi, so they are a neutral
+// element: adding them changes nothing.
+type piAggregation struct {
+	sum    big.Rat
+	jobs   int64
+	points uint64
+}
+
+// Add aggregates the result of a pi processing job
+func (pa *piAggregation) Add(pcd piComputeData) {
+	if pcd.Total == 0 {
+		return // big.NewRat would panic on a 0 denominator
+	}
+	pa.jobs++
+	pa.points += pcd.Total
+	pa.sum.Add(&pa.sum, big.NewRat(4*int64(pcd.InCircle), int64(pcd.Total)))
+}
+
+// Result returns the approximation of pi given by the aggregated jobs,
+// or false if none of them picked any point.
+func (pa *piAggregation) Result() (*big.Rat, bool) {
+	if pa.jobs == 0 {
+		return nil, false
+	}
+	return new(big.Rat).Mul(&pa.sum, big.NewRat(1, pa.jobs)), true
+}
+
+// piWithError takes an approximation of pi obtained from a total number of
+// randomly picked points and returns it as a float together with its standard
+// error. The fraction p of points inside the circle follows a binomial
+// distribution, so the standard error of 4p is 4·sqrt(p(1-p)/totalPoints),
+// which shrinks as more points are picked.
+func piWithError(approximation *big.Rat, totalPoints uint64) (pi, stdErr float64) {
+	pi, _ = approximation.Float64()
+	if totalPoints == 0 {
+		return pi, math.Inf(1)
+	}
+	p := pi / 4
+	return pi, 4 * math.Sqrt(p*(1-p)/float64(totalPoints))
+}
+
+// formatPi formats an approximation of pi and its standard
+// error with the given number of decimal digits.
+func formatPi(pi, stdErr float64, digits int) string {
+	return fmt.Sprintf("π ≈ %.*f ± %.*f", digits, pi, digits, stdErr)
+}
+
 // main pushes numberOfJobs pi processing jobs (each computing a million points),
 // starts 10 workers, waits for all the jobs to be processed and then aggregates
-// the results of the jobs to approximate pi. Finally, it prints the approximation
-// and exits orderly.
+// the results of the jobs to approximate pi. Finally, it prints the approximation,
+// with as many decimal digits as given by the -digits flag, and exits orderly.
+// The jobs are seeded from the -seed flag, or from the clock if it is zero; the
+// seed is printed so that the run can be reproduced.
 func main() {
 	const numberOfJobs = 10000
+	digits := flag.Int("digits", 4, "number of decimal digits of the printed result")
+	seed := flag.Int64("seed", 0, "seed of the random points, or 0 to seed them from the clock")
+	flag.Parse()
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	log.Printf("Seeding the jobs with %d", *seed)
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	defer cancelCtx()
-	client, worker := queue.New(piProcessor{})
+	client, worker := queue.New(piProcessor{seed: *seed})
 	log.Printf("Pushing %d pi processing jobs...", numberOfJobs)
-	for i := 0; i < numberOfJobs; i++ {
-		client.CreateJob(ctx, fmt.Sprintf("j-%d", i), &piComputeData{Total: 1000000})
+	jobs := make([]queue.JobSpec, numberOfJobs)
+	for i := range jobs {
+		jobs[i] = queue.JobSpec{ID: fmt.Sprintf("j-%d", i), Data: &piComputeData{Total: 1000000}}
+	}
+	if err := client.CreateJobs(ctx, jobs); err != nil {
+		log.Fatal(err)
 	}
 	log.Print("Starting 10 workers...")
 	workerStopped := make(chan struct{})
@@ -102,36 +288,35 @@ func main() {
 		close(workerStopped)
 	}()
 	log.Print("Waiting for results and aggregating them...")
-	result := &big.Rat{}
-	for i := 0; i < numberOfJobs; i++ {
-		jobID := fmt.Sprintf("j-%d", i)
-		for {
-			job, err := client.GetJob(ctx, jobID)
-			if err != nil {
-				log.Fatal(err)
-			}
-			if job == nil {
-				log.Fatalf("Job %q could not be found", jobID)
-			}
-			state := job.State()
-			if state == queue.Failed {
-				log.Fatal(job.Error())
-			}
-			if state == queue.Finished {
-				var partialResult piComputeData
-				err = job.GetData(&partialResult)
-				if err != nil {
-					log.Fatal(err)
-				}
-				result = result.Add(result, big.NewRat(4*int64(partialResult.InCircle), int64(partialResult.Total)))
-				break
-			}
-			time.Sleep(5 * time.Second) // Wait a bit for the job to finish
+	ids := make([]string, len(jobs))
+	for i, spec := range jobs {
+		ids[i] = spec.ID
+	}
+	results, err := client.WaitForJobs(ctx, ids, queue.WithFailFast())
+	if err != nil {
+		log.Fatal(err)
+	}
+	var aggregation piAggregation
+	for _, id := range ids {
+		var partialResult piComputeData
+		if err := results[id].GetData(&partialResult); err != nil {
+			log.Fatal(err)
 		}
+		aggregation.Add(partialResult)
+	}
+	drainCtx, cancelDrain := context.WithTimeout(ctx, 10*time.Second)
+	err = worker.Drain(drainCtx)
+	cancelDrain()
+	if err != nil {
+		log.Printf("Workers did not stop in time: %v", err)
 	}
 	cancelCtx()
-	result = result.Mul(result, big.NewRat(1, numberOfJobs))
-	log.Printf("Result is %+v = %s", result, result.FloatString(20))
+	result, ok := aggregation.Result()
+	if !ok {
+		log.Fatal("No job picked any point, so pi cannot be approximated")
+	}
+	pi, stdErr := piWithError(result, aggregation.points)
+	log.Printf("Result is %+v, that is %s", result, formatPi(pi, stdErr, *digits))
 	log.Printf("Preparing to exit...")
 	<-workerStopped
 	log.Printf("Exiting")
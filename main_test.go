@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestPiAggregationIgnoresZeroTotalJobs(t *testing.T) {
+	var pa piAggregation
+	pa.Add(piComputeData{InCircle: 3, Total: 4})
+	pa.Add(piComputeData{InCircle: 0, Total: 0})
+	pa.Add(piComputeData{InCircle: 1, Total: 2})
+	result, ok := pa.Result()
+	if !ok {
+		t.Fatal("got no result from jobs with points")
+	}
+	// Mean of 4·3/4 and 4·1/2, without the empty job
+	if want := big.NewRat(5, 2); result.Cmp(want) != 0 {
+		t.Errorf("got %v, want %v", result, want)
+	}
+	if pa.points != 6 {
+		t.Errorf("got %d aggregated points, want 6", pa.points)
+	}
+}
+
+func TestPiAggregationWithoutPoints(t *testing.T) {
+	var pa piAggregation
+	pa.Add(piComputeData{Total: 0})
+	if result, ok := pa.Result(); ok {
+		t.Errorf("got result %v from jobs without points", result)
+	}
+}
+
+func TestPiWithErrorShrinksWithPoints(t *testing.T) {
+	approximation := big.NewRat(314159, 100000)
+	previous := math.Inf(1)
+	for _, points := range []uint64{100, 10000, 1000000, 100000000} {
+		pi, stdErr := piWithError(approximation, points)
+		if pi != 3.14159 {
+			t.Errorf("got pi %v, want 3.14159", pi)
+		}
+		if stdErr <= 0 || stdErr >= previous {
+			t.Errorf("got error bound %v with %d points, want it in (0, %v)", stdErr, points, previous)
+		}
+		previous = stdErr
+	}
+}
+
+func TestPiWithErrorWithoutPoints(t *testing.T) {
+	if _, stdErr := piWithError(big.NewRat(3, 1), 0); !math.IsInf(stdErr, 1) {
+		t.Errorf("got error bound %v without points, want +Inf", stdErr)
+	}
+}
+
+func TestFormatPi(t *testing.T) {
+	if got, want := formatPi(3.14159, 0.00031, 4), "π ≈ 3.1416 ± 0.0003"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestComputeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Far more points than could be picked before the test times out
+	pcd := &piComputeData{Total: 1 << 50}
+	var picked uint64
+	err := pcd.Compute(ctx, func(p uint64) {
+		picked = p
+		if p > 0 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	// Compute stops at the check following the progress report that canceled ctx
+	if pcd.InCircle == 0 || pcd.InCircle > 2*picked {
+		t.Errorf("got %d points in circle out of %d picked, want the partial count", pcd.InCircle, 2*picked)
+	}
+}
+
+func TestComputeReportsProgress(t *testing.T) {
+	pcd := &piComputeData{Total: 10000}
+	var reported []uint64
+	if err := pcd.Compute(context.Background(), func(p uint64) { reported = append(reported, p) }); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{0, 4096, 8192}; !reflect.DeepEqual(reported, want) {
+		t.Errorf("got progress %v, want %v", reported, want)
+	}
+	if pcd.InCircle == 0 || pcd.InCircle > pcd.Total {
+		t.Errorf("got %d points in circle out of %d", pcd.InCircle, pcd.Total)
+	}
+}
+
+func TestComputeWithSameSeed(t *testing.T) {
+	first := piComputeData{Total: 10000, Seed: 42}
+	second := first
+	if err := first.Compute(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Compute(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if first.InCircle != second.InCircle {
+		t.Errorf("got %d and %d points in the circle with the same seed, want the same", first.InCircle, second.InCircle)
+	}
+}
+
+func TestProcessSeedsFromJobID(t *testing.T) {
+	var results []piComputeData
+	for i := 0; i < 2; i++ {
+		c, w := queue.New(piProcessor{})
+		ctx := context.Background()
+		if err := c.CreateJob(ctx, "j-1", &piComputeData{Total: 10000}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.(queue.SyncWorker).ProcessAll(ctx); err != nil {
+			t.Fatal(err)
+		}
+		j, err := c.GetJob(ctx, "j-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pcd piComputeData
+		if err := j.GetData(&pcd); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, pcd)
+	}
+	if results[0] != results[1] {
+		t.Errorf("got results %+v and %+v for jobs with the same ID, want the same", results[0], results[1])
+	}
+	if results[0].Seed == 0 {
+		t.Error("got no seed stored with the result")
+	}
+}
+
+func TestJobSeedsDiffer(t *testing.T) {
+	seeds := map[int64]string{}
+	for _, base := range []int64{1, 2} {
+		for _, id := range []string{"j-1", "j-2", "j-10"} {
+			seed := jobSeed(base, id)
+			if other, ok := seeds[seed]; ok {
+				t.Errorf("got seed %d for job %q with base %d, and for %s", seed, id, base, other)
+			}
+			seeds[seed] = fmt.Sprintf("job %q with base %d", id, base)
+		}
+	}
+	a := rand.New(rand.NewSource(jobSeed(1, "j-1")))
+	b := rand.New(rand.NewSource(jobSeed(1, "j-2")))
+	same := 0
+	for i := 0; i < 100; i++ {
+		if a.Float64() == b.Float64() {
+			same++
+		}
+	}
+	if same > 0 {
+		t.Errorf("got %d of 100 values equal in the streams of two jobs, want none", same)
+	}
+}
+
+func TestSeededJobsConverge(t *testing.T) {
+	const jobs, points = 200, 5000
+	pp := piProcessor{seed: 7}
+	var pa piAggregation
+	for i := 0; i < jobs; i++ {
+		pcd := piComputeData{Total: points, Seed: jobSeed(pp.seed, fmt.Sprintf("j-%d", i))}
+		if err := pcd.Compute(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+		pa.Add(pcd)
+	}
+	result, _ := pa.Result()
+	pi, stdErr := piWithError(result, pa.points)
+	// The seeds are fixed, so this is deterministic; 4 standard errors
+	// would only be exceeded by chance once in about 16000 seeds.
+	if math.Abs(pi-math.Pi) > 4*stdErr {
+		t.Errorf("got pi ≈ %v ± %v from seeded jobs, want it within 4 standard errors of %v", pi, stdErr, math.Pi)
+	}
+}
+
+func TestComputeFromOffset(t *testing.T) {
+	whole := piComputeData{Total: 3*pointsPerStream + 100, Seed: 42}
+	if err := whole.Compute(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The parts start within streams and at their start, and span them
+	bounds := []uint64{0, 1000, pointsPerStream, 2*pointsPerStream + 7, whole.Total}
+	var inCircle uint64
+	for i := 1; i < len(bounds); i++ {
+		part := piComputeData{Total: bounds[i] - bounds[i-1], Seed: 42, Offset: bounds[i-1]}
+		if err := part.Compute(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+		inCircle += part.InCircle
+	}
+	if inCircle != whole.InCircle {
+		t.Errorf("got %d points in the circle from offsets, want %d as picked at once", inCircle, whole.InCircle)
+	}
+}
+
+func TestShardedPiMatchesUnsharded(t *testing.T) {
+	c, w := queue.New(piProcessor{seed: 7})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx, 4)
+
+	data := piComputeData{Total: 5*pointsPerStream + 123, Seed: 42}
+	if err := c.CreateJob(ctx, "whole", &data); err != nil {
+		t.Fatal(err)
+	}
+	j, err := c.WaitForJob(ctx, "whole")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var whole piComputeData
+	if err := j.GetData(&whole); err != nil {
+		t.Fatal(err)
+	}
+	j, err = c.Shard(ctx, "sharded", 4, &data, splitPi, mergePi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sharded piComputeData
+	if err := j.GetData(&sharded); err != nil {
+		t.Fatal(err)
+	}
+	if sharded != whole {
+		t.Errorf("got %+v from 4 shards, want %+v as computed at once", sharded, whole)
+	}
+}
@@ -0,0 +1,54 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// WithIDPrefix makes CreateJobAuto prepend prefix to the ID it generates
+// for the job, so that the jobs of a producer giving its own prefix can be
+// told from the others when debugging. The prefix is not a namespace: the
+// IDs are unique across prefixes, as the rest of them is random. Other
+// ways of creating jobs ignore it, as they are given the ID.
+func WithIDPrefix(prefix string) JobOption {
+	return func(o *jobOptions) {
+		o.idPrefix = prefix
+	}
+}
+
+// CreateJobAuto creates a job in the default queue like CreateJob,
+// with an ID it generates, given a prefix by WithIDPrefix, if any, and
+// returns it. The rest of the ID is 16 random bytes in hexadecimal, so
+// that producers need no coordination to generate unique IDs, and one
+// taken already, however unlikely, is generated again.
+func (c *client) CreateJobAuto(ctx context.Context, initialData MarshalUnmarshaler, opts ...JobOption) (string, error) {
+	var o jobOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	for {
+		id, err := newJobID(o.idPrefix)
+		if err != nil {
+			return "", err
+		}
+		err = c.create(ctx, DefaultQueue, id, initialData, time.Time{}, opts, true)
+		switch {
+		case err == nil:
+			return id, nil
+		case !errors.Is(err, ErrDuplicateJob):
+			return "", err
+		}
+	}
+}
+
+// newJobID returns a random job ID with the given prefix
+func newJobID(prefix string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
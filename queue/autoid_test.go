@@ -0,0 +1,82 @@
+package queue_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestCreateJobAutoWithIDPrefix(t *testing.T) {
+	c, w := queue.New(doubler)
+	id, err := c.CreateJobAuto(context.Background(), &intData{N: 2}, queue.WithIDPrefix("billing-"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(id, "billing-") || id == "billing-" {
+		t.Errorf("got ID %q, want it generated after the prefix %q", id, "billing-")
+	}
+	processAll(t, w)
+	var got intData
+	if err := waitForJob(t, c, id).GetData(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.N != 4 {
+		t.Errorf("got %d, want %d", got.N, 4)
+	}
+}
+
+func TestCreateJobAutoWithoutPrefix(t *testing.T) {
+	c, _ := queue.New(doubler)
+	id, err := c.CreateJobAuto(context.Background(), &intData{N: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j, err := c.GetJob(context.Background(), id); err != nil || j == nil {
+		t.Fatalf("got job %v and error %v, want the job with ID %q", j, err, id)
+	}
+	if strings.Trim(id, "0123456789abcdef") != "" || len(id) != 32 {
+		t.Errorf("got ID %q, want 16 random bytes in hexadecimal", id)
+	}
+}
+
+func TestCreateJobAutoUniqueUnderConcurrency(t *testing.T) {
+	const producers, jobsEach = 3, 50
+	c, _ := queue.New(doubler)
+	prefixes := []string{"a-", "b-", ""}
+	ids := make([][]string, producers)
+	var wg sync.WaitGroup
+	for i := range ids {
+		ids[i] = make([]string, jobsEach)
+		for j := range ids[i] {
+			wg.Add(1)
+			go func(i, j int) {
+				defer wg.Done()
+				id, err := c.CreateJobAuto(context.Background(), &intData{N: j}, queue.WithIDPrefix(prefixes[i]))
+				if err != nil {
+					t.Error(err)
+				}
+				ids[i][j] = id
+			}(i, j)
+		}
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, produced := range ids {
+		for _, id := range produced {
+			if !strings.HasPrefix(id, prefixes[i]) {
+				t.Errorf("got ID %q from producer %d, want it prefixed with %q", id, i, prefixes[i])
+			}
+			if seen[id] {
+				t.Errorf("got ID %q twice", id)
+			}
+			seen[id] = true
+		}
+	}
+	if got := stats(t, c).Jobs[queue.Queued]; got != producers*jobsEach {
+		t.Errorf("got %d jobs Queued, want %d", got, producers*jobsEach)
+	}
+}
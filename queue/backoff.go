@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ConstantBackoff returns a RetryPolicy BackoffFor waiting d before every retry
+func ConstantBackoff(d time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		return d
+	}
+}
+
+// ExponentialBackoff returns a RetryPolicy BackoffFor waiting base after
+// the first attempt, and twice as long after each of the following ones,
+// but never longer than max.
+func ExponentialBackoff(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		if attempt < 1 {
+			attempt = 1
+		}
+		shift := uint(attempt - 1)
+		if shift >= 63 || base > max>>shift {
+			return max
+		}
+		return base << shift
+	}
+}
+
+// ExponentialBackoffWithJitter is like ExponentialBackoff, but with full
+// jitter: it waits a random time between zero and what ExponentialBackoff
+// would wait, so that many jobs failing at once are not all queued again
+// at once. See FullJitter.
+func ExponentialBackoffWithJitter(base, max time.Duration) func(attempt int) time.Duration {
+	return FullJitter(ExponentialBackoff(base, max), rand.Int63n)
+}
+
+// FullJitter returns a RetryPolicy BackoffFor waiting a random time between
+// zero and what backoff waits, both included. The random time is taken from
+// int63n, which must return a number in [0, n) like rand.Int63n, so that it
+// can be made deterministic. It is called from the goroutines of the workers,
+// so it must be safe for concurrent use if there are several of them.
+func FullJitter(backoff func(attempt int) time.Duration, int63n func(n int64) int64) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		max := backoff(attempt)
+		if max <= 0 {
+			return 0
+		}
+		if max == 1<<63-1 {
+			return time.Duration(int63n(int64(max)))
+		}
+		return time.Duration(int63n(int64(max) + 1))
+	}
+}
+
+// RetryAfter is an error processors can return, wrapping Err, to tell
+// how long to wait before the job is retried, like when a rate-limited
+// downstream system says so with a Retry-After header. Delay replaces
+// the backoff of the retry policy for that attempt only, and the job is
+// still retried only as long as the policy allows more attempts.
+type RetryAfter struct {
+	Delay time.Duration
+	Err   error
+}
+
+func (e RetryAfter) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("retry after %s", e.Delay)
+	}
+	return e.Err.Error()
+}
+
+func (e RetryAfter) Unwrap() error {
+	return e.Err
+}
+
+// retryDelay returns how long to wait before retrying a job that failed
+// the given attempt with err: the delay err asks for if it is a RetryAfter,
+// and the backoff of rp otherwise.
+func (rp RetryPolicy) retryDelay(attempt int, err error) time.Duration {
+	var retryAfter RetryAfter
+	if errors.As(err, &retryAfter) {
+		return retryAfter.Delay
+	}
+	return rp.backoff(attempt)
+}
@@ -0,0 +1,136 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	backoff := queue.ConstantBackoff(time.Second)
+	for attempt := 1; attempt <= 5; attempt++ {
+		if got := backoff(attempt); got != time.Second {
+			t.Errorf("got backoff %v after attempt %d, want %v", got, attempt, time.Second)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := queue.ExponentialBackoff(100*time.Millisecond, time.Second)
+	var got []time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		got = append(got, backoff(attempt))
+	}
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got backoffs %v, want %v", got, want)
+	}
+	for _, attempt := range []int{64, 100, math.MaxInt32} {
+		if got := backoff(attempt); got != time.Second {
+			t.Errorf("got backoff %v after attempt %d, want it capped at %v", got, attempt, time.Second)
+		}
+	}
+}
+
+func TestFullJitter(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	capped := queue.ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond)
+	backoff := queue.FullJitter(capped, rnd.Int63n)
+	for attempt := 1; attempt <= 10; attempt++ {
+		max := capped(attempt)
+		for i := 0; i < 100; i++ {
+			if got := backoff(attempt); got < 0 || got > max {
+				t.Fatalf("got backoff %v after attempt %d, want it in [0, %v]", got, attempt, max)
+			}
+		}
+	}
+}
+
+func TestFullJitterBounds(t *testing.T) {
+	capped := queue.ConstantBackoff(time.Second)
+	lowest := queue.FullJitter(capped, func(n int64) int64 { return 0 })
+	highest := queue.FullJitter(capped, func(n int64) int64 { return n - 1 })
+	if got := lowest(1); got != 0 {
+		t.Errorf("got lowest backoff %v, want 0", got)
+	}
+	if got := highest(1); got != time.Second {
+		t.Errorf("got highest backoff %v, want %v", got, time.Second)
+	}
+	never := queue.FullJitter(queue.ConstantBackoff(0), func(n int64) int64 {
+		t.Fatal("got the random source called without a backoff to jitter")
+		return 0
+	})
+	if got := never(1); got != 0 {
+		t.Errorf("got backoff %v, want 0", got)
+	}
+}
+
+func TestExponentialBackoffWithJitter(t *testing.T) {
+	backoff := queue.ExponentialBackoffWithJitter(time.Millisecond, 8*time.Millisecond)
+	for attempt := 1; attempt <= 6; attempt++ {
+		max := queue.ExponentialBackoff(time.Millisecond, 8*time.Millisecond)(attempt)
+		if got := backoff(attempt); got < 0 || got > max {
+			t.Errorf("got backoff %v after attempt %d, want it in [0, %v]", got, attempt, max)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	errLimited := errors.New("rate limited")
+	clock := queue.NewFakeClock()
+	var failed, retried time.Time
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		if j.Attempt() == 1 {
+			failed = clock.Now()
+			return queue.RetryAfter{Delay: delay, Err: errLimited}
+		}
+		retried = clock.Now()
+		return nil
+	})
+	events := &eventRecorder{}
+	c, w := queue.New(p, queue.WithFakeClock(clock), queue.WithEventHandler(events), queue.WithRetryPolicy(queue.RetryPolicy{
+		MaxAttempts: 2,
+		BackoffFor:  queue.ConstantBackoff(time.Hour),
+	}))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if n := advanceAndProcess(t, clock, w, delay-time.Nanosecond); n != 0 {
+		t.Errorf("got %d jobs retried before the delay, want none", n)
+	}
+	advanceAndProcess(t, clock, w, time.Nanosecond)
+
+	checkState(t, c, "a", queue.Finished)
+	if waited := retried.Sub(failed); waited != delay {
+		t.Errorf("got job retried after %s, want %s", waited, delay)
+	}
+	want := []string{"created a", "started a 1", "retry a 1: rate limited, in 50ms", "started a 2", "finished a 2"}
+	if got := events.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+func TestRetryAfterWithoutAttemptsLeft(t *testing.T) {
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		return queue.RetryAfter{Delay: time.Millisecond}
+	})
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if j := waitForJob(t, c, "a"); j.State() != queue.Failed || j.Error() != "retry after 1ms" {
+		t.Errorf("got job %s with error %q, want it %s", j.State(), j.Error(), queue.Failed)
+	}
+}
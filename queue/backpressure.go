@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+)
+
+// WithMaxQueueDepth limits the Queued jobs of each named queue to n,
+// so that producers cannot outpace the workers without bound. When
+// a queue is full, CreateJob, CreateJobInQueue and CreateJobs block
+// until there is room for their jobs or their context gets done, and
+// TryCreateJob returns false right away without creating its job.
+// Blocked producers get room in the order they blocked. A batch larger
+// than n waits for the queue to be empty and then goes in whole. Jobs
+// Scheduled for later, and jobs queued again to be retried or after an
+// interruption, are not held back, so they may take a queue over n for
+// a while.
+// By default, the depth of the queues is not limited.
+func WithMaxQueueDepth(n int) Option {
+	return func(c *config) {
+		c.maxDepth = n
+	}
+}
+
+// depthWaiter is a producer waiting for room for need jobs in a full
+// queue. granted is closed when room is reserved for them.
+type depthWaiter struct {
+	need    int
+	granted chan struct{}
+}
+
+// fits tells whether need more jobs fit in the named queue, counting
+// the room reserved for the waiters that were granted it.
+// mu must be held by the caller.
+func (q *memoryQueue) fits(name string, need int) bool {
+	used := q.depth(name) + q.reserved[name]
+	return used+need <= q.maxDepth || used == 0
+}
+
+// waitForRoom returns when need more jobs fit in the named queue, behind
+// the producers already waiting for room in it. If block is false, it fails
+// with ErrQueueFull instead of waiting, and if ctx gets done first, it
+// returns ctx's error. It releases mu while waiting, so the caller must
+// check again anything it checked before, and then call admit once it
+// has pushed its jobs or given up.
+// mu must be held by the caller.
+func (q *memoryQueue) waitForRoom(ctx context.Context, name string, need int, block bool) error {
+	if q.maxDepth <= 0 || (len(q.waiters[name]) == 0 && q.fits(name, need)) {
+		return nil
+	}
+	if !block {
+		return fmt.Errorf("cannot queue %d jobs in queue %q: %w", need, name, ErrQueueFull)
+	}
+	w := &depthWaiter{need: need, granted: make(chan struct{})}
+	q.waiters[name] = append(q.waiters[name], w)
+	q.mu.Unlock()
+	select {
+	case <-w.granted:
+		q.mu.Lock()
+		q.reserved[name] -= need
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		select {
+		case <-w.granted:
+			q.reserved[name] -= need
+		default:
+			q.dropWaiter(name, w)
+		}
+		return ctx.Err()
+	}
+}
+
+// dropWaiter removes the given waiter from those of the named queue.
+// mu must be held by the caller.
+func (q *memoryQueue) dropWaiter(name string, w *depthWaiter) {
+	waiters := q.waiters[name]
+	for i, other := range waiters {
+		if other == w {
+			q.waiters[name] = append(waiters[:i], waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// admit reserves room in the named queue for the producers waiting
+// for it, in order, as long as their jobs fit.
+// mu must be held by the caller.
+func (q *memoryQueue) admit(name string) {
+	for len(q.waiters[name]) > 0 {
+		w := q.waiters[name][0]
+		if !q.fits(name, w.need) {
+			return
+		}
+		q.waiters[name] = q.waiters[name][1:]
+		q.reserved[name] += w.need
+		close(w.granted)
+	}
+}
@@ -0,0 +1,150 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// blockedFor is how long a producer is given to return
+// before it is taken for blocked
+const blockedFor = 20 * time.Millisecond
+
+// createInBackground creates a job in the background, and returns
+// a channel getting what CreateJob returned
+func createInBackground(c queue.Client, id string) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- c.CreateJob(context.Background(), id, &intData{})
+	}()
+	return result
+}
+
+// checkBlocked checks that nothing is received from result for blockedFor
+func checkBlocked(t *testing.T, result <-chan error, what string) {
+	t.Helper()
+	select {
+	case err := <-result:
+		t.Errorf("got %s returning %v, want it blocked", what, err)
+	case <-time.After(blockedFor):
+	}
+}
+
+// checkUnblocked checks that nil is received from result within testTimeout
+func checkUnblocked(t *testing.T, result <-chan error, what string) {
+	t.Helper()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("got %s returning %v, want nil", what, err)
+		}
+	case <-time.After(testTimeout):
+		t.Errorf("got %s still blocked", what)
+	}
+}
+
+func TestTryCreateJobWhenFull(t *testing.T) {
+	c, _ := queue.New(doubler, queue.WithMaxQueueDepth(2))
+	ctx := context.Background()
+	createJobs(t, c, map[string]int{"a": 1, "b": 2})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if accepted, err := c.TryCreateJob(ctx, "c", &intData{}); accepted || err != nil {
+			t.Errorf("got job accepted %t and error %v in a full queue, want neither", accepted, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("got TryCreateJob blocked on a full queue")
+	}
+	if j, _ := c.GetJob(ctx, "c"); j != nil {
+		t.Errorf("got job %q created in a full queue", j.ID())
+	}
+	// Scheduled jobs do not take room until they are due
+	if err := c.CreateJobAt(ctx, "later", &intData{}, time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("got error %v scheduling a job in a full queue", err)
+	}
+	if err := c.CancelJob(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if accepted, err := c.TryCreateJob(ctx, "c", &intData{}); !accepted || err != nil {
+		t.Errorf("got job accepted %t and error %v once there is room, want it accepted", accepted, err)
+	}
+}
+
+func TestCreateJobBlocksWhenFull(t *testing.T) {
+	c, _ := queue.New(doubler, queue.WithMaxQueueDepth(1))
+	createJobs(t, c, map[string]int{"a": 1})
+	ctx, cancel := context.WithTimeout(context.Background(), blockedFor)
+	defer cancel()
+	if err := c.CreateJob(ctx, "b", &intData{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v creating a job in a full queue, want %v", err, context.DeadlineExceeded)
+	}
+	if j, _ := c.GetJob(context.Background(), "b"); j != nil {
+		t.Errorf("got job %q created after giving up", j.ID())
+	}
+	// Other queues have room of their own
+	if err := c.CreateJobInQueue(context.Background(), "other", "c", &intData{}); err != nil {
+		t.Errorf("got error %v creating a job in another queue", err)
+	}
+}
+
+func TestConsumingUnblocksOneProducer(t *testing.T) {
+	c, w := queue.New(doubler, queue.WithMaxQueueDepth(1))
+	createJobs(t, c, map[string]int{"a": 1})
+	first := createInBackground(c, "first")
+	checkBlocked(t, first, "the first producer")
+	second := createInBackground(c, "second")
+	checkBlocked(t, second, "the second producer")
+
+	if n := processAll(t, w); n != 1 {
+		t.Errorf("got %d jobs processed, want 1", n)
+	}
+	// Producers get room in the order they blocked
+	checkUnblocked(t, first, "the first producer")
+	checkBlocked(t, second, "the second producer")
+
+	processAll(t, w)
+	checkUnblocked(t, second, "the second producer")
+}
+
+func TestProducerGivingUpLeavesRoom(t *testing.T) {
+	c, _ := queue.New(doubler, queue.WithMaxQueueDepth(1))
+	createJobs(t, c, map[string]int{"a": 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	gaveUp := make(chan error, 1)
+	go func() {
+		gaveUp <- c.CreateJob(ctx, "quitter", &intData{})
+	}()
+	checkBlocked(t, gaveUp, "the producer")
+	next := createInBackground(c, "next")
+	checkBlocked(t, next, "the next producer")
+	cancel()
+	if err := <-gaveUp; !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v from the producer giving up, want %v", err, context.Canceled)
+	}
+	if err := c.CancelJob(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	checkUnblocked(t, next, "the next producer")
+}
+
+func TestCreateJobsLargerThanMaxDepth(t *testing.T) {
+	c, w := queue.New(doubler, queue.WithMaxQueueDepth(2))
+	createJobs(t, c, map[string]int{"a": 1})
+	batch := make(chan error, 1)
+	go func() {
+		batch <- c.CreateJobs(context.Background(), specs(3))
+	}()
+	checkBlocked(t, batch, "the batch")
+	processAll(t, w)
+	checkUnblocked(t, batch, "the batch")
+	if n := processAll(t, w); n != 3 {
+		t.Errorf("got %d jobs processed, want the 3 of the batch", n)
+	}
+}
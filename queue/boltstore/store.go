@@ -0,0 +1,186 @@
+// Package boltstore provides a queue.Store that keeps the records of the
+// jobs in a file, using the bbolt embedded key-value store, so that jobs
+// survive process restarts.
+package boltstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+	bolt "go.etcd.io/bbolt"
+)
+
+// jobsBucket is the bucket the records of the jobs are kept in, by ID
+var jobsBucket = []byte("jobs")
+
+// Store is a queue.Store backed by a bbolt database file.
+// It is safe for concurrent use. The times of the records are
+// kept in UTC, to the nanosecond, so they load back equal to the
+// saved ones whatever the time zone of the processes using the file.
+type Store struct {
+	db *bolt.DB
+}
+
+var _ queue.Store = &Store{}
+
+// Option configures a Store opened with Open
+type Option func(*config)
+
+// config holds the settings of a Store, as set by the options given to Open
+type config struct {
+	noSync  bool
+	timeout time.Duration
+}
+
+// WithoutSync makes the store skip the fsync after each write. Writes are
+// faster then, but the last ones may be lost if the system crashes.
+func WithoutSync() Option {
+	return func(c *config) {
+		c.noSync = true
+	}
+}
+
+// WithOpenTimeout limits the time Open waits for another process holding
+// the database file to release it to d. By default Open waits forever.
+func WithOpenTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.timeout = d
+	}
+}
+
+// Open opens the database file at path, creating it if it does not exist,
+// and returns a Store keeping the jobs in it. By default every write is
+// synced to disk before returning. The Store must be closed with Close.
+func Open(path string, opts ...Option) (*Store, error) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: cfg.timeout, NoSync: cfg.noSync})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Save(ctx context.Context, r queue.JobRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := encode(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(r.ID), b)
+	})
+}
+
+func (s *Store) Load(ctx context.Context, id string) (queue.JobRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return queue.JobRecord{}, err
+	}
+	var r queue.JobRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket).Get([]byte(id))
+		if b == nil {
+			return fmt.Errorf("cannot load job %q: %w", id, queue.ErrJobNotFound)
+		}
+		return json.Unmarshal(b, &r)
+	})
+	return r, err
+}
+
+func (s *Store) UpdateState(ctx context.Context, id string, state queue.State) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		b := bucket.Get([]byte(id))
+		if b == nil {
+			return fmt.Errorf("cannot update job %q: %w", id, queue.ErrJobNotFound)
+		}
+		var r queue.JobRecord
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+		r.State = state
+		b, err := encode(r)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), b)
+	})
+}
+
+func (s *Store) List(ctx context.Context) ([]queue.JobRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var records []queue.JobRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, b []byte) error {
+			var r queue.JobRecord
+			if err := json.Unmarshal(b, &r); err != nil {
+				return fmt.Errorf("cannot decode job %q: %w", k, err)
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (s *Store) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return fmt.Errorf("cannot delete job %q: %w", id, queue.ErrJobNotFound)
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// encode marshals r to keep it in the database, with its times in UTC,
+// which JSON keeps as RFC 3339 with nanoseconds
+func encode(r queue.JobRecord) ([]byte, error) {
+	r.RunAt = utc(r.RunAt)
+	r.Heartbeat = utc(r.Heartbeat)
+	r.Deadline = utc(r.Deadline)
+	if r.Schedule != nil {
+		schedule := make([]time.Time, len(r.Schedule))
+		for i, t := range r.Schedule {
+			schedule[i] = utc(t)
+		}
+		r.Schedule = schedule
+	}
+	return json.Marshal(r)
+}
+
+// utc returns t in UTC, leaving the zero time as it is
+func utc(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.UTC()
+}
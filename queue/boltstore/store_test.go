@@ -0,0 +1,248 @@
+package boltstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+	"github.com/ingrammicro/backend-test/queue/boltstore"
+)
+
+// intData is a job payload holding a single integer
+type intData struct {
+	N int `json:"n"`
+}
+
+func (d *intData) Marshal() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (d *intData) Unmarshal(b []byte) error {
+	return json.Unmarshal(b, d)
+}
+
+// processorFunc turns a function into a queue.Processor
+type processorFunc func(ctx context.Context, j queue.JobProcessingAccess) error
+
+func (f processorFunc) Process(ctx context.Context, j queue.JobProcessingAccess) error {
+	return f(ctx, j)
+}
+
+// doubler is a processor that doubles the payload of intData jobs
+var doubler = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+	var d intData
+	if err := j.GetData(&d); err != nil {
+		return err
+	}
+	d.N *= 2
+	return j.SetData(ctx, &d)
+})
+
+// open opens a store in the given directory
+func open(t *testing.T, dir string) *boltstore.Store {
+	t.Helper()
+	s, err := boltstore.Open(filepath.Join(dir, "jobs.db"), boltstore.WithOpenTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("opening store: %v", err)
+	}
+	return s
+}
+
+// tempDir creates a temporary directory, and returns
+// it with a function that removes it
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "boltstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// checkJob checks the state and payload of the job with the given ID
+func checkJob(t *testing.T, c queue.Client, id string, wantState queue.State, wantN int) {
+	t.Helper()
+	j, err := c.GetJob(context.Background(), id)
+	if err != nil || j == nil {
+		t.Fatalf("got job %v and error %v, want job %q", j, err, id)
+	}
+	var d intData
+	if err := j.GetData(&d); err != nil {
+		t.Fatalf("job %q: getting data: %v", id, err)
+	}
+	if j.State() != wantState || d.N != wantN {
+		t.Errorf("job %q: got it %s with data %d, want it %s with data %d", id, j.State(), d.N, wantState, wantN)
+	}
+}
+
+func TestJobsSurviveRestart(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	ctx := context.Background()
+	s := open(t, dir)
+	c, w, err := queue.NewWithStore(ctx, s, doubler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, n := range map[string]int{"a": 1, "b": 2} {
+		if err := c.CreateJob(ctx, id, &intData{N: n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.(queue.SyncWorker).ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateJob(ctx, "c", &intData{N: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, dir)
+	defer s.Close()
+	c, w, err = queue.NewWithStore(ctx, s, doubler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJob(t, c, "a", queue.Finished, 2)
+	checkJob(t, c, "b", queue.Finished, 4)
+	checkJob(t, c, "c", queue.Queued, 3)
+	if n, err := w.(queue.SyncWorker).ProcessAll(ctx); err != nil || n != 1 {
+		t.Fatalf("got %d jobs processed and error %v, want only the Queued one", n, err)
+	}
+	checkJob(t, c, "c", queue.Finished, 6)
+}
+
+func TestInterruptedJobsQueuedOnRestart(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	ctx := context.Background()
+	s := open(t, dir)
+	started, release := make(chan struct{}), make(chan struct{})
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		if err := j.SetData(ctx, &intData{N: 10}); err != nil { // A checkpoint
+			return err
+		}
+		close(started)
+		<-release
+		return nil
+	})
+	c, w, err := queue.NewWithStore(ctx, s, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateJob(ctx, "a", &intData{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	ran := make(chan error, 1)
+	go func() {
+		ran <- w.Run(ctx, 1)
+	}()
+	<-started
+	// The process "crashes" with the job Processing: the store is
+	// closed under the worker, which cannot record anything anymore
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-ran; err == nil {
+		t.Error("got no Run error after closing the store")
+	}
+
+	s = open(t, dir)
+	defer s.Close()
+	c, w, err = queue.NewWithStore(ctx, s, doubler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkJob(t, c, "a", queue.Queued, 10)
+	if _, err := w.(queue.SyncWorker).ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkJob(t, c, "a", queue.Finished, 20)
+}
+
+func TestMissingJob(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	s := open(t, dir)
+	defer s.Close()
+	ctx := context.Background()
+	if _, err := s.Load(ctx, "missing"); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v loading a missing job, want %v", err, queue.ErrJobNotFound)
+	}
+	if err := s.UpdateState(ctx, "missing", queue.Queued); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v updating a missing job, want %v", err, queue.ErrJobNotFound)
+	}
+	if err := s.Delete(ctx, "missing"); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v deleting a missing job, want %v", err, queue.ErrJobNotFound)
+	}
+}
+
+func TestWithoutSync(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	s, err := boltstore.Open(filepath.Join(dir, "jobs.db"), boltstore.WithoutSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	want := queue.JobRecord{ID: "a", State: queue.Queued, Data: []byte(`{"n":1}`)}
+	if err := s.Save(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Load(ctx, "a"); err != nil || got.ID != want.ID || string(got.Data) != string(want.Data) {
+		t.Errorf("got record %+v and error %v, want %+v", got, err, want)
+	}
+}
+
+func TestTimesRoundTrip(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	zone := time.FixedZone("UTC+5:30", 5*3600+30*60)
+	at := time.Date(2024, 3, 10, 1, 59, 59, 123456789, zone)
+	r := queue.JobRecord{
+		ID:        "a",
+		State:     queue.Scheduled,
+		RunAt:     at,
+		Heartbeat: at.Add(time.Nanosecond),
+		Deadline:  at.Add(time.Hour),
+		Schedule:  []time.Time{at, at.Add(time.Microsecond)},
+	}
+	s := open(t, dir)
+	if err := s.Save(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateState(context.Background(), "a", queue.Queued); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s = open(t, dir)
+	defer s.Close()
+	got, err := s.Load(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := append([]time.Time{r.RunAt, r.Heartbeat, r.Deadline}, r.Schedule...)
+	loaded := append([]time.Time{got.RunAt, got.Heartbeat, got.Deadline}, got.Schedule...)
+	if len(loaded) != len(want) {
+		t.Fatalf("got times %v, want %v", loaded, want)
+	}
+	for i, tt := range loaded {
+		if !tt.Equal(want[i]) || tt.Location() != time.UTC {
+			t.Errorf("got time %v loaded, want %v in UTC", tt, want[i].UTC())
+		}
+	}
+	if !got.Heartbeat.After(got.RunAt) {
+		t.Errorf("got heartbeat %v not after %v, want the nanosecond between them kept", got.Heartbeat, got.RunAt)
+	}
+}
@@ -0,0 +1,25 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// Budget returns the time left before the deadline of the given context
+// and true, or zero and false if the context has no deadline.
+// A deadline that already passed gives a zero budget.
+//
+// Processors running under a context with a deadline should use it to
+// decide how much work to attempt: once the deadline is hit the context
+// is done, and whatever was being computed is lost.
+func Budget(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	remaining := time.Until(deadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
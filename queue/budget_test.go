@@ -0,0 +1,36 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestBudgetWithoutDeadline(t *testing.T) {
+	if budget, ok := queue.Budget(context.Background()); ok || budget != 0 {
+		t.Errorf("got budget %v, %v without a deadline, want 0, false", budget, ok)
+	}
+}
+
+func TestBudgetBeforeDeadline(t *testing.T) {
+	const timeout = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	budget, ok := queue.Budget(ctx)
+	if !ok {
+		t.Fatal("got no budget with a deadline")
+	}
+	if budget <= 0 || budget > timeout {
+		t.Errorf("got budget %v, want it in (0, %v]", budget, timeout)
+	}
+}
+
+func TestBudgetAfterDeadline(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Minute))
+	defer cancel()
+	if budget, ok := queue.Budget(ctx); !ok || budget != 0 {
+		t.Errorf("got budget %v, %v past the deadline, want 0, true", budget, ok)
+	}
+}
@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// CancelResult tells how the processor of a job cancelled while
+// Processing stopped
+type CancelResult string
+
+const (
+	// CancelAcknowledged means the processor returned the error of its
+	// context once it was canceled, as well-behaved processors do
+	CancelAcknowledged CancelResult = "acknowledged"
+	// CancelForced means the processor did not return in time, and the
+	// worker abandoned it, as it does when a job times out
+	CancelForced CancelResult = "forced"
+	// CancelUnacknowledged means the processor returned in time, but
+	// with another error or none, as if it went on with the job without
+	// taking notice of its context. Without a cancel grace or a job
+	// timeout, the worker waits for such processors as long as they take.
+	CancelUnacknowledged CancelResult = "unacknowledged"
+)
+
+// CancelResultHandler is implemented by the EventHandlers that want
+// to tell well-behaved processors from runaway ones. For jobs cancelled
+// while Processing, OnCancelResult is called right after OnCancelled,
+// the same way, with how their processor stopped.
+type CancelResultHandler interface {
+	OnCancelResult(id string, result CancelResult)
+}
+
+// WithCancelGrace gives processors d to return once their context is
+// canceled, whether because their job was cancelled or because the worker
+// is stopped, after which the worker abandons them and moves on, and the
+// job is Cancelled or queued again all the same. By default, processors
+// are waited for as long as they take, unless there is a job timeout,
+// in which case they are abandoned right away. See WithJobTimeout.
+func WithCancelGrace(d time.Duration) Option {
+	return func(c *config) {
+		c.cancelGrace = d
+	}
+}
+
+// abandonedError is the error of an attempt whose processor was
+// abandoned after its context was canceled with err. It reads as err,
+// so that the error of the job is the same as if the processor had
+// returned err.
+type abandonedError struct {
+	err error
+}
+
+func (e abandonedError) Error() string {
+	return e.err.Error()
+}
+
+func (e abandonedError) Unwrap() error {
+	return e.err
+}
+
+// cancelResult returns how the processor stopped given
+// the error of the attempt to process a cancelled job
+func cancelResult(procErr error) CancelResult {
+	var abandoned abandonedError
+	switch {
+	case errors.As(procErr, &abandoned):
+		return CancelForced
+	case errors.Is(procErr, context.Canceled):
+		return CancelAcknowledged
+	}
+	return CancelUnacknowledged
+}
+
+// emitCancelResult calls the event handler of the queue,
+// if it is a CancelResultHandler, with how the processor of
+// the given job stopped, like emit.
+func (q *memoryQueue) emitCancelResult(id string, procErr error) {
+	if h, ok := q.events.(CancelResultHandler); ok {
+		q.emit(id, func(EventHandler) { h.OnCancelResult(id, cancelResult(procErr)) })
+	}
+}
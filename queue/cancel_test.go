@@ -0,0 +1,114 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// cancelResults is an EventHandler sending on results how the
+// processors of the jobs cancelled while Processing stopped
+type cancelResults struct {
+	queue.NopEventHandler
+	results chan queue.CancelResult
+}
+
+func (h cancelResults) OnCancelResult(id string, result queue.CancelResult) {
+	h.results <- result
+}
+
+// cancelProcessing cancels the job with the given ID once it has started
+// processing, waits for it to be Cancelled, and returns how its processor
+// stopped, as told to h
+func cancelProcessing(t *testing.T, c queue.Client, started chan string, h cancelResults, id string) queue.CancelResult {
+	t.Helper()
+	waitForStarts(t, started, 1)
+	if err := c.CancelJob(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if j := waitForJob(t, c, id); j.State() != queue.Cancelled {
+		t.Fatalf("got job %s, want it %s", j.State(), queue.Cancelled)
+	}
+	select {
+	case result := <-h.results:
+		return result
+	case <-time.After(testTimeout):
+		t.Fatal("got no cancel result")
+		return ""
+	}
+}
+
+func TestCancelAcknowledged(t *testing.T) {
+	p, started, _ := gated()
+	h := cancelResults{results: make(chan queue.CancelResult, 1)}
+	c, w := queue.New(p, queue.WithEventHandler(h), queue.WithCancelGrace(testTimeout))
+	createJobs(t, c, map[string]int{"a": 1})
+	defer runWorker(t, w, 1)()
+	if got := cancelProcessing(t, c, started, h, "a"); got != queue.CancelAcknowledged {
+		t.Errorf("got cancel result %q, want %q", got, queue.CancelAcknowledged)
+	}
+}
+
+func TestCancelForced(t *testing.T) {
+	started, release := make(chan string, 1), make(chan struct{})
+	defer close(release)
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		started <- j.ID()
+		<-release
+		return nil
+	})
+	h := cancelResults{results: make(chan queue.CancelResult, 1)}
+	c, w := queue.New(p, queue.WithEventHandler(h), queue.WithCancelGrace(10*time.Millisecond))
+	createJobs(t, c, map[string]int{"runaway": 1})
+	defer runWorker(t, w, 1)()
+	if got := cancelProcessing(t, c, started, h, "runaway"); got != queue.CancelForced {
+		t.Errorf("got cancel result %q, want %q", got, queue.CancelForced)
+	}
+}
+
+func TestCancelUnacknowledged(t *testing.T) {
+	started, release := make(chan string, 1), make(chan struct{})
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		started <- j.ID()
+		<-release
+		return nil
+	})
+	h := cancelResults{results: make(chan queue.CancelResult, 1)}
+	c, w := queue.New(p, queue.WithEventHandler(h))
+	createJobs(t, c, map[string]int{"stubborn": 1})
+	defer runWorker(t, w, 1)()
+	waitForStarts(t, started, 1)
+	if err := c.CancelJob(context.Background(), "stubborn"); err != nil {
+		t.Fatal(err)
+	}
+	// Without a cancel grace, the worker waits for the processor,
+	// which goes on regardless of its context.
+	close(release)
+	if j := waitForJob(t, c, "stubborn"); j.State() != queue.Cancelled {
+		t.Fatalf("got job %s, want it %s", j.State(), queue.Cancelled)
+	}
+	select {
+	case got := <-h.results:
+		if got != queue.CancelUnacknowledged {
+			t.Errorf("got cancel result %q, want %q", got, queue.CancelUnacknowledged)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("got no cancel result")
+	}
+}
+
+func TestCancelQueuedJobHasNoResult(t *testing.T) {
+	h := cancelResults{results: make(chan queue.CancelResult, 1)}
+	c, _ := queue.New(doubler, queue.WithEventHandler(h))
+	createJobs(t, c, map[string]int{"a": 1})
+	if err := c.CancelJob(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case result := <-h.results:
+		t.Errorf("got cancel result %q for a job that was not Processing", result)
+	default:
+	}
+}
@@ -0,0 +1,44 @@
+package queue
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// WithChecksumVerification makes the queue store the payloads of the
+// jobs followed by their CRC-32 checksum, and check it whenever it
+// reads them back, so that payloads corrupted in the store are not
+// handed out: GetData fails with an error wrapping ErrChecksumMismatch
+// instead. The checksum is of the payload as stored, once compressed
+// and encrypted. A store keeping checksummed payloads must always be
+// used with checksums.
+func WithChecksumVerification() Option {
+	return func(c *config) {
+		c.checksums = true
+	}
+}
+
+// checksumSize is the size of the checksums following the payloads
+const checksumSize = crc32.Size
+
+// appendChecksum returns b followed by its checksum
+func appendChecksum(b []byte) []byte {
+	out := make([]byte, len(b), len(b)+checksumSize)
+	copy(out, b)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(b))
+}
+
+// verifyChecksum returns b, followed by its checksum by appendChecksum,
+// without it, failing with an error wrapping ErrChecksumMismatch if the
+// checksum does not match
+func verifyChecksum(b []byte) ([]byte, error) {
+	if len(b) < checksumSize {
+		return nil, fmt.Errorf("payload of %d bytes is too short for a checksum: %w", len(b), ErrChecksumMismatch)
+	}
+	data, sum := b[:len(b)-checksumSize], binary.BigEndian.Uint32(b[len(b)-checksumSize:])
+	if crc32.ChecksumIEEE(data) != sum {
+		return nil, fmt.Errorf("payload of %d bytes: %w", len(b), ErrChecksumMismatch)
+	}
+	return data, nil
+}
@@ -0,0 +1,69 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// tamper flips a bit of the payload of the job with the given ID in s
+func tamper(t *testing.T, s queue.Store, id string) {
+	t.Helper()
+	ctx := context.Background()
+	r, err := s.Load(ctx, id)
+	if err != nil {
+		t.Fatalf("loading job %q: %v", id, err)
+	}
+	r.Data = append([]byte(nil), r.Data...)
+	r.Data[len(r.Data)/2] ^= 1
+	if err := s.Save(ctx, r); err != nil {
+		t.Fatalf("saving job %q: %v", id, err)
+	}
+}
+
+func TestChecksumRoundTrip(t *testing.T) {
+	for name, opts := range map[string][]queue.Option{
+		"plain":     nil,
+		"encrypted": {queue.WithEncryption(encryptionKey), queue.WithCompression(queue.Gzip)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := &queue.MemoryStore{}
+			c := createSecret(t, s, append(opts, queue.WithChecksumVerification())...)
+			checkText(t, c, "a", secret)
+			// The checksum is kept with the payload
+			checkText(t, reopen(t, s, append(opts, queue.WithChecksumVerification())...), "a", secret)
+		})
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	s := &queue.MemoryStore{}
+	c := createSecret(t, s, queue.WithChecksumVerification())
+	tamper(t, s, "a")
+	j, err := c.GetJob(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("getting job: %v", err)
+	}
+	var d textData
+	if err := j.GetData(&d); !errors.Is(err, queue.ErrChecksumMismatch) {
+		t.Errorf("got error %v getting a tampered payload, want %v", err, queue.ErrChecksumMismatch)
+	}
+}
+
+func TestChecksumMismatchFailsJob(t *testing.T) {
+	s := &queue.MemoryStore{}
+	ctx := context.Background()
+	c, w, err := queue.NewWithStore(ctx, s, doubler, queue.WithChecksumVerification())
+	if err != nil {
+		t.Fatalf("creating queue: %v", err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	tamper(t, s, "a")
+	processAll(t, w)
+	j := waitForJob(t, c, "a")
+	if j.State() != queue.Failed || j.FailureCategory() != queue.FailureCorrupt {
+		t.Errorf("got job %s with failure category %q, want it %s with %q", j.State(), j.FailureCategory(), queue.Failed, queue.FailureCorrupt)
+	}
+}
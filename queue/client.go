@@ -0,0 +1,448 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// client is the Client returned by New
+type client struct {
+	q *memoryQueue
+}
+
+// CreateJob creates a job in the default queue
+func (c *client) CreateJob(ctx context.Context, id string, initialData MarshalUnmarshaler, opts ...JobOption) error {
+	return c.create(ctx, DefaultQueue, id, initialData, time.Time{}, opts, true)
+}
+
+// TryCreateJob creates a job in the default queue if it is not
+// full, without waiting for room, and tells whether it did
+func (c *client) TryCreateJob(ctx context.Context, id string, initialData MarshalUnmarshaler, opts ...JobOption) (accepted bool, err error) {
+	err = c.create(ctx, DefaultQueue, id, initialData, time.Time{}, opts, false)
+	if errors.Is(err, ErrQueueFull) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CreateJobInQueue creates a job in the named queue
+func (c *client) CreateJobInQueue(ctx context.Context, queueName, id string, initialData MarshalUnmarshaler, opts ...JobOption) error {
+	if err := checkQueueName(queueName); err != nil {
+		return err
+	}
+	return c.create(ctx, queueName, id, initialData, time.Time{}, opts, true)
+}
+
+// CreateJobAt creates a job in the default queue that
+// stays Scheduled until runAt
+func (c *client) CreateJobAt(ctx context.Context, id string, initialData MarshalUnmarshaler, runAt time.Time, opts ...JobOption) error {
+	return c.create(ctx, DefaultQueue, id, initialData, runAt, opts, true)
+}
+
+// create marshals initialData and stores it as the payload of a new
+// job with the given ID in the named queue, configured by opts. The job
+// is Queued right away if runAt is not in the future, and Scheduled until
+// then otherwise, unless there are as many Scheduled jobs as allowed by
+// WithMaxScheduled already. It fails if there is already a job with that
+// ID, in any queue.
+// If the job is to be Queued and the queue is full, create waits for room
+// if block is true, and fails with ErrQueueFull otherwise.
+func (c *client) create(ctx context.Context, queueName, id string, initialData MarshalUnmarshaler, runAt time.Time, opts []JobOption, block bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := c.q.marshal(initialData)
+	if err != nil {
+		return err
+	}
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	if name, ok := c.q.givenBack[queueName]; ok {
+		queueName = name
+	}
+	delay := c.q.untilDue(runAt)
+	if delay <= 0 {
+		for {
+			if err := c.q.waitForRoom(ctx, queueName, 1, block); err != nil {
+				return err
+			}
+			// The queue of a reservation may have been given back
+			// while waiting, so the job goes to the other one
+			name, ok := c.q.givenBack[queueName]
+			if !ok {
+				break
+			}
+			c.q.admit(queueName)
+			queueName = name
+		}
+		defer c.q.admit(queueName)
+	}
+	if c.q.taken(id) {
+		return fmt.Errorf("cannot create job %q: %w", id, ErrDuplicateJob)
+	}
+	r := c.newRecord(queueName, id, data, 1, opts)
+	if original, ok := c.q.dedup[r.DedupKey]; ok {
+		c.q.aliases[id] = original
+		return nil
+	}
+	if delay > 0 {
+		if c.q.maxScheduled > 0 && c.q.counts[Scheduled] >= c.q.maxScheduled {
+			return fmt.Errorf("cannot schedule job %q over the limit of %d: %w", id, c.q.maxScheduled, ErrTooManyScheduled)
+		}
+		r.State = Scheduled
+		r.RunAt = runAt
+	}
+	if err := c.save(ctx, &r); err != nil {
+		return err
+	}
+	c.q.lastSeq++
+	c.q.add(r)
+	if jobs, ok := c.q.reservations[queueName]; ok {
+		jobs[id] = struct{}{}
+	}
+	c.q.metrics.Counter(MetricJobsCreated, 1)
+	c.q.emit(id, func(h EventHandler) { h.OnCreated(id) })
+	if delay > 0 {
+		c.q.schedule(id, delay)
+		return nil
+	}
+	c.q.push(r)
+	return nil
+}
+
+// CreateJobs creates a Queued job in the default queue for each
+// of the given specs, holding the lock of the queue only once
+// (besides waiting for room in the queue, if it is full).
+// If a spec fails the checks of ValidateJobs, the *BatchError telling
+// the first one that does is returned. If a job cannot be created
+// otherwise, the ones of the batch already saved to the store are
+// deleted from it, so that none is created.
+func (c *client) CreateJobs(ctx context.Context, jobs []JobSpec, opts ...JobOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, marshalErr := c.marshalBatch(jobs)
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	if marshalErr != nil {
+		// The batch fails anyway, but possibly at an earlier spec
+		return c.checkBatch(jobs, data, marshalErr)
+	}
+	if err := c.q.waitForRoom(ctx, DefaultQueue, len(jobs), true); err != nil {
+		return err
+	}
+	defer c.q.admit(DefaultQueue)
+	if err := c.checkBatch(jobs, data, nil); err != nil {
+		return err
+	}
+	// Jobs deduplicated by an existing job or an earlier one
+	// of the batch become aliases once the batch is created
+	records := make([]JobRecord, 0, len(jobs))
+	aliases := make(map[string]string)
+	batchKeys := make(map[string]string)
+	for i, spec := range jobs {
+		r := c.newRecord(DefaultQueue, spec.ID, data[i], uint64(len(records)+1), opts)
+		if original, ok := c.q.dedup[r.DedupKey]; ok {
+			aliases[r.ID] = original
+			continue
+		}
+		if original, ok := batchKeys[r.DedupKey]; ok {
+			aliases[r.ID] = original
+			continue
+		}
+		if r.DedupKey != "" {
+			batchKeys[r.DedupKey] = r.ID
+		}
+		if err := c.save(ctx, &r); err != nil {
+			for _, r := range records {
+				c.q.store.Delete(context.Background(), r.ID)
+			}
+			return err
+		}
+		records = append(records, r)
+	}
+	c.q.lastSeq += uint64(len(records))
+	for _, r := range records {
+		c.q.add(r)
+	}
+	for alias, original := range aliases {
+		c.q.aliases[alias] = original
+	}
+	c.q.metrics.Counter(MetricJobsCreated, float64(len(records)))
+	for _, r := range records {
+		id := r.ID
+		c.q.emit(id, func(h EventHandler) { h.OnCreated(id) })
+	}
+	if len(records) > 0 {
+		c.q.push(records...)
+	}
+	return nil
+}
+
+// marshalBatch marshals the payloads of the jobs of the given specs,
+// without holding c.q.mu. If one cannot be marshaled, it stops there,
+// returning the payloads of the specs before it and a *BatchError.
+func (c *client) marshalBatch(jobs []JobSpec) ([][]byte, error) {
+	data := make([][]byte, 0, len(jobs))
+	for i, spec := range jobs {
+		b, err := c.q.marshal(spec.Data)
+		if err != nil {
+			return data, &BatchError{Index: i, Err: fmt.Errorf("cannot marshal job %q: %w", spec.ID, err)}
+		}
+		data = append(data, b)
+	}
+	return data, nil
+}
+
+// checkBatch checks the given specs in turn, given what marshalBatch
+// returned for them: the payload of each must have been marshaled, and
+// its ID be neither taken nor given by a spec before it. It returns a
+// *BatchError telling the first spec that fails, if any does.
+// c.q.mu must be held by the caller.
+func (c *client) checkBatch(jobs []JobSpec, data [][]byte, marshalErr error) error {
+	ids := make(map[string]struct{}, len(data))
+	for i := range data {
+		if err := c.checkID(jobs[i].ID, ids); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return marshalErr
+}
+
+// checkID checks that the given ID of a spec of a batch is neither taken
+// nor among ids, those of the specs before it, and then adds it to ids.
+// c.q.mu must be held by the caller.
+func (c *client) checkID(id string, ids map[string]struct{}) error {
+	if c.q.taken(id) {
+		return fmt.Errorf("cannot create job %q: %w", id, ErrDuplicateJob)
+	}
+	if _, ok := ids[id]; ok {
+		return fmt.Errorf("cannot create job %q twice in the batch: %w", id, ErrDuplicateJob)
+	}
+	ids[id] = struct{}{}
+	return nil
+}
+
+// ValidateJobs checks the given specs like CreateJobs would before
+// creating their jobs, without creating any: their payloads must be
+// marshalable and within the limit of the queue, if any, and their IDs
+// neither taken nor given twice. It checks each spec in turn, its payload
+// and then its ID, and returns a *BatchError telling the first one that
+// fails. It does not wait for room in the queue, and the store may still
+// fail once the jobs are created.
+func (c *client) ValidateJobs(ctx context.Context, jobs []JobSpec) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, marshalErr := c.marshalBatch(jobs)
+	c.q.mu.RLock()
+	defer c.q.mu.RUnlock()
+	return c.checkBatch(jobs, data, marshalErr)
+}
+
+// newRecord returns the record of a new Queued job configured by opts,
+// which is the nth to be created from now on.
+// c.q.mu must be held by the caller.
+func (c *client) newRecord(queueName, id string, data []byte, n uint64, opts []JobOption) JobRecord {
+	var o jobOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return JobRecord{
+		ID:       id,
+		Seq:      c.q.lastSeq + n,
+		Queue:    queueName,
+		Priority: o.priority,
+		Key:      o.key,
+		DedupKey: dedupKey(o, queueName, data),
+		Deadline: o.deadline,
+		Schedule: o.schedule,
+		State:    Queued,
+		Data:     data,
+	}
+}
+
+// save saves the record of a new job in the store, encoding its
+// payload, tracing its creation as a child of the span in ctx.
+func (c *client) save(ctx context.Context, r *JobRecord) error {
+	ctx, span := startCreateSpan(ctx, c.q.tracer, r)
+	defer span.End()
+	data, err := c.q.encode(r.Data)
+	if err == nil {
+		r.Data = data
+		err = c.q.store.Save(ctx, *r)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetJob returns a snapshot of the job with the given ID or alias,
+// or nil if there is no such job.
+func (c *client) GetJob(ctx context.Context, id string) (Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.q.mu.RLock()
+	defer c.q.mu.RUnlock()
+	r, err := c.q.store.Load(ctx, c.q.resolve(id))
+	if errors.Is(err, ErrJobNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job{q: c.q, r: r}, nil
+}
+
+// GetJobs returns snapshots of the jobs with the given IDs or aliases,
+// by the IDs given, loading all of them under a single hold of the lock.
+func (c *client) GetJobs(ctx context.Context, ids []string) (map[string]Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.q.mu.RLock()
+	defer c.q.mu.RUnlock()
+	jobs := make(map[string]Job, len(ids))
+	for _, id := range ids {
+		r, err := c.q.store.Load(ctx, c.q.resolve(id))
+		if errors.Is(err, ErrJobNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs[id] = &job{q: c.q, r: r}
+	}
+	return jobs, nil
+}
+
+// WaitForJob waits until the done channel of the job with the given ID
+// or alias is closed, and then returns a snapshot of the job.
+func (c *client) WaitForJob(ctx context.Context, id string) (Job, error) {
+	c.q.mu.RLock()
+	id = c.q.resolve(id)
+	l, ok := c.q.live[id]
+	c.q.mu.RUnlock()
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	select {
+	case <-l.done:
+	default:
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	c.q.mu.RLock()
+	defer c.q.mu.RUnlock()
+	r, err := c.q.store.Load(context.Background(), id)
+	if err != nil {
+		return nil, err
+	}
+	return &job{q: c.q, r: r}, nil
+}
+
+// DeleteJob removes the job with the given ID from the queue, with its
+// aliases. Only Finished, Failed and Cancelled jobs can be deleted.
+// Given an alias, it removes only the alias, whatever the job's state.
+func (c *client) DeleteJob(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	if _, ok := c.q.aliases[id]; ok {
+		delete(c.q.aliases, id)
+		return nil
+	}
+	r, err := c.q.store.Load(ctx, id)
+	if errors.Is(err, ErrJobNotFound) {
+		return fmt.Errorf("cannot delete job %q: %w", id, ErrJobNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if !isTerminal(r.State) {
+		return fmt.Errorf("cannot delete job %q, which is %s: %w", id, r.State, ErrJobNotTerminal)
+	}
+	if err := c.q.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	c.q.forget(id)
+	return nil
+}
+
+// CancelJob cancels the job with the given ID or alias
+func (c *client) CancelJob(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	id = c.q.resolve(id)
+	l, ok := c.q.live[id]
+	if !ok {
+		return fmt.Errorf("cannot cancel job %q: %w", id, ErrJobNotFound)
+	}
+	r, err := c.q.store.Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if isTerminal(r.State) {
+		return fmt.Errorf("cannot cancel job %q, which is %s: %w", id, r.State, ErrJobTerminal)
+	}
+	return c.q.cancelJob(ctx, r, l)
+}
+
+// ListJobs returns snapshots of the jobs matching filter,
+// in the order they were created.
+func (c *client) ListJobs(ctx context.Context, filter ListFilter) ([]Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.q.mu.RLock()
+	defer c.q.mu.RUnlock()
+	after := uint64(0)
+	if filter.After != "" {
+		r, err := c.q.store.Load(ctx, filter.After)
+		if errors.Is(err, ErrJobNotFound) {
+			return nil, fmt.Errorf("cannot list jobs after %q: %w", filter.After, ErrJobNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+		after = r.Seq
+	}
+	records, err := c.q.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matching []JobRecord
+	for _, r := range records {
+		if r.Seq > after && (filter.State == "" || r.State == filter.State) {
+			matching = append(matching, r)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].Seq < matching[j].Seq
+	})
+	if filter.Limit > 0 && len(matching) > filter.Limit {
+		matching = matching[:filter.Limit]
+	}
+	jobs := make([]Job, len(matching))
+	for i, r := range matching {
+		jobs[i] = &job{q: c.q, r: r}
+	}
+	return jobs, nil
+}
@@ -0,0 +1,674 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestCreateJobQueuesIt(t *testing.T) {
+	c, _ := queue.New(doubler)
+	ctx := context.Background()
+	if err := c.CreateJob(ctx, "a", &intData{N: 3}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := c.GetJob(ctx, "a")
+	if err != nil || j == nil {
+		t.Fatalf("got job %v and error %v, want the job", j, err)
+	}
+	var d intData
+	if err := j.GetData(&d); err != nil {
+		t.Fatal(err)
+	}
+	if j.ID() != "a" || j.State() != queue.Queued || d.N != 3 {
+		t.Errorf("got job %q in state %q with data %d, want %q in state %q with data 3", j.ID(), j.State(), d.N, "a", queue.Queued)
+	}
+}
+
+func TestCreateJobRejectsDuplicates(t *testing.T) {
+	c, _ := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	if err := c.CreateJob(context.Background(), "a", &intData{N: 2}); !errors.Is(err, queue.ErrDuplicateJob) {
+		t.Errorf("got error %v creating a job with a duplicate ID, want %v", err, queue.ErrDuplicateJob)
+	}
+	if err := c.CreateJobInQueue(context.Background(), "other", "a", &intData{N: 3}); !errors.Is(err, queue.ErrDuplicateJob) {
+		t.Errorf("got error %v creating a job with a duplicate ID in another queue, want %v", err, queue.ErrDuplicateJob)
+	}
+	j, _ := c.GetJob(context.Background(), "a")
+	var d intData
+	if err := j.GetData(&d); err != nil || d.N != 1 {
+		t.Errorf("got data %d and error %v for the original job, want 1", d.N, err)
+	}
+}
+
+func TestRecreateTerminalJob(t *testing.T) {
+	c, w := queue.New(doubler)
+	ctx := context.Background()
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if err := c.CreateJob(ctx, "a", &intData{N: 5}); !errors.Is(err, queue.ErrDuplicateJob) {
+		t.Errorf("got error %v recreating a finished job, want %v", err, queue.ErrDuplicateJob)
+	}
+	if err := c.DeleteJob(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateJob(ctx, "a", &intData{N: 5}); err != nil {
+		t.Fatalf("recreating a deleted job: %v", err)
+	}
+	processAll(t, w)
+	var d intData
+	if err := waitForJob(t, c, "a").GetData(&d); err != nil || d.N != 10 {
+		t.Errorf("got data %d and error %v for the recreated job, want 10", d.N, err)
+	}
+}
+
+func TestGetJobNotFound(t *testing.T) {
+	c, _ := queue.New(doubler)
+	j, err := c.GetJob(context.Background(), "missing")
+	if j != nil || err != nil {
+		t.Errorf("got job %v and error %v, want nil, nil", j, err)
+	}
+}
+
+func TestWaitForJobFinishingLater(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 3})
+	waited := make(chan queue.Job, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		j, err := c.WaitForJob(ctx, "a")
+		if err != nil {
+			t.Errorf("waiting for job: %v", err)
+		}
+		waited <- j
+	}()
+	defer runWorker(t, w, 1)()
+	if j := <-waited; j == nil || j.State() != queue.Finished {
+		t.Errorf("got job %v, want it Finished", j)
+	}
+}
+
+func TestWaitForJobAlreadyFinished(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 3})
+	processAll(t, w)
+	// Even a done context does not stop WaitForJob from returning a terminal job
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	j, err := c.WaitForJob(ctx, "a")
+	if err != nil || j.State() != queue.Finished {
+		t.Errorf("got job %v and error %v, want it Finished", j, err)
+	}
+}
+
+func TestWaitForJobCanceled(t *testing.T) {
+	c, _ := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 3})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitForJob(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestWaitForJobNotFound(t *testing.T) {
+	c, _ := queue.New(doubler)
+	if _, err := c.WaitForJob(context.Background(), "missing"); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v, want %v", err, queue.ErrJobNotFound)
+	}
+}
+
+func TestDeleteFinishedJob(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	ctx := context.Background()
+	if err := c.DeleteJob(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := c.GetJob(ctx, "a"); j != nil || err != nil {
+		t.Errorf("got job %v and error %v after deleting it, want nil, nil", j, err)
+	}
+}
+
+func TestDeleteProcessingJob(t *testing.T) {
+	p, started, release, _ := stubborn()
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 1})
+	stop := runWorker(t, w, 1)
+	defer stop()
+	defer close(release)
+	<-started
+	if err := c.DeleteJob(context.Background(), "a"); !errors.Is(err, queue.ErrJobNotTerminal) {
+		t.Errorf("got error %v deleting a Processing job, want %v", err, queue.ErrJobNotTerminal)
+	}
+}
+
+func TestDeleteQueuedJob(t *testing.T) {
+	c, _ := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	if err := c.DeleteJob(context.Background(), "a"); !errors.Is(err, queue.ErrJobNotTerminal) {
+		t.Errorf("got error %v deleting a Queued job, want %v", err, queue.ErrJobNotTerminal)
+	}
+}
+
+func TestDeleteMissingJob(t *testing.T) {
+	c, _ := queue.New(doubler)
+	if err := c.DeleteJob(context.Background(), "missing"); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v, want %v", err, queue.ErrJobNotFound)
+	}
+}
+
+func TestListJobsByState(t *testing.T) {
+	c, w := queue.New(doubler)
+	ctx := context.Background()
+	for i := -2; i < 3; i++ {
+		if err := c.CreateJob(ctx, fmt.Sprint(i), &intData{N: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	processAll(t, w)
+	for _, id := range []string{"q1", "q2"} {
+		if err := c.CreateJob(ctx, id, &intData{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for state, want := range map[queue.State][]string{
+		queue.Failed:   {"-2", "-1"},
+		queue.Queued:   {"q1", "q2"},
+		queue.Finished: {"0", "1", "2"},
+	} {
+		jobs, err := c.ListJobs(ctx, queue.ListFilter{State: state})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(jobs); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %s jobs %v, want %v", state, got, want)
+		}
+	}
+}
+
+func TestListJobsPages(t *testing.T) {
+	c, _ := queue.New(doubler)
+	ctx := context.Background()
+	var want []string
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprint("j", i)
+		if err := c.CreateJob(ctx, id, &intData{}); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, id)
+	}
+	var got []string
+	after := ""
+	for page := 0; ; page++ {
+		if page > len(want) {
+			t.Fatalf("got more pages than jobs, listing %v so far", got)
+		}
+		jobs, err := c.ListJobs(ctx, queue.ListFilter{Limit: 3, After: after})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(jobs) > 3 {
+			t.Fatalf("got page of %d jobs, want at most 3", len(jobs))
+		}
+		if len(jobs) == 0 {
+			break
+		}
+		got = append(got, ids(jobs)...)
+		after = jobs[len(jobs)-1].ID()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v listed page by page, want %v", got, want)
+	}
+}
+
+func TestListJobsAfterMissingJob(t *testing.T) {
+	c, _ := queue.New(doubler)
+	if _, err := c.ListJobs(context.Background(), queue.ListFilter{After: "missing"}); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v, want %v", err, queue.ErrJobNotFound)
+	}
+}
+
+// ids returns the IDs of the given jobs
+func ids(jobs []queue.Job) []string {
+	ids := make([]string, len(jobs))
+	for i, j := range jobs {
+		ids[i] = j.ID()
+	}
+	return ids
+}
+
+func TestCreateJobAt(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	c, w := queue.New(doubler)
+	ctx := context.Background()
+	began := time.Now()
+	if err := c.CreateJobAt(ctx, "later", &intData{N: 1}, began.Add(delay)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateJobAt(ctx, "past", &intData{N: 1}, began.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := c.GetJob(ctx, "later"); j.State() != queue.Scheduled {
+		t.Errorf("got state %q for a job scheduled in the future, want %q", j.State(), queue.Scheduled)
+	}
+	defer runWorker(t, w, 1)()
+	if j := waitForJob(t, c, "past"); j.State() != queue.Finished {
+		t.Errorf("got state %q for a job scheduled in the past, want %q", j.State(), queue.Finished)
+	}
+	if j := waitForJob(t, c, "later"); j.State() != queue.Finished {
+		t.Errorf("got state %q for a job scheduled in the future, want %q", j.State(), queue.Finished)
+	}
+	if elapsed := time.Since(began); elapsed < delay {
+		t.Errorf("got job processed %v after creating it, want at least %v", elapsed, delay)
+	}
+}
+
+func TestDeleteScheduledJob(t *testing.T) {
+	c, _ := queue.New(doubler)
+	ctx := context.Background()
+	if err := c.CreateJobAt(ctx, "a", &intData{}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteJob(ctx, "a"); !errors.Is(err, queue.ErrJobNotTerminal) {
+		t.Errorf("got error %v deleting a Scheduled job, want %v", err, queue.ErrJobNotTerminal)
+	}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	rec := &recorder{}
+	c, w := queue.New(rec)
+	createJobs(t, c, map[string]int{"a": 0, "b": 0})
+	ctx := context.Background()
+	if err := c.CancelJob(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if j := waitForJob(t, c, "a"); j.State() != queue.Cancelled {
+		t.Errorf("got state %q, want %q", j.State(), queue.Cancelled)
+	}
+	if n := processAll(t, w); n != 1 {
+		t.Errorf("got %d jobs processed, want only the one not cancelled", n)
+	}
+	if got, want := rec.processed(), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v processed, want %v", got, want)
+	}
+}
+
+func TestCancelScheduledJob(t *testing.T) {
+	c, _ := queue.New(doubler)
+	ctx := context.Background()
+	if err := c.CreateJobAt(ctx, "a", &intData{}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CancelJob(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := c.GetJob(ctx, "a"); j.State() != queue.Cancelled {
+		t.Errorf("got state %q, want %q", j.State(), queue.Cancelled)
+	}
+}
+
+func TestCancelProcessingJob(t *testing.T) {
+	started := make(chan struct{}, 1)
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 3}))
+	createJobs(t, c, map[string]int{"a": 0})
+	defer runWorker(t, w, 1)()
+	<-started
+	if err := c.CancelJob(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	// The processor returning an error does not get the job retried
+	if j := waitForJob(t, c, "a"); j.State() != queue.Cancelled {
+		t.Errorf("got state %q, want %q", j.State(), queue.Cancelled)
+	}
+}
+
+func TestCancelProcessingJobWithTimeout(t *testing.T) {
+	p, started, release, _ := stubborn()
+	defer close(release)
+	c, w := queue.New(p, queue.WithJobTimeout(time.Hour))
+	createJobs(t, c, map[string]int{"a": 0})
+	createJobs(t, c, map[string]int{"b": 0})
+	defer runWorker(t, w, 1)()
+	<-started
+	if err := c.CancelJob(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	// The processor ignoring cancellation is abandoned, so the only worker slot moves on
+	if j := waitForJob(t, c, "a"); j.State() != queue.Cancelled {
+		t.Errorf("got state %q, want %q", j.State(), queue.Cancelled)
+	}
+	if id := <-started; id != "b" {
+		t.Errorf("got job %q started next, want %q", id, "b")
+	}
+}
+
+func TestCancelFinishedJob(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	ctx := context.Background()
+	if err := c.CancelJob(ctx, "a"); !errors.Is(err, queue.ErrJobTerminal) {
+		t.Errorf("got error %v, want %v", err, queue.ErrJobTerminal)
+	}
+	if j, _ := c.GetJob(ctx, "a"); j.State() != queue.Finished {
+		t.Errorf("got state %q, want it left %q", j.State(), queue.Finished)
+	}
+}
+
+func TestCancelMissingJob(t *testing.T) {
+	c, _ := queue.New(doubler)
+	if err := c.CancelJob(context.Background(), "missing"); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v, want %v", err, queue.ErrJobNotFound)
+	}
+}
+
+func TestCreateJobs(t *testing.T) {
+	sink := &queue.MemoryMetricsSink{}
+	rec := &recorder{}
+	c, w := queue.New(rec, queue.WithMetricsSink(sink))
+	ctx := context.Background()
+	createJobs(t, c, map[string]int{"first": 0})
+	specs := []queue.JobSpec{{ID: "a", Data: &intData{N: 1}}, {ID: "b", Data: &intData{N: 2}}, {ID: "c", Data: &intData{N: 3}}}
+	if err := c.CreateJobs(ctx, specs, queue.WithPriority(1)); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := c.ListJobs(ctx, queue.ListFilter{State: queue.Queued})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(jobs), []string{"first", "a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got queued jobs %v, want %v", got, want)
+	}
+	var d intData
+	if err := jobs[2].GetData(&d); err != nil || d.N != 2 {
+		t.Errorf("got job %q data %d and error %v, want 2", jobs[2].ID(), d.N, err)
+	}
+	if got := sink.GaugeValue(queue.MetricQueueDepth); got != 4 {
+		t.Errorf("got queue depth %v, want 4", got)
+	}
+	if got := sink.CounterValue(queue.MetricJobsCreated); got != 4 {
+		t.Errorf("got %s %v, want 4", queue.MetricJobsCreated, got)
+	}
+	processAll(t, w)
+	// The batch was created with a higher priority
+	if got, want := rec.processed(), []string{"a", "b", "c", "first"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v processed, want %v", got, want)
+	}
+}
+
+func TestCreateJobsWithDuplicate(t *testing.T) {
+	ctx := context.Background()
+	for name, specs := range map[string][]queue.JobSpec{
+		"existing ID": {{ID: "a", Data: &intData{}}, {ID: "taken", Data: &intData{}}},
+		"repeated ID": {{ID: "a", Data: &intData{}}, {ID: "b", Data: &intData{}}, {ID: "a", Data: &intData{}}},
+	} {
+		c, _ := queue.New(doubler)
+		createJobs(t, c, map[string]int{"taken": 7})
+		if err := c.CreateJobs(ctx, specs); !errors.Is(err, queue.ErrDuplicateJob) {
+			t.Errorf("%s: got error %v creating the batch, want %v", name, err, queue.ErrDuplicateJob)
+		}
+		jobs, err := c.ListJobs(ctx, queue.ListFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := ids(jobs), []string{"taken"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got jobs %v, want only %v", name, got, want)
+		}
+		var d intData
+		if err := jobs[0].GetData(&d); err != nil || d.N != 7 {
+			t.Errorf("%s: got data %d and error %v for the existing job, want 7", name, d.N, err)
+		}
+	}
+}
+
+func TestCreateJobsRollsBackOnStoreFailure(t *testing.T) {
+	s := &failingStore{}
+	ctx := context.Background()
+	c, w, err := queue.NewWithStore(ctx, s, doubler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.failAfter(2)
+	specs := []queue.JobSpec{{ID: "a", Data: &intData{}}, {ID: "b", Data: &intData{}}, {ID: "c", Data: &intData{}}}
+	if err := c.CreateJobs(ctx, specs); !errors.Is(err, errStoreDown) {
+		t.Errorf("got error %v creating the batch, want %v", err, errStoreDown)
+	}
+	if records, _ := s.List(ctx); len(records) != 0 {
+		t.Errorf("got %d records left in the store, want none", len(records))
+	}
+	if n := processAll(t, w); n != 0 {
+		t.Errorf("got %d jobs processed, want none", n)
+	}
+}
+
+func TestValidateJobs(t *testing.T) {
+	ctx := context.Background()
+	large := &textData{S: strings.Repeat("x", 100)}
+	tests := []struct {
+		name string
+		bad  queue.JobSpec
+		want error
+	}{
+		{name: "existing ID", bad: queue.JobSpec{ID: "taken", Data: &intData{}}, want: queue.ErrDuplicateJob},
+		{name: "repeated ID", bad: queue.JobSpec{ID: "j-1", Data: &intData{}}, want: queue.ErrDuplicateJob},
+		{name: "large payload", bad: queue.JobSpec{ID: "large", Data: large}, want: queue.ErrPayloadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := queue.New(doubler, queue.WithMaxPayloadBytes(50))
+			createJobs(t, c, map[string]int{"taken": 7})
+			batch := specs(10)
+			batch[5] = tt.bad
+			err := c.ValidateJobs(ctx, batch)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v validating the batch, want %v", err, tt.want)
+			}
+			var batchErr *queue.BatchError
+			if !errors.As(err, &batchErr) || batchErr.Index != 5 {
+				t.Errorf("got error %v validating the batch, want one telling spec 5", err)
+			}
+			jobs, err := c.ListJobs(ctx, queue.ListFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := ids(jobs), []string{"taken"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got jobs %v, want only %v", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateJobsStopsAtFirstFailure(t *testing.T) {
+	ctx := context.Background()
+	duplicate := queue.JobSpec{ID: "j-0", Data: &intData{}}
+	large := queue.JobSpec{ID: "large", Data: &textData{S: strings.Repeat("x", 100)}}
+	tests := []struct {
+		name          string
+		first, second queue.JobSpec
+		want          error
+	}{
+		{name: "repeated ID first", first: duplicate, second: large, want: queue.ErrDuplicateJob},
+		{name: "large payload first", first: large, second: duplicate, want: queue.ErrPayloadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := queue.New(doubler, queue.WithMaxPayloadBytes(50))
+			batch := specs(10)
+			batch[3], batch[6] = tt.first, tt.second
+			err := c.ValidateJobs(ctx, batch)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v validating the batch, want %v", err, tt.want)
+			}
+			var batchErr *queue.BatchError
+			if !errors.As(err, &batchErr) || batchErr.Index != 3 {
+				t.Errorf("got error %v validating the batch, want one telling spec 3", err)
+			}
+			if createErr := c.CreateJobs(ctx, batch); createErr == nil || createErr.Error() != err.Error() {
+				t.Errorf("got error %v creating the batch, want %v as when validating it", createErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateJobsCreatesNothing(t *testing.T) {
+	ctx := context.Background()
+	c, w := queue.New(doubler)
+	if err := c.ValidateJobs(ctx, specs(3)); err != nil {
+		t.Fatal(err)
+	}
+	if n := processAll(t, w); n != 0 {
+		t.Errorf("got %d jobs processed after validating a batch, want none", n)
+	}
+	if err := c.CreateJobs(ctx, specs(3)); err != nil {
+		t.Errorf("got error %v creating the validated batch", err)
+	}
+}
+
+// specs returns the specs of n jobs
+func specs(n int) []queue.JobSpec {
+	specs := make([]queue.JobSpec, n)
+	for i := range specs {
+		specs[i] = queue.JobSpec{ID: fmt.Sprintf("j-%d", i), Data: &intData{N: i}}
+	}
+	return specs
+}
+
+func BenchmarkCreateJob(b *testing.B) {
+	ctx := context.Background()
+	specs := specs(10000)
+	for i := 0; i < b.N; i++ {
+		c, _ := queue.New(doubler)
+		for _, spec := range specs {
+			if err := c.CreateJob(ctx, spec.ID, spec.Data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkCreateJobs(b *testing.B) {
+	ctx := context.Background()
+	specs := specs(10000)
+	for i := 0; i < b.N; i++ {
+		c, _ := queue.New(doubler)
+		if err := c.CreateJobs(ctx, specs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestGetJobs(t *testing.T) {
+	c, w := queue.New(doubler)
+	ctx := context.Background()
+	createJobs(t, c, map[string]int{"a": 1, "b": 2, "c": 3})
+	processAll(t, w)
+	jobs, err := c.GetJobs(ctx, []string{"a", "missing", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Errorf("got %d jobs, want 2", len(jobs))
+	}
+	for id, want := range map[string]int{"a": 2, "c": 6} {
+		var d intData
+		if j, ok := jobs[id]; !ok || j.GetData(&d) != nil || d.N != want {
+			t.Errorf("got job %q with data %d, want %d", id, d.N, want)
+		}
+	}
+	if j, ok := jobs["missing"]; ok {
+		t.Errorf("got job %v for a missing ID, want none", j)
+	}
+}
+
+// loggingStore is a Store that logs the loads and saves made to it
+type loggingStore struct {
+	queue.MemoryStore
+	mu  sync.Mutex
+	log []string
+}
+
+func (s *loggingStore) append(entry string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = append(s.log, entry)
+}
+
+func (s *loggingStore) Load(ctx context.Context, id string) (queue.JobRecord, error) {
+	s.append("load " + id)
+	return s.MemoryStore.Load(ctx, id)
+}
+
+func (s *loggingStore) Save(ctx context.Context, r queue.JobRecord) error {
+	s.append("save " + r.ID)
+	return s.MemoryStore.Save(ctx, r)
+}
+
+func (s *loggingStore) logged() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.log...)
+}
+
+func TestGetJobsTakesLockOnce(t *testing.T) {
+	s := &loggingStore{}
+	ctx := context.Background()
+	c, _, err := queue.NewWithStore(ctx, s, doubler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 200)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("j-%d", i)
+		if err := c.CreateJob(ctx, ids[i], &intData{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Jobs created meanwhile cannot get in between the loads
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			c.CreateJob(ctx, fmt.Sprintf("other-%d", i), &intData{})
+		}
+	}()
+	jobs, err := c.GetJobs(ctx, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if len(jobs) != len(ids) {
+		t.Errorf("got %d jobs, want %d", len(jobs), len(ids))
+	}
+	logged := s.logged()
+	first := -1
+	for i, entry := range logged {
+		if entry == "load j-0" {
+			first = i
+			break
+		}
+	}
+	if first < 0 || len(logged) < first+len(ids) {
+		t.Fatalf("got store log %q, want the loads of the jobs", logged)
+	}
+	for i, id := range ids {
+		if got := logged[first+i]; got != "load "+id {
+			t.Errorf("got %q as store call %d of GetJobs, want %q", got, i, "load "+id)
+			break
+		}
+	}
+}
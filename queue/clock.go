@@ -0,0 +1,32 @@
+package queue
+
+import "time"
+
+// clock tells the time and sets off timers for the queue, so that the
+// tests can make time pass at will rather than by sleeping. The queue
+// goes through it for what happens at given times: Scheduled jobs to
+// be Queued, retries to be Queued again, priority aging, deadlines,
+// heartbeats and the reaper, the expiry of terminal jobs, the stall
+// detector, the periods of the summaries and the accounting of the time
+// of the worker goroutines.
+type clock interface {
+	now() time.Time
+	afterFunc(d time.Duration, f func()) timer
+}
+
+// timer is a timer set off by a clock, like a *time.Timer
+type timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// realClock is the clock of package time, the one used by default
+type realClock struct{}
+
+func (realClock) now() time.Time {
+	return time.Now()
+}
+
+func (realClock) afterFunc(d time.Duration, f func()) timer {
+	return time.AfterFunc(d, f)
+}
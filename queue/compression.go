@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Compression is a way of compressing the payloads of the jobs in the store
+type Compression int
+
+const (
+	// NoCompression stores the payloads as marshaled, which is the default
+	NoCompression Compression = iota
+	// Gzip compresses the payloads with gzip
+	Gzip
+)
+
+// WithCompression makes the queue compress the payloads of the jobs
+// before storing them, and decompress them before they are unmarshaled,
+// so it makes no difference to the payloads themselves. Payloads that
+// do not get smaller, like already compressed ones, are stored as they
+// are. A store keeping compressed payloads must always be used with
+// the same compression.
+func WithCompression(c Compression) Option {
+	return func(cfg *config) {
+		cfg.compression = c
+	}
+}
+
+// Headers of the compressed payloads, telling how they are compressed
+const (
+	uncompressedPayload byte = iota
+	gzipPayload
+)
+
+// compress returns b compressed with c, behind a header telling
+// how it is compressed. Empty payloads are kept empty.
+func compress(c Compression, b []byte) ([]byte, error) {
+	if c == NoCompression || len(b) == 0 {
+		return b, nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(gzipPayload)
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() > len(b) {
+		return append([]byte{uncompressedPayload}, b...), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress returns b, compressed with c by compress, decompressed
+func decompress(c Compression, b []byte) ([]byte, error) {
+	if c == NoCompression || len(b) == 0 {
+		return b, nil
+	}
+	switch b[0] {
+	case uncompressedPayload:
+		return b[1:], nil
+	case gzipPayload:
+		zr, err := gzip.NewReader(bytes.NewReader(b[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress payload: %w", err)
+		}
+		defer zr.Close()
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress payload: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("cannot decompress payload with unknown header %d", b[0])
+	}
+}
@@ -0,0 +1,127 @@
+package queue_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// emptyData is a job payload marshaled to no bytes at all
+type emptyData struct{}
+
+func (emptyData) Marshal() ([]byte, error) {
+	return nil, nil
+}
+
+func (emptyData) Unmarshal(b []byte) error {
+	if len(b) != 0 {
+		return fmt.Errorf("got %d bytes for an empty payload", len(b))
+	}
+	return nil
+}
+
+// storedSize returns the size of the payload of the given job in s
+func storedSize(t *testing.T, s queue.Store, id string) int {
+	t.Helper()
+	r, err := s.Load(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(r.Data)
+}
+
+// checkText checks that the job with the given ID has want as payload
+func checkText(t *testing.T, c queue.Client, id string, want string) {
+	t.Helper()
+	j, err := c.GetJob(context.Background(), id)
+	if err != nil || j == nil {
+		t.Fatalf("getting job %q: got %v, %v", id, j, err)
+	}
+	var d textData
+	if err := j.GetData(&d); err != nil {
+		t.Fatalf("getting the payload of job %q: %v", id, err)
+	}
+	if d.S != want {
+		t.Errorf("got payload %.20q... of %d characters for job %q, want %.20q... of %d", d.S, len(d.S), id, want, len(want))
+	}
+}
+
+func TestCompressedPayloadRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := &queue.MemoryStore{}
+	c, _, err := queue.NewWithStore(ctx, s, doubler, queue.WithCompression(queue.Gzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressible := strings.Repeat("pi = 3.14159 ", 1000)
+	random := make([]byte, 1000)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	incompressible := base64.StdEncoding.EncodeToString(random)
+	for id, text := range map[string]string{"compressible": compressible, "incompressible": incompressible, "short": "x"} {
+		if err := c.CreateJob(ctx, id, &textData{S: text}); err != nil {
+			t.Fatal(err)
+		}
+		checkText(t, c, id, text)
+	}
+
+	marshaled, _ := (&textData{S: compressible}).Marshal()
+	if got := storedSize(t, s, "compressible"); got >= len(marshaled)/10 {
+		t.Errorf("got %d bytes stored for a compressible payload of %d, want far less", got, len(marshaled))
+	}
+	marshaled, _ = (&textData{S: incompressible}).Marshal()
+	if got, want := storedSize(t, s, "incompressible"), len(marshaled)+1; got > want {
+		t.Errorf("got %d bytes stored for an incompressible payload of %d, want at most %d", got, len(marshaled), want)
+	}
+}
+
+func TestCompressedEmptyPayload(t *testing.T) {
+	s := &queue.MemoryStore{}
+	c, _, err := queue.NewWithStore(context.Background(), s, doubler, queue.WithCompression(queue.Gzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateJob(context.Background(), "a", emptyData{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedSize(t, s, "a"); got != 0 {
+		t.Errorf("got %d bytes stored for an empty payload, want 0", got)
+	}
+	j, _ := c.GetJob(context.Background(), "a")
+	if err := j.GetData(emptyData{}); err != nil {
+		t.Errorf("got error %v getting an empty payload, want none", err)
+	}
+}
+
+func TestCompressedPayloadSetByProcessor(t *testing.T) {
+	result := strings.Repeat("done ", 1000)
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		var d textData
+		if err := j.GetData(&d); err != nil {
+			return err
+		}
+		return j.SetData(ctx, &textData{S: d.S + result})
+	})
+	s := &queue.MemoryStore{}
+	c, w, err := queue.NewWithStore(context.Background(), s, p, queue.WithCompression(queue.Gzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateJob(context.Background(), "a", &textData{S: "start "}); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Fatalf("got job %s (%s), want %s", j.State(), j.Error(), queue.Finished)
+	}
+	checkText(t, c, "a", "start "+result)
+	if got := storedSize(t, s, "a"); got >= len(result)/10 {
+		t.Errorf("got %d bytes stored for the payload set by the processor, want far less than %d", got, len(result))
+	}
+}
@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"runtime"
+	"time"
+)
+
+// cpuSampleInterval is how often the CPU usage of the process
+// is sampled, with a CPU target
+const cpuSampleInterval = 100 * time.Millisecond
+
+// WithCPUTarget keeps the CPU usage of the process around fraction of
+// GOMAXPROCS, so that CPU-bound jobs do not starve the other goroutines
+// of the process, such as those serving health checks, whatever the
+// number of worker goroutines. The usage is sampled every 100ms while
+// workers run: when it is over the target, fewer jobs are processed at
+// once, the worker goroutines waiting for the others to be done with
+// theirs before they take a job, and when it is under, more again, up
+// to all of them. At least one job is processed at a time, and jobs
+// being processed are never interrupted, so short jobs make the usage
+// follow the target more closely than long ones. By default, the CPU
+// usage is not watched.
+func WithCPUTarget(fraction float64) Option {
+	return func(c *config) {
+		c.cpuTarget = fraction
+	}
+}
+
+// armCPUSampler sets off the timer sampling the CPU usage, if there
+// is a CPU target and it is not set off already. mu must be held by
+// the caller.
+func (q *memoryQueue) armCPUSampler() {
+	if q.cpuTarget <= 0 || q.cpuSampling {
+		return
+	}
+	q.cpuSampling = true
+	q.lastCPU, q.lastCPUAt = q.cpuTime(), q.clock.now()
+	if q.cpuTimer == nil {
+		q.cpuTimer = q.clock.afterFunc(cpuSampleInterval, q.sampleCPU)
+		return
+	}
+	q.cpuTimer.Reset(cpuSampleInterval)
+}
+
+// sampleCPU measures the CPU usage since the last sample and adjusts
+// the number of jobs processed at once to it, and sets off the timer
+// again as long as there are worker goroutines. Over the target, the
+// limit is scaled down with the usage, from the jobs being processed,
+// and under it, it goes up by one, until it is lifted once it reaches
+// the number of worker goroutines.
+func (q *memoryQueue) sampleCPU() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.cpuSampling = false
+	cpu, at := q.cpuTime(), q.clock.now()
+	if elapsed := at.Sub(q.lastCPUAt); elapsed > 0 {
+		usage := float64(cpu-q.lastCPU) / (float64(elapsed) * float64(runtime.GOMAXPROCS(0)))
+		switch {
+		case usage > q.cpuTarget:
+			q.cpuLimit = max(1, int(float64(max(q.processing, 1))*q.cpuTarget/usage))
+		case q.cpuLimit > 0:
+			q.cpuLimit++
+			if q.cpuLimit >= q.workers {
+				q.cpuLimit = 0
+			}
+			q.wake()
+		}
+	}
+	if q.workers > 0 {
+		q.armCPUSampler()
+	}
+}
+
+// cpuThrottled tells whether no more jobs are to be processed until
+// some being processed are done, to keep the CPU usage to the target.
+// mu must be held by the caller.
+func (q *memoryQueue) cpuThrottled() bool {
+	return q.cpuLimit > 0 && q.processing+q.dispatching >= q.cpuLimit
+}
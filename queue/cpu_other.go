@@ -0,0 +1,27 @@
+//go:build !unix
+
+package queue
+
+import (
+	"runtime/metrics"
+	"time"
+)
+
+// cpuMetrics are the runtime metrics telling the CPU time of the process
+var cpuMetrics = []string{"/cpu/classes/total:cpu-seconds", "/cpu/classes/idle:cpu-seconds"}
+
+// processCPUTime returns the CPU time the process used so far, as
+// estimated by the runtime: the time its Ps were not idle. The runtime
+// only updates it now and then, so the usage is sampled coarsely.
+func processCPUTime() time.Duration {
+	samples := make([]metrics.Sample, len(cpuMetrics))
+	for i, name := range cpuMetrics {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindFloat64 || samples[1].Value.Kind() != metrics.KindFloat64 {
+		return 0
+	}
+	busy := samples[0].Value.Float64() - samples[1].Value.Float64()
+	return time.Duration(busy * float64(time.Second))
+}
@@ -0,0 +1,103 @@
+package queue_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// cpuSampleInterval is how often the queue samples the CPU usage
+const cpuSampleInterval = 100 * time.Millisecond
+
+func TestCPUTargetLimitsJobsAtOnce(t *testing.T) {
+	var mu sync.Mutex
+	var running int
+	var seen []int
+	started, proceed := make(chan string, 10), make(chan struct{})
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		mu.Lock()
+		running++
+		seen = append(seen, running)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		started <- j.ID()
+		<-proceed
+		return nil
+	})
+	clock := queue.NewFakeClock()
+	var cpu atomic.Int64
+	c, w := queue.New(p,
+		queue.WithCPUTarget(0.5),
+		queue.WithFakeClock(clock),
+		queue.WithFakeCPU(func() time.Duration { return time.Duration(cpu.Load()) }),
+	)
+	payloads := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		payloads[fmt.Sprint(i)] = i
+	}
+	createJobs(t, c, payloads)
+	defer runWorker(t, w, 4)()
+	waitForStarts(t, started, 4)
+
+	// All the CPU is used, twice the target, so half as many jobs go on
+	cpu.Add(int64(cpuSampleInterval) * int64(runtime.GOMAXPROCS(0)))
+	clock.Advance(cpuSampleInterval)
+	for i := 0; i < 3; i++ {
+		proceed <- struct{}{}
+	}
+	waitForStarts(t, started, 1)
+	// No CPU is used, so one more job goes on
+	clock.Advance(cpuSampleInterval)
+	waitForStarts(t, started, 1)
+	close(proceed)
+	for id := range payloads {
+		waitForJob(t, c, id)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if seen[4] > 2 || seen[5] > 3 {
+		t.Errorf("got %d and then %d jobs processed at once, want at most 2 and then 3", seen[4], seen[5])
+	}
+}
+
+func TestCPUTargetKeepsGoroutineResponsive(t *testing.T) {
+	spin := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		for end := time.Now().Add(5 * time.Millisecond); time.Now().Before(end); {
+		}
+		return nil
+	})
+	c, w := queue.New(spin, queue.WithCPUTarget(0.5))
+	const jobs = 200
+	payloads := make(map[string]int)
+	for i := 0; i < jobs; i++ {
+		payloads[fmt.Sprint(i)] = i
+	}
+	createJobs(t, c, payloads)
+	defer runWorker(t, w, 2*runtime.GOMAXPROCS(0))()
+
+	// A goroutine beside the workers, like one serving health checks,
+	// gets to run every millisecond or so while the jobs are processed
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var worst time.Duration
+	for last := time.Now(); ctx.Err() == nil; {
+		time.Sleep(time.Millisecond)
+		now := time.Now()
+		worst = max(worst, now.Sub(last))
+		last = now
+	}
+	if worst > 100*time.Millisecond {
+		t.Errorf("got a goroutine waiting %s to run beside the workers, want at most 100ms", worst)
+	}
+}
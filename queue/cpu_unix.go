@@ -0,0 +1,18 @@
+//go:build unix
+
+package queue
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime returns the CPU time the process used so far,
+// in user and system mode, as told by the system
+func processCPUTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
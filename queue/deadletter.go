@@ -0,0 +1,44 @@
+package queue
+
+import "time"
+
+// WithDeadLetterRetention makes the queue remove the Failed jobs, which
+// are dead letters as no attempt is left to them, once they have been
+// Failed for d, like WithResultTTL does, so that they do not pile up.
+// It applies to them instead of the result TTL, if any, which then only
+// applies to Finished and Cancelled jobs.
+func WithDeadLetterRetention(d time.Duration) Option {
+	return func(c *config) {
+		c.deadLetterTTL = d
+	}
+}
+
+// WithDeadLetterAlert makes the queue call cb with the number of Failed
+// jobs when it reaches threshold, as a growing number of them signals
+// trouble. cb is called once each time the number goes from below the
+// threshold to the threshold, so it is called again only after jobs are
+// deleted or expire, like WithDeadLetterRetention makes them. It is
+// called from a goroutine of its own, not from the workers.
+func WithDeadLetterAlert(threshold int, cb func(count int)) Option {
+	return func(c *config) {
+		c.deadLetterAlert = threshold
+		c.onDeadLetters = cb
+	}
+}
+
+// watchDeadLetters calls the dead-letter alert, if there is one, when
+// the number of Failed jobs has reached its threshold, and re-arms it
+// when the number drops below. mu must be held by the caller.
+func (q *memoryQueue) watchDeadLetters() {
+	if q.onDeadLetters == nil {
+		return
+	}
+	n := q.counts[Failed]
+	switch {
+	case n < q.deadLetterAlert:
+		q.deadLettersAlerted = false
+	case !q.deadLettersAlerted:
+		q.deadLettersAlerted = true
+		go q.onDeadLetters(n)
+	}
+}
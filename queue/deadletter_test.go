@@ -0,0 +1,87 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestDeadLetterRetentionPurgesFailedJob(t *testing.T) {
+	clock := queue.NewFakeClock()
+	c, w := queue.New(doubler, queue.WithDeadLetterRetention(resultTTL), queue.WithFakeClock(clock))
+	createJobs(t, c, map[string]int{"good": 1, "bad": -1})
+	processAll(t, w)
+	if j, err := c.GetJob(context.Background(), "bad"); err != nil || j == nil || j.State() != queue.Failed {
+		t.Fatalf("got job %v and error %v right after it failed, want it %s", j, err, queue.Failed)
+	}
+
+	clock.Advance(resultTTL - time.Nanosecond)
+	checkExpired(t, c, "bad", false)
+	clock.Advance(time.Nanosecond)
+	checkExpired(t, c, "bad", true)
+	if j, err := c.GetJob(context.Background(), "good"); err != nil || j == nil || j.State() != queue.Finished {
+		t.Errorf("got job %v and error %v, want it kept %s without a result TTL", j, err, queue.Finished)
+	}
+}
+
+func TestDeadLetterRetentionOverridesResultTTL(t *testing.T) {
+	clock := queue.NewFakeClock()
+	c, w := queue.New(doubler,
+		queue.WithResultTTL(resultTTL), queue.WithDeadLetterRetention(time.Hour), queue.WithFakeClock(clock))
+	createJobs(t, c, map[string]int{"good": 1, "bad": -1})
+	processAll(t, w)
+
+	clock.Advance(resultTTL)
+	checkExpired(t, c, "good", true)
+	if j, err := c.GetJob(context.Background(), "bad"); err != nil || j == nil || j.State() != queue.Failed {
+		t.Errorf("got job %v and error %v, want it kept %s for its retention", j, err, queue.Failed)
+	}
+	clock.Advance(time.Hour)
+	checkExpired(t, c, "bad", true)
+}
+
+func TestDeadLetterAlert(t *testing.T) {
+	alerts := make(chan int, 10)
+	c, w := queue.New(doubler,
+		queue.WithDeadLetterAlert(2, func(count int) { alerts <- count }))
+	checkAlert := func(want int) {
+		t.Helper()
+		select {
+		case got := <-alerts:
+			if got != want {
+				t.Errorf("got an alert with %d dead letters, want %d", got, want)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("got no alert with %d dead letters after %s", want, testTimeout)
+		}
+	}
+	checkNoAlert := func() {
+		t.Helper()
+		select {
+		case got := <-alerts:
+			t.Errorf("got an alert with %d dead letters, want none", got)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	createJobs(t, c, map[string]int{"bad1": -1, "good": 1})
+	processAll(t, w)
+	checkNoAlert()
+	createJobs(t, c, map[string]int{"bad2": -1})
+	processAll(t, w)
+	checkAlert(2)
+	createJobs(t, c, map[string]int{"bad3": -1})
+	processAll(t, w)
+	checkNoAlert()
+
+	for _, id := range []string{"bad1", "bad2"} {
+		if err := c.DeleteJob(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createJobs(t, c, map[string]int{"bad4": -1})
+	processAll(t, w)
+	checkAlert(2)
+}
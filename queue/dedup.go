@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// WithDedup makes a job deduplicated by its payload: if a job with the
+// same marshaled initial data is already Scheduled, Queued or Processing
+// in the same named queue, no job is created, and the ID given for the new
+// one becomes an alias of the existing job instead. GetJob, WaitForJob,
+// Subscribe and CancelJob then resolve the alias to that job, whose own ID
+// is the one reported by the Job they return.
+//
+// Once the existing job reaches a terminal state, jobs with the same
+// payload are created again. The alias is kept until it is deleted with
+// DeleteJob, or until the existing job is deleted. Aliases are kept in
+// memory only, so they do not survive a restart, unlike deduplication
+// itself, which keeps working for the restored jobs.
+func WithDedup() JobOption {
+	return func(o *jobOptions) {
+		o.dedup = true
+	}
+}
+
+// WithDedupKey is like WithDedup, but deduplicates jobs by the given key
+// instead of by their payload, so that jobs whose payloads differ in
+// irrelevant ways can still be told to be the same.
+func WithDedupKey(key string) JobOption {
+	return func(o *jobOptions) {
+		o.dedup = true
+		o.dedupKey = key
+	}
+}
+
+// dedupKey returns the deduplication key of a job with the given
+// options and payload in the named queue, or "" if it has none.
+func dedupKey(o jobOptions, queueName string, data []byte) string {
+	if !o.dedup {
+		return ""
+	}
+	key := o.dedupKey
+	if key == "" {
+		sum := sha256.Sum256(data)
+		key = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return queueName + "\x00" + key
+}
+
+// taken tells whether there is a job or an alias with the given ID.
+// mu must be held by the caller.
+func (q *memoryQueue) taken(id string) bool {
+	_, isJob := q.live[id]
+	_, isAlias := q.aliases[id]
+	return isJob || isAlias
+}
+
+// resolve returns the ID of the job the given ID, which may be
+// an alias, refers to. mu must be held by the caller.
+func (q *memoryQueue) resolve(id string) string {
+	if original, ok := q.aliases[id]; ok {
+		return original
+	}
+	return id
+}
+
+// add starts keeping in memory the job with the given record,
+// indexing it by its deduplication key while it is not terminal.
+// mu must be held by the caller.
+func (q *memoryQueue) add(r JobRecord) *liveJob {
+	l := &liveJob{id: r.ID, done: make(chan struct{}), key: r.Key, dedupKey: r.DedupKey}
+	q.live[r.ID] = l
+	q.count(l, r.State)
+	if r.DedupKey != "" && !isTerminal(r.State) {
+		q.dedup[r.DedupKey] = r.ID
+	}
+	return l
+}
+
+// forget stops keeping in memory the job with the given ID, and drops
+// its aliases. mu must be held by the caller.
+func (q *memoryQueue) forget(id string) {
+	if l, ok := q.live[id]; ok {
+		q.counts[l.state]--
+		q.watchDeadLetters()
+	}
+	delete(q.live, id)
+	for alias, original := range q.aliases {
+		if original == id {
+			delete(q.aliases, alias)
+		}
+	}
+}
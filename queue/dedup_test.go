@@ -0,0 +1,147 @@
+package queue_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// createDeduped creates jobs deduplicated by their payload
+func createDeduped(t *testing.T, c queue.Client, ids []string, n int, opts ...queue.JobOption) {
+	t.Helper()
+	for _, id := range ids {
+		if err := c.CreateJob(context.Background(), id, &intData{N: n}, append(opts, queue.WithDedup())...); err != nil {
+			t.Fatalf("creating job %q: %v", id, err)
+		}
+	}
+}
+
+func TestDedupIdenticalPayloads(t *testing.T) {
+	rec := &recorder{}
+	c, w := queue.New(rec)
+	ctx := context.Background()
+	createDeduped(t, c, []string{"a", "b"}, 1)
+	createDeduped(t, c, []string{"c"}, 2)
+	processAll(t, w)
+	if got, want := rec.processed(), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v processed, want %v", got, want)
+	}
+	for _, id := range []string{"a", "b"} {
+		j, err := c.GetJob(ctx, id)
+		if err != nil || j == nil || j.ID() != "a" {
+			t.Errorf("got job %v and error %v looking up %q, want job a", j, err, id)
+		}
+	}
+	if j := waitForJob(t, c, "b"); j.ID() != "a" || j.State() != queue.Finished {
+		t.Errorf("got job %q %s waiting for b, want job a %s", j.ID(), j.State(), queue.Finished)
+	}
+	jobs, err := c.ListJobs(ctx, queue.ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(jobs), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v, want %v", got, want)
+	}
+}
+
+func TestDedupAfterOriginalCompletes(t *testing.T) {
+	rec := &recorder{}
+	c, w := queue.New(rec)
+	createDeduped(t, c, []string{"a"}, 1)
+	processAll(t, w)
+	createDeduped(t, c, []string{"b"}, 1)
+	processAll(t, w)
+	if got, want := rec.processed(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v processed, want %v", got, want)
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	rec := &recorder{}
+	c, w := queue.New(rec)
+	ctx := context.Background()
+	for i, id := range []string{"a", "b"} {
+		if err := c.CreateJob(ctx, id, &intData{N: i}, queue.WithDedupKey("report")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Jobs with the same payload but another key, or in another queue, are not the same
+	createDeduped(t, c, []string{"c"}, 0)
+	if err := c.CreateJobInQueue(ctx, "other", "d", &intData{}, queue.WithDedupKey("report")); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	if got, want := rec.processed(), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v processed, want %v", got, want)
+	}
+	if j, _ := c.GetJob(ctx, "d"); j == nil || j.ID() != "d" {
+		t.Errorf("got job %v for the job in the other queue, want d", j)
+	}
+}
+
+func TestDedupInBatch(t *testing.T) {
+	rec := &recorder{}
+	c, w := queue.New(rec)
+	ctx := context.Background()
+	createDeduped(t, c, []string{"a"}, 1)
+	specs := []queue.JobSpec{{ID: "b", Data: &intData{N: 1}}, {ID: "c", Data: &intData{N: 2}}, {ID: "d", Data: &intData{N: 2}}}
+	if err := c.CreateJobs(ctx, specs, queue.WithDedup()); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	if got, want := rec.processed(), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v processed, want %v", got, want)
+	}
+	for alias, want := range map[string]string{"b": "a", "d": "c"} {
+		if j, _ := c.GetJob(ctx, alias); j == nil || j.ID() != want {
+			t.Errorf("got job %v looking up %q, want %q", j, alias, want)
+		}
+	}
+}
+
+func TestDeleteDedupedJob(t *testing.T) {
+	c, w := queue.New(doubler)
+	ctx := context.Background()
+	createDeduped(t, c, []string{"a", "b", "c"}, 1)
+	processAll(t, w)
+	if err := c.DeleteJob(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := c.GetJob(ctx, "b"); j != nil {
+		t.Errorf("got job %q looking up a deleted alias", j.ID())
+	}
+	if j, _ := c.GetJob(ctx, "a"); j == nil {
+		t.Error("got no job a after deleting one of its aliases")
+	}
+	if err := c.DeleteJob(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := c.GetJob(ctx, "c"); j != nil {
+		t.Errorf("got job %q looking up an alias of a deleted job", j.ID())
+	}
+	// The IDs of the aliases can be used again
+	createJobs(t, c, map[string]int{"b": 2, "c": 3})
+}
+
+func TestDedupSurvivesRestart(t *testing.T) {
+	s := &queue.MemoryStore{}
+	ctx := context.Background()
+	c, _, err := queue.NewWithStore(ctx, s, doubler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createDeduped(t, c, []string{"a"}, 1)
+
+	rec := &recorder{}
+	c, w, err := queue.NewWithStore(ctx, s, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createDeduped(t, c, []string{"b"}, 1)
+	processAll(t, w)
+	if got, want := rec.processed(), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v processed, want %v", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// watcher returns a processor that starts a goroutine watching the Done
+// channel of its job, sending the job's ID to done when it gets closed,
+// and returns once it does. started gets the ID of each job it starts.
+func watcher() (p queue.Processor, started, done chan string) {
+	started, done = make(chan string, 1), make(chan string, 1)
+	p = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		watched := make(chan struct{})
+		go func() {
+			<-j.Done()
+			done <- j.ID()
+			close(watched)
+		}()
+		started <- j.ID()
+		<-watched
+		return ctx.Err()
+	})
+	return p, started, done
+}
+
+// waitForDone waits for done to get the ID of the given job
+func waitForDone(t *testing.T, done chan string, id string) {
+	t.Helper()
+	select {
+	case got := <-done:
+		if got != id {
+			t.Errorf("got Done closed for job %q, want %q", got, id)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("Done was not closed for job %q", id)
+	}
+}
+
+func TestDoneClosedOnCancel(t *testing.T) {
+	p, started, done := watcher()
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	defer runWorker(t, w, 1)()
+	<-started
+	if err := c.CancelJob(context.Background(), "a"); err != nil {
+		t.Fatalf("cancelling job: %v", err)
+	}
+	waitForDone(t, done, "a")
+	if j := waitForJob(t, c, "a"); j.State() != queue.Cancelled {
+		t.Errorf("got state %q, want %q", j.State(), queue.Cancelled)
+	}
+}
+
+func TestDoneClosedOnTimeout(t *testing.T) {
+	p, started, done := watcher()
+	c, w := queue.New(p, queue.WithJobTimeout(10*time.Millisecond))
+	createJobs(t, c, map[string]int{"a": 0})
+	defer runWorker(t, w, 1)()
+	<-started
+	waitForDone(t, done, "a")
+	if j := waitForJob(t, c, "a"); j.FailureCategory() != queue.FailureTimeout {
+		t.Errorf("got failure category %q, want %q", j.FailureCategory(), queue.FailureTimeout)
+	}
+}
+
+func TestDoneOpenWhileProcessing(t *testing.T) {
+	var open bool
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		select {
+		case <-j.Done():
+		default:
+			open = true
+		}
+		return nil
+	})
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	processAll(t, w)
+	if !open {
+		t.Error("got Done closed while the job was processed")
+	}
+}
@@ -0,0 +1,90 @@
+package queue
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// WithEncryption makes the queue encrypt the payloads of the jobs with
+// AES-GCM before storing them, and decrypt them before they are
+// unmarshaled. key is the AES key the payloads are encrypted with, of 16,
+// 24 or 32 bytes. The payloads that do not decrypt with key are decrypted
+// with oldKeys, in order, so that the key can be rotated: jobs stored
+// with the old key stay readable after a new key is given, and the
+// old key can be dropped once they are gone. If a key is invalid,
+// every operation storing or reading a payload fails.
+func WithEncryption(key []byte, oldKeys ...[]byte) Option {
+	return func(c *config) {
+		c.encryptionKeys = append([][]byte{key}, oldKeys...)
+	}
+}
+
+// encryption encrypts and decrypts the payloads of the jobs.
+// The payloads are encrypted with the first of aeads and
+// decrypted with the first of them that can. err is the error
+// that was met setting them up from the keys, if any.
+type encryption struct {
+	aeads []cipher.AEAD
+	err   error
+}
+
+// newEncryption returns the encryption with the given keys,
+// or nil if there are none.
+func newEncryption(keys [][]byte) *encryption {
+	if len(keys) == 0 {
+		return nil
+	}
+	e := &encryption{}
+	for i, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err == nil {
+			var aead cipher.AEAD
+			aead, err = cipher.NewGCM(block)
+			e.aeads = append(e.aeads, aead)
+		}
+		if err != nil {
+			e.err = fmt.Errorf("invalid encryption key %d: %w", i, err)
+			return e
+		}
+	}
+	return e
+}
+
+// seal returns b encrypted with a random nonce, which it starts with
+func (e *encryption) seal(b []byte) ([]byte, error) {
+	if e == nil {
+		return b, nil
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	aead := e.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(b)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("cannot encrypt payload: %w", err)
+	}
+	return aead.Seal(nonce, nonce, b, nil), nil
+}
+
+// open returns b, encrypted by seal, decrypted. It fails with an
+// error wrapping ErrDecryptionFailed if no key can decrypt it.
+func (e *encryption) open(b []byte) ([]byte, error) {
+	if e == nil {
+		return b, nil
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	for _, aead := range e.aeads {
+		if len(b) < aead.NonceSize() {
+			break
+		}
+		nonce, sealed := b[:aead.NonceSize()], b[aead.NonceSize():]
+		if data, err := aead.Open(nil, nonce, sealed, nil); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("payload of %d bytes: %w", len(b), ErrDecryptionFailed)
+}
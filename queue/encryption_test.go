@@ -0,0 +1,94 @@
+package queue_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+var (
+	encryptionKey    = bytes.Repeat([]byte{1}, 32)
+	oldEncryptionKey = bytes.Repeat([]byte{2}, 32)
+)
+
+const secret = "the launch code is 0000"
+
+// createSecret creates a job with secret as payload in a queue keeping
+// its jobs in s, configured by opts
+func createSecret(t *testing.T, s queue.Store, opts ...queue.Option) queue.Client {
+	t.Helper()
+	c, _, err := queue.NewWithStore(context.Background(), s, doubler, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateJob(context.Background(), "a", &textData{S: secret}); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// reopen returns a client of a queue picking up the jobs in s, configured by opts
+func reopen(t *testing.T, s queue.Store, opts ...queue.Option) queue.Client {
+	t.Helper()
+	c, _, err := queue.NewWithStore(context.Background(), s, doubler, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEncryptedPayloadRoundTrip(t *testing.T) {
+	s := &queue.MemoryStore{}
+	c := createSecret(t, s, queue.WithEncryption(encryptionKey))
+	checkText(t, c, "a", secret)
+
+	r, err := s.Load(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(r.Data, []byte(secret)) {
+		t.Errorf("got payload %q stored in plaintext", r.Data)
+	}
+}
+
+func TestEncryptedAndCompressedPayload(t *testing.T) {
+	s := &queue.MemoryStore{}
+	c := createSecret(t, s, queue.WithEncryption(encryptionKey), queue.WithCompression(queue.Gzip))
+	checkText(t, c, "a", secret)
+	checkText(t, reopen(t, s, queue.WithCompression(queue.Gzip), queue.WithEncryption(encryptionKey)), "a", secret)
+}
+
+func TestEncryptedPayloadWithWrongKey(t *testing.T) {
+	s := &queue.MemoryStore{}
+	createSecret(t, s, queue.WithEncryption(encryptionKey))
+	j, err := reopen(t, s, queue.WithEncryption(oldEncryptionKey)).GetJob(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d textData
+	if err := j.GetData(&d); !errors.Is(err, queue.ErrDecryptionFailed) {
+		t.Errorf("got error %v getting the payload with the wrong key, want %v", err, queue.ErrDecryptionFailed)
+	}
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	s := &queue.MemoryStore{}
+	createSecret(t, s, queue.WithEncryption(oldEncryptionKey))
+	c := reopen(t, s, queue.WithEncryption(encryptionKey, oldEncryptionKey))
+	checkText(t, c, "a", secret)
+	if err := c.CreateJob(context.Background(), "b", &textData{S: secret}); err != nil {
+		t.Fatal(err)
+	}
+	// New payloads are encrypted with the new key only
+	checkText(t, reopen(t, s, queue.WithEncryption(encryptionKey)), "b", secret)
+}
+
+func TestInvalidEncryptionKey(t *testing.T) {
+	c, _ := queue.New(doubler, queue.WithEncryption([]byte("short")))
+	if err := c.CreateJob(context.Background(), "a", &textData{S: secret}); err == nil {
+		t.Error("got no error creating a job with an invalid encryption key")
+	}
+}
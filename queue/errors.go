@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrJobNotFound is returned by the operations that need
+// an existing job when there is no job with the given ID.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobNotTerminal is returned by the operations that can only
+// act on jobs that are either Finished, Failed or Cancelled,
+// when the job is still Scheduled, Queued or Processing.
+var ErrJobNotTerminal = errors.New("job is still scheduled, queued or processing")
+
+// ErrJobTerminal is returned by the operations that can only act
+// on jobs that are not done yet, when the job is already either
+// Finished, Failed or Cancelled.
+var ErrJobTerminal = errors.New("job is already finished, failed or cancelled")
+
+// ErrDuplicateJob is returned by the operations creating jobs
+// when there is already a job with the given ID, whatever its state.
+var ErrDuplicateJob = errors.New("job already exists")
+
+// ErrDeadlineBeforeStart is the error with which a job created
+// WithDeadline fails when it is dispatched past its deadline.
+var ErrDeadlineBeforeStart = errors.New("deadline exceeded before start")
+
+// ErrPayloadTooLarge is returned by the operations storing the payload
+// of a job when it is larger than WithMaxPayloadBytes allows.
+var ErrPayloadTooLarge = errors.New("payload is too large")
+
+// ErrDecryptionFailed is returned by the operations reading the payload
+// of a job when none of the keys given to WithEncryption can decrypt it.
+var ErrDecryptionFailed = errors.New("cannot decrypt payload")
+
+// ErrChecksumMismatch is returned when reading the payload of a job
+// WithChecksumVerification whose checksum does not match, as it got
+// corrupted in the store.
+var ErrChecksumMismatch = errors.New("payload checksum mismatch")
+
+// ErrJobFailed is returned by WaitForJobs WithFailFast
+// when one of the jobs it waits for is Failed.
+var ErrJobFailed = errors.New("job failed")
+
+// ErrQueueFull is returned by MoveJob when the queue already
+// has as many Queued jobs as WithMaxQueueDepth allows.
+var ErrQueueFull = errors.New("queue is full")
+
+// ErrJobNotScheduled is returned by Reschedule when the
+// job is not Scheduled anymore, or never was.
+var ErrJobNotScheduled = errors.New("job is not scheduled")
+
+// ErrTooManyScheduled is returned when creating a job to be Queued
+// later while there are as many Scheduled jobs as WithMaxScheduled allows.
+var ErrTooManyScheduled = errors.New("too many scheduled jobs")
+
+// ErrJobProcessing is returned by MoveJob when the job is Processing.
+var ErrJobProcessing = errors.New("job is processing")
+
+// ErrShutdown is returned by the calls to Run, ProcessOne and ProcessAll
+// of a worker a processor shut down by calling Shutdown with a nil error.
+var ErrShutdown = errors.New("worker shut down by a processor")
+
+// ErrReservedQueueName is returned by the operations taking the name
+// of a queue when it starts like those of the queues of reservations,
+// which only CreateJobReserved can create jobs in.
+var ErrReservedQueueName = errors.New("queue name is reserved")
+
+// ErrNotEnoughWorkers is returned by Reserve when no call to Run
+// has as many worker goroutines as the slots to reserve.
+var ErrNotEnoughWorkers = errors.New("not enough workers")
+
+// BatchError is returned by ValidateJobs and CreateJobs when one of the
+// specs of the batch keeps CreateJobs from creating its jobs. Index is
+// the index of the spec in the batch, and Err why it does.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("job %d of the batch: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
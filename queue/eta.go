@@ -0,0 +1,53 @@
+package queue
+
+import "time"
+
+// DrainTimeUnknown is returned by EstimatedDrainTime
+// when no throughput has been measured yet
+const DrainTimeUnknown time.Duration = -1
+
+// drainWeight is the weight of the last interval between two jobs
+// ending in the moving average of those intervals
+const drainWeight = 0.2
+
+// EstimatedDrainTime returns an estimate of how long the workers will take
+// to be done with the Queued and Processing jobs of all the named queues,
+// but for those parked in QuarantineQueue, given how fast jobs ended
+// recently, as told by an exponential moving average of the intervals
+// between them. Only the jobs that were processed count, not those that
+// were cancelled or failed without being attempted, which end without
+// taking up the workers. While none ends, the estimate grows as if the
+// next one was about to. It returns 0 if there is no job to be done, and
+// DrainTimeUnknown if fewer than two jobs ended so far.
+func (c *client) EstimatedDrainTime() time.Duration {
+	c.q.mu.RLock()
+	defer c.q.mu.RUnlock()
+	depth := c.q.counts[Queued] - c.q.quarantined() + c.q.counts[Processing]
+	if depth == 0 {
+		return 0
+	}
+	if c.q.endInterval <= 0 {
+		return DrainTimeUnknown
+	}
+	interval := c.q.endInterval
+	if since := c.q.clock.now().Sub(c.q.lastEnd); since > interval {
+		interval = since
+	}
+	return time.Duration(depth) * interval
+}
+
+// ended accounts for a job that just finished or failed after an
+// attempt in the average interval between the jobs that do.
+// mu must be held by the caller.
+func (q *memoryQueue) ended() {
+	now := q.clock.now()
+	if !q.lastEnd.IsZero() {
+		interval := now.Sub(q.lastEnd)
+		if q.endInterval <= 0 {
+			q.endInterval = interval
+		} else {
+			q.endInterval = time.Duration(drainWeight*float64(interval) + (1-drainWeight)*float64(q.endInterval))
+		}
+	}
+	q.lastEnd = now
+}
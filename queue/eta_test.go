@@ -0,0 +1,73 @@
+package queue_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// timed returns a processor making d pass on clock for each job
+func timed(clock *queue.FakeClock, d time.Duration) queue.Processor {
+	return processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		clock.Advance(d)
+		return nil
+	})
+}
+
+// checkDrainTime checks that c estimates the drain time to be want
+func checkDrainTime(t *testing.T, c queue.Client, want time.Duration) {
+	t.Helper()
+	if got := c.EstimatedDrainTime(); got != want {
+		t.Errorf("got estimate %s, want %s", got, want)
+	}
+}
+
+func TestEstimatedDrainTime(t *testing.T) {
+	const perJob = 10 * time.Millisecond
+	clock := queue.NewFakeClock()
+	c, w := queue.New(timed(clock, perJob), queue.WithFakeClock(clock))
+	for i := 0; i < 15; i++ {
+		createJobs(t, c, map[string]int{fmt.Sprintf("job-%d", i): i})
+	}
+	checkDrainTime(t, c, queue.DrainTimeUnknown)
+	for i := 0; i < 5; i++ {
+		processOne(t, w)
+	}
+	checkDrainTime(t, c, 10*perJob)
+	processAll(t, w)
+	checkDrainTime(t, c, 0)
+}
+
+func TestEstimatedDrainTimeGrowsWhileStalled(t *testing.T) {
+	const perJob = 10 * time.Millisecond
+	clock := queue.NewFakeClock()
+	c, w := queue.New(timed(clock, perJob), queue.WithFakeClock(clock))
+	createJobs(t, c, map[string]int{"a": 1, "b": 2, "c": 3})
+	processOne(t, w)
+	processOne(t, w)
+	clock.Advance(3 * perJob)
+	checkDrainTime(t, c, 3*perJob)
+}
+
+func TestEstimatedDrainTimeIgnoresCancelledJobs(t *testing.T) {
+	const perJob = 10 * time.Millisecond
+	clock := queue.NewFakeClock()
+	c, w := queue.New(timed(clock, perJob), queue.WithFakeClock(clock))
+	for i := 0; i < 12; i++ {
+		createJobs(t, c, map[string]int{fmt.Sprintf("job-%d", i): i})
+	}
+	processOne(t, w)
+	processOne(t, w)
+	checkDrainTime(t, c, 10*perJob)
+
+	// Cancelling jobs all at once is no sign the others go faster
+	for i := 2; i < 7; i++ {
+		if err := c.CancelJob(context.Background(), fmt.Sprintf("job-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkDrainTime(t, c, 5*perJob)
+}
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"log/slog"
+	"time"
+)
+
+// EventHandler receives callbacks at each transition of the jobs of a
+// queue, so that they can be audited or forwarded to other systems.
+//
+// The OnCreated method is called when a job is created.
+//
+// The OnStarted method is called when an attempt to process a job starts.
+//
+// The OnRetry method is called when an attempt to process a job fails
+// with err and the job is to be retried after delay.
+//
+// The OnFinished method is called when a job finishes successfully,
+// on the given attempt.
+//
+// The OnFailed method is called when a job fails with err, on the given
+// attempt, and is not to be retried.
+//
+// The OnCancelled method is called when a job is cancelled. Handlers
+// can also implement CancelResultHandler to know, for jobs cancelled while
+// Processing, whether their processor stopped once asked to.
+//
+// The queue calls these methods synchronously, while holding internal
+// locks, so implementations must not block nor call back into the queue,
+// unless it has WithNotificationWorkers. Panics are recovered and logged
+// with the logger of the queue, so they do not affect the queue.
+type EventHandler interface {
+	OnCreated(id string)
+	OnStarted(id string, attempt int)
+	OnRetry(id string, attempt int, err error, delay time.Duration)
+	OnFinished(id string, attempt int)
+	OnFailed(id string, attempt int, err error)
+	OnCancelled(id string)
+}
+
+// WithEventHandler makes the queue call h at each transition of its jobs
+func WithEventHandler(h EventHandler) Option {
+	return func(c *config) {
+		c.events = h
+	}
+}
+
+// NopEventHandler is an EventHandler that ignores all events. It is
+// the one used by default, and it can be embedded by handlers which
+// are only interested in some of the events.
+type NopEventHandler struct{}
+
+func (NopEventHandler) OnCreated(id string) {}
+
+func (NopEventHandler) OnStarted(id string, attempt int) {}
+
+func (NopEventHandler) OnRetry(id string, attempt int, err error, delay time.Duration) {}
+
+func (NopEventHandler) OnFinished(id string, attempt int) {}
+
+func (NopEventHandler) OnFailed(id string, attempt int, err error) {}
+
+func (NopEventHandler) OnCancelled(id string) {}
+
+// emit calls the event handler of the queue with call for an event of
+// the given job, through the event lanes of the queue, if it has any,
+// logging the panics of the handler instead of propagating them.
+func (q *memoryQueue) emit(id string, call func(h EventHandler)) {
+	h := q.events
+	guarded := func() {
+		defer func() {
+			if v := recover(); v != nil {
+				q.logger.Error("event handler panicked",
+					slog.String("job_id", id),
+					slog.Any("panic", v))
+			}
+		}()
+		call(h)
+	}
+	if q.eventLanes != nil {
+		q.eventLanes.dispatch(id, guarded)
+		return
+	}
+	guarded()
+}
@@ -0,0 +1,229 @@
+package queue_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// eventRecorder is an EventHandler recording the events it gets
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *eventRecorder) record(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+}
+
+func (r *eventRecorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *eventRecorder) OnCreated(id string) {
+	r.record("created %s", id)
+}
+
+func (r *eventRecorder) OnStarted(id string, attempt int) {
+	r.record("started %s %d", id, attempt)
+}
+
+func (r *eventRecorder) OnRetry(id string, attempt int, err error, delay time.Duration) {
+	r.record("retry %s %d: %v, in %v", id, attempt, err, delay)
+}
+
+func (r *eventRecorder) OnFinished(id string, attempt int) {
+	r.record("finished %s %d", id, attempt)
+}
+
+func (r *eventRecorder) OnFailed(id string, attempt int, err error) {
+	r.record("failed %s %d: %v", id, attempt, err)
+}
+
+func (r *eventRecorder) OnCancelled(id string) {
+	r.record("cancelled %s", id)
+}
+
+func TestEventsOfFinishedJob(t *testing.T) {
+	rec := &eventRecorder{}
+	c, w := queue.New(doubler, queue.WithEventHandler(rec))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	want := []string{"created a", "started a 1", "finished a 1"}
+	if got := rec.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+func TestEventsOfRetriedJob(t *testing.T) {
+	rec := &eventRecorder{}
+	p, _ := flaky(2)
+	c, w := queue.New(p, queue.WithEventHandler(rec), queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	processAll(t, w)
+	want := []string{
+		"created a",
+		"started a 1",
+		"retry a 1: attempt 1 failed, in 0s",
+		"started a 2",
+		"failed a 2: attempt 2 failed",
+	}
+	if got := rec.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+func TestEventsOfCancelledJob(t *testing.T) {
+	rec := &eventRecorder{}
+	c, _ := queue.New(doubler, queue.WithEventHandler(rec))
+	if err := c.CreateJobs(context.Background(), []queue.JobSpec{{ID: "a", Data: &intData{}}, {ID: "b", Data: &intData{}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CancelJob(context.Background(), "b"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"created a", "created b", "cancelled b"}
+	if got := rec.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+// panickingHandler is an EventHandler panicking when jobs start
+type panickingHandler struct {
+	queue.NopEventHandler
+}
+
+func (panickingHandler) OnStarted(id string, attempt int) {
+	panic("handler bug")
+}
+
+func TestPanickingEventHandler(t *testing.T) {
+	h := &logRecorder{}
+	c, w := queue.New(doubler, queue.WithEventHandler(panickingHandler{}), queue.WithLogger(slog.New(h)))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	j := waitForJob(t, c, "a")
+	var d intData
+	if err := j.GetData(&d); err != nil || j.State() != queue.Finished || d.N != 2 {
+		t.Errorf("got job %s with data %d and error %v, want it %s with data 2", j.State(), d.N, err, queue.Finished)
+	}
+	want := []string{"ERROR event handler panicked", "DEBUG job started", "DEBUG job finished"}
+	if got := h.logged("a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got logs %q, want %q", got, want)
+	}
+}
+
+// slowHandler is an eventRecorder whose calls wait for release to be
+// closed, measuring how many of them are made at once in busiest
+type slowHandler struct {
+	eventRecorder
+	release chan struct{}
+	mu      sync.Mutex
+	active  int
+	busiest int
+}
+
+func (h *slowHandler) wait() {
+	h.mu.Lock()
+	h.active++
+	if h.active > h.busiest {
+		h.busiest = h.active
+	}
+	h.mu.Unlock()
+	<-h.release
+	h.mu.Lock()
+	h.active--
+	h.mu.Unlock()
+}
+
+func (h *slowHandler) OnCreated(id string) {
+	h.wait()
+	h.eventRecorder.OnCreated(id)
+}
+
+func (h *slowHandler) OnStarted(id string, attempt int) {
+	h.wait()
+	h.eventRecorder.OnStarted(id, attempt)
+}
+
+func (h *slowHandler) OnFinished(id string, attempt int) {
+	h.wait()
+	h.eventRecorder.OnFinished(id, attempt)
+}
+
+// waitForEvents waits for h to record n events
+func waitForEvents(t *testing.T, h *slowHandler, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		events := h.recorded()
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d events after %s, want %d", len(events), testTimeout, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNotificationWorkers(t *testing.T) {
+	h := &slowHandler{release: make(chan struct{})}
+	c, w := queue.New(doubler, queue.WithEventHandler(h), queue.WithNotificationWorkers(2))
+	const jobs = 20
+	for i := 0; i < jobs; i++ {
+		createJobs(t, c, map[string]int{fmt.Sprintf("job-%d", i): i})
+	}
+	// The workers do not wait for the handler
+	if n := processAll(t, w); n != jobs {
+		t.Fatalf("processed %d jobs while the handler was blocked, want %d", n, jobs)
+	}
+	close(h.release)
+	events := waitForEvents(t, h, 3*jobs)
+	h.mu.Lock()
+	busiest := h.busiest
+	h.mu.Unlock()
+	if busiest > 2 {
+		t.Errorf("got %d concurrent calls to the handler, want at most 2", busiest)
+	}
+	// The events of each job are handled in order
+	for i := 0; i < jobs; i++ {
+		id := fmt.Sprintf("job-%d", i)
+		var got []string
+		for _, e := range events {
+			if strings.HasSuffix(e, " "+id) || strings.Contains(e, " "+id+" ") {
+				got = append(got, e)
+			}
+		}
+		want := []string{"created " + id, "started " + id + " 1", "finished " + id + " 1"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got events %q for job %q, want %q", got, id, want)
+		}
+	}
+}
+
+func TestEventsSynchronousByDefault(t *testing.T) {
+	h := &eventRecorder{}
+	c, w := queue.New(doubler, queue.WithEventHandler(h))
+	createJobs(t, c, map[string]int{"a": 1})
+	// The events are handled by the time the calls return
+	if got, want := h.recorded(), []string{"created a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+	processAll(t, w)
+	if got := len(h.recorded()); got != 3 {
+		t.Errorf("got %d events, want 3", got)
+	}
+}
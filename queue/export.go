@@ -0,0 +1,98 @@
+package queue
+
+import "time"
+
+// JobExport is everything about a job as a plain struct, to be marshaled
+// to JSON like to hand it to other systems. It has the payload of the job
+// in Data, unmarshaled as GetData would, only if it was exported
+// WithPayload. Times that do not apply to the job are zero: RunAt for
+// jobs that were never Scheduled, Heartbeat, the last time the worker
+// processing the job reported being alive, for jobs that were never
+// Processing, and Deadline for jobs with no deadline.
+type JobExport struct {
+	ID              string            `json:"id"`
+	Queue           string            `json:"queue"`
+	State           State             `json:"state"`
+	Priority        int               `json:"priority"`
+	Attempts        int               `json:"attempts"`
+	Error           string            `json:"error,omitempty"`
+	FailureCategory FailureCategory   `json:"failure_category,omitempty"`
+	ClaimedBy       string            `json:"claimed_by,omitempty"`
+	Completed       uint64            `json:"completed"`
+	Total           uint64            `json:"total"`
+	RunAt           time.Time         `json:"run_at"`
+	Heartbeat       time.Time         `json:"heartbeat"`
+	Deadline        time.Time         `json:"deadline"`
+	Schedule        []time.Time       `json:"schedule,omitempty"`
+	Key             string            `json:"key,omitempty"`
+	DedupKey        string            `json:"dedup_key,omitempty"`
+	Trace           map[string]string `json:"trace,omitempty"`
+	Data            []byte            `json:"data,omitempty"`
+}
+
+// ExportOption configures a call to Export
+type ExportOption func(*exportOptions)
+
+// exportOptions holds the settings of a call to
+// Export, as set by the options given to it
+type exportOptions struct {
+	payload bool
+}
+
+// WithPayload makes Export include the payload of the job, which
+// is left out if it cannot be decoded, as GetData would tell.
+func WithPayload() ExportOption {
+	return func(o *exportOptions) {
+		o.payload = true
+	}
+}
+
+// Export returns everything about the job
+func (j *job) Export(opts ...ExportOption) JobExport {
+	return j.q.export(j.r, opts)
+}
+
+// Export returns everything about the job as it is now
+func (pj *processingJob) Export(opts ...ExportOption) JobExport {
+	r, _ := pj.record()
+	return pj.q.export(r, opts)
+}
+
+// export returns the export of the job with the given record,
+// configured by opts
+func (q *memoryQueue) export(r JobRecord, opts []ExportOption) JobExport {
+	var o exportOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	e := JobExport{
+		ID:              r.ID,
+		Queue:           r.Queue,
+		State:           r.State,
+		Priority:        r.Priority,
+		Attempts:        r.Attempts,
+		Error:           r.Error,
+		FailureCategory: r.FailureCategory,
+		ClaimedBy:       r.ClaimedBy,
+		Completed:       r.Completed,
+		Total:           r.Total,
+		RunAt:           r.RunAt,
+		Heartbeat:       r.Heartbeat,
+		Deadline:        r.Deadline,
+		Schedule:        append([]time.Time(nil), r.Schedule...),
+		Key:             r.Key,
+		DedupKey:        r.DedupKey,
+	}
+	if r.Trace != nil {
+		e.Trace = make(map[string]string, len(r.Trace))
+		for k, v := range r.Trace {
+			e.Trace[k] = v
+		}
+	}
+	if o.payload {
+		if b, err := q.decode(r.Data); err == nil {
+			e.Data = b
+		}
+	}
+	return e
+}
@@ -0,0 +1,99 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestExport(t *testing.T) {
+	p, _ := flaky(1)
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 3}), queue.WithOwnerID("worker-1"))
+	ctx := context.Background()
+	deadline := time.Now().Add(time.Hour).Round(0)
+	err := c.CreateJobInQueue(ctx, queue.DefaultQueue, "a", &intData{N: 1},
+		queue.WithPriority(3), queue.WithConcurrencyKey("k"), queue.WithDeadline(deadline))
+	if err != nil {
+		t.Fatalf("creating job: %v", err)
+	}
+	processOne(t, w)
+	j, err := c.GetJob(ctx, "a")
+	if err != nil {
+		t.Fatalf("getting job: %v", err)
+	}
+	completed, total := j.Progress()
+	want := queue.JobExport{
+		ID:              "a",
+		Queue:           queue.DefaultQueue,
+		State:           j.State(),
+		Priority:        3,
+		Attempts:        1,
+		Error:           j.Error(),
+		FailureCategory: j.FailureCategory(),
+		ClaimedBy:       j.ClaimedBy(),
+		Completed:       completed,
+		Total:           total,
+		Deadline:        deadline,
+		Key:             "k",
+	}
+	got := j.Export()
+	if got.Heartbeat.IsZero() {
+		t.Error("got no heartbeat exported for a job that was processed")
+	}
+	want.Heartbeat = got.Heartbeat
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got export %+v, want %+v", got, want)
+	}
+	if want.State != queue.Queued || want.FailureCategory != queue.FailureProcessorError {
+		t.Errorf("got job %s failed with %q, want it retried after a processor error", want.State, want.FailureCategory)
+	}
+}
+
+func TestExportWithPayload(t *testing.T) {
+	c, _ := queue.New(doubler, queue.WithEncryption(encryptionKey))
+	createJobs(t, c, map[string]int{"a": 21})
+	j, err := c.GetJob(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("getting job: %v", err)
+	}
+	if e := j.Export(); e.Data != nil {
+		t.Errorf("got payload %q exported by default, want none", e.Data)
+	}
+	b, err := json.Marshal(j.Export(queue.WithPayload()))
+	if err != nil {
+		t.Fatalf("marshaling export: %v", err)
+	}
+	var e queue.JobExport
+	if err := json.Unmarshal(b, &e); err != nil {
+		t.Fatalf("unmarshaling export: %v", err)
+	}
+	var d intData
+	if err := d.Unmarshal(e.Data); err != nil || d.N != 21 {
+		t.Errorf("got exported payload %d, %v, want %d", d.N, err, 21)
+	}
+	if e.ID != "a" || e.State != queue.Queued {
+		t.Errorf("got export of job %q %s, want job %q %s", e.ID, e.State, "a", queue.Queued)
+	}
+}
+
+func TestExportWhileProcessing(t *testing.T) {
+	exports := make(chan queue.JobExport, 1)
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		if err := j.SetProgress(ctx, 1, 2); err != nil {
+			return err
+		}
+		exports <- j.Export()
+		return nil
+	})
+	c, w := queue.New(p, queue.WithOwnerID("worker-1"))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	e := <-exports
+	if e.State != queue.Processing || e.ClaimedBy != "worker-1" || e.Completed != 1 || e.Total != 2 {
+		t.Errorf("got export %+v, want the job processing by %q at 1 of 2", e, "worker-1")
+	}
+}
@@ -0,0 +1,60 @@
+package queue
+
+import "errors"
+
+// FailureCategory tells how a job failed, or that it was cancelled,
+// so that failures can be told apart without parsing their errors,
+// like to alert on timeouts separately from errors of the processor.
+type FailureCategory string
+
+const (
+	// FailureProcessorError is the category of the jobs
+	// whose processor returned an error
+	FailureProcessorError FailureCategory = "processor_error"
+	// FailureTimeout is the category of the jobs whose
+	// processor did not return within the job timeout
+	FailureTimeout FailureCategory = "timeout"
+	// FailurePanic is the category of the jobs whose processor panicked
+	FailurePanic FailureCategory = "panic"
+	// FailureCorrupt is the category of the jobs whose payload
+	// could not be decrypted or did not match its checksum
+	FailureCorrupt FailureCategory = "corrupt"
+	// FailureLifetimeExceeded is the category of the jobs
+	// that were not done by their deadline
+	FailureLifetimeExceeded FailureCategory = "lifetime_exceeded"
+	// FailureStalled is the category of the jobs reaped
+	// because their worker stopped reporting being alive
+	FailureStalled FailureCategory = "stalled"
+	// FailureCancelled is the category of the cancelled jobs
+	FailureCancelled FailureCategory = "cancelled"
+)
+
+// categorizedError is the error of a job that failed in a way
+// telling its category by itself, like by timing out. Other
+// than that, it is the same error as err.
+type categorizedError struct {
+	category FailureCategory
+	err      error
+}
+
+func (e categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e categorizedError) Unwrap() error {
+	return e.err
+}
+
+// failureCategory returns the category of a job that failed with procErr
+func failureCategory(procErr error) FailureCategory {
+	var categorized categorizedError
+	switch {
+	case errors.As(procErr, &categorized):
+		return categorized.category
+	case errors.Is(procErr, ErrDeadlineBeforeStart):
+		return FailureLifetimeExceeded
+	case errors.Is(procErr, ErrDecryptionFailed), errors.Is(procErr, ErrChecksumMismatch):
+		return FailureCorrupt
+	}
+	return FailureProcessorError
+}
@@ -0,0 +1,117 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// blockUntilDone is a processor blocking until its context is done
+var blockUntilDone = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+	<-ctx.Done()
+	return ctx.Err()
+})
+
+// checkFailureCategory checks that the job with the given ID is in the
+// given state with the given failure category
+func checkFailureCategory(t *testing.T, c queue.Client, id string, state queue.State, want queue.FailureCategory) {
+	t.Helper()
+	j := waitForJob(t, c, id)
+	if j.State() != state {
+		t.Fatalf("got job %q %s, want it %s", id, j.State(), state)
+	}
+	if got := j.FailureCategory(); got != want {
+		t.Errorf("got failure category %q for job %q, want %q", got, id, want)
+	}
+}
+
+func TestFailureCategories(t *testing.T) {
+	tests := []struct {
+		name string
+		p    queue.Processor
+		opts []queue.Option
+		want queue.FailureCategory
+	}{
+		{
+			name: "processor error",
+			p:    doubler,
+			want: queue.FailureProcessorError,
+		},
+		{
+			name: "panic",
+			p: processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+				panic("boom")
+			}),
+			want: queue.FailurePanic,
+		},
+		{
+			name: "timeout",
+			p:    blockUntilDone,
+			opts: []queue.Option{queue.WithJobTimeout(10 * time.Millisecond)},
+			want: queue.FailureTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, w := queue.New(tt.p, tt.opts...)
+			createJobs(t, c, map[string]int{"a": -1})
+			processAll(t, w)
+			checkFailureCategory(t, c, "a", queue.Failed, tt.want)
+		})
+	}
+}
+
+func TestFailureCategoryOfCorruptPayload(t *testing.T) {
+	s := &queue.MemoryStore{}
+	createSecret(t, s, queue.WithEncryption(encryptionKey))
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		var d textData
+		return j.GetData(&d)
+	})
+	c, w, err := queue.NewWithStore(context.Background(), s, p, queue.WithEncryption(oldEncryptionKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	checkFailureCategory(t, c, "a", queue.Failed, queue.FailureCorrupt)
+}
+
+func TestFailureCategoryOfExpiredJobs(t *testing.T) {
+	c, w := queue.New(blockUntilDone)
+	if err := c.CreateJob(context.Background(), "late", &intData{N: 1}, queue.WithDeadline(time.Now().Add(-time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateJob(context.Background(), "slow", &intData{N: 1}, queue.WithDeadline(time.Now().Add(10*time.Millisecond))); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	checkFailureCategory(t, c, "late", queue.Failed, queue.FailureLifetimeExceeded)
+	checkFailureCategory(t, c, "slow", queue.Failed, queue.FailureLifetimeExceeded)
+}
+
+func TestFailureCategoryOfCancelledAndFinishedJobs(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"cancelled": 1, "finished": 2})
+	if err := c.CancelJob(context.Background(), "cancelled"); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	checkFailureCategory(t, c, "cancelled", queue.Cancelled, queue.FailureCancelled)
+	checkFailureCategory(t, c, "finished", queue.Finished, "")
+}
+
+func TestFailureCategoryOfRetriedJob(t *testing.T) {
+	p, _ := flaky(1)
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2, BackoffFor: queue.ConstantBackoff(time.Hour)}))
+	createJobs(t, c, map[string]int{"a": 1})
+	processOne(t, w)
+	j, err := c.GetJob(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := j.FailureCategory(); got != queue.FailureProcessorError {
+		t.Errorf("got failure category %q for a job being retried, want %q", got, queue.FailureProcessorError)
+	}
+}
@@ -0,0 +1,52 @@
+package queue
+
+// WithRetryFairness makes the workers of the queue share their capacity
+// between the jobs being retried and the others, so that a storm of
+// retries does not starve new jobs, or the other way round. While both
+// are pending in a named queue, about ratio of the jobs dispatched from
+// it are retries, the others being dispatched by rank as usual among
+// their own. If only one kind is pending, it gets all the capacity.
+// ratio is clamped to [0, 1]. Jobs are retries if they were attempted
+// before, and requeued jobs whose attempt was interrupted are not.
+func WithRetryFairness(ratio float64) Option {
+	if ratio < 0 {
+		ratio = 0
+	} else if ratio > 1 {
+		ratio = 1
+	}
+	return func(c *config) {
+		c.retryFair, c.retryRatio = true, ratio
+	}
+}
+
+// fairnessEpsilon absorbs the rounding errors of adding ratios up
+const fairnessEpsilon = 1e-9
+
+// retryCredit returns the credit the named queue would have for a retry
+// if a job were dispatched from it now, and whether that is enough for
+// the job dispatched to be a retry. A retry takes up one unit of credit,
+// and each dispatch earns retryRatio of it, up to one unit, so that the
+// queue cannot save it up while no retry is pending. mu must be held by
+// the caller.
+func (q *memoryQueue) retryCredit(name string) (float64, bool) {
+	credit := q.retryCredits[name] + q.retryRatio
+	if credit > 1 {
+		credit = 1
+	}
+	return credit, credit >= 1-fairnessEpsilon
+}
+
+// dispatched accounts for a job dispatched from the named queue with the
+// given credit for a retry, as returned by retryCredit, taking one unit
+// of it if the job is a retry. A queue getting only retries goes no
+// further than no credit, so that new jobs are not held back once they
+// come. mu must be held by the caller.
+func (q *memoryQueue) dispatched(name string, credit float64, retry bool) {
+	if retry {
+		credit--
+		if credit < 0 {
+			credit = 0
+		}
+	}
+	q.retryCredits[name] = credit
+}
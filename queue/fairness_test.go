@@ -0,0 +1,159 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// dispatch is a job a processor was called for, and
+// whether the call was to retry it
+type dispatch struct {
+	id    string
+	retry bool
+}
+
+// churn returns a processor failing the first attempts of the jobs whose
+// IDs start with "retry", and a function returning the jobs it was
+// called for, in order
+func churn(failures int) (queue.Processor, func() []dispatch) {
+	var mu sync.Mutex
+	var calls []dispatch
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		mu.Lock()
+		calls = append(calls, dispatch{id: j.ID(), retry: j.Attempt() > 1})
+		mu.Unlock()
+		if strings.HasPrefix(j.ID(), "retry") && j.Attempt() <= failures {
+			return errors.New("still failing")
+		}
+		return nil
+	})
+	return p, func() []dispatch {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]dispatch(nil), calls...)
+	}
+}
+
+// createRetries creates n jobs whose IDs start with "retry" and
+// processes them once, so that they are retried
+func createRetries(t *testing.T, c queue.Client, w queue.Worker, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := c.CreateJob(context.Background(), fmt.Sprintf("retry-%d", i), &intData{N: i}); err != nil {
+			t.Fatalf("creating job: %v", err)
+		}
+	}
+	if got := processAll(t, w); got != n {
+		t.Fatalf("processed %d jobs, want %d", got, n)
+	}
+}
+
+func TestRetryFairness(t *testing.T) {
+	p, processed := churn(3)
+	c, w := queue.New(p,
+		queue.WithPriorityAging(0),
+		queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 10}),
+		queue.WithRetryFairness(0.25),
+	)
+	createRetries(t, c, w, 40)
+	before := len(processed())
+	for i := 0; i < 40; i++ {
+		if err := c.CreateJob(context.Background(), fmt.Sprintf("new-%d", i), &intData{N: i}); err != nil {
+			t.Fatalf("creating job: %v", err)
+		}
+	}
+	processAll(t, w)
+	// Until the last new job, there were retries pending all along,
+	// which would all have come first otherwise as they are older
+	var window []dispatch
+	for _, d := range processed()[before:] {
+		window = append(window, d)
+		if d.id == "new-39" {
+			break
+		}
+	}
+	retries := 0
+	for _, d := range window {
+		if d.retry {
+			retries++
+		}
+	}
+	if got := float64(retries) / float64(len(window)); got < 0.2 || got > 0.3 {
+		t.Errorf("dispatched %d retries out of %d jobs, want about a quarter: %v", retries, len(window), window)
+	}
+}
+
+func TestRetryFairnessWithOneKindPending(t *testing.T) {
+	p, processed := churn(1)
+	c, w := queue.New(p,
+		queue.WithPriorityAging(0),
+		queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}),
+		queue.WithRetryFairness(0),
+	)
+	createRetries(t, c, w, 4)
+	for i := 0; i < 2; i++ {
+		if err := c.CreateJob(context.Background(), fmt.Sprintf("new-%d", i), &intData{N: i}); err != nil {
+			t.Fatalf("creating job: %v", err)
+		}
+	}
+	// The retries wait for the new jobs, but are not starved once no
+	// new job is pending
+	if got := processAll(t, w); got != 6 {
+		t.Fatalf("processed %d jobs, want 6", got)
+	}
+	want := []dispatch{
+		{id: "new-0"},
+		{id: "new-1"},
+		{id: "retry-0", retry: true},
+		{id: "retry-1", retry: true},
+		{id: "retry-2", retry: true},
+		{id: "retry-3", retry: true},
+	}
+	if got := processed()[4:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("processed %v, want %v", got, want)
+	}
+}
+
+// drainBatch creates a batch of n new jobs in a queue created with the
+// given options and processes them all, returning how long it took
+func drainBatch(tb testing.TB, n int, opts ...queue.Option) time.Duration {
+	tb.Helper()
+	c, w := queue.New(doubler, opts...)
+	if err := c.CreateJobs(context.Background(), specs(n)); err != nil {
+		tb.Fatalf("creating jobs: %v", err)
+	}
+	start := time.Now()
+	got, err := w.(queue.SyncWorker).ProcessAll(context.Background())
+	if err != nil {
+		tb.Fatalf("processing all jobs: %v", err)
+	}
+	if got != n {
+		tb.Fatalf("processed %d jobs, want %d", got, n)
+	}
+	return time.Since(start)
+}
+
+func TestRetryFairnessDrainsLargeBatch(t *testing.T) {
+	// With no retry pending, dispatching a new job must not go through
+	// the other pending jobs, which would make draining the batch
+	// quadratic, so it takes about as long as without retry fairness
+	plain := drainBatch(t, 10000)
+	fair := drainBatch(t, 10000, queue.WithRetryFairness(0.25))
+	if fair > 20*plain {
+		t.Errorf("drained the batch in %s with retry fairness, %s without", fair, plain)
+	}
+}
+
+func BenchmarkRetryFairnessDrain(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		drainBatch(b, 10000, queue.WithRetryFairness(0.25))
+	}
+}
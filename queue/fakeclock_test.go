@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"sync"
+	"time"
+)
+
+// WithFakeClock makes the queue tell the time with c, so
+// that the tests can make time pass with Advance
+func WithFakeClock(c *FakeClock) Option {
+	return func(cfg *config) {
+		cfg.clock = c
+	}
+}
+
+// FakeClock is a clock whose time only passes when Advance is called,
+// going off the timers that are due then. Timers set off for no delay
+// go off right away, in a goroutine of their own, like with package time.
+type FakeClock struct {
+	mu     sync.Mutex
+	t      time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock returns a fake clock starting at an arbitrary time
+func NewFakeClock() *FakeClock {
+	return &FakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// Now returns the time of the clock
+func (c *FakeClock) Now() time.Time {
+	return c.now()
+}
+
+// Advance makes d pass, calling the functions of the timers that go off
+// meanwhile in the order they are due, each once the time has come to
+// when it is and before Advance returns. Timers set off by them go off
+// too if they are due by the end of d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.t.Add(d)
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		var next *fakeTimer
+		for _, t := range c.timers {
+			if t.active && !t.at.After(end) && (next == nil || t.at.Before(next.at)) {
+				next = t
+			}
+		}
+		if next == nil {
+			c.t = end
+			c.mu.Unlock()
+			return
+		}
+		c.t = next.at
+		next.active = false
+		c.mu.Unlock()
+		next.f()
+	}
+}
+
+// Timers returns the number of timers set off and not gone off yet
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *FakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *FakeClock) afterFunc(d time.Duration, f func()) timer {
+	t := &fakeTimer{c: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// fakeTimer is a timer of a FakeClock, going off at at if active
+type fakeTimer struct {
+	c      *FakeClock
+	f      func()
+	at     time.Time
+	active bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	wasActive := t.active
+	if d <= 0 {
+		t.active = false
+		go t.f()
+		return wasActive
+	}
+	if !wasActive {
+		t.c.dropGone()
+		t.c.timers = append(t.c.timers, t)
+	}
+	t.at, t.active = t.c.t.Add(d), true
+	return wasActive
+}
+
+// dropGone drops the timers that are not active anymore.
+// mu must be held by the caller.
+func (c *FakeClock) dropGone() {
+	active := c.timers[:0]
+	for _, t := range c.timers {
+		if t.active {
+			active = append(active, t)
+		}
+	}
+	for i := len(active); i < len(c.timers); i++ {
+		c.timers[i] = nil
+	}
+	c.timers = active
+}
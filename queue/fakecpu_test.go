@@ -0,0 +1,11 @@
+package queue
+
+import "time"
+
+// WithFakeCPU makes the queue tell the CPU time of the process
+// with cpu, so that the tests can make the CPU usage what they want
+func WithFakeCPU(cpu func() time.Duration) Option {
+	return func(cfg *config) {
+		cfg.cpuTime = cpu
+	}
+}
@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+)
+
+// WithGoroutineGuard makes the workers count the goroutines of the
+// process before and after each call to the processor, and log the
+// attempts leaving more than maxPerJob of them behind at the Warn level,
+// so that processors leaking goroutines are caught in tests or staging.
+// The count is only a sample: goroutines started or ended at the same
+// time, like by other processors running concurrently, are counted too,
+// and those a processor leaves to end shortly after it returns are
+// counted as left behind. It is meant as a diagnostic, with a single
+// worker goroutine for precise counts, which is why the attempts are
+// not failed unless WithGoroutineLeakFailures says so.
+func WithGoroutineGuard(maxPerJob int) Option {
+	return func(c *config) {
+		c.leakLimit = maxPerJob
+	}
+}
+
+// WithGoroutineLeakFailures makes the goroutine guard of
+// WithGoroutineGuard fail the attempts it logs that would have
+// succeeded, as if the processor returned an error. Given how the
+// goroutines are counted, attempts may fail for the goroutines of
+// others unless Run is given a single worker goroutine.
+func WithGoroutineLeakFailures() Option {
+	return func(c *config) {
+		c.leakFail = true
+	}
+}
+
+// guardGoroutines returns the error of an attempt to process the given
+// job that returned err, given the number of goroutines of the process
+// before the processor was called, which is err unless the processor
+// left more goroutines behind than allowed by the goroutine guard and
+// the guard fails the attempts that do.
+func (w *worker) guardGoroutines(pj *processingJob, before int, err error) error {
+	left := runtime.NumGoroutine() - before
+	if left <= w.cfg.leakLimit {
+		return err
+	}
+	w.cfg.logger.Warn("processor left goroutines behind",
+		slog.String("job_id", pj.id),
+		slog.String("queue", pj.queue),
+		slog.Int("attempt", pj.attempt),
+		slog.Int("goroutines", left),
+		slog.Int("limit", w.cfg.leakLimit))
+	if err != nil || !w.cfg.leakFail {
+		return err
+	}
+	return fmt.Errorf("processor left %d goroutines behind, over the limit of %d", left, w.cfg.leakLimit)
+}
@@ -0,0 +1,80 @@
+package queue_test
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// leaker returns a processor leaving n goroutines behind for each job,
+// which return once the test is over
+func leaker(t *testing.T, n int) queue.Processor {
+	leaked := make(chan struct{})
+	t.Cleanup(func() { close(leaked) })
+	return processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		for i := 0; i < n; i++ {
+			go func() { <-leaked }()
+		}
+		return nil
+	})
+}
+
+func TestGoroutineGuard(t *testing.T) {
+	h := &logRecorder{}
+	c, w := queue.New(leaker(t, 3), queue.WithGoroutineGuard(1), queue.WithLogger(slog.New(h)))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Errorf("got job %s with error %q, want it %s, the leak only logged", j.State(), j.Error(), queue.Finished)
+	}
+	want := []string{
+		"DEBUG job started",
+		"WARN processor left goroutines behind",
+		"DEBUG job finished",
+	}
+	if got := h.logged("a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got logs %q, want %q", got, want)
+	}
+}
+
+func TestGoroutineGuardFailing(t *testing.T) {
+	h := &logRecorder{}
+	c, w := queue.New(leaker(t, 3),
+		queue.WithGoroutineGuard(1), queue.WithGoroutineLeakFailures(), queue.WithLogger(slog.New(h)))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	j := waitForJob(t, c, "a")
+	if j.State() != queue.Failed || !strings.Contains(j.Error(), "goroutines behind") {
+		t.Errorf("got job %s with error %q, want it failed for leaving goroutines behind", j.State(), j.Error())
+	}
+	want := []string{
+		"DEBUG job started",
+		"WARN processor left goroutines behind",
+		"ERROR job failed",
+	}
+	if got := h.logged("a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got logs %q, want %q", got, want)
+	}
+}
+
+func TestGoroutineGuardWithinLimit(t *testing.T) {
+	c, w := queue.New(leaker(t, 1), queue.WithGoroutineGuard(3))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Errorf("got job %s with error %q, want it %s", j.State(), j.Error(), queue.Finished)
+	}
+}
+
+func TestNoGoroutineGuardByDefault(t *testing.T) {
+	c, w := queue.New(leaker(t, 3))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Errorf("got job %s with error %q, want it %s", j.State(), j.Error(), queue.Finished)
+	}
+}
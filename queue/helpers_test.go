@@ -0,0 +1,128 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// testTimeout bounds every wait in the tests, so that a bug
+// makes them fail instead of hanging.
+const testTimeout = 5 * time.Second
+
+// intData is a job payload holding a single integer
+type intData struct {
+	N int `json:"n"`
+}
+
+func (d *intData) Marshal() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (d *intData) Unmarshal(b []byte) error {
+	return json.Unmarshal(b, d)
+}
+
+// processorFunc turns a function into a queue.Processor
+type processorFunc func(ctx context.Context, j queue.JobProcessingAccess) error
+
+func (f processorFunc) Process(ctx context.Context, j queue.JobProcessingAccess) error {
+	return f(ctx, j)
+}
+
+// errNegative is returned by doubler for negative payloads
+var errNegative = errors.New("negative payload")
+
+// doubler is a processor that doubles the payload of intData jobs,
+// and fails with errNegative when it is negative.
+var doubler = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+	var d intData
+	if err := j.GetData(&d); err != nil {
+		return err
+	}
+	if d.N < 0 {
+		return errNegative
+	}
+	d.N *= 2
+	return j.SetData(ctx, &d)
+})
+
+// recorder is a processor that records the IDs of
+// the jobs it processes, in order.
+type recorder struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (r *recorder) Process(ctx context.Context, j queue.JobProcessingAccess) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ids = append(r.ids, j.ID())
+	return nil
+}
+
+func (r *recorder) processed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ids...)
+}
+
+// runWorker runs w with the given number of worker goroutines in the
+// background. It returns a function that stops w and returns what Run
+// returned.
+func runWorker(t *testing.T, w queue.Worker, workers int) (stop func() error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- w.Run(ctx, workers)
+	}()
+	return func() error {
+		cancel()
+		select {
+		case err := <-result:
+			return err
+		case <-time.After(testTimeout):
+			t.Fatal("Run did not return after its context was canceled")
+			return nil
+		}
+	}
+}
+
+// waitForJob waits for the job with the given ID to be terminal,
+// failing the test if that takes longer than testTimeout.
+func waitForJob(t *testing.T, c queue.Client, id string) queue.Job {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	j, err := c.WaitForJob(ctx, id)
+	if err != nil {
+		t.Fatalf("waiting for job %q: %v", id, err)
+	}
+	return j
+}
+
+// createJobs creates jobs with the given IDs and intData payloads
+func createJobs(t *testing.T, c queue.Client, payloads map[string]int) {
+	t.Helper()
+	for id, n := range payloads {
+		if err := c.CreateJob(context.Background(), id, &intData{N: n}); err != nil {
+			t.Fatalf("creating job %q: %v", id, err)
+		}
+	}
+}
+
+// processAll processes synchronously all the jobs queued for w
+func processAll(t *testing.T, w queue.Worker) int {
+	t.Helper()
+	n, err := w.(queue.SyncWorker).ProcessAll(context.Background())
+	if err != nil {
+		t.Fatalf("processing all jobs: %v", err)
+	}
+	return n
+}
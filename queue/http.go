@@ -0,0 +1,312 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HTTPCodec converts the payloads of the jobs to and from
+// the JSON they are given as and returned in by the handler
+// returned by NewHTTPHandler.
+//
+// The FromJSON method returns the payload of a job to create
+// from the given JSON, or an error if it is not a valid payload.
+//
+// The ToJSON method returns the payload of the given job as JSON.
+type HTTPCodec interface {
+	FromJSON(data json.RawMessage) (MarshalUnmarshaler, error)
+	ToJSON(j Job) (json.RawMessage, error)
+}
+
+// RawJSON is a job payload that is a JSON document, stored as it is
+type RawJSON json.RawMessage
+
+// Marshal returns the JSON document
+func (d *RawJSON) Marshal() ([]byte, error) {
+	return *d, nil
+}
+
+// Unmarshal sets the JSON document to b, which must be valid JSON
+func (d *RawJSON) Unmarshal(b []byte) error {
+	if !json.Valid(b) {
+		return errors.New("payload is not valid JSON")
+	}
+	*d = append((*d)[:0], b...)
+	return nil
+}
+
+// RawJSONCodec is the HTTPCodec used by default. It stores
+// the JSON given as payloads as it is, as a RawJSON, with
+// a missing payload stored as null.
+type RawJSONCodec struct{}
+
+func (RawJSONCodec) FromJSON(data json.RawMessage) (MarshalUnmarshaler, error) {
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+	d := RawJSON(data)
+	return &d, nil
+}
+
+func (RawJSONCodec) ToJSON(j Job) (json.RawMessage, error) {
+	var d RawJSON
+	if err := j.GetData(&d); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(d), nil
+}
+
+// DefaultWaitTimeout is how long the handler returned by NewHTTPHandler
+// waits for a job to be done by default
+const DefaultWaitTimeout = 30 * time.Second
+
+// HTTPOption configures the handler returned by NewHTTPHandler
+type HTTPOption func(*httpHandler)
+
+// WithHTTPCodec makes the handler convert the payloads
+// of the jobs with codec instead of RawJSONCodec.
+func WithHTTPCodec(codec HTTPCodec) HTTPOption {
+	return func(h *httpHandler) {
+		h.codec = codec
+	}
+}
+
+// NewHTTPHandler returns an http.Handler exposing the jobs of the
+// queue of c as a REST API, so that services not written in Go
+// can use the queue:
+//
+//   - POST /jobs creates a job from a JSON body with its "id", its
+//     "data" and, optionally, its "priority", and returns the job
+//     with status 201 Created.
+//   - GET /jobs/{id} returns the job, with its "id", "state", "error",
+//     "failure_category", "claimed_by", "data" and "completed" and
+//     "total" progress.
+//   - DELETE /jobs/{id} deletes the job, returning 204 No Content.
+//   - GET /jobs/{id}/wait?timeout=30s waits for the job to be done,
+//     that is Finished, Failed or Cancelled, and returns it then.
+//     If it is not done within the timeout, DefaultWaitTimeout by
+//     default, it returns 408 Request Timeout. It stops waiting
+//     when the client goes away.
+//
+// Errors are returned as a JSON object with their "error", with status
+// 400 Bad Request for invalid requests and payloads, 404 Not Found for
+// missing jobs, 409 Conflict for duplicate jobs and jobs that cannot be
+// deleted yet, 413 Request Entity Too Large for payloads over the limit
+// of the queue, and 500 Internal Server Error otherwise.
+func NewHTTPHandler(c Client, opts ...HTTPOption) http.Handler {
+	h := &httpHandler{c: c, codec: RawJSONCodec{}, logger: discardLogger}
+	if c, ok := c.(*client); ok {
+		h.logger = c.q.logger
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// httpHandler is the http.Handler returned by NewHTTPHandler. logger
+// is the logger of the queue of c, which it logs the responses it fails
+// to write with.
+type httpHandler struct {
+	c      Client
+	codec  HTTPCodec
+	logger *slog.Logger
+}
+
+// createRequest is the body of the requests creating jobs
+type createRequest struct {
+	ID       string          `json:"id"`
+	Data     json.RawMessage `json:"data"`
+	Priority int             `json:"priority"`
+}
+
+// jobResponse is the body of the responses returning a job
+type jobResponse struct {
+	ID              string          `json:"id"`
+	State           State           `json:"state"`
+	Error           string          `json:"error,omitempty"`
+	FailureCategory FailureCategory `json:"failure_category,omitempty"`
+	ClaimedBy       string          `json:"claimed_by,omitempty"`
+	Completed       uint64          `json:"completed"`
+	Total           uint64          `json:"total"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// errorResponse is the body of the responses returning an error
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	if segments[0] != "jobs" {
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("no such path %q", r.URL.Path))
+		return
+	}
+	if len(segments) == 1 {
+		h.route(w, r, map[string]http.HandlerFunc{http.MethodPost: h.create})
+		return
+	}
+	id, err := url.PathUnescape(segments[1])
+	switch {
+	case err != nil || id == "":
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("no such path %q", r.URL.Path))
+	case len(segments) == 2:
+		h.route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet:    func(w http.ResponseWriter, r *http.Request) { h.writeJob(w, r, id, http.StatusOK) },
+			http.MethodDelete: func(w http.ResponseWriter, r *http.Request) { h.delete(w, r, id) },
+		})
+	case len(segments) == 3 && segments[2] == "wait":
+		h.route(w, r, map[string]http.HandlerFunc{
+			http.MethodGet: func(w http.ResponseWriter, r *http.Request) { h.wait(w, r, id) },
+		})
+	default:
+		h.writeError(w, http.StatusNotFound, fmt.Errorf("no such path %q", r.URL.Path))
+	}
+}
+
+// route serves r with the handler of its method,
+// or with 405 Method Not Allowed if there is none
+func (h *httpHandler) route(w http.ResponseWriter, r *http.Request, handlers map[string]http.HandlerFunc) {
+	if handler, ok := handlers[r.Method]; ok {
+		handler(w, r)
+		return
+	}
+	allowed := make([]string, 0, len(handlers))
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	h.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+}
+
+// create serves POST /jobs
+func (h *httpHandler) create(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %v", err))
+		return
+	}
+	if req.ID == "" {
+		h.writeError(w, http.StatusBadRequest, errors.New("missing job id"))
+		return
+	}
+	data, err := h.codec.FromJSON(req.Data)
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid data: %v", err))
+		return
+	}
+	if err := h.c.CreateJob(r.Context(), req.ID, data, WithPriority(req.Priority)); err != nil {
+		h.writeError(w, statusOf(err), err)
+		return
+	}
+	h.writeJob(w, r, req.ID, http.StatusCreated)
+}
+
+// delete serves DELETE /jobs/{id}
+func (h *httpHandler) delete(w http.ResponseWriter, r *http.Request, id string) {
+	if err := h.c.DeleteJob(r.Context(), id); err != nil {
+		h.writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// wait serves GET /jobs/{id}/wait
+func (h *httpHandler) wait(w http.ResponseWriter, r *http.Request, id string) {
+	timeout := DefaultWaitTimeout
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			h.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout %q", s))
+			return
+		}
+		timeout = d
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	j, err := h.c.WaitForJob(ctx, id)
+	switch {
+	case r.Context().Err() != nil:
+		// The client is gone
+	case errors.Is(err, context.DeadlineExceeded):
+		h.writeError(w, http.StatusRequestTimeout, fmt.Errorf("job %q is not done after %s", id, timeout))
+	case err != nil:
+		h.writeError(w, statusOf(err), err)
+	default:
+		h.write(w, j, http.StatusOK)
+	}
+}
+
+// writeJob writes the job with the given ID with the given status
+func (h *httpHandler) writeJob(w http.ResponseWriter, r *http.Request, id string, status int) {
+	j, err := h.c.GetJob(r.Context(), id)
+	if err == nil && j == nil {
+		err = fmt.Errorf("cannot get job %q: %w", id, ErrJobNotFound)
+	}
+	if err != nil {
+		h.writeError(w, statusOf(err), err)
+		return
+	}
+	h.write(w, j, status)
+}
+
+// write writes j with the given status
+func (h *httpHandler) write(w http.ResponseWriter, j Job, status int) {
+	data, err := h.codec.ToJSON(j)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, fmt.Errorf("cannot encode the data of job %q: %v", j.ID(), err))
+		return
+	}
+	completed, total := j.Progress()
+	h.writeJSON(w, status, jobResponse{
+		ID:              j.ID(),
+		State:           j.State(),
+		Error:           j.Error(),
+		FailureCategory: j.FailureCategory(),
+		ClaimedBy:       j.ClaimedBy(),
+		Completed:       completed,
+		Total:           total,
+		Data:            data,
+	})
+}
+
+// writeError writes err with the given status
+func (h *httpHandler) writeError(w http.ResponseWriter, status int, err error) {
+	h.writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// writeJSON writes v as JSON with the given status. As the status is
+// written already, failing to encode v is only logged.
+func (h *httpHandler) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("cannot write the response",
+			slog.Int("status", status),
+			slog.String("error", err.Error()))
+	}
+}
+
+// statusOf returns the HTTP status for the given error of the queue
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrDuplicateJob), errors.Is(err, ErrJobNotTerminal):
+		return http.StatusConflict
+	case errors.Is(err, ErrPayloadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,293 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// apiJob is a job as returned by the HTTP API
+type apiJob struct {
+	ID        string          `json:"id"`
+	State     queue.State     `json:"state"`
+	Error     string          `json:"error"`
+	Completed uint64          `json:"completed"`
+	Total     uint64          `json:"total"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// request makes a request to srv with the given method, path and body,
+// checks that it gets the given status, and decodes the response into
+// out, if not nil.
+func request(t *testing.T, srv *httptest.Server, method, path, body string, status int, out interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		var e struct{ Error string }
+		json.NewDecoder(resp.Body).Decode(&e)
+		t.Fatalf("%s %s: got status %d (%s), want %d", method, path, resp.StatusCode, e.Error, status)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+}
+
+// finisher is a processor that sets the payload of raw JSON jobs to done
+var finisher = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+	done := queue.RawJSON(`{"done":true}`)
+	return j.SetData(ctx, &done)
+})
+
+func TestHTTPCreateAndFetch(t *testing.T) {
+	c, w := queue.New(finisher)
+	srv := httptest.NewServer(queue.NewHTTPHandler(c))
+	defer srv.Close()
+
+	var created apiJob
+	request(t, srv, http.MethodPost, "/jobs", `{"id": "a", "data": {"digits": 10}}`, http.StatusCreated, &created)
+	if created.ID != "a" || created.State != queue.Queued || string(created.Data) != `{"digits":10}` {
+		t.Errorf("got created job %+v, want job a Queued with its data", created)
+	}
+
+	var pending apiJob
+	request(t, srv, http.MethodGet, "/jobs/a", "", http.StatusOK, &pending)
+	if pending.State != queue.Queued || string(pending.Data) != `{"digits":10}` {
+		t.Errorf("got pending job %+v, want it Queued with its data", pending)
+	}
+
+	processAll(t, w)
+	var finished apiJob
+	request(t, srv, http.MethodGet, "/jobs/a", "", http.StatusOK, &finished)
+	if finished.State != queue.Finished || string(finished.Data) != `{"done":true}` {
+		t.Errorf("got finished job %+v, want it Finished with the data set by the processor", finished)
+	}
+
+	request(t, srv, http.MethodDelete, "/jobs/a", "", http.StatusNoContent, nil)
+	request(t, srv, http.MethodGet, "/jobs/a", "", http.StatusNotFound, nil)
+}
+
+func TestHTTPErrors(t *testing.T) {
+	c, _ := queue.New(finisher, queue.WithMaxPayloadBytes(16))
+	srv := httptest.NewServer(queue.NewHTTPHandler(c))
+	defer srv.Close()
+	request(t, srv, http.MethodPost, "/jobs", `{"id": "a", "data": 1}`, http.StatusCreated, nil)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodGet, "/jobs/missing", "", http.StatusNotFound},
+		{http.MethodDelete, "/jobs/missing", "", http.StatusNotFound},
+		{http.MethodPost, "/jobs", `{"id": "a", "data": 2}`, http.StatusConflict},
+		{http.MethodDelete, "/jobs/a", "", http.StatusConflict},
+		{http.MethodPost, "/jobs", `{"id": "b", "data": `, http.StatusBadRequest},
+		{http.MethodPost, "/jobs", `{"data": 1}`, http.StatusBadRequest},
+		{http.MethodPost, "/jobs", `{"id": "big", "data": "this is over the limit"}`, http.StatusRequestEntityTooLarge},
+		{http.MethodPut, "/jobs/a", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/jobs/a/b/c", "", http.StatusNotFound},
+		{http.MethodGet, "/other", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		request(t, srv, tt.method, tt.path, tt.body, tt.status, nil)
+	}
+}
+
+// intCodec is an HTTPCodec for intData payloads
+type intCodec struct{}
+
+func (intCodec) FromJSON(data json.RawMessage) (queue.MarshalUnmarshaler, error) {
+	var d intData
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (intCodec) ToJSON(j queue.Job) (json.RawMessage, error) {
+	var d intData
+	if err := j.GetData(&d); err != nil {
+		return nil, err
+	}
+	return json.Marshal(d.N)
+}
+
+func TestHTTPCodec(t *testing.T) {
+	c, w := queue.New(doubler)
+	srv := httptest.NewServer(queue.NewHTTPHandler(c, queue.WithHTTPCodec(intCodec{})))
+	defer srv.Close()
+	request(t, srv, http.MethodPost, "/jobs", `{"id": "a", "data": {"n": 21}}`, http.StatusCreated, nil)
+	request(t, srv, http.MethodPost, "/jobs", `{"id": "b", "data": "not a number"}`, http.StatusBadRequest, nil)
+	processAll(t, w)
+
+	var j apiJob
+	request(t, srv, http.MethodGet, "/jobs/a", "", http.StatusOK, &j)
+	if string(j.Data) != "42" {
+		t.Errorf("got data %s, want 42", j.Data)
+	}
+}
+
+// brokenCodec is a HTTPCodec returning invalid JSON for the payloads
+type brokenCodec struct{ queue.RawJSONCodec }
+
+func (brokenCodec) ToJSON(queue.Job) (json.RawMessage, error) {
+	return json.RawMessage(`{"broken"`), nil
+}
+
+func TestHTTPLogsResponsesNotWritten(t *testing.T) {
+	logs := &logRecorder{}
+	c, _ := queue.New(finisher, queue.WithLogger(slog.New(logs)))
+	srv := httptest.NewServer(queue.NewHTTPHandler(c, queue.WithHTTPCodec(brokenCodec{})))
+	defer srv.Close()
+	request(t, srv, http.MethodPost, "/jobs", `{"id": "a"}`, http.StatusCreated, nil)
+
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	for _, r := range logs.records {
+		if r.Level == slog.LevelError && r.Message == "cannot write the response" && attrs(r)["status"] == "201" {
+			return
+		}
+	}
+	t.Errorf("got no error logged for the response not written")
+}
+
+func TestHTTPEscapedID(t *testing.T) {
+	c, _ := queue.New(finisher)
+	srv := httptest.NewServer(queue.NewHTTPHandler(c))
+	defer srv.Close()
+	request(t, srv, http.MethodPost, "/jobs", `{"id": "a/b"}`, http.StatusCreated, nil)
+	var j apiJob
+	request(t, srv, http.MethodGet, "/jobs/a%2Fb", "", http.StatusOK, &j)
+	if j.ID != "a/b" || string(j.Data) != "null" {
+		t.Errorf("got job %+v, want job a/b with null data", j)
+	}
+}
+
+// waitSpy is a Client telling when its WaitForJob
+// starts waiting, and what it returns
+type waitSpy struct {
+	queue.Client
+	waiting  chan struct{}
+	returned chan error
+}
+
+func newWaitSpy(c queue.Client) *waitSpy {
+	return &waitSpy{Client: c, waiting: make(chan struct{}, 1), returned: make(chan error, 1)}
+}
+
+func (c *waitSpy) WaitForJob(ctx context.Context, id string) (queue.Job, error) {
+	c.waiting <- struct{}{}
+	j, err := c.Client.WaitForJob(ctx, id)
+	c.returned <- err
+	return j, err
+}
+
+// started waits for the spy to start waiting, failing the
+// test if that takes longer than testTimeout.
+func (c *waitSpy) started(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.waiting:
+	case <-time.After(testTimeout):
+		t.Fatal("the handler did not wait for the job")
+	}
+}
+
+func TestHTTPWaitForCompletion(t *testing.T) {
+	c, w := queue.New(finisher)
+	spy := newWaitSpy(c)
+	srv := httptest.NewServer(queue.NewHTTPHandler(spy))
+	defer srv.Close()
+	request(t, srv, http.MethodPost, "/jobs", `{"id": "a"}`, http.StatusCreated, nil)
+
+	type response struct {
+		resp *http.Response
+		err  error
+	}
+	result := make(chan response, 1)
+	go func() {
+		resp, err := srv.Client().Get(srv.URL + "/jobs/a/wait?timeout=5s")
+		result <- response{resp, err}
+	}()
+	spy.started(t)
+	processAll(t, w)
+	var r response
+	select {
+	case r = <-result:
+	case <-time.After(testTimeout):
+		t.Fatal("the wait did not return once the job was done")
+	}
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	defer r.resp.Body.Close()
+	var j apiJob
+	if err := json.NewDecoder(r.resp.Body).Decode(&j); err != nil {
+		t.Fatal(err)
+	}
+	if r.resp.StatusCode != http.StatusOK || j.State != queue.Finished || string(j.Data) != `{"done":true}` {
+		t.Errorf("got status %d with job %+v, want %d with the job Finished with the data set by the processor", r.resp.StatusCode, j, http.StatusOK)
+	}
+}
+
+func TestHTTPWaitTimeout(t *testing.T) {
+	c, _ := queue.New(finisher)
+	srv := httptest.NewServer(queue.NewHTTPHandler(c))
+	defer srv.Close()
+	request(t, srv, http.MethodPost, "/jobs", `{"id": "a"}`, http.StatusCreated, nil)
+	request(t, srv, http.MethodGet, "/jobs/a/wait?timeout=20ms", "", http.StatusRequestTimeout, nil)
+	request(t, srv, http.MethodGet, "/jobs/a/wait?timeout=soon", "", http.StatusBadRequest, nil)
+	request(t, srv, http.MethodGet, "/jobs/missing/wait", "", http.StatusNotFound, nil)
+	request(t, srv, http.MethodPost, "/jobs/a/wait", "", http.StatusMethodNotAllowed, nil)
+}
+
+func TestHTTPWaitStopsWhenClientLeaves(t *testing.T) {
+	c, _ := queue.New(finisher)
+	spy := newWaitSpy(c)
+	srv := httptest.NewServer(queue.NewHTTPHandler(spy))
+	defer srv.Close()
+	request(t, srv, http.MethodPost, "/jobs", `{"id": "a"}`, http.StatusCreated, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/jobs/a/wait?timeout=1h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		resp, err := srv.Client().Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		sent <- err
+	}()
+	spy.started(t)
+	cancel()
+	if err := <-sent; err == nil {
+		t.Error("got a response to a canceled request")
+	}
+	select {
+	case err := <-spy.returned:
+		if err != context.Canceled {
+			t.Errorf("got error %v waiting for the job, want %v", err, context.Canceled)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the handler kept waiting for the job after the client left")
+	}
+}
@@ -0,0 +1,36 @@
+package queue
+
+import "context"
+
+// WorkerJob returns the job the worker goroutine with the given ID is
+// processing, if it is processing one. The goroutines of the calls to
+// Run of a worker, reservations included, are numbered from 1, each
+// goroutine taking the lowest ID not taken by another one.
+func (w *worker) WorkerJob(workerID int) (Job, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	clock, ok := w.goroutines[workerID]
+	if !ok {
+		return nil, false
+	}
+	w.q.mu.RLock()
+	defer w.q.mu.RUnlock()
+	if clock.job == "" {
+		return nil, false
+	}
+	r, err := w.q.store.Load(context.Background(), clock.job)
+	if err != nil {
+		return nil, false
+	}
+	return &job{q: w.q, r: r}, true
+}
+
+// freeGoroutineID returns the lowest ID not taken by a worker
+// goroutine of w. w.mu must be held by the caller.
+func (w *worker) freeGoroutineID() int {
+	id := 1
+	for w.goroutines[id] != nil {
+		id++
+	}
+	return id
+}
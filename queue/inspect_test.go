@@ -0,0 +1,76 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// workerJobs returns the IDs of the jobs the worker goroutines of w
+// with the IDs from 1 to n are processing, by goroutine ID
+func workerJobs(w queue.Worker, n int) map[int]string {
+	jobs := make(map[int]string)
+	for id := 1; id <= n; id++ {
+		if j, ok := w.(queue.InspectableWorker).WorkerJob(id); ok {
+			jobs[id] = j.ID()
+		}
+	}
+	return jobs
+}
+
+// waitForWorkerJobs waits for the worker goroutines of w with the IDs
+// from 1 to n to be processing count jobs, and returns them
+func waitForWorkerJobs(t *testing.T, w queue.Worker, n, count int) map[int]string {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		jobs := workerJobs(w, n)
+		if len(jobs) == count {
+			return jobs
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got worker goroutines processing %v after %s, want %d jobs", jobs, testTimeout, count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorkerJob(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p)
+	defer runWorker(t, w, 2)()
+	waitForWorkers(t, c, 2)
+	waitForWorkerJobs(t, w, 2, 0)
+
+	createJobs(t, c, map[string]int{"a": 1})
+	waitForStarts(t, started, 1)
+	for _, id := range waitForWorkerJobs(t, w, 2, 1) {
+		if id != "a" {
+			t.Errorf("got a worker goroutine processing %q, want %q", id, "a")
+		}
+	}
+	createJobs(t, c, map[string]int{"b": 2})
+	waitForStarts(t, started, 1)
+	jobs := waitForWorkerJobs(t, w, 2, 2)
+	if jobs[1] == jobs[2] {
+		t.Errorf("got both worker goroutines processing %q", jobs[1])
+	}
+	if _, ok := w.(queue.InspectableWorker).WorkerJob(3); ok {
+		t.Error("got a job for a worker goroutine that does not exist")
+	}
+
+	proceed <- struct{}{}
+	for _, id := range waitForWorkerJobs(t, w, 2, 1) {
+		j, err := c.GetJob(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.State() != queue.Processing {
+			t.Errorf("got a worker goroutine processing job %q, which is %s", id, j.State())
+		}
+	}
+	proceed <- struct{}{}
+	waitForWorkerJobs(t, w, 2, 0)
+}
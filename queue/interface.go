@@ -2,6 +2,7 @@ package queue
 
 import (
 	"context"
+	"time"
 )
 
 // State represents the state of a job
@@ -37,15 +38,44 @@ type MarshalUnmarshaler interface {
 // The State method returns the State of the job.
 //
 // The Error method returns a string describing the error with which a job failed.
+//
+// The FailureCategory method returns how the job failed, like by timing
+// out or by its processor panicking, or FailureCancelled if it was
+// cancelled. Like Error, it tells how the last attempt failed while
+// the job is retried, and it is empty for jobs that have not failed.
+//
+// The ClaimedBy method returns the identity of the queue processing the
+// job, as set by WithOwnerID, while it is Processing, so that operators
+// can tell which instance a stuck job is on. It is empty otherwise.
+//
+// The Progress method returns how many of the total units of work of
+// the job have been completed, as last reported by its processor.
+// Both are zero if no progress was reported in the current attempt:
+// progress is cleared when the job leaves Processing other than by
+// finishing, like when it is retried or fails. Once the job is Finished,
+// completed equals total.
+//
+// The Export method returns everything about the job as a JobExport,
+// to be marshaled, leaving the payload out unless it is given WithPayload.
+//
+// The ProgressReport method returns the progress of the job like Progress,
+// telling whether the processor reported any, whether it is determinate,
+// with the fraction of the work done, or whether the processor can only
+// tell that the job is busy.
 type Job interface {
 	ID() string
 	GetData(data MarshalUnmarshaler) error
 	State() State
 	Error() string
+	FailureCategory() FailureCategory
+	ClaimedBy() string
+	Progress() (completed, total uint64)
+	Export(opts ...ExportOption) JobExport
+	ProgressReport() ProgressReport
 }
 
-// JobProcessingAccess is just the Job interface with an extra method
-// that allows setting the data payload of the job. It is meant to be
+// JobProcessingAccess is just the Job interface with extra methods
+// that allow setting the data payload of the job. It is meant to be
 // used by job processors launched by workers, which will need to store
 // their results there.
 //
@@ -54,47 +84,384 @@ type Job interface {
 // argument to allow canceling or expiration of the SetData operation,
 // and return an error in that case or if the payload data update cannot
 // be performed.
+//
+// The Attempt method returns the number of the current attempt
+// to process the job, starting from 1, which is greater than 1
+// only when the job is being retried.
+//
+// The SetProgress method records how far along the job is, so that
+// clients can follow it while it is Processing. Like SetData, it should
+// use the context argument to allow canceling the operation. A total of
+// 0 reports indeterminate progress, for processors that cannot tell how
+// much work is left, but only that the job is busy.
+//
+// The Shutdown method lets a processor that hits a fatal condition, like
+// a corrupt shared resource, stop the worker processing the job, which
+// stops dispatching jobs, leaving them Queued, and lets the ones being
+// processed finish. The call to Run that took the job then returns the
+// given error.
+//
+// The Done method returns a channel that is closed when the context the
+// processor was called with is done, like when the job is cancelled or
+// times out, so that the goroutines the processor starts can tell that
+// they are to stop without being handed that context.
 type JobProcessingAccess interface {
 	Job
 	SetData(ctx context.Context, data MarshalUnmarshaler) error
+	Attempt() int
+	SetProgress(ctx context.Context, completed, total uint64) error
+	Shutdown(err error)
+	Done() <-chan struct{}
 }
 
 // A Processor defines the worker's job execution.
 // It returns an error:
-//  * If the error is not nil, the job is marked as Failed.
+//  * If the error is not nil, the job is marked as Failed,
+//    unless the worker has a RetryPolicy allowing to retry it.
 //  * If the error is nil the job is marked as finished
 //    (successfully).
 //
+// Processors should respect the deadline of the given context, if any:
+// Budget tells how much time is left to decide how much work to attempt.
+//
+// Jobs are delivered at least once: a job whose processing is interrupted
+// is queued again with the payload it had at that point, that is, with
+// the last data stored with SetData. Processors can make long jobs
+// resumable by storing checkpoints with SetData as they go and reading
+// the progress made so far from the payload when they start.
+//
 // This interface has already an implementation by us in the main.go file.
 type Processor interface {
 	Process(ctx context.Context, j JobProcessingAccess) error
 }
 
 // Worker is an interface that wraps the Run method, which
-// allows processing jobs in a queue.
+// allows processing jobs in a queue, and the Drain method,
+// which allows stopping it gracefully.
 //
-// Implementations should attempt to process as many jobs as
+// Implementations of Run should attempt to process as many jobs as
 // the given worker integer simultaneously using a Processor.
 // They should also use the given context to allow users to timeout
 // or cancel processing, returning only after all workers have stopped.
+//
+// Implementations of Drain should make Run stop taking new jobs
+// from the queue, leaving them Queued, but let the jobs being
+// processed finish. They should return when those jobs are done,
+// or when the given context is done, interrupting them, whichever
+// happens first.
 type Worker interface {
 	Run(ctx context.Context, workers int) error
+	Drain(ctx context.Context) error
+}
+
+// MultiQueueWorker is a Worker that can also process jobs in named
+// queues other than the default one, so that different kinds of jobs
+// do not share a single backlog. The worker returned by New processes
+// the jobs in the default queue and implements it.
+//
+// The ForQueue method returns a Worker that processes only the jobs in
+// the named queue, using the given Processor.
+type MultiQueueWorker interface {
+	Worker
+	ForQueue(name string, p Processor) Worker
+}
+
+// SyncWorker is a Worker that can also process jobs synchronously,
+// which makes it easy to write deterministic tests of processors
+// and producers. The worker returned by New implements it.
+//
+// The ProcessAll method processes every job queued at the time of
+// the call, in the order Run would use, on the calling goroutine.
+// It returns the number of processed jobs, and ctx's error if ctx
+// got done before that. Processing goes through the same state
+// transitions as with Run.
+//
+// The ProcessOne method processes the job Run would take next, if
+// any, on the calling goroutine, and returns its ID. It returns false
+// if there is no queued job, leaving the queue untouched then.
+//
+// The StepOnce method is like ProcessOne, but only tells whether a job
+// was processed, for tests that step through a queue one job at a time.
+type SyncWorker interface {
+	Worker
+	ProcessAll(ctx context.Context) (processed int, err error)
+	ProcessOne(ctx context.Context) (processedID string, ok bool, err error)
+	StepOnce(ctx context.Context) (processed bool, err error)
+}
+
+// PausableWorker is a Worker whose queue can be paused, to halt
+// processing for a while, like during an outage of a dependency of
+// the processor, without stopping Run. The worker returned by New
+// implements it, as do the ones returned by its ForQueue method.
+//
+// The Pause method stops the jobs in the queue of the worker from being
+// dispatched, while the jobs already being processed go on. Jobs can
+// still be created, and stay Queued until the Resume method is called,
+// which wakes up the idle workers to take them again. Pausing a paused
+// queue, or resuming one that is not paused, does nothing.
+type PausableWorker interface {
+	Worker
+	Pause()
+	Resume()
+}
+
+// InspectableWorker is a Worker telling what its worker goroutines are
+// doing, like to find out which job a hung one is stuck on. The worker
+// returned by New implements it, as do the ones returned by its ForQueue
+// method.
+//
+// The WorkerJob method returns the job the worker goroutine with the
+// given ID is processing, if any. The goroutines of the calls to Run
+// of the worker are numbered from 1, so with a single call, Run(ctx, n)
+// gives them the IDs from 1 to n.
+type InspectableWorker interface {
+	Worker
+	WorkerJob(workerID int) (Job, bool)
+}
+
+// ScalableWorker is a Worker whose number of worker goroutines can be
+// changed while it runs, so that autoscalers can follow the depth of
+// the queue. The worker returned by New implements it, as do the ones
+// returned by its ForQueue method.
+//
+// The SetConcurrency method changes the number of worker goroutines of
+// the calls to Run in progress to n, starting new ones when raising it.
+// When lowering it, the goroutines that are retired finish processing
+// their current job, if any, so that no job is interrupted. Setting it
+// to 0 stops dispatching jobs, without making Run return, until it is
+// raised again. It returns an error if n is negative.
+//
+// The ActiveWorkers method returns the number of worker goroutines
+// willing to take jobs, which leaves out those being retired and is
+// 0 while the queue of the worker is paused.
+type ScalableWorker interface {
+	Worker
+	SetConcurrency(n int) error
+	ActiveWorkers() int
+}
+
+// ReservingWorker is a Worker that can set aside some of its worker
+// goroutines for a batch of latency-critical jobs, so that other jobs
+// cannot hold them up. The worker returned by New implements it, as do
+// the ones returned by its ForQueue method.
+//
+// The Reserve method sets aside slots of the goroutines of a call to Run
+// in progress, which only process the jobs created against the returned
+// reservation with CreateJobReserved until it is released. It returns an
+// error wrapping ErrNotEnoughWorkers if no call to Run has enough of them.
+type ReservingWorker interface {
+	Worker
+	Reserve(ctx context.Context, slots int) (*Reservation, error)
 }
 
 // Client is an interface that allows pushing jobs into a queue
 // and querying their state and results.
 //
+// Implementations of CreateJob should create a Queued job with the given
+// ID and initialData as payload. The given options, like WithPriority,
+// tune how the job is processed. They should return an error wrapping
+// ErrDuplicateJob, leaving the existing job untouched, when there is
+// already a job with that ID. That includes terminal jobs: an ID can
+// only be used again once its job has been removed with DeleteJob.
+// Jobs deduplicated with WithDedup are not created, and their ID refers
+// to the existing job instead.
+// When the queue is full, as limited by WithMaxQueueDepth, they should
+// block until there is room for the job, or return the context's error
+// if it gets done first. The same goes for CreateJobInQueue and CreateJobs.
+//
+// Implementations of TryCreateJob should create a job like CreateJob
+// and return true, but return false and a nil error right away instead
+// of blocking when the queue is full, so that producers can shed load.
+//
 // Implementations of GetJob should return
 //  * a nil job and a nil error when the job is not found
 //  * the job and a nil error when the job is found
 //  * a nil job and an error, when some error prevents the retrieval
 //    of the job
+//
+// Implementations of GetJobs should return the jobs with the given IDs,
+// like GetJob does for one, by ID. Jobs that are not found are left out of
+// the map rather than making GetJobs fail. They should all be taken at once,
+// without the overhead of calling GetJob for each of them.
+//
+// Implementations of WaitForJob should block until the job reaches
+// a terminal state (Finished, Failed or Cancelled) and then return it, returning
+// right away if it is already in one. They should return
+//  * ErrJobNotFound when the job is not found
+//  * the context's error when it gets done before the job is terminal
+//
+// Implementations of CreateJobInQueue should create a job, like
+// CreateJob, in the named queue. Jobs created with CreateJob go to
+// DefaultQueue. Job IDs are unique across queues, so GetJob and
+// the other methods taking a job ID find jobs in any queue. Names
+// starting like those of the queues of reservations, "reservation:",
+// should be rejected with an error wrapping ErrReservedQueueName.
+//
+// Implementations of CreateJobAt should create a job in DefaultQueue
+// that stays Scheduled, without being dispatched to workers, until runAt.
+// Then it should become Queued. Jobs with a runAt that is not in the
+// future should be Queued right away.
+//
+// Implementations of ListJobs should return the jobs matching the given
+// filter in the order they were created, so that paging through them
+// with ListFilter's After is deterministic.
+//
+// Implementations of DeleteJob should remove a Finished, Failed or Cancelled
+// job, so it does not take memory anymore, and return errors wrapping
+//  * ErrJobNotFound when the job is not found
+//  * ErrJobNotTerminal when the job is still Scheduled, Queued or Processing
+//
+// Implementations of CancelJob should make a job that is no longer wanted
+// Cancelled. Scheduled and Queued jobs should be Cancelled right away,
+// without being dispatched to workers. For Processing jobs, the context
+// given to the processor should be canceled, and the job should become
+// Cancelled once the processor returns, whatever it returns. They should
+// return errors wrapping
+//  * ErrJobNotFound when the job is not found
+//  * ErrJobTerminal when the job is already Finished, Failed or Cancelled
+//
+// Implementations of CreateJobs should create a Queued job in DefaultQueue
+// for each of the given specs, like CreateJob with the given options, but
+// all at once. The batch is all or nothing: if a job cannot be created,
+// for any reason, none is. When an ID is already taken or appears twice
+// in the batch, the error should wrap ErrDuplicateJob. When a spec fails
+// the checks of ValidateJobs, the error should be the same *BatchError.
+//
+// Implementations of Subscribe should return a channel that gets the
+// current state of the job and then each state it moves to, in order,
+// without missing any, so subscribing right after creating a job shows
+// it go from Queued to Processing and so on. The channel should be
+// closed after the job reaches a terminal state, or when ctx gets done.
+// A slow receiver should not hold the queue back: the given options
+// may tell which states to drop rather than keep for it, but never the
+// terminal one. They should return an error wrapping ErrJobNotFound
+// when the job is not found.
+//
+// Implementations of Stats should return how many jobs there are in each
+// state, how many worker goroutines are running and how long the oldest
+// Queued job has been waiting, without going through all the jobs.
+//
+// Implementations of WaitForJobs should wait, like WaitForJob, for all the
+// jobs with the given IDs to be terminal and return them, by the IDs given,
+// without the overhead of a goroutine per job. If ctx gets done first, they
+// should return the jobs that are terminal and ctx's error. WithFailFast
+// makes them return as soon as a job is Failed, with an error wrapping
+// ErrJobFailed. They should return an error wrapping ErrJobNotFound when
+// one of the jobs is not found, without waiting.
+//
+// Implementations of CreateJobReserved should create a job like CreateJob,
+// but processed only by the worker goroutines set aside by the given
+// reservation, and by the other ones once it is released.
+//
+// Implementations of SubscribeSummaries should return a channel that gets
+// how many jobs reached each terminal state over each period set by
+// WithCoalescedNotifications, merging the periods the subscriber falls
+// behind on rather than dropping any. They should fail without it.
+//
+// Implementations of CreateJobWithSchedule should create a job that is
+// attempted at each of the given times in turn, as long as its attempts
+// fail, whatever the retry policy, and then Failed.
+//
+// Implementations of ValidateJobs should check the given specs as
+// CreateJobs would, without creating any job, one spec after the other,
+// and return a *BatchError telling the first spec at fault, if any is.
+//
+// Implementations of Reschedule should change when the given Scheduled
+// job is to be Queued, earlier or later, and fail with an error wrapping
+// ErrJobNotScheduled if the job is not Scheduled.
+//
+// Implementations of MoveJob should move the given Scheduled or Queued
+// job to the named queue, keeping its payload and attempts, and fail
+// with an error wrapping ErrJobProcessing if the job is Processing,
+// ErrQueueFull if a Queued job does not fit in the named queue, and
+// ErrReservedQueueName if the name is like those of CreateJobInQueue.
+//
+// Implementations of EstimatedDrainTime should estimate how long the jobs
+// not done yet will take to be, from how fast jobs were done recently,
+// and return DrainTimeUnknown if they cannot tell.
+//
+// Implementations of CreateJobRaw should create a job like CreateJob,
+// storing the given bytes as its payload as if they were marshaled.
+//
+// Implementations of Shard should process the given data in parts, as
+// split by split, in parallel sub-jobs, and create a Finished job with
+// the given ID holding their results, as merged by merge.
+//
+// Implementations of CreateJobAuto should create a job like CreateJob,
+// with a unique ID they generate, given the prefix of WithIDPrefix,
+// and return it.
+//
+// Implementations of Pressure should return a channel getting the
+// pressure level of the queue each time it changes, keeping only the
+// latest level for readers that fall behind, until ctx gets done.
+// Then the channel should be closed.
+//
+// Implementations of QueueDepthByType should return the number of jobs
+// pending dispatch in each named queue, without going through the jobs.
+//
+// Implementations of CreateJobStream should create the jobs of the specs
+// received on the channel like CreateJobs, in chunks of at most the given
+// size, holding no more specs than that at once, and receive the next
+// chunk only once the previous one is created, so that a full queue holds
+// the producer back. They should return the number of specs created.
 type Client interface {
-	CreateJob(ctx context.Context, id string, initialData MarshalUnmarshaler) error
+	CreateJob(ctx context.Context, id string, initialData MarshalUnmarshaler, opts ...JobOption) error
+	CreateJobInQueue(ctx context.Context, queueName, id string, initialData MarshalUnmarshaler, opts ...JobOption) error
+	CreateJobAt(ctx context.Context, id string, initialData MarshalUnmarshaler, runAt time.Time, opts ...JobOption) error
 	GetJob(ctx context.Context, id string) (Job, error)
+	WaitForJob(ctx context.Context, id string) (Job, error)
+	DeleteJob(ctx context.Context, id string) error
+	CancelJob(ctx context.Context, id string) error
+	ListJobs(ctx context.Context, filter ListFilter) ([]Job, error)
+	CreateJobs(ctx context.Context, jobs []JobSpec, opts ...JobOption) error
+	Subscribe(ctx context.Context, id string, opts ...SubscribeOption) (<-chan State, error)
+	TryCreateJob(ctx context.Context, id string, initialData MarshalUnmarshaler, opts ...JobOption) (accepted bool, err error)
+	GetJobs(ctx context.Context, ids []string) (map[string]Job, error)
+	Stats(ctx context.Context) (QueueStats, error)
+	WaitForJobs(ctx context.Context, ids []string, opts ...WaitOption) (map[string]Job, error)
+	CreateJobReserved(ctx context.Context, res *Reservation, id string, initialData MarshalUnmarshaler, opts ...JobOption) error
+	SubscribeSummaries(ctx context.Context) (<-chan Summary, error)
+	CreateJobWithSchedule(ctx context.Context, id string, attempts []time.Time, initialData MarshalUnmarshaler, opts ...JobOption) error
+	ValidateJobs(ctx context.Context, jobs []JobSpec) error
+	Reschedule(ctx context.Context, id string, at time.Time) error
+	MoveJob(ctx context.Context, id, targetQueue string) error
+	EstimatedDrainTime() time.Duration
+	CreateJobRaw(ctx context.Context, id string, data []byte, opts ...JobOption) error
+	Shard(ctx context.Context, id string, shards int, data MarshalUnmarshaler, split func(data MarshalUnmarshaler, n int) []MarshalUnmarshaler, merge func(parts []MarshalUnmarshaler) MarshalUnmarshaler) (Job, error)
+	CreateJobAuto(ctx context.Context, initialData MarshalUnmarshaler, opts ...JobOption) (string, error)
+	Pressure(ctx context.Context) (<-chan PressureLevel, error)
+	QueueDepthByType() map[string]int
+	CreateJobStream(ctx context.Context, jobs <-chan JobSpec, chunkSize int, opts ...JobOption) (created int, err error)
 }
 
+// JobSpec describes a job to be created by CreateJobs or CreateJobStream,
+// with the same ID and initial data CreateJob takes.
+type JobSpec struct {
+	ID   string
+	Data MarshalUnmarshaler
+}
+
+// ListFilter selects the jobs listed by ListJobs.
+//
+// State, if not empty, selects only the jobs in that state.
+//
+// Limit, if greater than zero, is the maximum number of jobs to list.
+//
+// After, if not empty, is the ID of a job, usually the last one of the
+// previous page, and selects only the jobs created after it. Listing
+// fails with ErrJobNotFound if that job does not exist (anymore).
+type ListFilter struct {
+	State State
+	Limit int
+	After string
+}
+
+// DefaultQueue is the name of the queue jobs are created in with CreateJob
+const DefaultQueue = "default"
+
 const (
+	// Scheduled to be queued at some point in the future
+	Scheduled State = "scheduled"
 	// Queued but not processing yet
 	Queued State = "queued"
 	// Processing - The worker got active
@@ -103,4 +470,6 @@ const (
 	Failed State = "failed"
 	// Finished successfully
 	Finished State = "finished"
+	// Cancelled by a client - See Client's CancelJob
+	Cancelled State = "cancelled"
 )
@@ -0,0 +1,237 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// job is a snapshot of a job, as handed out to clients.
+// Its record is a copy of the one in the store, so clients
+// cannot alter the job through it. q is the queue of the
+// job, which decodes its payload.
+type job struct {
+	q *memoryQueue
+	r JobRecord
+}
+
+// ID returns the ID of the job
+func (j *job) ID() string {
+	return j.r.ID
+}
+
+// GetData unmarshals the payload of the job into data
+func (j *job) GetData(data MarshalUnmarshaler) error {
+	b, err := j.q.decode(j.r.Data)
+	if err != nil {
+		return err
+	}
+	return data.Unmarshal(b)
+}
+
+// State returns the state of the job
+func (j *job) State() State {
+	return j.r.State
+}
+
+// Error returns the error with which the job failed, if any
+func (j *job) Error() string {
+	return j.r.Error
+}
+
+// FailureCategory returns how the job failed, if it did
+func (j *job) FailureCategory() FailureCategory {
+	return j.r.FailureCategory
+}
+
+// ClaimedBy returns the identity of the queue
+// processing the job, if it is Processing
+func (j *job) ClaimedBy() string {
+	return j.r.ClaimedBy
+}
+
+// Progress returns the progress of the job
+func (j *job) Progress() (completed, total uint64) {
+	return j.r.Completed, j.r.Total
+}
+
+// liveJob is what the queue keeps in memory about a job besides
+// its record in the store. done is closed when the job reaches
+// a terminal state, and starts counts the times the job has been
+// started, including interrupted attempts. While the job is Processing,
+// cancel cancels the context given to its processor, and cancelled tells
+// whether a client cancelled the job, which then becomes Cancelled once
+// the processor returns. subs are the subscriptions to the state of the job,
+// key is its concurrency key and dedupKey its deduplication key.
+// state is the state the job is counted in by the queue, and
+// started when its last attempt started. waiters are the calls to
+// WaitForJobs waiting for the job to be terminal. id is the ID of the
+// job, and ended when it became terminal, if it is to expire.
+type liveJob struct {
+	id        string
+	done      chan struct{}
+	starts    uint64
+	cancel    context.CancelFunc
+	cancelled bool
+	subs      []*subscription
+	key       string
+	dedupKey  string
+	state     State
+	started   time.Time
+	waiters   []*jobsWaiter
+	ended     time.Time
+}
+
+// processingJob is the JobProcessingAccess given to processors.
+// Unlike the snapshots returned to clients, it reads and writes
+// the record of the job in the store, so the processor always
+// sees the job's current payload and state.
+// attempt is the attempt it was given for, and start the value
+// of the job's starts when it was given, so that writes from an
+// abandoned attempt can be told apart from current ones even
+// when the attempt has been interrupted and started again.
+// queue, trace, deadline and schedule are the queue, trace
+// context, deadline and retry schedule of the job, and shutdown
+// shuts down the call of the worker the job was taken by.
+type processingJob struct {
+	q        *memoryQueue
+	id       string
+	attempt  int
+	start    uint64
+	queue    string
+	trace    map[string]string
+	deadline time.Time
+	schedule []time.Time
+	shutdown func(error)
+	done     <-chan struct{}
+}
+
+func (pj *processingJob) ID() string {
+	return pj.id
+}
+
+func (pj *processingJob) GetData(data MarshalUnmarshaler) error {
+	r, err := pj.record()
+	if err != nil {
+		return err
+	}
+	b, err := pj.q.decode(r.Data)
+	if err != nil {
+		return err
+	}
+	return data.Unmarshal(b)
+}
+
+func (pj *processingJob) State() State {
+	r, _ := pj.record()
+	return r.State
+}
+
+func (pj *processingJob) Error() string {
+	r, _ := pj.record()
+	return r.Error
+}
+
+func (pj *processingJob) FailureCategory() FailureCategory {
+	r, _ := pj.record()
+	return r.FailureCategory
+}
+
+func (pj *processingJob) ClaimedBy() string {
+	r, _ := pj.record()
+	return r.ClaimedBy
+}
+
+func (pj *processingJob) Progress() (completed, total uint64) {
+	r, _ := pj.record()
+	return r.Completed, r.Total
+}
+
+// Attempt returns the number of the current attempt
+// to process the job, starting from 1.
+func (pj *processingJob) Attempt() int {
+	return pj.attempt
+}
+
+// Done returns the Done channel of the context the processor was
+// called with, which is closed when the job is cancelled, times out
+// or has its attempt interrupted.
+func (pj *processingJob) Done() <-chan struct{} {
+	return pj.done
+}
+
+// SetData marshals data and stores it as the payload of the job.
+// It fails if ctx is already done, if the job is no longer
+// being processed in the attempt pj was given for, or if
+// the payload is larger than the limit of the queue. With
+// WithSkipUnchangedData, a payload that is the same as the
+// stored one is not stored again.
+func (pj *processingJob) SetData(ctx context.Context, data MarshalUnmarshaler) error {
+	plain, err := pj.q.marshal(data)
+	if err != nil {
+		return err
+	}
+	b, err := pj.q.encode(plain)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	pj.q.mu.Lock()
+	defer pj.q.mu.Unlock()
+	r, err := pj.current(ctx)
+	if err != nil {
+		return err
+	}
+	if pj.q.skipUnchanged {
+		if stored, err := pj.q.decode(r.Data); err == nil && bytes.Equal(stored, plain) {
+			return nil
+		}
+	}
+	r.Data = b
+	return pj.q.store.Save(ctx, r)
+}
+
+// SetProgress records how many of the total units of work
+// of the job have been completed. Like SetData, it fails if
+// ctx is already done or if the job is no longer being processed
+// in the attempt pj was given for.
+func (pj *processingJob) SetProgress(ctx context.Context, completed, total uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	pj.q.mu.Lock()
+	defer pj.q.mu.Unlock()
+	r, err := pj.current(ctx)
+	if err != nil {
+		return err
+	}
+	r.Completed, r.Total, r.Reported = completed, total, true
+	return pj.q.store.Save(ctx, r)
+}
+
+// record loads the current record of the job
+func (pj *processingJob) record() (JobRecord, error) {
+	pj.q.mu.RLock()
+	defer pj.q.mu.RUnlock()
+	return pj.q.store.Load(context.Background(), pj.id)
+}
+
+// current returns the record of the job if it is still being
+// processed in the attempt pj was given for, or an error otherwise.
+// pj.q.mu must be held by the caller.
+func (pj *processingJob) current(ctx context.Context) (JobRecord, error) {
+	r, err := pj.q.store.Load(ctx, pj.id)
+	if err != nil {
+		return JobRecord{}, err
+	}
+	if r.State != Processing {
+		return JobRecord{}, fmt.Errorf("job %q is %s, not %s", pj.id, r.State, Processing)
+	}
+	if l := pj.q.live[pj.id]; l == nil || l.starts != pj.start {
+		return JobRecord{}, fmt.Errorf("attempt %d of job %q was abandoned", pj.attempt, pj.id)
+	}
+	return r, nil
+}
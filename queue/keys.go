@@ -0,0 +1,51 @@
+package queue
+
+// WithConcurrencyKey sets the concurrency key of a job. Jobs sharing
+// a key that has a limit set with WithKeyLimit are not processed more
+// than that many at once. Jobs have no key by default.
+func WithConcurrencyKey(key string) JobOption {
+	return func(o *jobOptions) {
+		o.key = key
+	}
+}
+
+// WithKeyLimit makes the workers process at most max jobs with the given
+// concurrency key at once, however many worker goroutines are free. While
+// the limit is reached, the other jobs are dispatched before the queued
+// jobs with that key, whatever their priority. The limit applies to all
+// the workers of the queue together, and to every named queue.
+func WithKeyLimit(key string, max int) Option {
+	return func(c *config) {
+		if c.keyLimits == nil {
+			c.keyLimits = make(map[string]int)
+		}
+		c.keyLimits[key] = max
+	}
+}
+
+// keyFull tells whether as many jobs with the given
+// key as its limit allows are being processed.
+// mu must be held by the caller.
+func (q *memoryQueue) keyFull(key string) bool {
+	limit, ok := q.keyLimits[key]
+	return ok && q.running[key] >= limit
+}
+
+// takeKey counts one more job with the given key as being processed,
+// if the key is limited. mu must be held by the caller.
+func (q *memoryQueue) takeKey(key string) {
+	if _, ok := q.keyLimits[key]; ok {
+		q.running[key]++
+	}
+}
+
+// releaseKey counts one less job with the key of the given job as
+// being processed, if the key is limited, waking up the waiting
+// workers as jobs with that key may be dispatched again.
+// mu must be held by the caller.
+func (q *memoryQueue) releaseKey(l *liveJob) {
+	if _, ok := q.keyLimits[l.key]; ok {
+		q.running[l.key]--
+		q.wake()
+	}
+}
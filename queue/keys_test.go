@@ -0,0 +1,117 @@
+package queue_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// concurrencyMeter is a processor that blocks until release is closed,
+// measuring how many jobs of each kind, given by the prefix of their ID
+// before a dash, it processes at once. It sends their ID on started
+// when it starts processing them.
+type concurrencyMeter struct {
+	started chan string
+	release chan struct{}
+	mu      sync.Mutex
+	running map[string]int
+	max     map[string]int
+}
+
+func newConcurrencyMeter() *concurrencyMeter {
+	return &concurrencyMeter{
+		started: make(chan string, 100),
+		release: make(chan struct{}),
+		running: make(map[string]int),
+		max:     make(map[string]int),
+	}
+}
+
+func (m *concurrencyMeter) Process(ctx context.Context, j queue.JobProcessingAccess) error {
+	kind := strings.Split(j.ID(), "-")[0]
+	m.mu.Lock()
+	m.running[kind]++
+	if m.running[kind] > m.max[kind] {
+		m.max[kind] = m.running[kind]
+	}
+	m.mu.Unlock()
+	m.started <- j.ID()
+	<-m.release
+	m.mu.Lock()
+	m.running[kind]--
+	m.mu.Unlock()
+	return nil
+}
+
+// maxRunning returns the most jobs of the given kind processed at once
+func (m *concurrencyMeter) maxRunning(kind string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.max[kind]
+}
+
+func TestKeyLimit(t *testing.T) {
+	m := newConcurrencyMeter()
+	c, w := queue.New(m, queue.WithKeyLimit("pi", 2))
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 6; i++ {
+		id := fmt.Sprintf("pi-%d", i)
+		if err := c.CreateJob(ctx, id, &intData{}, queue.WithConcurrencyKey("pi")); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	for i := 0; i < 12; i++ {
+		id := fmt.Sprintf("io-%d", i)
+		if err := c.CreateJob(ctx, id, &intData{}); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	defer runWorker(t, w, 10)()
+	var releaseOnce sync.Once
+	release := func() { releaseOnce.Do(func() { close(m.release) }) }
+	defer release()
+
+	// The pi jobs were created first, but only 2 of them take a slot
+	for i := 0; i < 10; i++ {
+		<-m.started
+	}
+	if got := m.maxRunning("pi"); got != 2 {
+		t.Errorf("got %d pi jobs processed at once, want 2", got)
+	}
+	if got := m.maxRunning("io"); got != 8 {
+		t.Errorf("got %d other jobs processed at once, want the 8 free slots", got)
+	}
+	release()
+	for _, id := range ids {
+		waitForJob(t, c, id)
+	}
+	if got := m.maxRunning("pi"); got != 2 {
+		t.Errorf("got %d pi jobs processed at once, want at most 2", got)
+	}
+}
+
+func TestKeyLimitSkipsHigherPriority(t *testing.T) {
+	rec := &recorder{}
+	c, w := queue.New(rec, queue.WithKeyLimit("pi", 0))
+	ctx := context.Background()
+	if err := c.CreateJob(ctx, "pi-1", &intData{}, queue.WithConcurrencyKey("pi"), queue.WithPriority(5)); err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"io-1": 0})
+	if n := processAll(t, w); n != 1 {
+		t.Errorf("got %d jobs processed, want only the one without a key", n)
+	}
+	if got := rec.processed(); len(got) != 1 || got[0] != "io-1" {
+		t.Errorf("got jobs %v processed, want only io-1", got)
+	}
+	if j, _ := c.GetJob(ctx, "pi-1"); j.State() != queue.Queued {
+		t.Errorf("got the keyed job %s, want it still %s", j.State(), queue.Queued)
+	}
+}
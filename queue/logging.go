@@ -0,0 +1,53 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithLogger makes the queue log the transitions of its jobs to logger:
+// routine ones, like a job starting or finishing, at the Debug level, jobs
+// failing an attempt or stalling at the Warn level, and jobs failing for
+// good at the Error level. The records have the ID of the job and, as
+// relevant, its attempt, how long the attempt lasted and its error.
+// By default, the queue does not log anything.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// discardLogger is the logger used by default, which discards all records
+var discardLogger = slog.New(discardHandler{})
+
+// discardHandler is a slog.Handler discarding all records
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool { return false }
+
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h discardHandler) WithGroup(string) slog.Handler { return h }
+
+// log logs msg about the job with the given record at the given level,
+// with the attempt of the job and the given attributes.
+// mu must be held by the caller.
+func (q *memoryQueue) log(level slog.Level, msg string, r JobRecord, attrs ...slog.Attr) {
+	if !q.logger.Enabled(context.Background(), level) {
+		return
+	}
+	attrs = append([]slog.Attr{
+		slog.String("job_id", r.ID),
+		slog.String("queue", r.Queue),
+		slog.Int("attempt", r.Attempts),
+	}, attrs...)
+	q.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// attemptDuration returns the attribute with how long the
+// current attempt to process the given job has lasted
+func (q *memoryQueue) attemptDuration(l *liveJob) slog.Attr {
+	return slog.Duration("duration", q.clock.now().Sub(l.started))
+}
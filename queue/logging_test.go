@@ -0,0 +1,108 @@
+package queue_test
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// logRecorder is a slog.Handler recording the records
+// it gets, at every level, with their attributes
+type logRecorder struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *logRecorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *logRecorder) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *logRecorder) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *logRecorder) WithGroup(string) slog.Handler { return h }
+
+// logged returns the level and message of the recorded records
+// about the job with the given ID, in order
+func (h *logRecorder) logged(id string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var logged []string
+	for _, r := range h.records {
+		if attrs(r)["job_id"] == id {
+			logged = append(logged, r.Level.String()+" "+r.Message)
+		}
+	}
+	return logged
+}
+
+// attrs returns the attributes of r as strings, by key
+func attrs(r slog.Record) map[string]string {
+	m := make(map[string]string)
+	r.Attrs(func(a slog.Attr) bool {
+		m[a.Key] = a.Value.String()
+		return true
+	})
+	return m
+}
+
+func TestLogFailedJob(t *testing.T) {
+	h := &logRecorder{}
+	c, w := queue.New(doubler, queue.WithLogger(slog.New(h)))
+	createJobs(t, c, map[string]int{"bad": -1})
+	processAll(t, w)
+
+	want := []string{"DEBUG job started", "ERROR job failed"}
+	if got := h.logged("bad"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got records %q, want %q", got, want)
+	}
+	failed := attrs(h.records[len(h.records)-1])
+	for _, key := range []string{"queue", "attempt", "duration", "error"} {
+		if _, ok := failed[key]; !ok {
+			t.Errorf("got no %s attribute in the record of the failure, only %v", key, failed)
+		}
+	}
+	if got, want := failed["error"], errNegative.Error(); got != want {
+		t.Errorf("got error attribute %q, want %q", got, want)
+	}
+}
+
+func TestLogRetriedJob(t *testing.T) {
+	h := &logRecorder{}
+	p, _ := flaky(1)
+	c, w := queue.New(p, queue.WithLogger(slog.New(h)), queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	processAll(t, w)
+
+	want := []string{"DEBUG job started", "WARN job failed, retrying", "DEBUG job started", "DEBUG job finished"}
+	if got := h.logged("a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got records %q, want %q", got, want)
+	}
+}
+
+func TestLogReapedJob(t *testing.T) {
+	h := &logRecorder{}
+	s := &frozenStore{frozen: true}
+	p, _ := stallOnce(s)
+	c, w, err := queue.NewWithStore(context.Background(), s, p, queue.WithLogger(slog.New(h)), queue.WithReaper(reapInterval, staleAfter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	defer runWorker(t, w, 1)()
+	waitForJob(t, c, "a")
+
+	want := []string{"DEBUG job started", "WARN job stalled, reaping it", "ERROR job failed"}
+	if got := h.logged("a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got records %q, want %q", got, want)
+	}
+}
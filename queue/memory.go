@@ -0,0 +1,835 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// memoryQueue holds the state shared by the client and the workers
+// returned by New and NewWithStore.
+//
+// The records of the jobs are kept in store, and live holds what the
+// queue keeps in memory about each of them. pending holds, for each named
+// queue, the heaps of its Queued jobs ordered as they are to be dispatched.
+// All of it is guarded by mu, which serializes the calls to the store.
+// Whenever a job is added to pending, changed is closed (and replaced) to
+// wake up any worker waiting for jobs. Jobs are not dispatched from the
+// queues in paused. Transitions are reported to metrics to events, through
+// eventLanes if it is not nil, and to logger as they happen. clock tells
+// the time of what happens at given times, and sets off their timers.
+//
+// processing counts the Processing jobs, and lastProgress is the last
+// time an attempt to process a job ended or, if later, the last time
+// the queue got work after having none. counts counts the jobs in
+// each state, and workers the worker goroutines of the calls to Run,
+// which clocks time, adding up the time of those that returned in
+// idleTime and busyTime. lastSeq is the seq given to
+// the last created job. aging is the priority aging period, lifo
+// tells whether jobs of the same priority are taken newest first,
+// tracer traces the jobs, maxPayload limits the size of their
+// payloads, if not 0. compression compresses them in the store,
+// encryption, if not nil, encrypts them, and checksums tells whether
+// they are checksummed.
+//
+// givenBack holds the queues of the workers by the names of the queues of
+// the reservations given back to them, whose jobs go to the former,
+// reservations the IDs of the jobs that are not terminal in the queues of
+// the other reservations, by their names, and lastReservation counts the
+// reservations made.
+//
+// If the depth of the queues is limited to maxDepth, waiters holds, for
+// each named queue, the producers waiting for room in it, and reserved
+// the room that was granted to some of them but not taken yet.
+//
+// pressure is the pressure level of the queues, as worked out from their
+// depth with the thresholds mediumPressure and highPressure, and
+// pressureSubs the channels of Pressure it is sent to when it changes.
+//
+// maxScheduled, if not 0, limits the number of Scheduled jobs, and
+// clockSkew is how early they are Queued to make up for clock skew.
+//
+// dedup holds the IDs of the jobs that are not terminal by their
+// deduplication keys, and aliases the IDs of the jobs that were
+// deduplicated by the IDs given to create them.
+//
+// keyLimits are the limits of the concurrency keys, and running counts
+// the jobs with each limited key that were dispatched and whose attempt
+// has not ended yet.
+//
+// If the queue has a reaper, reapTimer goes off every reapInterval while
+// jobs are Processing, as told by reaping, to reap those whose heartbeat
+// is older than staleAfter, retrying them as retryPolicy allows.
+//
+// If the queue has a stall detector, stallTimer goes off stallAfter
+// since the last progress, to check whether the queue is stalled,
+// and stalled tells whether onStall was called since then.
+//
+// ownerID is the identity of the queue the jobs it
+// starts processing are claimed by.
+//
+// skipUnchanged tells whether SetData skips the
+// payloads that are the same as the stored ones.
+//
+// lastEnd is the last time a job reached a terminal state, and
+// endInterval the moving average of the intervals between them.
+//
+// With retry fairness, as told by retryFair, retryCredits holds the
+// credit of each named queue for dispatching a retry, which it
+// earns at retryRatio per dispatch.
+//
+// results expires the terminal jobs after the result TTL, if any, and
+// deadLetters the Failed ones after the dead-letter retention, if any.
+// If the queue has a dead-letter alert, onDeadLetters is called when
+// the number of Failed jobs reaches deadLetterAlert, after which
+// deadLettersAlerted tells that it is not to be called again until
+// the number drops below.
+//
+// If the queue coalesces notifications, summary counts the jobs that
+// reached a terminal state since the start of the current period of
+// summaryWindow, if any, and summaryTimer goes off at its end to hand
+// it to summarySubs.
+//
+// queueBuffer is the room set aside for the pending jobs of each named
+// queue, and notificationBuffer the number of states a subscription
+// holds for its subscriber before its drop policy applies.
+//
+// With a CPU target, cpuTimer goes off, as told by cpuSampling, to
+// sample the CPU time of the process, as told by cpuTime, which was
+// lastCPU at lastCPUAt, and cpuLimit, if not 0, is the number of jobs
+// to be processed at once, counting those dispatched to a worker
+// goroutine that did not start them yet.
+type memoryQueue struct {
+	mu           sync.RWMutex
+	store        Store
+	lastSeq      uint64
+	live         map[string]*liveJob
+	pending      map[string]*pendingQueue
+	paused       map[string]bool
+	changed      chan struct{}
+	clock        clock
+	metrics      MetricsSink
+	events       EventHandler
+	eventLanes   eventLanes
+	logger       *slog.Logger
+	processing   int
+	counts       map[State]int
+	workers      int
+	lastProgress time.Time
+	aging        time.Duration
+	lifo         bool
+	tracer       trace.Tracer
+	clocks       map[*workerClock]struct{}
+	idleTime     time.Duration
+	busyTime     time.Duration
+	maxPayload   int
+	compression  Compression
+	encryption   *encryption
+	checksums    bool
+
+	givenBack       map[string]string
+	reservations    map[string]map[string]struct{}
+	lastReservation int
+
+	maxDepth int
+	waiters  map[string][]*depthWaiter
+	reserved map[string]int
+
+	mediumPressure float64
+	highPressure   float64
+	pressure       PressureLevel
+	pressureSubs   []chan PressureLevel
+
+	maxScheduled int
+	clockSkew    time.Duration
+
+	dedup   map[string]string
+	aliases map[string]string
+
+	keyLimits map[string]int
+	running   map[string]int
+
+	reapInterval time.Duration
+	staleAfter   time.Duration
+	retryPolicy  RetryPolicy
+	reapTimer    timer
+	reaping      bool
+
+	stallAfter time.Duration
+	onStall    func()
+	stallTimer timer
+	stalled    bool
+
+	ownerID string
+
+	skipUnchanged bool
+
+	lastEnd     time.Time
+	endInterval time.Duration
+
+	retryFair    bool
+	retryRatio   float64
+	retryCredits map[string]float64
+
+	results            expirer
+	deadLetters        expirer
+	onDeadLetters      func(count int)
+	deadLetterAlert    int
+	deadLettersAlerted bool
+
+	summaryWindow time.Duration
+	summary       Summary
+	summaryTimer  timer
+	summarySubs   []*summarySubscription
+
+	queueBuffer        int
+	notificationBuffer int
+
+	cpuTarget   float64
+	cpuTime     func() time.Duration
+	cpuTimer    timer
+	cpuSampling bool
+	lastCPU     time.Duration
+	lastCPUAt   time.Time
+	cpuLimit    int
+	dispatching int
+}
+
+func newMemoryQueue(cfg config, store Store) *memoryQueue {
+	q := &memoryQueue{
+		store:       cfg.spill(store),
+		live:        make(map[string]*liveJob),
+		pending:     make(map[string]*pendingQueue),
+		paused:      make(map[string]bool),
+		changed:     make(chan struct{}),
+		clock:       cfg.clock,
+		counts:      make(map[State]int),
+		clocks:      make(map[*workerClock]struct{}),
+		metrics:     cfg.metrics,
+		events:      cfg.events,
+		eventLanes:  newEventLanes(cfg.eventWorkers),
+		logger:      cfg.logger,
+		aging:       cfg.priorityAging,
+		tracer:      cfg.tracer,
+		maxPayload:  cfg.maxPayload,
+		compression: cfg.compression,
+		encryption:  newEncryption(cfg.encryptionKeys),
+		checksums:   cfg.checksums,
+
+		givenBack:    make(map[string]string),
+		reservations: make(map[string]map[string]struct{}),
+
+		maxDepth: cfg.maxDepth,
+		waiters:  make(map[string][]*depthWaiter),
+		reserved: make(map[string]int),
+
+		mediumPressure: cfg.mediumPressure,
+		highPressure:   cfg.highPressure,
+
+		maxScheduled: cfg.maxScheduled,
+		clockSkew:    cfg.clockSkew,
+
+		dedup:   make(map[string]string),
+		aliases: make(map[string]string),
+
+		keyLimits: cfg.keyLimits,
+		running:   make(map[string]int),
+
+		reapInterval: cfg.reapInterval,
+		staleAfter:   cfg.staleAfter,
+		retryPolicy:  cfg.retryPolicy,
+
+		ownerID: cfg.ownerID,
+
+		skipUnchanged: cfg.skipUnchanged,
+
+		retryFair:    cfg.retryFair,
+		retryRatio:   cfg.retryRatio,
+		retryCredits: make(map[string]float64),
+
+		results:         expirer{ttl: cfg.resultTTL},
+		deadLetters:     expirer{ttl: cfg.deadLetterTTL},
+		onDeadLetters:   cfg.onDeadLetters,
+		deadLetterAlert: cfg.deadLetterAlert,
+
+		summaryWindow: cfg.summaryWindow,
+
+		queueBuffer:        cfg.queueBuffer,
+		notificationBuffer: cfg.notificationBuffer,
+
+		cpuTarget: cfg.cpuTarget,
+		cpuTime:   cfg.cpuTime,
+	}
+	if cfg.intraOrder == LIFO {
+		if cfg.explicitAging && cfg.priorityAging > 0 {
+			cfg.logger.Warn("dropping the priority aging of a queue with LIFO order",
+				slog.Duration("aging", cfg.priorityAging))
+		}
+		q.aging, q.lifo = 0, true
+	}
+	if cfg.onStall != nil && cfg.stallAfter > 0 {
+		q.stallAfter, q.onStall = cfg.stallAfter, cfg.onStall
+	}
+	return q
+}
+
+// restore makes the queue pick up the jobs already in its store.
+// Queued jobs are added to pending and Scheduled ones scheduled. Jobs
+// that were Processing had their processing interrupted, so they are
+// Queued again, like requeue does. Terminal jobs start expiring.
+func (q *memoryQueue) restore(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	records, err := q.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Seq < records[j].Seq
+	})
+	for _, r := range records {
+		if r.Seq > q.lastSeq {
+			q.lastSeq = r.Seq
+		}
+		l := q.add(r)
+		switch r.State {
+		case Scheduled:
+			q.schedule(r.ID, q.untilDue(r.RunAt))
+		case Processing:
+			r.State = Queued
+			r.Attempts--
+			r.Completed, r.Total, r.Reported = 0, 0, false
+			r.ClaimedBy = ""
+			if err := q.store.Save(ctx, r); err != nil {
+				return err
+			}
+			q.count(l, Queued)
+			q.push(r)
+		case Queued:
+			q.push(r)
+		default:
+			close(l.done)
+			q.expireLater(l)
+		}
+	}
+	q.watchDeadLetters()
+	return nil
+}
+
+// push adds the given Queued jobs to the pending jobs of their queues,
+// waking up the waiting workers once for all of them.
+// mu must be held by the caller.
+func (q *memoryQueue) push(records ...JobRecord) {
+	now := q.clock.now()
+	if !q.busy() {
+		q.progressed(now)
+	}
+	for i, r := range records {
+		p, ok := q.pending[r.Queue]
+		if !ok {
+			p = newPendingQueue(q.queueBuffer)
+			q.pending[r.Queue] = p
+		}
+		seq := r.Seq
+		if q.lifo {
+			seq = ^seq
+		}
+		h := &p.jobs
+		if q.retryFair && r.Attempts > 0 {
+			h = &p.retries
+		}
+		heap.Push(h, pendingJob{id: r.ID, rank: rank(r.Priority, now, q.aging), seq: seq, key: r.Key, since: now, retry: r.Attempts > 0})
+		if i == len(records)-1 || records[i+1].Queue != r.Queue {
+			q.reportDepth(r.Queue)
+		}
+	}
+	q.wake()
+}
+
+// wake wakes up the workers waiting for jobs.
+// mu must be held by the caller.
+func (q *memoryQueue) wake() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// next blocks until there is a pending job in the named queue or ctx is done.
+// It takes the first pending job of the queue out of pending and returns its
+// ID, or returns false if ctx got done first. The worker goroutine calling
+// it, timed by clock, is idle until it returns a job, and busy from then on.
+func (q *memoryQueue) next(ctx context.Context, name string, clock *workerClock) (string, bool) {
+	for {
+		if ctx.Err() != nil {
+			return "", false
+		}
+		q.mu.Lock()
+		id, ok := q.pop(name)
+		q.tick(clock, id)
+		changed := q.changed
+		q.mu.Unlock()
+		if ok {
+			return id, true
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+}
+
+// tryNext is like next, but returns false right away
+// when there are no pending jobs instead of waiting.
+func (q *memoryQueue) tryNext(name string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pop(name)
+}
+
+// pop takes the first job out of the pending jobs of the named queue,
+// if any and the queue is not paused, and returns its ID. Jobs whose
+// concurrency key is at its limit are skipped, and the job taken counts
+// against the limit of its key. mu must be held by the caller.
+func (q *memoryQueue) pop(name string) (string, bool) {
+	p, ok := q.pending[name]
+	if !ok || q.paused[name] || q.cpuThrottled() {
+		return "", false
+	}
+	var taken pendingJob
+	var found bool
+	if q.retryFair {
+		// The first job of the kind due is dispatched,
+		// or the first of the other if there is none.
+		credit, wantRetry := q.retryCredit(name)
+		first, second := &p.jobs, &p.retries
+		if wantRetry {
+			first, second = second, first
+		}
+		if taken, found = q.popHeap(first); !found {
+			taken, found = q.popHeap(second)
+		}
+		if found {
+			q.dispatched(name, credit, taken.retry)
+		}
+	} else {
+		taken, found = q.popHeap(&p.jobs)
+	}
+	if !found {
+		return "", false
+	}
+	q.takeKey(taken.key)
+	q.dispatching++
+	q.reportDepth(name)
+	q.admit(name)
+	return taken.id, true
+}
+
+// popHeap takes the first job out of h whose concurrency key is not
+// at its limit, if any, leaving the jobs it skipped in h.
+// mu must be held by the caller.
+func (q *memoryQueue) popHeap(h *pendingHeap) (pendingJob, bool) {
+	var skipped []pendingJob
+	defer func() {
+		for _, pj := range skipped {
+			heap.Push(h, pj)
+		}
+	}()
+	for h.Len() > 0 {
+		pj := heap.Pop(h).(pendingJob)
+		if !q.keyFull(pj.key) {
+			return pj, true
+		}
+		skipped = append(skipped, pj)
+	}
+	return pendingJob{}, false
+}
+
+// depth returns the number of pending jobs of the named queue.
+// mu must be held by the caller.
+func (q *memoryQueue) depth(name string) int {
+	if p, ok := q.pending[name]; ok {
+		return p.Len()
+	}
+	return 0
+}
+
+// reportDepth reports the number of pending jobs of the named queue.
+// mu must be held by the caller.
+func (q *memoryQueue) reportDepth(name string) {
+	q.metrics.Gauge(MetricQueueDepth, float64(q.depth(name)), Tag{Key: "queue", Value: name})
+	q.updatePressure()
+}
+
+// start moves the given job from Queued to Processing and returns
+// the access to it for the attempt to process it that starts.
+// cancel is the function canceling the context of that attempt.
+// It returns false if the job is not Queued anymore, or if it is
+// past its deadline, making it Failed then.
+func (q *memoryQueue) start(id string, cancel context.CancelFunc) (*processingJob, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dispatching--
+	if q.cpuLimit > 0 {
+		defer q.wake()
+	}
+	l, ok := q.live[id]
+	if !ok {
+		return nil, false, nil
+	}
+	r, err := q.store.Load(context.Background(), id)
+	if err != nil || r.State != Queued {
+		q.releaseKey(l)
+		return nil, false, err
+	}
+	if !r.Deadline.IsZero() && !q.clock.now().Before(r.Deadline) {
+		return nil, false, q.expire(r, l)
+	}
+	r.State = Processing
+	r.Attempts++
+	r.ClaimedBy = q.ownerID
+	now := q.clock.now()
+	r.Heartbeat = now
+	if err := q.store.Save(context.Background(), r); err != nil {
+		q.releaseKey(l)
+		return nil, false, err
+	}
+	l.cancel = cancel
+	l.starts++
+	l.started = now
+	q.transitioned(l, Processing)
+	q.processing++
+	q.armReaper()
+	q.metrics.Counter(MetricJobsStarted, 1)
+	q.emit(id, func(h EventHandler) { h.OnStarted(id, r.Attempts) })
+	q.log(slog.LevelDebug, "job started", r)
+	return &processingJob{q: q, id: id, attempt: r.Attempts, start: l.starts, queue: r.Queue, trace: r.Trace, deadline: r.Deadline, schedule: r.Schedule}, true, nil
+}
+
+// expire makes the given Queued job, which was dispatched
+// past its deadline, Failed with ErrDeadlineBeforeStart.
+// mu must be held by the caller.
+func (q *memoryQueue) expire(r JobRecord, l *liveJob) error {
+	defer q.releaseKey(l)
+	r.State = Failed
+	r.Error = ErrDeadlineBeforeStart.Error()
+	r.FailureCategory = FailureLifetimeExceeded
+	if err := q.store.Save(context.Background(), r); err != nil {
+		return err
+	}
+	q.progressed(q.clock.now())
+	q.transitioned(l, Failed)
+	q.metrics.Counter(MetricJobsFailed, 1)
+	q.emit(r.ID, func(h EventHandler) { h.OnFailed(r.ID, r.Attempts, ErrDeadlineBeforeStart) })
+	q.log(slog.LevelError, "job failed", r, slog.String("error", r.Error))
+	close(l.done)
+	return nil
+}
+
+// finish moves the given Processing job to Finished if procErr is nil,
+// or to Failed with procErr as its error otherwise. The progress of
+// finished jobs is completed, and that of failed ones cleared.
+func (q *memoryQueue) finish(pj *processingJob, procErr error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, l, err := q.loadProcessing(pj)
+	if err != nil || l == nil {
+		return err
+	}
+	return q.end(r, l, procErr)
+}
+
+// end is finish once the job is loaded.
+// mu must be held by the caller.
+func (q *memoryQueue) end(r JobRecord, l *liveJob, procErr error) error {
+	if l.cancelled {
+		return q.endCancelled(r, l, procErr)
+	}
+	metric := MetricJobsFinished
+	if procErr != nil {
+		r.State = Failed
+		r.Error = procErr.Error()
+		r.FailureCategory = failureCategory(procErr)
+		r.Completed, r.Total, r.Reported = 0, 0, false
+		r.ClaimedBy = ""
+		metric = MetricJobsFailed
+	} else {
+		r.State = Finished
+		r.Error = ""
+		r.FailureCategory = ""
+		r.Completed = r.Total
+		r.ClaimedBy = ""
+	}
+	if err := q.store.Save(context.Background(), r); err != nil {
+		return err
+	}
+	q.attemptEnded(l)
+	q.transitioned(l, r.State)
+	q.ended()
+	q.metrics.Counter(metric, 1)
+	if procErr != nil {
+		q.emit(r.ID, func(h EventHandler) { h.OnFailed(r.ID, r.Attempts, procErr) })
+		q.log(slog.LevelError, "job failed", r, q.attemptDuration(l), slog.String("error", r.Error))
+	} else {
+		q.emit(r.ID, func(h EventHandler) { h.OnFinished(r.ID, r.Attempts) })
+		q.log(slog.LevelDebug, "job finished", r, q.attemptDuration(l))
+	}
+	close(l.done)
+	return nil
+}
+
+// retry moves the given Processing job, which failed with procErr,
+// back to Queued, clearing its progress. The job is added to pending
+// after the given delay.
+func (q *memoryQueue) retry(pj *processingJob, procErr error, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, l, err := q.loadProcessing(pj)
+	if err != nil || l == nil {
+		return err
+	}
+	return q.retryLater(r, l, procErr, delay)
+}
+
+// retryLater is retry once the job is loaded.
+// mu must be held by the caller.
+func (q *memoryQueue) retryLater(r JobRecord, l *liveJob, procErr error, delay time.Duration) error {
+	if l.cancelled {
+		return q.endCancelled(r, l, procErr)
+	}
+	id := r.ID
+	r.State = Queued
+	r.Error = procErr.Error()
+	r.FailureCategory = failureCategory(procErr)
+	r.Completed, r.Total, r.Reported = 0, 0, false
+	r.ClaimedBy = ""
+	if err := q.store.Save(context.Background(), r); err != nil {
+		return err
+	}
+	q.attemptEnded(l)
+	q.transitioned(l, Queued)
+	q.metrics.Counter(MetricJobsRetried, 1)
+	q.emit(id, func(h EventHandler) { h.OnRetry(id, r.Attempts, procErr, delay) })
+	q.log(slog.LevelWarn, "job failed, retrying", r, q.attemptDuration(l), slog.String("error", r.Error), slog.Duration("delay", delay))
+	if delay <= 0 {
+		q.push(r)
+		return nil
+	}
+	q.clock.afterFunc(delay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if r, err := q.store.Load(context.Background(), id); err == nil && r.State == Queued {
+			q.push(r)
+		}
+	})
+	return nil
+}
+
+// requeue moves the given Processing job back to Queued, clearing its
+// progress and adding it again to the pending jobs of its queue. It is
+// meant for interrupted jobs, so the interrupted attempt is not taken
+// into account.
+//
+// Like finish and retry, requeue makes a job that was cancelled while
+// Processing Cancelled instead, given the error of the interrupted attempt.
+func (q *memoryQueue) requeue(pj *processingJob, procErr error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, l, err := q.loadProcessing(pj)
+	if err != nil || l == nil {
+		return err
+	}
+	if l.cancelled {
+		return q.endCancelled(r, l, procErr)
+	}
+	r.State = Queued
+	r.Attempts--
+	r.Completed, r.Total, r.Reported = 0, 0, false
+	r.ClaimedBy = ""
+	if err := q.store.Save(context.Background(), r); err != nil {
+		return err
+	}
+	q.processing--
+	l.cancel = nil
+	q.releaseKey(l)
+	q.transitioned(l, Queued)
+	q.log(slog.LevelDebug, "job interrupted, queued again", r, q.attemptDuration(l))
+	q.push(r)
+	return nil
+}
+
+// loadProcessing returns the record of the given job and what the
+// queue keeps in memory about it, or a nil liveJob if the job is not
+// Processing in the attempt pj was given for anymore, such as when
+// the attempt was reaped. mu must be held by the caller.
+func (q *memoryQueue) loadProcessing(pj *processingJob) (JobRecord, *liveJob, error) {
+	l, ok := q.live[pj.id]
+	if !ok || l.starts != pj.start {
+		return JobRecord{}, nil, nil
+	}
+	r, err := q.store.Load(context.Background(), pj.id)
+	if err != nil || r.State != Processing {
+		return JobRecord{}, nil, err
+	}
+	return r, l, nil
+}
+
+// cancelJob cancels the given job. Scheduled and Queued jobs
+// are taken out of pending and Cancelled right away. Processing jobs get
+// the context of their processor canceled, and are marked as cancelled
+// so they become Cancelled when their processor returns.
+// mu must be held by the caller.
+func (q *memoryQueue) cancelJob(ctx context.Context, r JobRecord, l *liveJob) error {
+	switch r.State {
+	case Scheduled, Queued:
+		if err := q.saveCancelled(ctx, r, l); err != nil {
+			return err
+		}
+		if p, ok := q.pending[r.Queue]; ok && p.remove(r.ID) {
+			q.reportDepth(r.Queue)
+			q.admit(r.Queue)
+		}
+	case Processing:
+		l.cancelled = true
+		l.cancel()
+	}
+	return nil
+}
+
+// endCancelled records the end of the attempt to process the given job,
+// which was cancelled while Processing, making it Cancelled. procErr is
+// the error of the attempt, telling how its processor stopped.
+// mu must be held by the caller.
+func (q *memoryQueue) endCancelled(r JobRecord, l *liveJob, procErr error) error {
+	if err := q.saveCancelled(context.Background(), r, l); err != nil {
+		return err
+	}
+	q.attemptEnded(l)
+	q.emitCancelResult(r.ID, procErr)
+	return nil
+}
+
+// saveCancelled makes the given job Cancelled.
+// mu must be held by the caller.
+func (q *memoryQueue) saveCancelled(ctx context.Context, r JobRecord, l *liveJob) error {
+	r.State = Cancelled
+	r.Error = ""
+	r.FailureCategory = FailureCancelled
+	r.Completed, r.Total, r.Reported = 0, 0, false
+	r.ClaimedBy = ""
+	if err := q.store.Save(ctx, r); err != nil {
+		return err
+	}
+	q.transitioned(l, Cancelled)
+	q.metrics.Counter(MetricJobsCancelled, 1)
+	q.emit(r.ID, func(h EventHandler) { h.OnCancelled(r.ID) })
+	q.log(slog.LevelDebug, "job cancelled", r)
+	close(l.done)
+	return nil
+}
+
+// schedule moves the given Scheduled job to Queued after delay, or
+// later if it was rescheduled to run later by then. If that fails, the
+// job is left Scheduled.
+func (q *memoryQueue) schedule(id string, delay time.Duration) {
+	q.clock.afterFunc(delay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		r, err := q.store.Load(context.Background(), id)
+		if err != nil || r.State != Scheduled {
+			return
+		}
+		if delay := q.untilDue(r.RunAt); delay > 0 {
+			q.schedule(id, delay)
+			return
+		}
+		if err := q.store.UpdateState(context.Background(), id, Queued); err != nil {
+			return
+		}
+		r.State = Queued
+		if l, ok := q.live[id]; ok {
+			q.transitioned(l, Queued)
+		}
+		q.push(r)
+	})
+}
+
+// attemptEnded records the end of an attempt to process the given job.
+// mu must be held by the caller.
+func (q *memoryQueue) attemptEnded(l *liveJob) {
+	q.processing--
+	if q.cpuLimit > 0 {
+		q.wake()
+	}
+	l.cancel = nil
+	q.releaseKey(l)
+	q.progressed(q.clock.now())
+}
+
+// progressed records that the queue made progress at the given time,
+// and sets off the stall timer, if any, to check for a stall stallAfter
+// since then. mu must be held by the caller.
+func (q *memoryQueue) progressed(at time.Time) {
+	q.lastProgress = at
+	q.stalled = false
+	if q.onStall == nil {
+		return
+	}
+	if q.stallTimer == nil {
+		q.stallTimer = q.clock.afterFunc(q.stallAfter, q.checkStall)
+		return
+	}
+	q.stallTimer.Reset(q.stallAfter)
+}
+
+// checkStall calls onStall if the queue just got stalled. If the queue
+// is busy but made progress since the stall timer was set off, the
+// timer is set off again to check when the queue would be stalled.
+func (q *memoryQueue) checkStall() {
+	q.mu.Lock()
+	if !q.busy() || q.stalled {
+		q.mu.Unlock()
+		return
+	}
+	if idle := q.clock.now().Sub(q.lastProgress); idle < q.stallAfter {
+		q.stallTimer.Reset(q.stallAfter - idle)
+		q.mu.Unlock()
+		return
+	}
+	q.stalled = true
+	q.mu.Unlock()
+	q.onStall()
+}
+
+// busy tells whether there are jobs either pending or Processing,
+// leaving out those parked in QuarantineQueue, which are not waiting to
+// be processed. mu must be held by the caller.
+func (q *memoryQueue) busy() bool {
+	if q.processing > 0 {
+		return true
+	}
+	for name, p := range q.pending {
+		if name != QuarantineQueue && p.Len() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// quarantined returns the number of jobs parked in QuarantineQueue.
+// mu must be held by the caller.
+func (q *memoryQueue) quarantined() int {
+	if p, ok := q.pending[QuarantineQueue]; ok {
+		return p.Len()
+	}
+	return 0
+}
+
+// isTerminal tells whether a job in the given state is done for good
+func isTerminal(s State) bool {
+	return s == Finished || s == Failed || s == Cancelled
+}
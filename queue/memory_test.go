@@ -0,0 +1,118 @@
+package queue_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// stallDetector returns the option setting up a stall detector with the
+// given idleFor, and a function returning how many stalls were detected
+func stallDetector(idleFor time.Duration) (queue.Option, func() int32) {
+	var count int32
+	opt := queue.WithStallDetector(idleFor, func() {
+		atomic.AddInt32(&count, 1)
+	})
+	return opt, func() int32 { return atomic.LoadInt32(&count) }
+}
+
+// checkStalls checks that the stall detector returning
+// count has detected the given number of stalls
+func checkStalls(t *testing.T, count func() int32, want int32) {
+	t.Helper()
+	if n := count(); n != want {
+		t.Errorf("got %d stalls detected, want %d", n, want)
+	}
+}
+
+func TestStallDetectedOncePerStall(t *testing.T) {
+	const idleFor = 10 * time.Millisecond
+	clock := queue.NewFakeClock()
+	opt, count := stallDetector(idleFor)
+	p, started, release, _ := stubborn()
+	c, w := queue.New(p, opt, queue.WithFakeClock(clock))
+	defer runWorker(t, w, 1)()
+	createJobs(t, c, map[string]int{"a": 0, "b": 0})
+	<-started
+	clock.Advance(idleFor - time.Nanosecond)
+	checkStalls(t, count, 0)
+	clock.Advance(time.Nanosecond)
+	checkStalls(t, count, 1)
+	clock.Advance(5 * idleFor)
+	checkStalls(t, count, 1)
+
+	// Finishing the first job is progress, and then the second one stalls again
+	release <- struct{}{}
+	<-started
+	clock.Advance(idleFor)
+	checkStalls(t, count, 2)
+	close(release)
+}
+
+func TestStallDetectedWithoutWorkers(t *testing.T) {
+	const idleFor = 10 * time.Millisecond
+	clock := queue.NewFakeClock()
+	opt, count := stallDetector(idleFor)
+	c, _ := queue.New(doubler, opt, queue.WithFakeClock(clock))
+	createJobs(t, c, map[string]int{"a": 1})
+	clock.Advance(idleFor)
+	checkStalls(t, count, 1)
+}
+
+func TestNoStallWhenIdle(t *testing.T) {
+	const idleFor = 10 * time.Millisecond
+	clock := queue.NewFakeClock()
+	opt, count := stallDetector(idleFor)
+	c, w := queue.New(doubler, opt, queue.WithFakeClock(clock))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	clock.Advance(5 * idleFor)
+	checkStalls(t, count, 0)
+}
+
+func TestSingleStallDetectorForAllWorkers(t *testing.T) {
+	const idleFor = 10 * time.Millisecond
+	clock := queue.NewFakeClock()
+	opt, count := stallDetector(idleFor)
+	p, started, release, _ := stubborn()
+	c, w := queue.New(p, opt, queue.WithFakeClock(clock))
+	defer runWorker(t, w, 1)()
+	defer runWorker(t, w, 1)()
+	defer runWorker(t, w.(queue.MultiQueueWorker).ForQueue("other", p), 1)()
+	defer close(release)
+	createJobs(t, c, map[string]int{"a": 0})
+	<-started
+	clock.Advance(5 * idleFor)
+	checkStalls(t, count, 1)
+}
+
+func TestStallDetectedAfterDeadlinePassed(t *testing.T) {
+	const idleFor = 10 * time.Millisecond
+	clock := queue.NewFakeClock()
+	opt, count := stallDetector(idleFor)
+	p, started, release, _ := stubborn()
+	c, w := queue.New(p, opt, queue.WithFakeClock(clock))
+	err := c.CreateJob(context.Background(), "late", &intData{N: 1},
+		queue.WithPriority(1), queue.WithDeadline(clock.Now().Add(idleFor/4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"b": 0})
+	clock.Advance(idleFor / 2)
+
+	// The late job failing when dispatched is progress,
+	// and then the other one stalls idleFor later
+	defer runWorker(t, w, 1)()
+	defer close(release)
+	<-started
+	clock.Advance(idleFor / 2)
+	checkStalls(t, count, 0)
+	clock.Advance(idleFor / 2)
+	checkStalls(t, count, 1)
+	if j := waitForJob(t, c, "late"); j.State() != queue.Failed {
+		t.Errorf("got job %s, want it %s", j.State(), queue.Failed)
+	}
+}
@@ -0,0 +1,124 @@
+package queue
+
+import "sync"
+
+// Names of the metrics reported to the MetricsSink of a queue
+const (
+	// Counts created jobs
+	MetricJobsCreated = "jobs_created_total"
+	// Counts attempts to process jobs
+	MetricJobsStarted = "jobs_started_total"
+	// Counts failed attempts that will be retried
+	MetricJobsRetried = "jobs_retried_total"
+	// Counts jobs that finished successfully
+	MetricJobsFinished = "jobs_finished_total"
+	// Counts jobs that failed
+	MetricJobsFailed = "jobs_failed_total"
+	// Counts jobs that were cancelled
+	MetricJobsCancelled = "jobs_cancelled_total"
+	// Gauges the number of jobs waiting to be dispatched
+	MetricQueueDepth = "queue_depth"
+	// Observes how long each attempt to process a job took, in seconds
+	MetricProcessingSeconds = "job_processing_seconds"
+)
+
+// Tag is a key-value pair qualifying a metric
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// MetricsSink receives the metrics of a queue, so they can be
+// bridged to any monitoring system. Package promsink provides one
+// for Prometheus, and OpenMetricsSink writes them out as text.
+//
+// The Counter method adds delta to a counter.
+//
+// The Gauge method sets the current value of a gauge.
+//
+// The Observe method records a value of a distribution,
+// like a duration in seconds.
+//
+// The queue may call these methods while holding internal locks,
+// so implementations should be quick and must not call back
+// into the queue.
+type MetricsSink interface {
+	Counter(name string, delta float64, tags ...Tag)
+	Gauge(name string, value float64, tags ...Tag)
+	Observe(name string, value float64, tags ...Tag)
+}
+
+// WithMetricsSink makes the queue report its metrics to s
+func WithMetricsSink(s MetricsSink) Option {
+	return func(c *config) {
+		c.metrics = s
+	}
+}
+
+// NopMetricsSink is a MetricsSink that discards all metrics.
+// It is the one used by default.
+type NopMetricsSink struct{}
+
+func (NopMetricsSink) Counter(name string, delta float64, tags ...Tag) {}
+
+func (NopMetricsSink) Gauge(name string, value float64, tags ...Tag) {}
+
+func (NopMetricsSink) Observe(name string, value float64, tags ...Tag) {}
+
+// MemoryMetricsSink is a MetricsSink that keeps metrics in memory,
+// ignoring their tags. It is meant for tests. The zero value is ready
+// to use, and it is safe for concurrent use.
+type MemoryMetricsSink struct {
+	mu           sync.Mutex
+	counters     map[string]float64
+	gauges       map[string]float64
+	observations map[string][]float64
+}
+
+func (s *MemoryMetricsSink) Counter(name string, delta float64, tags ...Tag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counters == nil {
+		s.counters = make(map[string]float64)
+	}
+	s.counters[name] += delta
+}
+
+func (s *MemoryMetricsSink) Gauge(name string, value float64, tags ...Tag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gauges == nil {
+		s.gauges = make(map[string]float64)
+	}
+	s.gauges[name] = value
+}
+
+func (s *MemoryMetricsSink) Observe(name string, value float64, tags ...Tag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.observations == nil {
+		s.observations = make(map[string][]float64)
+	}
+	s.observations[name] = append(s.observations[name], value)
+}
+
+// CounterValue returns the current value of the given counter
+func (s *MemoryMetricsSink) CounterValue(name string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters[name]
+}
+
+// GaugeValue returns the last value set to the given gauge
+func (s *MemoryMetricsSink) GaugeValue(name string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gauges[name]
+}
+
+// Observations returns the values observed so far for the given metric
+func (s *MemoryMetricsSink) Observations(name string) []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]float64(nil), s.observations[name]...)
+}
@@ -0,0 +1,85 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestMetricsOfJobLifecycle(t *testing.T) {
+	sink := &queue.MemoryMetricsSink{}
+	p, _ := flaky(1)
+	c, w := queue.New(p, queue.WithMetricsSink(sink), queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}))
+	createJobs(t, c, map[string]int{"a": 0, "b": 0})
+	if got := sink.GaugeValue(queue.MetricQueueDepth); got != 2 {
+		t.Errorf("got queue depth %v after creating the jobs, want 2", got)
+	}
+	processAll(t, w) // Both jobs fail their first attempt and get queued again
+	processAll(t, w)
+
+	for name, want := range map[string]float64{
+		queue.MetricJobsCreated:  2,
+		queue.MetricJobsStarted:  4,
+		queue.MetricJobsRetried:  2,
+		queue.MetricJobsFinished: 2,
+		queue.MetricJobsFailed:   0,
+	} {
+		if got := sink.CounterValue(name); got != want {
+			t.Errorf("got %s %v, want %v", name, got, want)
+		}
+	}
+	if got := sink.GaugeValue(queue.MetricQueueDepth); got != 0 {
+		t.Errorf("got queue depth %v after processing the jobs, want 0", got)
+	}
+	if got := len(sink.Observations(queue.MetricProcessingSeconds)); got != 4 {
+		t.Errorf("got %d processing times observed, want 4", got)
+	}
+}
+
+func TestMetricsOfFailedJob(t *testing.T) {
+	sink := &queue.MemoryMetricsSink{}
+	c, w := queue.New(doubler, queue.WithMetricsSink(sink))
+	createJobs(t, c, map[string]int{"neg": -1})
+	processAll(t, w)
+	if got := sink.CounterValue(queue.MetricJobsFailed); got != 1 {
+		t.Errorf("got %s %v, want 1", queue.MetricJobsFailed, got)
+	}
+	if got := sink.CounterValue(queue.MetricJobsFinished); got != 0 {
+		t.Errorf("got %s %v, want 0", queue.MetricJobsFinished, got)
+	}
+}
+
+func TestNopMetricsSink(t *testing.T) {
+	c, w := queue.New(doubler, queue.WithMetricsSink(queue.NopMetricsSink{}))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if j, _ := c.GetJob(context.Background(), "a"); j.State() != queue.Finished {
+		t.Errorf("got state %q, want %q", j.State(), queue.Finished)
+	}
+}
+
+func TestQueueDepthOnDispatch(t *testing.T) {
+	sink := &queue.MemoryMetricsSink{}
+	p, started, release, _ := stubborn()
+	c, w := queue.New(p, queue.WithMetricsSink(sink))
+	createJobs(t, c, map[string]int{"a": 0, "b": 0})
+	if got := sink.GaugeValue(queue.MetricQueueDepth); got != 2 {
+		t.Errorf("got queue depth %v after creating the jobs, want 2", got)
+	}
+	stop := runWorker(t, w, 1)
+	<-started
+	if got := sink.GaugeValue(queue.MetricQueueDepth); got != 1 {
+		t.Errorf("got queue depth %v with a job dispatched, want 1", got)
+	}
+	if got := len(sink.Observations(queue.MetricProcessingSeconds)); got != 0 {
+		t.Errorf("got %d processing times observed before any job finished, want 0", got)
+	}
+	close(release)
+	waitForJob(t, c, "a")
+	waitForJob(t, c, "b")
+	stop()
+	if got := len(sink.Observations(queue.MetricProcessingSeconds)); got != 2 {
+		t.Errorf("got %d processing times observed, want one per job", got)
+	}
+}
@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ProcessorFunc turns a function into a Processor
+type ProcessorFunc func(ctx context.Context, j JobProcessingAccess) error
+
+// Process calls f
+func (f ProcessorFunc) Process(ctx context.Context, j JobProcessingAccess) error {
+	return f(ctx, j)
+}
+
+// ProcessorMiddleware returns a Processor that wraps next, doing some
+// work around its Process calls, which it passes the same context
+// (or one derived from it) and the same JobProcessingAccess. It can
+// also return an error without calling next at all.
+type ProcessorMiddleware func(next Processor) Processor
+
+// WithMiddleware makes the workers process jobs through the given
+// middlewares, in order: the first one is the outermost, so it is the
+// first to get each job and the last to return. Middlewares given in
+// several options are chained in the order of the options. They run
+// within the job timeout, and their panics are recovered like those
+// of the processor.
+func WithMiddleware(mws ...ProcessorMiddleware) Option {
+	return func(c *config) {
+		c.middleware = append(c.middleware, mws...)
+	}
+}
+
+// wrap returns p wrapped in the middleware of the config
+func (c config) wrap(p Processor) Processor {
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		p = c.middleware[i](p)
+	}
+	return p
+}
+
+// LoggingMiddleware logs to logger when each attempt to process
+// a job starts and when it ends, with its outcome.
+func LoggingMiddleware(logger *log.Logger) ProcessorMiddleware {
+	return func(next Processor) Processor {
+		return ProcessorFunc(func(ctx context.Context, j JobProcessingAccess) error {
+			logger.Printf("job %q: attempt %d started", j.ID(), j.Attempt())
+			started := time.Now()
+			err := next.Process(ctx, j)
+			if err != nil {
+				logger.Printf("job %q: attempt %d failed after %s: %v", j.ID(), j.Attempt(), time.Since(started), err)
+				return err
+			}
+			logger.Printf("job %q: attempt %d succeeded after %s", j.ID(), j.Attempt(), time.Since(started))
+			return nil
+		})
+	}
+}
+
+// TimingMiddleware calls record with the ID of each job
+// and how long each attempt to process it took.
+func TimingMiddleware(record func(id string, d time.Duration)) ProcessorMiddleware {
+	return func(next Processor) Processor {
+		return ProcessorFunc(func(ctx context.Context, j JobProcessingAccess) error {
+			started := time.Now()
+			err := next.Process(ctx, j)
+			record(j.ID(), time.Since(started))
+			return err
+		})
+	}
+}
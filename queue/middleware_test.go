@@ -0,0 +1,148 @@
+package queue_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// callLog records the calls made through the middlewares it returns,
+// and the JobProcessingAccess given to each of them
+type callLog struct {
+	mu    sync.Mutex
+	calls []string
+	seen  []queue.JobProcessingAccess
+}
+
+// middleware returns a middleware recording when jobs enter and leave it
+func (tr *callLog) middleware(name string) queue.ProcessorMiddleware {
+	return func(next queue.Processor) queue.Processor {
+		return queue.ProcessorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+			tr.record(name+" in", j)
+			err := next.Process(ctx, j)
+			tr.record(name+" out", j)
+			return err
+		})
+	}
+}
+
+func (tr *callLog) record(call string, j queue.JobProcessingAccess) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.calls = append(tr.calls, call)
+	tr.seen = append(tr.seen, j)
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	tr := &callLog{}
+	p := queue.ProcessorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		tr.record("process", j)
+		return nil
+	})
+	c, w := queue.New(p,
+		queue.WithMiddleware(tr.middleware("outer"), tr.middleware("middle")),
+		queue.WithMiddleware(tr.middleware("inner")),
+	)
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	want := []string{"outer in", "middle in", "inner in", "process", "inner out", "middle out", "outer out"}
+	if !reflect.DeepEqual(tr.calls, want) {
+		t.Errorf("got calls %q, want %q", tr.calls, want)
+	}
+	for i, j := range tr.seen {
+		if j != tr.seen[0] {
+			t.Errorf("got %q given another JobProcessingAccess than the outermost middleware", tr.calls[i])
+		}
+	}
+}
+
+func TestMiddlewareShortCircuits(t *testing.T) {
+	errRejected := errors.New("rejected")
+	reject := func(next queue.Processor) queue.Processor {
+		return queue.ProcessorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+			return errRejected
+		})
+	}
+	tr := &callLog{}
+	rec := &recorder{}
+	c, w := queue.New(rec, queue.WithMiddleware(tr.middleware("outer"), reject, tr.middleware("inner")))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if got := rec.processed(); len(got) != 0 {
+		t.Errorf("got jobs %v processed, want none", got)
+	}
+	if want := []string{"outer in", "outer out"}; !reflect.DeepEqual(tr.calls, want) {
+		t.Errorf("got calls %q, want %q", tr.calls, want)
+	}
+	if j, _ := c.GetJob(context.Background(), "a"); j.State() != queue.Failed || j.Error() != errRejected.Error() {
+		t.Errorf("got job %s with error %q, want it %s with error %q", j.State(), j.Error(), queue.Failed, errRejected)
+	}
+}
+
+func TestMiddlewareForQueue(t *testing.T) {
+	tr := &callLog{}
+	c, w := queue.New(doubler, queue.WithMiddleware(tr.middleware("mw")))
+	other := w.(queue.MultiQueueWorker).ForQueue("other", doubler)
+	if err := c.CreateJobInQueue(context.Background(), "other", "a", &intData{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, other)
+	if want := []string{"mw in", "mw out"}; !reflect.DeepEqual(tr.calls, want) {
+		t.Errorf("got calls %q, want %q", tr.calls, want)
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	c, w := queue.New(doubler, queue.WithMiddleware(queue.LoggingMiddleware(log.New(&buf, "", 0))))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	createJobs(t, c, map[string]int{"neg": -1})
+	processAll(t, w)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	prefixes := []string{
+		`job "a": attempt 1 started`,
+		`job "a": attempt 1 succeeded after `,
+		`job "neg": attempt 1 started`,
+		`job "neg": attempt 1 failed after `,
+	}
+	if len(lines) != len(prefixes) {
+		t.Fatalf("got log %q, want %d lines", lines, len(prefixes))
+	}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("got log line %q, want it to start with %q", lines[i], prefix)
+		}
+	}
+	if !strings.HasSuffix(lines[3], errNegative.Error()) {
+		t.Errorf("got log line %q, want it to end with the error %q", lines[3], errNegative)
+	}
+}
+
+func TestTimingMiddleware(t *testing.T) {
+	const sleep = 5 * time.Millisecond
+	timings := make(map[string][]time.Duration)
+	record := func(id string, d time.Duration) {
+		timings[id] = append(timings[id], d)
+	}
+	p := queue.ProcessorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		time.Sleep(sleep)
+		return nil
+	})
+	c, w := queue.New(p, queue.WithMiddleware(queue.TimingMiddleware(record)))
+	createJobs(t, c, map[string]int{"a": 1, "b": 2})
+	processAll(t, w)
+	for _, id := range []string{"a", "b"} {
+		if got := timings[id]; len(got) != 1 || got[0] < sleep {
+			t.Errorf("got timings %v for job %q, want one of at least %v", got, id, sleep)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+)
+
+// MoveJob moves the Scheduled or Queued job with the given ID or alias to
+// the named queue, with its payload and attempts, so that the workers of
+// that queue process it instead. A Queued job keeps its place by priority
+// among the jobs of its new queue. It fails with an error wrapping
+// ErrJobNotFound if there is no such job, ErrJobTerminal if the job is
+// terminal, ErrJobProcessing if it is Processing, as its worker would
+// go on processing it in its queue anyway, and ErrQueueFull if it is
+// Queued and the new queue already has as many Queued jobs as
+// WithMaxQueueDepth allows.
+func (c *client) MoveJob(ctx context.Context, id, targetQueue string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkQueueName(targetQueue); err != nil {
+		return err
+	}
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	id = c.q.resolve(id)
+	if _, ok := c.q.live[id]; !ok {
+		return fmt.Errorf("cannot move job %q: %w", id, ErrJobNotFound)
+	}
+	r, err := c.q.store.Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case isTerminal(r.State):
+		return fmt.Errorf("cannot move job %q, which is %s: %w", id, r.State, ErrJobTerminal)
+	case r.State == Processing:
+		return fmt.Errorf("cannot move job %q to queue %q: %w", id, targetQueue, ErrJobProcessing)
+	case r.Queue == targetQueue:
+		return nil
+	case r.State == Queued:
+		if err := c.q.waitForRoom(ctx, targetQueue, 1, false); err != nil {
+			return fmt.Errorf("cannot move job %q: %w", id, err)
+		}
+	}
+	return c.q.relocate(ctx, r, targetQueue)
+}
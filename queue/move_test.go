@@ -0,0 +1,148 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestMoveQueuedJob(t *testing.T) {
+	c, w := queue.New(doubler)
+	other := w.(queue.MultiQueueWorker).ForQueue("other", doubler)
+	createJobs(t, c, map[string]int{"a": 21})
+	if err := c.MoveJob(context.Background(), "a", "other"); err != nil {
+		t.Fatalf("moving job: %v", err)
+	}
+	if n := processAll(t, w); n != 0 {
+		t.Errorf("processed %d jobs in the source queue, want 0", n)
+	}
+	if n := processAll(t, other); n != 1 {
+		t.Errorf("processed %d jobs in the target queue, want 1", n)
+	}
+	var d intData
+	if err := waitForJob(t, c, "a").GetData(&d); err != nil || d.N != 42 {
+		t.Errorf("got payload %d, %v, want %d", d.N, err, 42)
+	}
+}
+
+func TestMoveScheduledJob(t *testing.T) {
+	c, w := queue.New(doubler)
+	other := w.(queue.MultiQueueWorker).ForQueue("other", doubler)
+	if err := c.CreateJobAt(context.Background(), "a", &intData{N: 1}, time.Now().Add(10*time.Millisecond)); err != nil {
+		t.Fatalf("creating job: %v", err)
+	}
+	if err := c.MoveJob(context.Background(), "a", "other"); err != nil {
+		t.Fatalf("moving job: %v", err)
+	}
+	defer runWorker(t, other, 1)()
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Errorf("got state %q, want %q", j.State(), queue.Finished)
+	}
+	if n := processAll(t, w); n != 0 {
+		t.Errorf("processed %d jobs in the source queue, want 0", n)
+	}
+}
+
+func TestMoveJobKeepsAttempts(t *testing.T) {
+	p, attempts := flaky(1)
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}))
+	other := w.(queue.MultiQueueWorker).ForQueue("other", p)
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if err := c.MoveJob(context.Background(), "a", "other"); err != nil {
+		t.Fatalf("moving job: %v", err)
+	}
+	processAll(t, other)
+	if got := attempts(); len(got) != 2 || got[1] != 2 {
+		t.Errorf("got attempts %v, want [1 2]", got)
+	}
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Errorf("got state %q, want %q", j.State(), queue.Finished)
+	}
+}
+
+func TestMoveJobToFullQueue(t *testing.T) {
+	c, w := queue.New(doubler, queue.WithMaxQueueDepth(1))
+	createJobs(t, c, map[string]int{"a": 1})
+	if err := c.CreateJobInQueue(context.Background(), "other", "c", &intData{N: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MoveJob(context.Background(), "a", "other"); !errors.Is(err, queue.ErrQueueFull) {
+		t.Errorf("got error %v moving a job to a full queue, want %v", err, queue.ErrQueueFull)
+	}
+	if err := c.CreateJobAt(context.Background(), "later", &intData{}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MoveJob(context.Background(), "later", "other"); err != nil {
+		t.Errorf("got error %v moving a scheduled job to a full queue, want nil", err)
+	}
+	if n := processAll(t, w); n != 1 {
+		t.Errorf("processed %d jobs in the source queue, want 1", n)
+	}
+}
+
+// valueStore is a store recording the value of valueKey
+// in the contexts it is given to save records
+type valueStore struct {
+	queue.MemoryStore
+	mu     sync.Mutex
+	values []any
+}
+
+type valueKey struct{}
+
+func (s *valueStore) Save(ctx context.Context, r queue.JobRecord) error {
+	s.mu.Lock()
+	s.values = append(s.values, ctx.Value(valueKey{}))
+	s.mu.Unlock()
+	return s.MemoryStore.Save(ctx, r)
+}
+
+func TestMoveJobSavesWithContext(t *testing.T) {
+	s := &valueStore{}
+	c, _, err := queue.NewWithStore(context.Background(), s, doubler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	ctx := context.WithValue(context.Background(), valueKey{}, "move")
+	if err := c.MoveJob(ctx, "a", "other"); err != nil {
+		t.Fatalf("moving job: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if got := s.values[len(s.values)-1]; got != "move" {
+		t.Errorf("got the job saved with context value %v, want %q", got, "move")
+	}
+}
+
+func TestMoveProcessingJob(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	defer runWorker(t, w, 1)()
+	waitForStarts(t, started, 1)
+	if err := c.MoveJob(context.Background(), "a", "other"); !errors.Is(err, queue.ErrJobProcessing) {
+		t.Errorf("got error %v moving a processing job, want %v", err, queue.ErrJobProcessing)
+	}
+	close(proceed)
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Errorf("got state %q, want %q", j.State(), queue.Finished)
+	}
+}
+
+func TestMoveTerminalOrMissingJob(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 0})
+	processAll(t, w)
+	if err := c.MoveJob(context.Background(), "a", "other"); !errors.Is(err, queue.ErrJobTerminal) {
+		t.Errorf("got error %v moving a finished job, want %v", err, queue.ErrJobTerminal)
+	}
+	if err := c.MoveJob(context.Background(), "missing", "other"); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v moving a missing job, want %v", err, queue.ErrJobNotFound)
+	}
+}
@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// WithNotificationWorkers makes the queue call its event handler from up
+// to n goroutines of its own rather than synchronously, so that a slow
+// handler does not hold back the workers, and it may block or call back
+// into the queue. The events of a job are handled one after the other,
+// in order, and those of different jobs concurrently. Events are queued
+// without bound while the handler is busy, and the goroutines only run
+// while there are events to handle.
+func WithNotificationWorkers(n int) Option {
+	return func(c *config) {
+		c.eventWorkers = n
+	}
+}
+
+// eventLanes dispatches the calls to an event handler to goroutines,
+// one per lane at most, each job having its events in one of the lanes
+// so that they are handled in order.
+type eventLanes []eventLane
+
+// eventLane is a lane of eventLanes. calls are the calls waiting to be
+// made, and running tells whether a goroutine is making them, both
+// guarded by mu.
+type eventLane struct {
+	mu      sync.Mutex
+	calls   []func()
+	running bool
+}
+
+// newEventLanes returns n lanes, or nil if n is not positive
+func newEventLanes(n int) eventLanes {
+	if n <= 0 {
+		return nil
+	}
+	return make(eventLanes, n)
+}
+
+// dispatch has call made in the lane of the given job, starting
+// a goroutine to make it unless the lane has one already
+func (e eventLanes) dispatch(id string, call func()) {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	l := &e[h.Sum32()%uint32(len(e))]
+	l.mu.Lock()
+	l.calls = append(l.calls, call)
+	start := !l.running
+	l.running = true
+	l.mu.Unlock()
+	if start {
+		go l.drain()
+	}
+}
+
+// drain makes the calls of the lane in order until there is none left
+func (l *eventLane) drain() {
+	for {
+		l.mu.Lock()
+		if len(l.calls) == 0 {
+			l.running = false
+			l.mu.Unlock()
+			return
+		}
+		call := l.calls[0]
+		l.calls[0] = nil
+		l.calls = l.calls[1:]
+		l.mu.Unlock()
+		call()
+	}
+}
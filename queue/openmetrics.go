@@ -0,0 +1,137 @@
+package queue
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OpenMetricsSink is a MetricsSink that keeps the metrics of a queue in
+// memory to write them in the OpenMetrics text format with WriteMetrics,
+// like to serve them to a scraper, without depending on a Prometheus
+// client. Counters and gauges are kept by tags, which become labels,
+// and observations are summed up as summaries with a count and a sum.
+// The names of the metrics are the same as with package promsink without
+// a namespace. The zero value is ready to use, and it is safe for
+// concurrent use.
+type OpenMetricsSink struct {
+	mu       sync.Mutex
+	counters map[string]map[string]float64
+	gauges   map[string]map[string]float64
+	counts   map[string]map[string]float64
+	sums     map[string]map[string]float64
+}
+
+var _ MetricsSink = &OpenMetricsSink{}
+
+func (s *OpenMetricsSink) Counter(name string, delta float64, tags ...Tag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters = addSample(s.counters, name, labels(tags), delta)
+}
+
+func (s *OpenMetricsSink) Gauge(name string, value float64, tags ...Tag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := labels(tags)
+	s.gauges = addSample(s.gauges, name, l, 0)
+	s.gauges[name][l] = value
+}
+
+func (s *OpenMetricsSink) Observe(name string, value float64, tags ...Tag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := labels(tags)
+	s.counts = addSample(s.counts, name, l, 1)
+	s.sums = addSample(s.sums, name, l, value)
+}
+
+// WriteMetrics writes the metrics kept so far to w in the OpenMetrics
+// text format, sorted by name and labels, and returns the error of w,
+// if any. Counters are written with the _total suffix, which their
+// names have already when they are those of the queue.
+func (s *OpenMetricsSink) WriteMetrics(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, name := range sortedNames(s.counters) {
+		family := strings.TrimSuffix(name, "_total")
+		writeFamily(bw, family, "counter", map[string]map[string]float64{family + "_total": s.counters[name]})
+	}
+	for _, name := range sortedNames(s.gauges) {
+		writeFamily(bw, name, "gauge", map[string]map[string]float64{name: s.gauges[name]})
+	}
+	for _, name := range sortedNames(s.counts) {
+		writeFamily(bw, name, "summary", map[string]map[string]float64{
+			name + "_count": s.counts[name],
+			name + "_sum":   s.sums[name],
+		})
+	}
+	bw.WriteString("# EOF\n")
+	return bw.Flush()
+}
+
+// addSample adds delta to the sample of the named metric with the
+// given labels in samples, which it returns, making it if it is nil
+func addSample(samples map[string]map[string]float64, name, labels string, delta float64) map[string]map[string]float64 {
+	if samples == nil {
+		samples = make(map[string]map[string]float64)
+	}
+	if samples[name] == nil {
+		samples[name] = make(map[string]float64)
+	}
+	samples[name][labels] += delta
+	return samples
+}
+
+// writeFamily writes the metric family with the given name and type,
+// whose samples are given by sample name and then by labels
+func writeFamily(w *bufio.Writer, family, typ string, samples map[string]map[string]float64) {
+	w.WriteString("# TYPE " + family + " " + typ + "\n")
+	for _, name := range sortedNames(samples) {
+		for _, l := range sortedLabels(samples[name]) {
+			w.WriteString(name + l + " " + strconv.FormatFloat(samples[name][l], 'g', -1, 64) + "\n")
+		}
+	}
+}
+
+// labels returns the OpenMetrics labels of the given tags, sorted by key,
+// as in `{queue="default"}`, or an empty string if there is no tag
+func labels(tags []Tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	sorted := append([]Tag(nil), tags...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	pairs := make([]string, len(sorted))
+	for i, t := range sorted {
+		pairs[i] = t.Key + `="` + labelEscaper.Replace(t.Value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes the values of the labels
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sortedNames returns the names of the metrics of samples, sorted
+func sortedNames(samples map[string]map[string]float64) []string {
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedLabels returns the labels of the samples of a metric, sorted
+func sortedLabels(samples map[string]float64) []string {
+	keys := make([]string, 0, len(samples))
+	for l := range samples {
+		keys = append(keys, l)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,83 @@
+package queue_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// openMetricsLine matches the lines of the OpenMetrics text format
+// written by OpenMetricsSink: type comments and samples
+var openMetricsLine = regexp.MustCompile(`^(# TYPE [a-z_]+ (counter|gauge|summary)|[a-z_]+(\{[a-z_]+="([^"\\]|\\.)*"(,[a-z_]+="([^"\\]|\\.)*")*\})? -?[0-9.e+-]+)$`)
+
+// writeMetrics writes the metrics of s, checking that each
+// line is well formed, and returns the lines
+func writeMetrics(t *testing.T, s *queue.OpenMetricsSink) []string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := s.WriteMetrics(&b); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if last := lines[len(lines)-1]; last != "# EOF" {
+		t.Errorf("got last line %q, want %q", last, "# EOF")
+	}
+	lines = lines[:len(lines)-1]
+	for _, line := range lines {
+		if !openMetricsLine.MatchString(line) {
+			t.Errorf("got malformed line %q", line)
+		}
+	}
+	return lines
+}
+
+func TestOpenMetricsOfJobs(t *testing.T) {
+	s := &queue.OpenMetricsSink{}
+	c, w := queue.New(doubler, queue.WithMetricsSink(s))
+	createJobs(t, c, map[string]int{"a": 1, "b": 2, "bad": -1})
+	processAll(t, w)
+
+	lines := writeMetrics(t, s)
+	got := make(map[string]bool)
+	for _, line := range lines {
+		got[line] = true
+	}
+	for _, want := range []string{
+		"# TYPE jobs_created counter",
+		"jobs_created_total 3",
+		"jobs_finished_total 2",
+		"jobs_failed_total 1",
+		"# TYPE queue_depth gauge",
+		`queue_depth{queue="default"} 0`,
+		"# TYPE job_processing_seconds summary",
+		"job_processing_seconds_count 3",
+	} {
+		if !got[want] {
+			t.Errorf("got no line %q in %q", want, lines)
+		}
+	}
+}
+
+func TestOpenMetricsLabels(t *testing.T) {
+	s := &queue.OpenMetricsSink{}
+	s.Gauge("queue_depth", 2, queue.Tag{Key: "queue", Value: `odd "name"`}, queue.Tag{Key: "a", Value: "b"})
+	s.Gauge("queue_depth", 1, queue.Tag{Key: "queue", Value: "default"})
+
+	want := []string{
+		"# TYPE queue_depth gauge",
+		`queue_depth{a="b",queue="odd \"name\""} 2`,
+		`queue_depth{queue="default"} 1`,
+	}
+	if got := writeMetrics(t, s); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got lines %q, want %q", got, want)
+	}
+}
+
+func TestOpenMetricsEmpty(t *testing.T) {
+	if got := writeMetrics(t, &queue.OpenMetricsSink{}); len(got) != 0 {
+		t.Errorf("got lines %q with no metric, want none", got)
+	}
+}
@@ -0,0 +1,260 @@
+package queue
+
+import (
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures the queue returned by New
+type Option func(*config)
+
+// config holds the settings of a queue, as set by the options given to New
+type config struct {
+	retryPolicy RetryPolicy
+	jobTimeout  time.Duration
+	metrics     MetricsSink
+	stallAfter  time.Duration
+	onStall     func()
+	onDispatch  func(id string)
+	clock       clock
+
+	deadLetterAlert int
+	onDeadLetters   func(count int)
+
+	mediumPressure float64
+	highPressure   float64
+
+	priorityAging  time.Duration
+	explicitAging  bool
+	intraOrder     IntraPriorityOrder
+	tracer         trace.Tracer
+	middleware     []ProcessorMiddleware
+	maxDepth       int
+	maxScheduled   int
+	clockSkew      time.Duration
+	keyLimits      map[string]int
+	events         EventHandler
+	eventWorkers   int
+	reapInterval   time.Duration
+	staleAfter     time.Duration
+	maxPayload     int
+	skipUnchanged  bool
+	compression    Compression
+	checksums      bool
+	encryptionKeys [][]byte
+	logger         *slog.Logger
+	resultTTL      time.Duration
+	spillDir       string
+	spillMax       int
+	deadLetterTTL  time.Duration
+	cancelGrace    time.Duration
+	summaryWindow  time.Duration
+	cpuBound       map[string]bool
+	panicPolicy    PanicPolicy
+	ownerID        string
+	leakLimit      int
+	leakFail       bool
+	retryFair      bool
+	retryRatio     float64
+
+	queueBuffer        int
+	notificationBuffer int
+
+	cpuTarget float64
+	cpuTime   func() time.Duration
+}
+
+// DefaultPriorityAging is the priority aging period used by default.
+// See WithPriorityAging.
+const DefaultPriorityAging = 10 * time.Second
+
+// newConfig returns the config resulting from applying opts to the defaults
+func newConfig(opts []Option) config {
+	cfg := config{
+		metrics:       NopMetricsSink{},
+		priorityAging: DefaultPriorityAging,
+		tracer:        noopTracer,
+		events:        NopEventHandler{},
+		logger:        discardLogger,
+		ownerID:       defaultOwnerID(),
+		leakLimit:     -1,
+		clock:         realClock{},
+
+		mediumPressure: DefaultMediumPressure,
+		highPressure:   DefaultHighPressure,
+
+		notificationBuffer: DefaultNotificationBuffer,
+
+		cpuTime: processCPUTime,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// RetryPolicy defines how jobs whose processing fails are retried.
+//
+// MaxAttempts is the maximum number of times a job is processed before
+// it is marked as Failed. Values lower than 2 mean that jobs are not retried.
+//
+// BackoffFor returns how long to wait before queuing again a job that just
+// failed the given attempt (attempts are numbered from 1). If it is nil,
+// failed jobs are queued again right away. ConstantBackoff, ExponentialBackoff
+// and ExponentialBackoffWithJitter return common ones. Processors can wait
+// for another delay before an attempt is retried by returning a RetryAfter.
+type RetryPolicy struct {
+	MaxAttempts int
+	BackoffFor  func(attempt int) time.Duration
+}
+
+// backoff returns the time to wait before retrying
+// a job that just failed the given attempt.
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+	if rp.BackoffFor == nil {
+		return 0
+	}
+	return rp.BackoffFor(attempt)
+}
+
+// WithRetryPolicy makes the worker retry failed jobs as defined by rp
+// instead of marking them as Failed on their first failure.
+func WithRetryPolicy(rp RetryPolicy) Option {
+	return func(c *config) {
+		c.retryPolicy = rp
+	}
+}
+
+// WithJobTimeout limits the time the worker lets the processor run on
+// a job to d. The context given to the processor is canceled after d,
+// and the job fails with a timeout error. The worker moves on to other
+// jobs right away even if the processor does not return. Likewise, when
+// the worker is stopped, the processor is not waited for, unless there is
+// a cancel grace (see WithCancelGrace), and the job is queued again.
+func WithJobTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.jobTimeout = d
+	}
+}
+
+// WithStallDetector makes the queue call cb when there are jobs to process
+// but no attempt to process a job has ended for idleFor, which may be caused
+// by a deadlock, a stuck downstream system or no worker running at all.
+// cb is called once per stall: it is not called again until jobs make
+// progress and then stall again. It is called from a goroutine of its own,
+// not from the workers.
+func WithStallDetector(idleFor time.Duration, cb func()) Option {
+	return func(c *config) {
+		c.stallAfter = idleFor
+		c.onStall = cb
+	}
+}
+
+// WithDispatchObserver makes the workers call observe with the ID of each
+// job they are about to process, on the goroutine processing it, right
+// before calling the processor, so that tests can tell the order jobs
+// were dispatched in whatever order they end in. Without it, nothing is
+// called.
+func WithDispatchObserver(observe func(id string)) Option {
+	return func(c *config) {
+		c.onDispatch = observe
+	}
+}
+
+// WithCPUBound tells the workers of the named queues that their processor
+// is CPU-bound, or the worker returned by New if no queue is named, so
+// that each of them runs at most GOMAXPROCS goroutines, in all its calls
+// to Run together and counting the slots set aside by Reserve, whatever
+// the number of workers asked for with Run or SetConcurrency: beyond one
+// per CPU the processor would only contend for them. Asking for more is
+// logged at the Warn level. The workers of the other queues, like those
+// returned by ForQueue for I/O-bound processors, are not limited. By
+// default, processors are taken to be I/O-bound and the workers run as
+// many goroutines as asked for.
+func WithCPUBound(queues ...string) Option {
+	return func(c *config) {
+		if len(queues) == 0 {
+			queues = []string{DefaultQueue}
+		}
+		if c.cpuBound == nil {
+			c.cpuBound = make(map[string]bool)
+		}
+		for _, name := range queues {
+			c.cpuBound[name] = true
+		}
+	}
+}
+
+// WithPriorityAging sets the priority aging period of the queue.
+//
+// Workers take the queued jobs with the highest priority first, and those
+// created first among jobs with the same priority. To keep a sustained load
+// of high priority jobs from starving lower priority ones forever, each
+// aging period a job has been queued counts as one more level of priority.
+// For instance, with the default period a job with priority 0 goes before
+// any job with priority 1 queued more than 10s after it.
+// If d is zero, priorities are strict and jobs never age.
+// It makes no difference with WithIntraPriorityOrder(LIFO).
+func WithPriorityAging(d time.Duration) Option {
+	return func(c *config) {
+		c.priorityAging, c.explicitAging = d, true
+	}
+}
+
+// IntraPriorityOrder is the order in which the workers take the queued
+// jobs with the same priority. See WithIntraPriorityOrder.
+type IntraPriorityOrder int
+
+const (
+	// FIFO takes the jobs created first first, which is the default
+	FIFO IntraPriorityOrder = iota
+	// LIFO takes the jobs created last first, for the lowest
+	// latency of fresh jobs at the expense of the older ones
+	LIFO
+)
+
+// WithIntraPriorityOrder sets the order in which the workers take the
+// queued jobs with the same priority. With LIFO, jobs do not age, as
+// with WithPriorityAging(0), since ranking the jobs of a priority by
+// how long they have been queued would undo it: a period given with
+// WithPriorityAging is dropped, which is logged at the Warn level.
+func WithIntraPriorityOrder(order IntraPriorityOrder) Option {
+	return func(c *config) {
+		c.intraOrder = order
+	}
+}
+
+// JobOption configures a job created by a Client
+type JobOption func(*jobOptions)
+
+// jobOptions holds the settings of a job, as
+// set by the options given when creating it
+type jobOptions struct {
+	priority int
+	key      string
+	dedup    bool
+	dedupKey string
+	deadline time.Time
+	schedule []time.Time
+	idPrefix string
+}
+
+// WithPriority sets the priority of a job, which is 0 by default.
+// Jobs with higher priorities are processed first.
+func WithPriority(n int) JobOption {
+	return func(o *jobOptions) {
+		o.priority = n
+	}
+}
+
+// WithDeadline sets the deadline of a job, after which it is no use.
+// The context given to the processor is canceled at the deadline, and
+// a job dispatched past its deadline fails with ErrDeadlineBeforeStart
+// without being processed. Jobs have no deadline by default.
+func WithDeadline(t time.Time) JobOption {
+	return func(o *jobOptions) {
+		o.deadline = t
+	}
+}
@@ -0,0 +1,27 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+)
+
+// WithOwnerID sets the identity of the queue, which the jobs it processes
+// are claimed by while they are Processing, as told by ClaimedBy, so that
+// the instance processing a job can be told apart from the others sharing
+// a store. It is the host name and the process ID by default, as in
+// "host-1234".
+func WithOwnerID(id string) Option {
+	return func(c *config) {
+		c.ownerID = id
+	}
+}
+
+// defaultOwnerID returns the identity of the queues
+// without WithOwnerID: the host name and the process ID
+func defaultOwnerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
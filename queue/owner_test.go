@@ -0,0 +1,76 @@
+package queue_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// claimedBy returns who the job of c with the given ID is claimed by
+func claimedBy(t *testing.T, c queue.Client, id string) string {
+	t.Helper()
+	j, err := c.GetJob(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j.ClaimedBy()
+}
+
+func TestClaimedByWhileProcessing(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p, queue.WithOwnerID("host-a"))
+	createJobs(t, c, map[string]int{"a": 1})
+	if got := claimedBy(t, c, "a"); got != "" {
+		t.Errorf("got Queued job claimed by %q, want it unclaimed", got)
+	}
+	defer runWorker(t, w, 1)()
+	waitForStarts(t, started, 1)
+	if got := claimedBy(t, c, "a"); got != "host-a" {
+		t.Errorf("got Processing job claimed by %q, want %q", got, "host-a")
+	}
+	proceed <- struct{}{}
+	waitForJob(t, c, "a")
+	if got := claimedBy(t, c, "a"); got != "" {
+		t.Errorf("got Finished job claimed by %q, want it unclaimed", got)
+	}
+}
+
+func TestClaimedByClearedOnRetryAndFailure(t *testing.T) {
+	c, w := queue.New(doubler, queue.WithOwnerID("host-a"), queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2, BackoffFor: queue.ConstantBackoff(time.Hour)}))
+	createJobs(t, c, map[string]int{"bad": -1})
+	processOne(t, w)
+	if got := claimedBy(t, c, "bad"); got != "" {
+		t.Errorf("got job to retry claimed by %q, want it unclaimed", got)
+	}
+}
+
+func TestClaimedByDefault(t *testing.T) {
+	var claim string
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		claim = j.ClaimedBy()
+		return nil
+	})
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if want := fmt.Sprintf("-%d", os.Getpid()); !strings.HasSuffix(claim, want) {
+		t.Errorf("got job claimed by %q, want the host name and the process ID", claim)
+	}
+}
+
+func TestClaimedByClearedOnRestore(t *testing.T) {
+	s := &queue.MemoryStore{}
+	err := s.Save(context.Background(), queue.JobRecord{ID: "a", State: queue.Processing, Attempts: 1, ClaimedBy: "dead-host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := reopen(t, s)
+	if got := claimedBy(t, c, "a"); got != "" {
+		t.Errorf("got interrupted job claimed by %q after restoring it, want it unclaimed", got)
+	}
+}
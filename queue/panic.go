@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"context"
+	"log/slog"
+)
+
+// PanicPolicy tells what the workers do when a processor panics
+type PanicPolicy int
+
+const (
+	// PanicFailJob makes the workers recover from the panics of the
+	// processor, failing the attempt with an error with the panic value
+	// and stack trace, and carry on with other jobs. It is the default.
+	PanicFailJob PanicPolicy = iota
+	// PanicCrash makes the workers log the panics of the processor at the
+	// Error level and panic again with the same value, crashing the process
+	// unless the caller of ProcessOne or ProcessAll recovers. The job is
+	// left Processing, so it is Queued again if the queue is restored
+	// from its store.
+	PanicCrash
+	// PanicQuarantine makes the workers recover from the panics of the
+	// processor like PanicFailJob, but park the job in QuarantineQueue,
+	// Queued with the error of the attempt, instead of failing or retrying
+	// it, so that a job that crashes its processor is kept aside for a
+	// look without taking the workers of its queue again. It stays there
+	// until it is moved back with MoveJob, deleted or processed by
+	// a worker for QuarantineQueue. Parked jobs are not waiting to be
+	// processed, so the stall detector, Stats and EstimatedDrainTime
+	// leave them out of the jobs to be done.
+	PanicQuarantine
+)
+
+// QuarantineQueue is the named queue the jobs whose
+// processor panicked are parked in with PanicQuarantine
+const QuarantineQueue = "quarantine"
+
+// WithPanicPolicy sets what the workers do when a processor panics,
+// which is PanicFailJob by default
+func WithPanicPolicy(policy PanicPolicy) Option {
+	return func(c *config) {
+		c.panicPolicy = policy
+	}
+}
+
+// quarantine moves the given Processing job, whose processor panicked
+// with procErr, to QuarantineQueue, Queued with the error of the attempt
+// and its progress cleared, like retry would in its own queue.
+func (q *memoryQueue) quarantine(pj *processingJob, procErr error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	r, l, err := q.loadProcessing(pj)
+	if err != nil || l == nil {
+		return err
+	}
+	if l.cancelled {
+		return q.endCancelled(r, l, procErr)
+	}
+	from := r.Queue
+	r.State = Queued
+	r.Queue = QuarantineQueue
+	r.Error = procErr.Error()
+	r.FailureCategory = failureCategory(procErr)
+	r.Completed, r.Total, r.Reported = 0, 0, false
+	r.ClaimedBy = ""
+	if err := q.store.Save(context.Background(), r); err != nil {
+		return err
+	}
+	delete(q.reservations[from], r.ID)
+	q.attemptEnded(l)
+	q.transitioned(l, Queued)
+	q.log(slog.LevelWarn, "processor panicked, job quarantined", r, q.attemptDuration(l), slog.String("from", from), slog.String("error", r.Error))
+	q.push(r)
+	return nil
+}
@@ -0,0 +1,125 @@
+package queue_test
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// panicking is a processor panicking on the jobs with a negative payload,
+// and doubling the others
+var panicking = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+	var d intData
+	if err := j.GetData(&d); err != nil {
+		return err
+	}
+	if d.N < 0 {
+		panic("boom")
+	}
+	return doubler.Process(ctx, j)
+})
+
+func TestPanicFailJob(t *testing.T) {
+	for name, opts := range map[string][]queue.Option{
+		"default":  nil,
+		"explicit": {queue.WithPanicPolicy(queue.PanicFailJob)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, w := queue.New(panicking, opts...)
+			createJobs(t, c, map[string]int{"bad": -1, "good": 1})
+			processAll(t, w)
+			if j := waitForJob(t, c, "bad"); j.State() != queue.Failed || !strings.Contains(j.Error(), "boom") {
+				t.Errorf("got job %s with error %q, want it %s with the panic", j.State(), j.Error(), queue.Failed)
+			}
+			if j := waitForJob(t, c, "good"); j.State() != queue.Finished {
+				t.Errorf("got job %s after another one panicked, want it %s", j.State(), queue.Finished)
+			}
+		})
+	}
+}
+
+func TestPanicCrash(t *testing.T) {
+	h := &logRecorder{}
+	c, w := queue.New(panicking, queue.WithPanicPolicy(queue.PanicCrash), queue.WithLogger(slog.New(h)))
+	createJobs(t, c, map[string]int{"bad": -1})
+
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+		w.(queue.SyncWorker).ProcessOne(context.Background())
+	}()
+	if recovered != "boom" {
+		t.Errorf("got panic %v from ProcessOne, want the panic of the processor", recovered)
+	}
+	j, err := c.GetJob(context.Background(), "bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.State() != queue.Processing {
+		t.Errorf("got job %s after crashing, want it left %s", j.State(), queue.Processing)
+	}
+	want := []string{"DEBUG job started", "ERROR processor panicked, crashing"}
+	if got := h.logged("bad"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got records %q, want %q", got, want)
+	}
+}
+
+func TestPanicQuarantine(t *testing.T) {
+	c, w := queue.New(panicking, queue.WithPanicPolicy(queue.PanicQuarantine), queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 3}))
+	createJobs(t, c, map[string]int{"bad": -1, "good": 1})
+	if n := processAll(t, w); n != 2 {
+		t.Errorf("processed %d jobs, want 2", n)
+	}
+	j := waitForJob(t, c, "good")
+	if j.State() != queue.Finished {
+		t.Errorf("got job %s after another one panicked, want it %s", j.State(), queue.Finished)
+	}
+	j, err := c.GetJob(context.Background(), "bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := j.Export(); e.State != queue.Queued || e.Queue != queue.QuarantineQueue || e.FailureCategory != queue.FailurePanic || !strings.Contains(e.Error, "boom") {
+		t.Errorf("got job %s in queue %q with category %q and error %q, want it %s in quarantine with the panic", e.State, e.Queue, e.FailureCategory, e.Error, queue.Queued)
+	}
+	if n := processAll(t, w); n != 0 {
+		t.Errorf("processed %d jobs after quarantining one, want 0", n)
+	}
+
+	quarantined := w.(queue.MultiQueueWorker).ForQueue(queue.QuarantineQueue, processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		return nil
+	}))
+	if n := processAll(t, quarantined); n != 1 {
+		t.Errorf("processed %d quarantined jobs, want 1", n)
+	}
+	if e := waitForJob(t, c, "bad").Export(); e.State != queue.Finished || e.Attempts != 2 {
+		t.Errorf("got quarantined job %s after %d attempts, want it %s after 2", e.State, e.Attempts, queue.Finished)
+	}
+}
+
+func TestQuarantinedJobsDoNotKeepQueueBusy(t *testing.T) {
+	const idleFor = 10 * time.Millisecond
+	clock := queue.NewFakeClock()
+	opt, count := stallDetector(idleFor)
+	c, w := queue.New(panicking, opt, queue.WithFakeClock(clock), queue.WithPanicPolicy(queue.PanicQuarantine))
+	createJobs(t, c, map[string]int{"bad": -1, "good": 1, "fine": 2})
+	processAll(t, w)
+	if j := waitForJob(t, c, "good"); j.State() != queue.Finished {
+		t.Fatalf("got job %s, want it %s", j.State(), queue.Finished)
+	}
+
+	clock.Advance(5 * idleFor)
+	checkStalls(t, count, 0)
+	s := stats(t, c)
+	if s.Jobs[queue.Queued] != 0 || s.Quarantined != 1 || s.OldestQueuedAge != 0 {
+		t.Errorf("got %d jobs Queued, %d quarantined and the oldest Queued for %s, want 0, 1 and 0",
+			s.Jobs[queue.Queued], s.Quarantined, s.OldestQueuedAge)
+	}
+	if got := c.EstimatedDrainTime(); got != 0 {
+		t.Errorf("got estimated drain time %s with only a quarantined job, want 0", got)
+	}
+}
@@ -0,0 +1,24 @@
+package queue
+
+// Pause stops the jobs in the queue of the worker from being dispatched,
+// by any worker, until Resume is called. The jobs being processed go on,
+// and clients can still create jobs, which stay Queued in the meantime.
+// Pausing a paused queue does nothing.
+func (w *worker) Pause() {
+	w.q.mu.Lock()
+	defer w.q.mu.Unlock()
+	w.q.paused[w.queue] = true
+}
+
+// Resume lets the jobs in the queue of the worker be dispatched again,
+// waking up the idle workers so they take them right away. Resuming
+// a queue that is not paused does nothing.
+func (w *worker) Resume() {
+	w.q.mu.Lock()
+	defer w.q.mu.Unlock()
+	if !w.q.paused[w.queue] {
+		return
+	}
+	delete(w.q.paused, w.queue)
+	w.q.wake()
+}
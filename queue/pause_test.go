@@ -0,0 +1,105 @@
+package queue_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestPauseStopsDispatch(t *testing.T) {
+	// Any job dispatched before the queue is resumed is recorded
+	var mu sync.Mutex
+	var paused bool
+	var early []string
+	c, w := queue.New(doubler, queue.WithDispatchObserver(func(id string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if paused {
+			early = append(early, id)
+		}
+	}))
+	defer runWorker(t, w, 2)()
+	pw := w.(queue.PausableWorker)
+	mu.Lock()
+	paused = true
+	mu.Unlock()
+	pw.Pause()
+	pw.Pause()
+	createJobs(t, c, map[string]int{"a": 1, "b": 2})
+	for _, id := range []string{"a", "b"} {
+		if j, err := c.GetJob(context.Background(), id); err != nil || j.State() != queue.Queued {
+			t.Fatalf("got job %q %v with error %v while paused, want it %s", id, j, err, queue.Queued)
+		}
+	}
+
+	mu.Lock()
+	paused = false
+	mu.Unlock()
+	pw.Resume()
+	waitForJob(t, c, "a")
+	waitForJob(t, c, "b")
+	mu.Lock()
+	defer mu.Unlock()
+	if len(early) != 0 {
+		t.Errorf("got jobs %v dispatched while paused, want none", early)
+	}
+}
+
+func TestPauseLetsJobsInFlightFinish(t *testing.T) {
+	p, started, release, stored := stubborn()
+	c, w := queue.New(p)
+	defer runWorker(t, w, 2)()
+	createJobs(t, c, map[string]int{"slow": 1})
+	<-started
+	pw := w.(queue.PausableWorker)
+	pw.Pause()
+	createJobs(t, c, map[string]int{"later": 1})
+
+	close(release)
+	if err := <-stored; err != nil {
+		t.Fatal(err)
+	}
+	if j := waitForJob(t, c, "slow"); j.State() != queue.Finished {
+		t.Errorf("got job slow %s, want it %s", j.State(), queue.Finished)
+	}
+	if j, err := c.GetJob(context.Background(), "later"); err != nil || j.State() != queue.Queued {
+		t.Fatalf("got job later %v with error %v while paused, want it %s", j, err, queue.Queued)
+	}
+
+	pw.Resume()
+	waitForJob(t, c, "later")
+}
+
+func TestPauseSyncWorker(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	pw := w.(queue.PausableWorker)
+	pw.Resume()
+	pw.Pause()
+	if n := processAll(t, w); n != 0 {
+		t.Fatalf("processed %d jobs while paused, want none", n)
+	}
+	pw.Resume()
+	pw.Resume()
+	if n := processAll(t, w); n != 1 {
+		t.Errorf("processed %d jobs after resuming, want 1", n)
+	}
+}
+
+func TestPauseIsPerQueue(t *testing.T) {
+	c, w := queue.New(doubler)
+	other := w.(queue.MultiQueueWorker).ForQueue("other", doubler)
+	other.(queue.PausableWorker).Pause()
+	createJobs(t, c, map[string]int{"a": 1})
+	if err := c.CreateJobInQueue(context.Background(), "other", "b", &intData{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if n := processAll(t, w); n != 1 {
+		t.Errorf("processed %d jobs of the default queue, want 1", n)
+	}
+	if n := processAll(t, other); n != 0 {
+		t.Errorf("processed %d jobs of the paused queue, want none", n)
+	}
+}
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WithMaxPayloadBytes limits the size of the payloads of the jobs to
+// n bytes, once marshaled. Creating a job with a larger payload, or
+// setting one with SetData, fails with ErrPayloadTooLarge and leaves
+// the store untouched. If n is 0, the default, payloads are not limited.
+func WithMaxPayloadBytes(n int) Option {
+	return func(c *config) {
+		c.maxPayload = n
+	}
+}
+
+// WithSkipUnchangedData makes SetData leave the store untouched when the
+// payload it is given marshals to the same bytes as the stored one, like
+// when jobs are processed again with the same result. It is not the
+// default so that every call to SetData writes to the store otherwise.
+func WithSkipUnchangedData() Option {
+	return func(c *config) {
+		c.skipUnchanged = true
+	}
+}
+
+// CreateJobRaw creates a job in the default queue like CreateJob, with
+// data as its payload, already marshaled, so that callers forwarding
+// payloads do not have to unmarshal them first. GetData unmarshals it
+// like any other payload. data is copied, so callers may reuse it.
+func (c *client) CreateJobRaw(ctx context.Context, id string, data []byte, opts ...JobOption) error {
+	return c.create(ctx, DefaultQueue, id, rawPayload(append([]byte(nil), data...)), time.Time{}, opts, true)
+}
+
+// rawPayload is a payload that is already marshaled
+type rawPayload []byte
+
+func (p rawPayload) Marshal() ([]byte, error) {
+	return p, nil
+}
+
+func (p rawPayload) Unmarshal(b []byte) error {
+	return errors.New("cannot unmarshal into a raw payload")
+}
+
+// marshal marshals data to store it as the payload of a job,
+// failing if it is larger than the payloads are limited to.
+// The limit applies to the payload before it is encoded.
+func (q *memoryQueue) marshal(data MarshalUnmarshaler) ([]byte, error) {
+	b, err := data.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if q.maxPayload > 0 && len(b) > q.maxPayload {
+		return nil, fmt.Errorf("payload of %d bytes is over the limit of %d: %w", len(b), q.maxPayload, ErrPayloadTooLarge)
+	}
+	return b, nil
+}
+
+// encode turns the given marshaled payload into the bytes
+// of the payload kept in the store, compressing and then
+// encrypting it, and then appending its checksum
+func (q *memoryQueue) encode(b []byte) ([]byte, error) {
+	b, err := compress(q.compression, b)
+	if err != nil {
+		return nil, err
+	}
+	b, err = q.encryption.seal(b)
+	if err != nil || !q.checksums {
+		return b, err
+	}
+	return appendChecksum(b), nil
+}
+
+// decode turns the bytes of a payload kept in the
+// store back into the marshaled payload
+func (q *memoryQueue) decode(b []byte) ([]byte, error) {
+	if q.checksums {
+		var err error
+		if b, err = verifyChecksum(b); err != nil {
+			return nil, err
+		}
+	}
+	b, err := q.encryption.open(b)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(q.compression, b)
+}
@@ -0,0 +1,186 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// textData is a job payload holding a string
+type textData struct {
+	S string `json:"s"`
+}
+
+func (d *textData) Marshal() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (d *textData) Unmarshal(b []byte) error {
+	return json.Unmarshal(b, d)
+}
+
+// textOfSize returns a textData marshaled to exactly n bytes
+func textOfSize(n int) *textData {
+	const overhead = len(`{"s":""}`)
+	return &textData{S: strings.Repeat("x", n-overhead)}
+}
+
+func TestCreateJobOverPayloadLimit(t *testing.T) {
+	ctx := context.Background()
+	c, _ := queue.New(doubler, queue.WithMaxPayloadBytes(64))
+	if err := c.CreateJob(ctx, "max", textOfSize(64)); err != nil {
+		t.Errorf("got error %v creating a job at the limit, want none", err)
+	}
+	if err := c.CreateJob(ctx, "big", textOfSize(65)); !errors.Is(err, queue.ErrPayloadTooLarge) {
+		t.Errorf("got error %v creating a job over the limit, want %v", err, queue.ErrPayloadTooLarge)
+	}
+	err := c.CreateJobs(ctx, []queue.JobSpec{{ID: "small", Data: textOfSize(10)}, {ID: "big-batch", Data: textOfSize(65)}})
+	if !errors.Is(err, queue.ErrPayloadTooLarge) {
+		t.Errorf("got error %v creating a batch with a job over the limit, want %v", err, queue.ErrPayloadTooLarge)
+	}
+	for _, id := range []string{"big", "small", "big-batch"} {
+		if j, err := c.GetJob(ctx, id); j != nil || err != nil {
+			t.Errorf("got job %q (error %v) stored, want none", id, err)
+		}
+	}
+}
+
+func TestSetDataOverPayloadLimit(t *testing.T) {
+	results := make(chan error, 2)
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		results <- j.SetData(ctx, textOfSize(65))
+		results <- j.SetData(ctx, textOfSize(64))
+		return nil
+	})
+	c, w := queue.New(p, queue.WithMaxPayloadBytes(64))
+	if err := c.CreateJob(context.Background(), "a", textOfSize(10)); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	if err := <-results; !errors.Is(err, queue.ErrPayloadTooLarge) {
+		t.Errorf("got error %v setting data over the limit, want %v", err, queue.ErrPayloadTooLarge)
+	}
+	if err := <-results; err != nil {
+		t.Errorf("got error %v setting data at the limit, want none", err)
+	}
+	var d textData
+	if err := waitForJob(t, c, "a").GetData(&d); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.S) != len(textOfSize(64).S) {
+		t.Errorf("got payload of %d characters, want the one at the limit", len(d.S))
+	}
+}
+
+func TestNoPayloadLimitByDefault(t *testing.T) {
+	c, _ := queue.New(doubler)
+	if err := c.CreateJob(context.Background(), "a", textOfSize(1<<20)); err != nil {
+		t.Errorf("got error %v creating a job with a large payload, want none", err)
+	}
+}
+
+// resetter is a processor setting the payload of its jobs to the
+// one they were created with, and then to another one
+var resetter = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+	var d intData
+	if err := j.GetData(&d); err != nil {
+		return err
+	}
+	if err := j.SetData(ctx, &d); err != nil {
+		return err
+	}
+	return j.SetData(ctx, &intData{N: d.N + 1})
+})
+
+func TestSkipUnchangedData(t *testing.T) {
+	for name, opts := range map[string][]queue.Option{
+		"plain":     nil,
+		"encrypted": {queue.WithEncryption(encryptionKey), queue.WithCompression(queue.Gzip)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := &recordingStore{}
+			ctx := context.Background()
+			c, w, err := queue.NewWithStore(ctx, s, resetter, append(opts, queue.WithSkipUnchangedData())...)
+			if err != nil {
+				t.Fatalf("creating queue: %v", err)
+			}
+			createJobs(t, c, map[string]int{"a": 1})
+			processAll(t, w)
+			want := []string{
+				"save a queued",
+				"save a processing",
+				"save a processing", // Only the SetData changing the payload
+				"save a finished",
+			}
+			if got := s.recorded(); !reflect.DeepEqual(got, want) {
+				t.Errorf("got writes %q, want %q", got, want)
+			}
+			var d intData
+			if err := waitForJob(t, c, "a").GetData(&d); err != nil || d.N != 2 {
+				t.Errorf("got payload %v, %v, want %d", d.N, err, 2)
+			}
+		})
+	}
+}
+
+func TestUnchangedDataStoredByDefault(t *testing.T) {
+	s := &recordingStore{}
+	ctx := context.Background()
+	c, w, err := queue.NewWithStore(ctx, s, resetter)
+	if err != nil {
+		t.Fatalf("creating queue: %v", err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	want := []string{
+		"save a queued",
+		"save a processing",
+		"save a processing",
+		"save a processing",
+		"save a finished",
+	}
+	if got := s.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got writes %q, want %q", got, want)
+	}
+}
+
+func TestCreateJobRaw(t *testing.T) {
+	for name, opts := range map[string][]queue.Option{
+		"plain":     nil,
+		"encrypted": {queue.WithEncryption(encryptionKey), queue.WithCompression(queue.Gzip)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, w := queue.New(doubler, opts...)
+			data := []byte(`{"N":21}`)
+			if err := c.CreateJobRaw(context.Background(), "a", data); err != nil {
+				t.Fatalf("creating job: %v", err)
+			}
+			// The payload does not change with the bytes it was created with
+			copy(data, `{"N":99}`)
+			j, err := c.GetJob(context.Background(), "a")
+			if err != nil {
+				t.Fatalf("getting job: %v", err)
+			}
+			var d intData
+			if err := j.GetData(&d); err != nil || d.N != 21 {
+				t.Errorf("got payload %d, %v, want %d", d.N, err, 21)
+			}
+			processAll(t, w)
+			if err := waitForJob(t, c, "a").GetData(&d); err != nil || d.N != 42 {
+				t.Errorf("got processed payload %d, %v, want %d", d.N, err, 42)
+			}
+		})
+	}
+}
+
+func TestCreateJobRawOverPayloadLimit(t *testing.T) {
+	c, _ := queue.New(doubler, queue.WithMaxPayloadBytes(4))
+	if err := c.CreateJobRaw(context.Background(), "a", []byte(`{"N":21}`)); !errors.Is(err, queue.ErrPayloadTooLarge) {
+		t.Errorf("got error %v, want %v", err, queue.ErrPayloadTooLarge)
+	}
+}
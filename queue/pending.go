@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"container/heap"
+	"time"
+)
+
+// pendingJob is a job waiting in a pendingHeap to be dispatched.
+// Lower ranks are dispatched first, and seq breaks ties, which is
+// the seq of the job, or its complement to dispatch the newest first.
+// key is the concurrency key of the job, since when it was
+// added to the heap, and retry whether it was attempted before.
+type pendingJob struct {
+	id    string
+	rank  int64
+	seq   uint64
+	key   string
+	since time.Time
+	retry bool
+}
+
+// pendingHeap is a priority queue of pending jobs, to be used
+// with the container/heap functions.
+type pendingHeap []pendingJob
+
+func (h pendingHeap) Len() int { return len(h) }
+
+func (h pendingHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank < h[j].rank
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h pendingHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *pendingHeap) Push(x interface{}) { *h = append(*h, x.(pendingJob)) }
+
+func (h *pendingHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// pendingQueue holds the pending jobs of a named queue. With retry
+// fairness, the jobs being retried are kept in retries, apart from the
+// others in jobs, so that the next job of either kind is at hand.
+// Otherwise, all of them are in jobs.
+type pendingQueue struct {
+	jobs    pendingHeap
+	retries pendingHeap
+}
+
+// WithQueueBuffer makes the queue set aside room for n pending jobs in
+// each named queue as soon as a job is queued in it, rather than growing
+// the room as jobs pile up. A burst of up to n jobs then goes in without
+// the pending jobs being copied over to a larger room, at the cost of
+// the memory of n jobs per named queue, used or not, which is never
+// given back. Room is never a limit: more jobs than n can be pending,
+// and WithMaxQueueDepth is what holds producers back. By default, the
+// room starts empty and grows with the jobs.
+func WithQueueBuffer(n int) Option {
+	return func(c *config) {
+		c.queueBuffer = n
+	}
+}
+
+// newPendingQueue returns a pendingQueue with room for n jobs
+func newPendingQueue(n int) *pendingQueue {
+	return &pendingQueue{jobs: make(pendingHeap, 0, max(n, 0))}
+}
+
+// Len returns the number of pending jobs
+func (p *pendingQueue) Len() int { return p.jobs.Len() + p.retries.Len() }
+
+// each calls f for each pending job, in no particular order
+func (p *pendingQueue) each(f func(pendingJob)) {
+	for _, pj := range p.jobs {
+		f(pj)
+	}
+	for _, pj := range p.retries {
+		f(pj)
+	}
+}
+
+// remove takes the job with the given ID out of p,
+// returning false if it is not in p.
+func (p *pendingQueue) remove(id string) bool {
+	return p.jobs.remove(id) || p.retries.remove(id)
+}
+
+// remove takes the job with the given ID out of h,
+// returning false if it is not in h.
+func (h *pendingHeap) remove(id string) bool {
+	for i, pj := range *h {
+		if pj.id == id {
+			heap.Remove(h, i)
+			return true
+		}
+	}
+	return false
+}
+
+// rank returns the rank in a pendingHeap of a job with the given
+// priority that gets pending at the given time.
+//
+// Without aging, jobs are ranked by priority alone. With aging, each
+// aging period a job has been pending counts as one more level of
+// priority, so that low priority jobs are not starved forever by a
+// sustained load of higher priority ones. As all pending jobs age at
+// the same pace, that amounts to ranking them by the time they got
+// pending minus their priority times aging, which does not change
+// while they wait. To keep that from overflowing, priorities are
+// clamped to ±2⁶²/aging levels (about ±4.6e8 with the default aging).
+func rank(priority int, pendingSince time.Time, aging time.Duration) int64 {
+	p := int64(priority)
+	if aging <= 0 {
+		return -p
+	}
+	maxPriority := int64(1<<62) / int64(aging)
+	if p > maxPriority {
+		p = maxPriority
+	} else if p < -maxPriority {
+		p = -maxPriority
+	}
+	return pendingSince.UnixNano() - p*int64(aging)
+}
+
+var _ heap.Interface = &pendingHeap{}
@@ -0,0 +1,39 @@
+package queue_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestQueueBufferIsNotLimit(t *testing.T) {
+	r := &recorder{}
+	c, w := queue.New(r, queue.WithQueueBuffer(2))
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if err := c.CreateJob(ctx, fmt.Sprint(i), &intData{N: i}, queue.WithPriority(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	processAll(t, w)
+	if got, want := r.processed(), []string{"4", "3", "2", "1", "0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs processed in order %v, want %v", got, want)
+	}
+}
+
+// BenchmarkQueueBuffer creates and drains a burst of jobs with the room
+// for the pending jobs set aside up front or not, to show what it saves
+func BenchmarkQueueBuffer(b *testing.B) {
+	const burst = 10000
+	for _, n := range []int{0, burst / 10, burst} {
+		b.Run(fmt.Sprintf("buffer=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				drainBatch(b, burst, queue.WithQueueBuffer(n))
+			}
+		})
+	}
+}
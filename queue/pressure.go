@@ -0,0 +1,103 @@
+package queue
+
+import "context"
+
+// PressureLevel tells producers how close to full the queues are,
+// so that they can slow down before blocking. See Pressure.
+type PressureLevel int
+
+const (
+	// LowPressure leaves plenty of room in the queues
+	LowPressure PressureLevel = iota
+	// MediumPressure is a hint to slow down
+	MediumPressure
+	// HighPressure means the queues are about to be full
+	HighPressure
+)
+
+// Default thresholds of the pressure levels, as fractions
+// of the depth of the queues. See WithPressureThresholds.
+const (
+	DefaultMediumPressure = 0.5
+	DefaultHighPressure   = 0.8
+)
+
+// WithPressureThresholds sets the thresholds of the pressure levels
+// reported by Pressure, as fractions of WithMaxQueueDepth: the pressure
+// is MediumPressure when the fullest queue has at least medium times the
+// maximum depth of Queued jobs, and HighPressure when it has at least
+// high times as many, and LowPressure below. Without WithMaxQueueDepth,
+// the pressure is always LowPressure. By default, the thresholds are
+// DefaultMediumPressure and DefaultHighPressure.
+func WithPressureThresholds(medium, high float64) Option {
+	return func(c *config) {
+		c.mediumPressure, c.highPressure = medium, high
+	}
+}
+
+// Pressure returns a channel that gets the pressure level of the queue,
+// first the current one and then each one it changes to, as its queues
+// fill up and drain, until ctx gets done. Then the channel is closed.
+// Producers can throttle themselves accordingly, before the queue gets
+// full and CreateJob blocks. The channel holds only the latest level: a
+// reader that falls behind gets the level of the queue when it reads,
+// not the ones it missed. Without WithMaxQueueDepth, the level is
+// always LowPressure.
+func (c *client) Pressure(ctx context.Context) (<-chan PressureLevel, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	levels := make(chan PressureLevel, 1)
+	c.q.mu.Lock()
+	levels <- c.q.pressure
+	c.q.pressureSubs = append(c.q.pressureSubs, levels)
+	c.q.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		c.q.unsubscribePressure(levels)
+	}()
+	return levels, nil
+}
+
+// unsubscribePressure drops the given channel of Pressure, closing it
+func (q *memoryQueue) unsubscribePressure(levels chan PressureLevel) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, sub := range q.pressureSubs {
+		if sub == levels {
+			q.pressureSubs = append(q.pressureSubs[:i], q.pressureSubs[i+1:]...)
+			break
+		}
+	}
+	close(levels)
+}
+
+// updatePressure works the pressure level out from the depth of the
+// fullest queue and, if it changed, replaces the level waiting in the
+// channels of Pressure with it. mu must be held by the caller.
+func (q *memoryQueue) updatePressure() {
+	level := LowPressure
+	if q.maxDepth > 0 {
+		fullest := 0
+		for name := range q.pending {
+			fullest = max(fullest, q.depth(name))
+		}
+		switch full := float64(fullest) / float64(q.maxDepth); {
+		case full >= q.highPressure:
+			level = HighPressure
+		case full >= q.mediumPressure:
+			level = MediumPressure
+		}
+	}
+	if level == q.pressure {
+		return
+	}
+	q.pressure = level
+	for _, levels := range q.pressureSubs {
+		select {
+		case <-levels:
+		default:
+		}
+		levels <- level
+	}
+}
@@ -0,0 +1,154 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// subscribePressure subscribes to the pressure
+// level of c until the test is done
+func subscribePressure(t *testing.T, c queue.Client) <-chan queue.PressureLevel {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	levels, err := c.Pressure(ctx)
+	if err != nil {
+		t.Fatalf("subscribing to the pressure: %v", err)
+	}
+	return levels
+}
+
+// checkPressure checks that the latest pressure level
+// sent to levels is want, and that no other one is left
+func checkPressure(t *testing.T, levels <-chan queue.PressureLevel, want queue.PressureLevel) {
+	t.Helper()
+	select {
+	case got := <-levels:
+		if got != want {
+			t.Errorf("got pressure %d, want %d", got, want)
+		}
+	default:
+		t.Errorf("got no pressure level, want %d", want)
+	}
+	select {
+	case got := <-levels:
+		t.Errorf("got pressure %d after the latest one", got)
+	default:
+	}
+}
+
+// checkNoPressure checks that the pressure level
+// has not changed since it was last read from levels
+func checkNoPressure(t *testing.T, levels <-chan queue.PressureLevel) {
+	t.Helper()
+	select {
+	case got := <-levels:
+		t.Errorf("got pressure %d, want it unchanged", got)
+	default:
+	}
+}
+
+// fill creates n more jobs in the default queue of c, from the nth
+func fill(t *testing.T, c queue.Client, from, n int) {
+	t.Helper()
+	for i := from; i < from+n; i++ {
+		if accepted, err := c.TryCreateJob(context.Background(), fmt.Sprint(i), &intData{N: i}); !accepted || err != nil {
+			t.Fatalf("got job %d accepted %t and error %v, want it accepted", i, accepted, err)
+		}
+	}
+}
+
+func TestPressureAcrossThresholds(t *testing.T) {
+	c, w := queue.New(doubler, queue.WithMaxQueueDepth(10), queue.WithPressureThresholds(0.4, 0.8))
+	levels := subscribePressure(t, c)
+	checkPressure(t, levels, queue.LowPressure)
+
+	fill(t, c, 0, 3)
+	checkNoPressure(t, levels)
+	fill(t, c, 3, 1)
+	checkPressure(t, levels, queue.MediumPressure)
+	fill(t, c, 4, 3)
+	checkNoPressure(t, levels)
+	fill(t, c, 7, 1)
+	checkPressure(t, levels, queue.HighPressure)
+
+	processOne(t, w)
+	checkPressure(t, levels, queue.MediumPressure)
+	for i := 0; i < 3; i++ {
+		processOne(t, w)
+	}
+	checkNoPressure(t, levels)
+	processOne(t, w)
+	checkPressure(t, levels, queue.LowPressure)
+}
+
+func TestPressureCoalescesLevels(t *testing.T) {
+	c, w := queue.New(doubler, queue.WithMaxQueueDepth(10))
+	levels := subscribePressure(t, c)
+	<-levels
+
+	// Falling behind through medium and high pressure
+	// only leaves the level of the queue when reading.
+	fill(t, c, 0, 9)
+	checkPressure(t, levels, queue.HighPressure)
+	for i := 0; i < 5; i++ {
+		processOne(t, w)
+	}
+	fill(t, c, 9, 1)
+	processAll(t, w)
+	checkPressure(t, levels, queue.LowPressure)
+
+	if got := <-subscribePressure(t, c); got != queue.LowPressure {
+		t.Errorf("got pressure %d for a new reader of a drained queue, want %d", got, queue.LowPressure)
+	}
+}
+
+func TestPressureOfFullestQueue(t *testing.T) {
+	c, _ := queue.New(doubler, queue.WithMaxQueueDepth(2))
+	levels := subscribePressure(t, c)
+	<-levels
+	fill(t, c, 0, 1)
+	checkPressure(t, levels, queue.MediumPressure)
+	for i := 0; i < 2; i++ {
+		if err := c.CreateJobInQueue(context.Background(), "other", fmt.Sprint("other-", i), &intData{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkPressure(t, levels, queue.HighPressure)
+}
+
+func TestPressureWithoutMaxDepth(t *testing.T) {
+	c, _ := queue.New(doubler)
+	levels := subscribePressure(t, c)
+	fill(t, c, 0, 100)
+	checkPressure(t, levels, queue.LowPressure)
+}
+
+func TestPressureUntilContextDone(t *testing.T) {
+	c, _ := queue.New(doubler, queue.WithMaxQueueDepth(2))
+	ctx, cancel := context.WithCancel(context.Background())
+	levels, err := c.Pressure(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-levels
+	cancel()
+	select {
+	case _, ok := <-levels:
+		if ok {
+			t.Error("got a pressure level after the context was done")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("channel not closed after the context was done")
+	}
+	fill(t, c, 0, 2)
+
+	if _, err := c.Pressure(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v subscribing with a done context, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,41 @@
+package queue
+
+// ProgressReport tells how far along a job is, as returned by the
+// ProgressReport method of Job, from the units of work its processor
+// last reported with SetProgress. Reported tells whether it reported
+// any in the current attempt, or in the one the job finished with,
+// and the rest of the report is zero if not. The progress is
+// Determinate if the processor gave a total, and Fraction is then the
+// fraction of it completed, from 0 to 1. Otherwise, the processor can
+// only tell that the job is busy, and how many units it completed, if
+// any, so Fraction is 0.
+type ProgressReport struct {
+	Reported    bool
+	Determinate bool
+	Completed   uint64
+	Total       uint64
+	Fraction    float64
+}
+
+// newProgressReport returns the report of the progress of the job
+// with the given record
+func newProgressReport(r JobRecord) ProgressReport {
+	completed, total := r.Completed, r.Total
+	p := ProgressReport{Reported: r.Reported, Completed: completed, Total: total}
+	if total > 0 {
+		p.Determinate = true
+		p.Fraction = min(float64(completed)/float64(total), 1)
+	}
+	return p
+}
+
+// ProgressReport returns the progress of the job as a ProgressReport
+func (j *job) ProgressReport() ProgressReport {
+	return newProgressReport(j.r)
+}
+
+// ProgressReport returns the progress of the job as a ProgressReport
+func (pj *processingJob) ProgressReport() ProgressReport {
+	r, _ := pj.record()
+	return newProgressReport(r)
+}
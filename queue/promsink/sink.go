@@ -0,0 +1,124 @@
+// Package promsink provides a queue.MetricsSink that exports the metrics
+// of a queue to Prometheus.
+package promsink
+
+import (
+	"github.com/ingrammicro/backend-test/queue"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// counters are the counters of a queue, with their help
+var counters = map[string]string{
+	queue.MetricJobsCreated:   "Number of jobs created.",
+	queue.MetricJobsStarted:   "Number of attempts to process jobs.",
+	queue.MetricJobsRetried:   "Number of failed attempts that were retried.",
+	queue.MetricJobsFinished:  "Number of jobs that finished successfully.",
+	queue.MetricJobsFailed:    "Number of jobs that failed.",
+	queue.MetricJobsCancelled: "Number of jobs that were cancelled.",
+}
+
+// Sink is a queue.MetricsSink that exports the metrics of a queue as
+// Prometheus collectors. The depth of the queues is labelled by queue.
+// Metrics the sink does not know about are ignored.
+type Sink struct {
+	counters   map[string]prometheus.Counter
+	depth      *prometheus.GaugeVec
+	processing prometheus.Histogram
+}
+
+var _ queue.MetricsSink = &Sink{}
+
+// Option configures a Sink created with New
+type Option func(*config)
+
+// config holds the settings of a Sink, as set by the options given to New
+type config struct {
+	namespace string
+	buckets   []float64
+}
+
+// WithNamespace prefixes the names of the metrics with namespace and an
+// underscore, as in "myapp_jobs_created_total". By default, they have
+// no prefix.
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithBuckets sets the upper bounds, in seconds, of the buckets of the
+// histogram of processing times. By default, they are prometheus.DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.buckets = buckets
+	}
+}
+
+// New returns a Sink whose collectors are registered with reg.
+// It fails if any of them cannot be registered, for instance because
+// another Sink with the same namespace was registered with reg before.
+func New(reg prometheus.Registerer, opts ...Option) (*Sink, error) {
+	cfg := config{buckets: prometheus.DefBuckets}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	s := &Sink{
+		counters: make(map[string]prometheus.Counter, len(counters)),
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: cfg.namespace,
+			Name:      queue.MetricQueueDepth,
+			Help:      "Number of jobs waiting to be dispatched.",
+		}, []string{"queue"}),
+		processing: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Name:      queue.MetricProcessingSeconds,
+			Help:      "Time each attempt to process a job took, in seconds.",
+			Buckets:   cfg.buckets,
+		}),
+	}
+	collectors := []prometheus.Collector{s.depth, s.processing}
+	for name, help := range counters {
+		c := prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      name,
+			Help:      help,
+		})
+		s.counters[name] = c
+		collectors = append(collectors, c)
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Sink) Counter(name string, delta float64, tags ...queue.Tag) {
+	if c, ok := s.counters[name]; ok {
+		c.Add(delta)
+	}
+}
+
+func (s *Sink) Gauge(name string, value float64, tags ...queue.Tag) {
+	if name == queue.MetricQueueDepth {
+		s.depth.WithLabelValues(queueName(tags)).Set(value)
+	}
+}
+
+func (s *Sink) Observe(name string, value float64, tags ...queue.Tag) {
+	if name == queue.MetricProcessingSeconds {
+		s.processing.Observe(value)
+	}
+}
+
+// queueName returns the value of the queue tag,
+// or the name of the default queue if there is none
+func queueName(tags []queue.Tag) string {
+	for _, t := range tags {
+		if t.Key == "queue" {
+			return t.Value
+		}
+	}
+	return queue.DefaultQueue
+}
@@ -0,0 +1,123 @@
+package promsink_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+	"github.com/ingrammicro/backend-test/queue/promsink"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// intData is a job payload holding a single integer
+type intData struct {
+	N int `json:"n"`
+}
+
+func (d *intData) Marshal() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (d *intData) Unmarshal(b []byte) error {
+	return json.Unmarshal(b, d)
+}
+
+// processorFunc turns a function into a queue.Processor
+type processorFunc func(ctx context.Context, j queue.JobProcessingAccess) error
+
+func (f processorFunc) Process(ctx context.Context, j queue.JobProcessingAccess) error {
+	return f(ctx, j)
+}
+
+// failNegative is a processor that fails intData jobs with a negative payload
+var failNegative = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+	var d intData
+	if err := j.GetData(&d); err != nil {
+		return err
+	}
+	if d.N < 0 {
+		return errors.New("negative payload")
+	}
+	return nil
+})
+
+func TestSinkExportsMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	sink, err := promsink.New(reg, promsink.WithNamespace("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, w := queue.New(failNegative, queue.WithMetricsSink(sink))
+	ctx := context.Background()
+	for id, n := range map[string]int{"a": 1, "b": 2, "neg": -1} {
+		if err := c.CreateJob(ctx, id, &intData{N: n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.CreateJobInQueue(ctx, "other", "other", &intData{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.(queue.SyncWorker).ProcessAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got := gather(t, reg)
+	for name, want := range map[string]float64{
+		"test_jobs_created_total":           4,
+		"test_jobs_started_total":           3,
+		"test_jobs_finished_total":          2,
+		"test_jobs_failed_total":            1,
+		"test_jobs_retried_total":           0,
+		`test_queue_depth{queue="default"}`: 0,
+		`test_queue_depth{queue="other"}`:   1,
+		"test_job_processing_seconds_count": 3,
+	} {
+		if v, ok := got[name]; !ok || v != want {
+			t.Errorf("got %s %v, want %v", name, v, want)
+		}
+	}
+}
+
+func TestSinkRegisteredTwice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if _, err := promsink.New(reg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := promsink.New(reg); err == nil {
+		t.Error("got no error registering a second sink with the same namespace")
+	}
+	if _, err := promsink.New(reg, promsink.WithNamespace("other")); err != nil {
+		t.Errorf("got error %v registering a second sink with another namespace", err)
+	}
+}
+
+// gather returns the values of the metrics in reg, by name and labels.
+// Histograms have their sample count as the value of name_count.
+func gather(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := f.GetName()
+			for _, l := range m.GetLabel() {
+				name += fmt.Sprintf("{%s=%q}", l.GetName(), l.GetValue())
+			}
+			switch {
+			case m.Counter != nil:
+				values[name] = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				values[name] = m.GetGauge().GetValue()
+			case m.Histogram != nil:
+				values[name+"_count"] = float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// WithReaper makes the workers refresh the heartbeat of the jobs they
+// are processing, and the queue check every interval for Processing
+// jobs whose heartbeat is older than staleAfter, which are deemed stuck.
+// Such a job has the context of its processor canceled, and its attempt
+// counts as a failed one: the job is Queued again if the retry policy
+// allows another attempt, or the schedule of the job, and Failed otherwise. Whatever the processor
+// of the reaped attempt does afterwards is ignored.
+//
+// The heartbeat of a job is refreshed every third of staleAfter while it
+// is processed, whether or not its processor makes progress, so it is
+// the worker running it that is checked rather than the processor.
+func WithReaper(interval, staleAfter time.Duration) Option {
+	return func(c *config) {
+		c.reapInterval = interval
+		c.staleAfter = staleAfter
+	}
+}
+
+// heartbeat refreshes the heartbeat of the given job every third of
+// staleAfter until the returned function is called, if there is a reaper.
+func (w *worker) heartbeat(pj *processingJob) (stop func()) {
+	if w.cfg.reapInterval <= 0 || w.cfg.staleAfter <= 0 {
+		return func() {}
+	}
+	every := w.cfg.staleAfter / 3
+	var mu sync.Mutex
+	var t timer
+	stopped := false
+	mu.Lock()
+	defer mu.Unlock()
+	t = w.q.clock.afterFunc(every, func() {
+		pj.beat()
+		mu.Lock()
+		defer mu.Unlock()
+		if !stopped {
+			t.Reset(every)
+		}
+	})
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		t.Stop()
+	}
+}
+
+// beat refreshes the heartbeat of the job, if it is still
+// being processed in the attempt pj was given for.
+func (pj *processingJob) beat() {
+	pj.q.mu.Lock()
+	defer pj.q.mu.Unlock()
+	r, err := pj.current(context.Background())
+	if err != nil {
+		return
+	}
+	r.Heartbeat = pj.q.clock.now()
+	pj.q.store.Save(context.Background(), r)
+}
+
+// armReaper sets off the reaper timer, if there is a reaper
+// and it is not set off already. mu must be held by the caller.
+func (q *memoryQueue) armReaper() {
+	if q.reapInterval <= 0 || q.staleAfter <= 0 || q.reaping {
+		return
+	}
+	q.reaping = true
+	if q.reapTimer == nil {
+		q.reapTimer = q.clock.afterFunc(q.reapInterval, q.reap)
+		return
+	}
+	q.reapTimer.Reset(q.reapInterval)
+}
+
+// reap ends the attempts of the Processing jobs whose heartbeat
+// is stale, as a failure, and sets off the reaper timer again
+// as long as there are Processing jobs. If the store fails,
+// the jobs are checked again at the next interval.
+func (q *memoryQueue) reap() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reaping = false
+	records, err := q.store.List(context.Background())
+	if err == nil {
+		now := q.clock.now()
+		for _, r := range records {
+			l, ok := q.live[r.ID]
+			if !ok || r.State != Processing || l.cancel == nil || now.Sub(r.Heartbeat) <= q.staleAfter {
+				continue
+			}
+			l.cancel()
+			q.log(slog.LevelWarn, "job stalled, reaping it", r, q.attemptDuration(l), slog.Time("heartbeat", r.Heartbeat))
+			stallErr := categorizedError{category: FailureStalled, err: fmt.Errorf("job stalled: no heartbeat for over %s", q.staleAfter)}
+			if retried, delay := nextAttempt(q.retryPolicy, r.Schedule, r.Attempts, stallErr, q.clock.now()); retried {
+				q.retryLater(r, l, stallErr, delay)
+			} else {
+				q.end(r, l, stallErr)
+			}
+		}
+	}
+	if q.processing > 0 {
+		q.armReaper()
+	}
+}
@@ -0,0 +1,171 @@
+package queue_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// frozenStore is a Store in which the heartbeats of the
+// Processing jobs do not advance while it is frozen, as if
+// the workers processing them were stuck.
+type frozenStore struct {
+	queue.MemoryStore
+	mu     sync.Mutex
+	frozen bool
+}
+
+func (s *frozenStore) setFrozen(frozen bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frozen = frozen
+}
+
+func (s *frozenStore) Save(ctx context.Context, r queue.JobRecord) error {
+	s.mu.Lock()
+	frozen := s.frozen
+	s.mu.Unlock()
+	if old, err := s.MemoryStore.Load(ctx, r.ID); frozen && err == nil && old.State == queue.Processing && r.State == queue.Processing {
+		r.Heartbeat = old.Heartbeat
+	}
+	return s.MemoryStore.Save(ctx, r)
+}
+
+// reapInterval and staleAfter are given to WithReaper in the tests
+const (
+	reapInterval = 5 * time.Millisecond
+	staleAfter   = 30 * time.Millisecond
+)
+
+// stallOnce returns a processor that blocks on the first attempt to process
+// a job until its context is canceled, and then unfreezes s and sends on
+// abandoned the error of trying to report progress. Later attempts succeed.
+func stallOnce(s *frozenStore) (p queue.Processor, abandoned chan error) {
+	abandoned = make(chan error, 1)
+	p = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		if j.Attempt() > 1 {
+			return nil
+		}
+		<-ctx.Done()
+		s.setFrozen(false)
+		abandoned <- j.SetProgress(context.Background(), 1, 2)
+		return ctx.Err()
+	})
+	return p, abandoned
+}
+
+func TestReaperRetriesStalledJob(t *testing.T) {
+	s := &frozenStore{frozen: true}
+	p, abandoned := stallOnce(s)
+	c, w, err := queue.NewWithStore(context.Background(), s, p,
+		queue.WithReaper(reapInterval, staleAfter),
+		queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	ch := subscribe(t, c, "a")
+	defer runWorker(t, w, 2)()
+
+	want := []queue.State{queue.Queued, queue.Processing, queue.Queued, queue.Processing, queue.Finished}
+	if got := collect(t, ch); !reflect.DeepEqual(got, want) {
+		t.Errorf("got states %v, want %v", got, want)
+	}
+	r, err := s.Load(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Attempts != 2 {
+		t.Errorf("got %d attempts, want 2 counting the reaped one", r.Attempts)
+	}
+	select {
+	case err := <-abandoned:
+		if err == nil {
+			t.Error("got no error reporting progress from the reaped attempt")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the reaped attempt did not have its context canceled")
+	}
+}
+
+func TestReaperFailsStalledJobWithoutRetries(t *testing.T) {
+	s := &frozenStore{frozen: true}
+	p, _ := stallOnce(s)
+	c, w, err := queue.NewWithStore(context.Background(), s, p, queue.WithReaper(reapInterval, staleAfter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	defer runWorker(t, w, 1)()
+
+	j := waitForJob(t, c, "a")
+	if j.State() != queue.Failed {
+		t.Fatalf("got job %s, want %s", j.State(), queue.Failed)
+	}
+	if !strings.Contains(j.Error(), "stalled") {
+		t.Errorf("got error %q, want one telling the job stalled", j.Error())
+	}
+	if got := j.FailureCategory(); got != queue.FailureStalled {
+		t.Errorf("got failure category %q, want %q", got, queue.FailureStalled)
+	}
+}
+
+func TestReaperSparesJobsWithHeartbeat(t *testing.T) {
+	var attempts []int
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		attempts = append(attempts, j.Attempt())
+		select {
+		case <-time.After(5 * staleAfter):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	c, w := queue.New(p,
+		queue.WithReaper(reapInterval, staleAfter),
+		queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}),
+	)
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+
+	j := waitForJob(t, c, "a")
+	if j.State() != queue.Finished {
+		t.Errorf("got job %s (%s), want %s", j.State(), j.Error(), queue.Finished)
+	}
+	if !reflect.DeepEqual(attempts, []int{1}) {
+		t.Errorf("got attempts %v, want only the first", attempts)
+	}
+}
+
+func TestReaperSparesJobsWithHeartbeatOnQueueClock(t *testing.T) {
+	p, started, proceed := gated()
+	clock := queue.NewFakeClock()
+	c, w := queue.New(p,
+		queue.WithReaper(reapInterval, staleAfter),
+		queue.WithFakeClock(clock),
+	)
+	createJobs(t, c, map[string]int{"a": 1})
+	defer runWorker(t, w, 1)()
+	waitForStarts(t, started, 1)
+
+	for i := 0; i < 5*int(staleAfter/reapInterval); i++ {
+		clock.Advance(reapInterval)
+	}
+	j, err := c.GetJob(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.State() != queue.Processing {
+		t.Fatalf("got job %s (%s), want it still %s", j.State(), j.Error(), queue.Processing)
+	}
+	proceed <- struct{}{}
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Errorf("got job %s (%s), want %s", j.State(), j.Error(), queue.Finished)
+	}
+}
@@ -0,0 +1,169 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// reservationPrefix starts the names of the queues of the reservations.
+// Users cannot name queues like that, so they cannot collide with them.
+const reservationPrefix = "reservation:"
+
+// checkQueueName fails with ErrReservedQueueName if the given
+// name of a queue is that of the queue of a reservation
+func checkQueueName(name string) error {
+	if strings.HasPrefix(name, reservationPrefix) {
+		return fmt.Errorf("cannot use queue %q, starting with %q: %w", name, reservationPrefix, ErrReservedQueueName)
+	}
+	return nil
+}
+
+// Reservation is a number of worker goroutines of a call to Run set
+// aside by Reserve, which only process the jobs created against the
+// reservation with CreateJobReserved, until it is released.
+//
+// The jobs created against it are kept in a queue of their own, named
+// after the reservation, until it is released, which happens when the
+// call to Run it was made from returns, if not before. retire retires its
+// goroutines, and released tells whether it was released. They are
+// guarded by the mu of the worker.
+type Reservation struct {
+	w        *worker
+	r        *run
+	queue    string
+	retire   []context.CancelFunc
+	released bool
+}
+
+// Reserve sets aside slots of the worker goroutines of a call to Run in
+// progress, so that other jobs do not get them: they only process the jobs
+// created against the returned reservation, with the processor of the
+// worker, until it is released. The other goroutines of the call keep
+// processing the queue of the worker, as many as SetConcurrency allows,
+// if it is used. The reservation is released when that call returns, if
+// it was not before. Reserve fails with ErrNotEnoughWorkers if no call
+// to Run has that many goroutines processing the queue of the worker.
+func (w *worker) Reserve(ctx context.Context, slots int) (*Reservation, error) {
+	if slots < 1 {
+		return nil, fmt.Errorf("cannot reserve %d slots", slots)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for r := range w.runs {
+		if r.stopped || len(r.retire) < slots {
+			continue
+		}
+		w.q.mu.Lock()
+		w.q.lastReservation++
+		name := fmt.Sprintf("%s%d/%s", reservationPrefix, w.q.lastReservation, w.queue)
+		w.q.reservations[name] = make(map[string]struct{})
+		w.q.mu.Unlock()
+		kept := len(r.retire) - slots
+		for _, retire := range r.retire[kept:] {
+			retire()
+		}
+		r.retire = r.retire[:kept]
+		r.reserved += slots
+		res := &Reservation{w: w, r: r, queue: name, retire: w.spawn(r, name, slots)}
+		r.reservations[res] = struct{}{}
+		return res, nil
+	}
+	return nil, fmt.Errorf("cannot reserve %d slots: %w", slots, ErrNotEnoughWorkers)
+}
+
+// Release gives the goroutines of the reservation back to the queue of
+// the worker once they are done with their current job, if any. The jobs
+// created against the reservation that are not done yet are moved to the
+// queue of the worker, as are those created against it from then on.
+// Releasing a reservation that was already released does nothing.
+// It returns the error of the store if moving the jobs failed.
+func (res *Reservation) Release() error {
+	w := res.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if res.released {
+		return nil
+	}
+	res.released = true
+	delete(res.r.reservations, res)
+	for _, retire := range res.retire {
+		retire()
+	}
+	res.r.reserved -= len(res.retire)
+	if !res.r.stopped {
+		res.r.retire = append(res.r.retire, w.spawn(res.r, w.queue, len(res.retire))...)
+	}
+	w.q.mu.Lock()
+	defer w.q.mu.Unlock()
+	w.q.givenBack[res.queue] = w.queue
+	for id := range w.q.reservations[res.queue] {
+		if _, ok := w.q.live[id]; !ok {
+			continue
+		}
+		r, err := w.q.store.Load(context.Background(), id)
+		if err != nil {
+			return err
+		}
+		if err := w.q.relocate(context.Background(), r, w.queue); err != nil {
+			return err
+		}
+	}
+	delete(w.q.reservations, res.queue)
+	return nil
+}
+
+// CreateJobReserved creates a job like CreateJob, but against
+// the given reservation, so that only its goroutines process it
+func (c *client) CreateJobReserved(ctx context.Context, res *Reservation, id string, initialData MarshalUnmarshaler, opts ...JobOption) error {
+	if res.w.q != c.q {
+		return fmt.Errorf("cannot create job %q against a reservation of another queue", id)
+	}
+	return c.create(ctx, res.queue, id, initialData, time.Time{}, opts, true)
+}
+
+// releaseAll releases the reservations made from the given call to Run,
+// which returned, so that the jobs created against them are not left
+// Queued with no goroutine to process them. It returns the first error
+// of the store, if any.
+func (w *worker) releaseAll(r *run) error {
+	w.mu.Lock()
+	reservations := make([]*Reservation, 0, len(r.reservations))
+	for res := range r.reservations {
+		reservations = append(reservations, res)
+	}
+	w.mu.Unlock()
+	var err error
+	for _, res := range reservations {
+		if releaseErr := res.Release(); err == nil {
+			err = releaseErr
+		}
+	}
+	return err
+}
+
+// relocate moves the given job, which is not terminal, to the named
+// queue, taking it out of the pending jobs of its queue, if it is there,
+// to add it to those of the other one, whether it has room for it or not.
+// mu must be held by the caller.
+func (q *memoryQueue) relocate(ctx context.Context, r JobRecord, name string) error {
+	from := r.Queue
+	r.Queue = name
+	if err := q.store.Save(ctx, r); err != nil {
+		return err
+	}
+	delete(q.reservations[from], r.ID)
+	if jobs, ok := q.reservations[name]; ok {
+		jobs[r.ID] = struct{}{}
+	}
+	if p, ok := q.pending[from]; ok && p.remove(r.ID) {
+		q.reportDepth(from)
+		q.admit(from)
+		q.push(r)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// reserve reserves slots of the goroutines of w
+func reserve(t *testing.T, w queue.Worker, slots int) *queue.Reservation {
+	t.Helper()
+	res, err := w.(queue.ReservingWorker).Reserve(context.Background(), slots)
+	if err != nil {
+		t.Fatalf("reserving %d slots: %v", slots, err)
+	}
+	return res
+}
+
+func TestReserveUnderCompetingLoad(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p)
+	defer runWorker(t, w, 2)()
+	waitForWorkers(t, c, 2)
+	res := reserve(t, w, 1)
+	for i := 0; i < 4; i++ {
+		createJobs(t, c, map[string]int{fmt.Sprint(i): i})
+	}
+	waitForStarts(t, started, 1)
+	checkNoStart(t, started)
+
+	if err := c.CreateJobReserved(context.Background(), res, "vip", &intData{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-started:
+		if id != "vip" {
+			t.Fatalf("got job %q started, want the reserved one", id)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("reserved job not started while a slot was reserved for it")
+	}
+	for i := 0; i < 2; i++ {
+		proceed <- struct{}{}
+	}
+	waitForJob(t, c, "vip")
+	waitForStarts(t, started, 1)
+	checkNoStart(t, started)
+
+	if err := res.Release(); err != nil {
+		t.Fatal(err)
+	}
+	waitForStarts(t, started, 1)
+	for i := 0; i < 3; i++ {
+		proceed <- struct{}{}
+	}
+	for i := 0; i < 4; i++ {
+		waitForJob(t, c, fmt.Sprint(i))
+	}
+}
+
+func TestReleaseGivesJobsBack(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p)
+	defer runWorker(t, w, 2)()
+	waitForWorkers(t, c, 2)
+	res := reserve(t, w, 1)
+	for _, id := range []string{"vip-1", "vip-2"} {
+		if err := c.CreateJobReserved(context.Background(), res, id, &intData{N: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForStarts(t, started, 1)
+	checkNoStart(t, started)
+
+	if err := res.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateJobReserved(context.Background(), res, "late", &intData{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitForStarts(t, started, 1)
+	for i := 0; i < 3; i++ {
+		proceed <- struct{}{}
+	}
+	for _, id := range []string{"vip-1", "vip-2", "late"} {
+		if j := waitForJob(t, c, id); j.State() != queue.Finished {
+			t.Errorf("got job %q %s, want it %s", id, j.State(), queue.Finished)
+		}
+	}
+}
+
+func TestCreateJobReservedAfterReleaseWaitsForRoom(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p, queue.WithMaxQueueDepth(1))
+	defer runWorker(t, w, 1)()
+	waitForWorkers(t, c, 1)
+	res := reserve(t, w, 1)
+	if err := res.Release(); err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	waitForStarts(t, started, 1)
+	createJobs(t, c, map[string]int{"b": 2})
+
+	// The job goes to the full queue of the worker, not to the
+	// empty one of the reservation, so it waits for room there.
+	ctx, cancel := context.WithTimeout(context.Background(), blockedFor)
+	defer cancel()
+	if err := c.CreateJobReserved(ctx, res, "late", &intData{N: 3}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v creating a job against a released reservation with a full queue, want %v", err, context.DeadlineExceeded)
+	}
+	proceed <- struct{}{}
+	waitForStarts(t, started, 1)
+	proceed <- struct{}{}
+	waitForJob(t, c, "b")
+}
+
+func TestReserveNotEnoughWorkers(t *testing.T) {
+	c, w := queue.New(doubler)
+	rw := w.(queue.ReservingWorker)
+	if _, err := rw.Reserve(context.Background(), 1); !errors.Is(err, queue.ErrNotEnoughWorkers) {
+		t.Errorf("got error %v reserving without Run, want %v", err, queue.ErrNotEnoughWorkers)
+	}
+	defer runWorker(t, w, 1)()
+	waitForWorkers(t, c, 1)
+	if _, err := rw.Reserve(context.Background(), 2); !errors.Is(err, queue.ErrNotEnoughWorkers) {
+		t.Errorf("got error %v reserving more slots than workers, want %v", err, queue.ErrNotEnoughWorkers)
+	}
+}
+
+func TestReservedQueueNames(t *testing.T) {
+	c, w := queue.New(doubler)
+	defer runWorker(t, w, 1)()
+	waitForWorkers(t, c, 1)
+	reserve(t, w, 1)
+	ctx := context.Background()
+	if err := c.CreateJobInQueue(ctx, "reservation:1/default", "a", &intData{N: 1}); !errors.Is(err, queue.ErrReservedQueueName) {
+		t.Errorf("got error %v creating a job in the queue of a reservation, want %v", err, queue.ErrReservedQueueName)
+	}
+	createJobs(t, c, map[string]int{"b": 1})
+	if err := c.MoveJob(ctx, "b", "reservation:1/default"); !errors.Is(err, queue.ErrReservedQueueName) {
+		t.Errorf("got error %v moving a job to the queue of a reservation, want %v", err, queue.ErrReservedQueueName)
+	}
+}
+
+func TestReservationReleasedWhenRunReturns(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p)
+	stop := runWorker(t, w, 1)
+	waitForWorkers(t, c, 1)
+	res := reserve(t, w, 1)
+	for _, id := range []string{"interrupted", "queued"} {
+		if err := c.CreateJobReserved(context.Background(), res, id, &intData{N: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForStarts(t, started, 1)
+	stop()
+
+	// The jobs left against the reservation, and those created against
+	// it from then on, go to the queue of the worker for the next Run
+	if err := c.CreateJobReserved(context.Background(), res, "late", &intData{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer runWorker(t, w, 1)()
+	for i := 0; i < 3; i++ {
+		waitForStarts(t, started, 1)
+		proceed <- struct{}{}
+	}
+	for _, id := range []string{"interrupted", "queued", "late"} {
+		if j := waitForJob(t, c, id); j.State() != queue.Finished {
+			t.Errorf("got job %q %s, want it %s", id, j.State(), queue.Finished)
+		}
+	}
+}
+
+func TestReleaseUnblocksProducerOfReservation(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p, queue.WithMaxQueueDepth(1))
+	defer runWorker(t, w, 2)()
+	waitForWorkers(t, c, 2)
+	res := reserve(t, w, 1)
+	for _, id := range []string{"busy", "queued"} {
+		if err := c.CreateJobReserved(context.Background(), res, id, &intData{N: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if id == "busy" {
+			waitForStarts(t, started, 1)
+		}
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- c.CreateJobReserved(context.Background(), res, "blocked", &intData{N: 1})
+	}()
+	checkBlocked(t, result, "creating a job against a full reservation")
+
+	// The producer goes on with the queue of the worker,
+	// where the job is processed
+	if err := res.Release(); err != nil {
+		t.Fatal(err)
+	}
+	checkUnblocked(t, result, "creating a job against a released reservation")
+	waitForStarts(t, started, 2)
+	for i := 0; i < 3; i++ {
+		proceed <- struct{}{}
+	}
+	for _, id := range []string{"busy", "queued", "blocked"} {
+		if j := waitForJob(t, c, id); j.State() != queue.Finished {
+			t.Errorf("got job %q %s, want it %s", id, j.State(), queue.Finished)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package queue_test
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// gated returns a processor that sends on started the ID of each job it
+// starts processing, and then finishes it once it receives on proceed
+func gated() (p queue.Processor, started chan string, proceed chan struct{}) {
+	started, proceed = make(chan string, 10), make(chan struct{})
+	p = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		started <- j.ID()
+		select {
+		case <-proceed:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	return p, started, proceed
+}
+
+// waitForStarts waits for n jobs to start processing
+func waitForStarts(t *testing.T, started chan string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-started:
+		case <-time.After(testTimeout):
+			t.Fatalf("got %d jobs started, want %d", i, n)
+		}
+	}
+}
+
+// checkNoStart checks that no job starts processing for a while
+func checkNoStart(t *testing.T, started chan string) {
+	t.Helper()
+	select {
+	case id := <-started:
+		t.Fatalf("got job %q started, want none", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// waitForWorkers waits for the queue to have n worker goroutines
+func waitForWorkers(t *testing.T, c queue.Client, n int) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		stats, err := c.Stats(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Workers == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d workers after %s, want %d", stats.Workers, testTimeout, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSetConcurrencyRaises(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 1, "b": 2, "c": 3})
+	defer runWorker(t, w, 1)()
+	waitForStarts(t, started, 1)
+	checkNoStart(t, started)
+
+	if err := w.(queue.ScalableWorker).SetConcurrency(3); err != nil {
+		t.Fatal(err)
+	}
+	waitForWorkers(t, c, 3)
+	waitForStarts(t, started, 2)
+	for i := 0; i < 3; i++ {
+		proceed <- struct{}{}
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		waitForJob(t, c, id)
+	}
+}
+
+func TestSetConcurrencyLowers(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 1, "b": 2, "c": 3})
+	defer runWorker(t, w, 3)()
+	waitForStarts(t, started, 3)
+
+	if err := w.(queue.ScalableWorker).SetConcurrency(1); err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"d": 4, "e": 5})
+	for i := 0; i < 3; i++ {
+		proceed <- struct{}{}
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if j := waitForJob(t, c, id); j.State() != queue.Finished {
+			t.Errorf("got job %q %s, want it %s", id, j.State(), queue.Finished)
+		}
+	}
+	waitForWorkers(t, c, 1)
+	for i := 0; i < 2; i++ {
+		waitForStarts(t, started, 1)
+		checkNoStart(t, started)
+		proceed <- struct{}{}
+	}
+	waitForJob(t, c, "d")
+	waitForJob(t, c, "e")
+}
+
+func TestSetConcurrencyToZero(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p)
+	defer runWorker(t, w, 2)()
+	waitForWorkers(t, c, 2)
+	sw := w.(queue.ScalableWorker)
+	if err := sw.SetConcurrency(0); err != nil {
+		t.Fatal(err)
+	}
+	waitForWorkers(t, c, 0)
+	createJobs(t, c, map[string]int{"a": 1})
+	checkNoStart(t, started)
+
+	if err := sw.SetConcurrency(1); err != nil {
+		t.Fatal(err)
+	}
+	waitForStarts(t, started, 1)
+	proceed <- struct{}{}
+	waitForJob(t, c, "a")
+}
+
+func TestSetConcurrencyToZeroDrains(t *testing.T) {
+	c, w := queue.New(doubler)
+	stop := runWorker(t, w, 2)
+	waitForWorkers(t, c, 2)
+	if err := w.(queue.ScalableWorker).SetConcurrency(0); err != nil {
+		t.Fatal(err)
+	}
+	waitForWorkers(t, c, 0)
+	createJobs(t, c, map[string]int{"a": 1})
+	if err := w.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("got error %v from Run, want none", err)
+	}
+	if j, err := c.GetJob(context.Background(), "a"); err != nil || j.State() != queue.Queued {
+		t.Errorf("got job %v with error %v, want it still %s", j, err, queue.Queued)
+	}
+}
+
+func TestActiveWorkers(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p)
+	sw := w.(queue.ScalableWorker)
+	if n := sw.ActiveWorkers(); n != 0 {
+		t.Errorf("got %d active workers before Run, want 0", n)
+	}
+	stop := runWorker(t, w, 3)
+	waitForWorkers(t, c, 3)
+	if n := sw.ActiveWorkers(); n != 3 {
+		t.Errorf("got %d active workers, want 3", n)
+	}
+	// Retired goroutines busy with a job are not active anymore
+	createJobs(t, c, map[string]int{"a": 1, "b": 2})
+	waitForStarts(t, started, 2)
+	if err := sw.SetConcurrency(1); err != nil {
+		t.Fatalf("setting concurrency: %v", err)
+	}
+	if n := sw.ActiveWorkers(); n != 1 {
+		t.Errorf("got %d active workers once lowered to 1, want 1", n)
+	}
+	proceed <- struct{}{}
+	proceed <- struct{}{}
+	if err := sw.SetConcurrency(4); err != nil {
+		t.Fatalf("setting concurrency: %v", err)
+	}
+	if n := sw.ActiveWorkers(); n != 4 {
+		t.Errorf("got %d active workers once raised to 4, want 4", n)
+	}
+	pw := w.(queue.PausableWorker)
+	pw.Pause()
+	if n := sw.ActiveWorkers(); n != 0 {
+		t.Errorf("got %d active workers while paused, want 0", n)
+	}
+	pw.Resume()
+	if n := sw.ActiveWorkers(); n != 4 {
+		t.Errorf("got %d active workers once resumed, want 4", n)
+	}
+	if err := w.Drain(context.Background()); err != nil {
+		t.Fatalf("draining: %v", err)
+	}
+	if n := sw.ActiveWorkers(); n != 0 {
+		t.Errorf("got %d active workers once drained, want 0", n)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("got error %v from Run, want none", err)
+	}
+}
+
+func TestSetConcurrencyNegative(t *testing.T) {
+	_, w := queue.New(doubler)
+	if err := w.(queue.ScalableWorker).SetConcurrency(-1); err == nil {
+		t.Error("got no error setting a negative concurrency")
+	}
+}
+
+func TestCPUBoundClampsWorkers(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	c, w := queue.New(doubler, queue.WithCPUBound())
+	defer runWorker(t, w, 4)()
+	waitForWorkers(t, c, 2)
+
+	if err := w.(queue.ScalableWorker).SetConcurrency(1); err != nil {
+		t.Fatal(err)
+	}
+	waitForWorkers(t, c, 1)
+	if err := w.(queue.ScalableWorker).SetConcurrency(5); err != nil {
+		t.Fatal(err)
+	}
+	waitForWorkers(t, c, 2)
+}
+
+func TestCPUBoundClampsAllRuns(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	c, w := queue.New(doubler, queue.WithCPUBound())
+	defer runWorker(t, w, 1)()
+	waitForWorkers(t, c, 1)
+	// The second call starts both of its goroutines at once if not clamped
+	defer runWorker(t, w, 2)()
+	waitForWorkers(t, c, 2)
+}
+
+func TestCPUBoundCountsReservedSlots(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	c, w := queue.New(doubler, queue.WithCPUBound())
+	defer runWorker(t, w, 2)()
+	waitForWorkers(t, c, 2)
+	res := reserve(t, w, 1)
+	defer res.Release()
+	waitForWorkers(t, c, 2)
+
+	if err := w.(queue.ScalableWorker).SetConcurrency(2); err != nil {
+		t.Fatal(err)
+	}
+	if got := stats(t, c).Workers; got != 2 {
+		t.Errorf("got %d workers with a slot reserved, want 2", got)
+	}
+}
+
+func TestCPUBoundPerQueue(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	c, w := queue.New(doubler, queue.WithCPUBound("images"))
+	images := w.(queue.MultiQueueWorker).ForQueue("images", doubler)
+	emails := w.(queue.MultiQueueWorker).ForQueue("emails", doubler)
+	defer runWorker(t, emails, 3)()
+	waitForWorkers(t, c, 3)
+	defer runWorker(t, w, 3)()
+	waitForWorkers(t, c, 6)
+	defer runWorker(t, images, 3)()
+	waitForWorkers(t, c, 8)
+}
+
+func TestIOBoundDoesNotClampWorkers(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	c, w := queue.New(doubler)
+	defer runWorker(t, w, 4)()
+	waitForWorkers(t, c, 4)
+}
@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WithMaxScheduled limits the number of Scheduled jobs, whose time to
+// be Queued has not come yet, to n, across all the named queues, so
+// that a storm of jobs created for later cannot take up all the memory.
+// Creating one more fails with ErrTooManyScheduled, and room is made as
+// Scheduled jobs get Queued or cancelled. Jobs created to be Queued
+// right away are not limited. If n is 0, the default, nothing is.
+func WithMaxScheduled(n int) Option {
+	return func(c *config) {
+		c.maxScheduled = n
+	}
+}
+
+// WithClockSkewTolerance makes Scheduled jobs Queued as early as d before
+// their time, so that jobs scheduled by hosts whose clock is up to d ahead
+// of the one of the queue are not held back by the skew, as their times
+// are in the future of the queue. The tradeoff is that jobs may then be
+// processed up to d earlier than they were scheduled for: a job created
+// to be Queued within d is Queued right away, and so is one rescheduled
+// within d. The times of the schedules given to CreateJobWithSchedule
+// after the first one are not affected, as they are retry delays. If d
+// is 0, the default, jobs are Queued at their time.
+func WithClockSkewTolerance(d time.Duration) Option {
+	return func(c *config) {
+		c.clockSkew = d
+	}
+}
+
+// untilDue returns how long until a Scheduled job to be Queued at the
+// given time is due, given the clock skew tolerance of the queue
+func (q *memoryQueue) untilDue(at time.Time) time.Duration {
+	return at.Sub(q.clock.now()) - q.clockSkew
+}
+
+// CreateJobWithSchedule creates a job in the default queue which is
+// attempted at the given times, in order, rather than retried as the
+// retry policy of the worker allows: it stays Scheduled until the first
+// one, and each attempt that fails is retried at the next one, if any is
+// left, or fails the job for good. Times that are past when they come
+// up are attempted right away. Processors returning a RetryAfter cannot
+// change the schedule.
+func (c *client) CreateJobWithSchedule(ctx context.Context, id string, attempts []time.Time, initialData MarshalUnmarshaler, opts ...JobOption) error {
+	if len(attempts) == 0 {
+		return errors.New("cannot create a job with no scheduled attempt")
+	}
+	schedule := append([]time.Time(nil), attempts...)
+	opts = append(opts[:len(opts):len(opts)], func(o *jobOptions) {
+		o.schedule = schedule
+	})
+	return c.create(ctx, DefaultQueue, id, initialData, schedule[0], opts, true)
+}
+
+// Reschedule changes the time at which the given Scheduled job is to
+// be Queued to at, which may be earlier or later than the current one.
+// If at is not in the future, the job is Queued right away. It fails
+// with an error wrapping ErrJobNotFound if there is no such job, and
+// ErrJobNotScheduled if the job is not Scheduled.
+func (c *client) Reschedule(ctx context.Context, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	id = c.q.resolve(id)
+	r, err := c.q.store.Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if r.State != Scheduled {
+		return fmt.Errorf("cannot reschedule job %q, which is %s: %w", id, r.State, ErrJobNotScheduled)
+	}
+	// The timer of the current time moves the job later by itself
+	earlier := at.Before(r.RunAt)
+	r.RunAt = at
+	if err := c.q.store.Save(ctx, r); err != nil {
+		return err
+	}
+	if earlier {
+		c.q.schedule(id, c.q.untilDue(at))
+	}
+	return nil
+}
+
+// nextAttempt tells whether a job that just failed the given attempt with
+// err is to be retried, and after how long: at the next time of its
+// schedule, if it was created with one, given that it is now, or as rp
+// allows otherwise.
+func nextAttempt(rp RetryPolicy, schedule []time.Time, attempt int, err error, now time.Time) (bool, time.Duration) {
+	if schedule != nil {
+		if attempt >= len(schedule) {
+			return false, 0
+		}
+		return true, schedule[attempt].Sub(now)
+	}
+	if attempt >= rp.MaxAttempts {
+		return false, 0
+	}
+	return true, rp.retryDelay(attempt, err)
+}
@@ -0,0 +1,241 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// timedFailures returns a processor failing the attempts before the given
+// one, and a function returning when each attempt started, by now
+func timedFailures(succeedAt int, now func() time.Time) (queue.Processor, func() []time.Time) {
+	var mu sync.Mutex
+	var starts []time.Time
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		mu.Lock()
+		starts = append(starts, now())
+		mu.Unlock()
+		if succeedAt == 0 || j.Attempt() < succeedAt {
+			return errors.New("dependency down")
+		}
+		return nil
+	})
+	return p, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), starts...)
+	}
+}
+
+// checkAttemptTimes checks that the attempts started at the scheduled times
+func checkAttemptTimes(t *testing.T, starts, schedule []time.Time) {
+	t.Helper()
+	if len(starts) != len(schedule) {
+		t.Fatalf("got %d attempts, want %d", len(starts), len(schedule))
+	}
+	for i, start := range starts {
+		if !start.Equal(schedule[i]) {
+			t.Errorf("got attempt %d started %s after its scheduled time", i+1, start.Sub(schedule[i]))
+		}
+	}
+}
+
+// advanceAndProcess makes d pass on clock and then processes
+// the jobs that are Queued with w, returning how many there were
+func advanceAndProcess(t *testing.T, clock *queue.FakeClock, w queue.Worker, d time.Duration) int {
+	t.Helper()
+	clock.Advance(d)
+	return processAll(t, w)
+}
+
+func TestScheduledAttemptsThenFailed(t *testing.T) {
+	clock := queue.NewFakeClock()
+	p, starts := timedFailures(0, clock.Now)
+	c, w := queue.New(p, queue.WithFakeClock(clock), queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 10}))
+	now := clock.Now()
+	schedule := []time.Time{now.Add(10 * time.Millisecond), now.Add(30 * time.Millisecond), now.Add(60 * time.Millisecond)}
+	if err := c.CreateJobWithSchedule(context.Background(), "a", schedule, &intData{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	checkState(t, c, "a", queue.Scheduled)
+
+	for i, step := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond} {
+		if n := advanceAndProcess(t, clock, w, step-time.Nanosecond); n != 0 {
+			t.Fatalf("got %d attempts processed before scheduled attempt %d, want none", n, i+1)
+		}
+		if n := advanceAndProcess(t, clock, w, time.Nanosecond); n != 1 {
+			t.Fatalf("got %d attempts processed at scheduled attempt %d, want 1", n, i+1)
+		}
+	}
+	checkState(t, c, "a", queue.Failed)
+	checkAttemptTimes(t, starts(), schedule)
+}
+
+func TestScheduledAttemptsStopOnSuccess(t *testing.T) {
+	clock := queue.NewFakeClock()
+	p, starts := timedFailures(2, clock.Now)
+	c, w := queue.New(p, queue.WithFakeClock(clock))
+	now := clock.Now()
+	schedule := []time.Time{now, now.Add(20 * time.Millisecond), now.Add(time.Hour)}
+	if err := c.CreateJobWithSchedule(context.Background(), "a", schedule, &intData{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	advanceAndProcess(t, clock, w, 20*time.Millisecond)
+
+	checkState(t, c, "a", queue.Finished)
+	checkAttemptTimes(t, starts(), schedule[:2])
+}
+
+func TestCreateJobWithEmptySchedule(t *testing.T) {
+	c, _ := queue.New(doubler)
+	if err := c.CreateJobWithSchedule(context.Background(), "a", nil, &intData{N: 1}); err == nil {
+		t.Error("created a job with no scheduled attempt")
+	}
+}
+
+// createScheduled creates a job in the queue of c
+// that stays Scheduled until at
+func createScheduled(t *testing.T, c queue.Client, id string, at time.Time) {
+	t.Helper()
+	if err := c.CreateJobAt(context.Background(), id, &intData{N: 1}, at); err != nil {
+		t.Fatalf("creating job %q: %v", id, err)
+	}
+}
+
+// reschedule reschedules the job of c with the given ID to at
+func reschedule(t *testing.T, c queue.Client, id string, at time.Time) {
+	t.Helper()
+	if err := c.Reschedule(context.Background(), id, at); err != nil {
+		t.Fatalf("rescheduling job %q: %v", id, err)
+	}
+}
+
+func TestRescheduleLater(t *testing.T) {
+	clock := queue.NewFakeClock()
+	p, starts := timedFailures(1, clock.Now)
+	c, w := queue.New(p, queue.WithFakeClock(clock))
+	now := clock.Now()
+	createScheduled(t, c, "a", now.Add(10*time.Millisecond))
+	later := now.Add(50 * time.Millisecond)
+	reschedule(t, c, "a", later)
+
+	if n := advanceAndProcess(t, clock, w, 50*time.Millisecond-time.Nanosecond); n != 0 {
+		t.Errorf("got %d jobs processed before the new time, want none", n)
+	}
+	advanceAndProcess(t, clock, w, time.Nanosecond)
+	checkState(t, c, "a", queue.Finished)
+	checkAttemptTimes(t, starts(), []time.Time{later})
+}
+
+func TestRescheduleEarlier(t *testing.T) {
+	clock := queue.NewFakeClock()
+	p, starts := timedFailures(1, clock.Now)
+	c, w := queue.New(p, queue.WithFakeClock(clock))
+	createScheduled(t, c, "a", clock.Now().Add(time.Hour))
+	earlier := clock.Now().Add(10 * time.Millisecond)
+	reschedule(t, c, "a", earlier)
+
+	advanceAndProcess(t, clock, w, 10*time.Millisecond)
+	checkState(t, c, "a", queue.Finished)
+	checkAttemptTimes(t, starts(), []time.Time{earlier})
+}
+
+func TestRescheduleRepeatedly(t *testing.T) {
+	clock := queue.NewFakeClock()
+	p, starts := timedFailures(1, clock.Now)
+	c, w := queue.New(p, queue.WithFakeClock(clock))
+	now := clock.Now()
+	createScheduled(t, c, "a", now.Add(5*time.Millisecond))
+	for i := 1; i <= 5; i++ {
+		reschedule(t, c, "a", now.Add(time.Duration(i)*10*time.Millisecond))
+	}
+
+	if n := advanceAndProcess(t, clock, w, 50*time.Millisecond-time.Nanosecond); n != 0 {
+		t.Errorf("got %d jobs processed before the last time, want none", n)
+	}
+	advanceAndProcess(t, clock, w, time.Nanosecond)
+	checkAttemptTimes(t, starts(), []time.Time{now.Add(50 * time.Millisecond)})
+}
+
+func TestRescheduleNotScheduled(t *testing.T) {
+	c, _ := queue.New(doubler)
+	createJobs(t, c, map[string]int{"queued": 1})
+	if err := c.Reschedule(context.Background(), "queued", time.Now().Add(time.Hour)); !errors.Is(err, queue.ErrJobNotScheduled) {
+		t.Errorf("got error %v rescheduling a Queued job, want %v", err, queue.ErrJobNotScheduled)
+	}
+	if err := c.Reschedule(context.Background(), "missing", time.Now()); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v rescheduling a missing job, want %v", err, queue.ErrJobNotFound)
+	}
+}
+
+func TestMaxScheduled(t *testing.T) {
+	c, w := queue.New(doubler, queue.WithMaxScheduled(2))
+	ctx := context.Background()
+	soon := time.Now().Add(10 * time.Millisecond)
+	for _, id := range []string{"a", "b"} {
+		if err := c.CreateJobAt(ctx, id, &intData{N: 1}, soon); err != nil {
+			t.Fatalf("creating job %q: %v", id, err)
+		}
+	}
+	if err := c.CreateJobAt(ctx, "c", &intData{N: 1}, soon); !errors.Is(err, queue.ErrTooManyScheduled) {
+		t.Errorf("got error %v scheduling one job too many, want %v", err, queue.ErrTooManyScheduled)
+	}
+	// Jobs to be Queued right away are not limited
+	createJobs(t, c, map[string]int{"now": 1})
+	// Room is made as Scheduled jobs get Queued
+	defer runWorker(t, w, 1)()
+	waitForJob(t, c, "a")
+	if err := c.CreateJobAt(ctx, "c", &intData{N: 1}, time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("got error %v scheduling a job once another was queued, want none", err)
+	}
+}
+
+func TestMaxScheduledAfterCancel(t *testing.T) {
+	c, _ := queue.New(doubler, queue.WithMaxScheduled(1))
+	ctx := context.Background()
+	later := time.Now().Add(time.Hour)
+	if err := c.CreateJobAt(ctx, "a", &intData{N: 1}, later); err != nil {
+		t.Fatalf("creating job: %v", err)
+	}
+	if err := c.CancelJob(ctx, "a"); err != nil {
+		t.Fatalf("cancelling job: %v", err)
+	}
+	if err := c.CreateJobAt(ctx, "b", &intData{N: 1}, later); err != nil {
+		t.Errorf("got error %v scheduling a job once the other was cancelled, want none", err)
+	}
+}
+
+func TestClockSkewToleranceQueuesJobOfHostAhead(t *testing.T) {
+	const tolerance, ahead = 100 * time.Millisecond, 50 * time.Millisecond
+	clock := queue.NewFakeClock()
+	c, _ := queue.New(doubler, queue.WithFakeClock(clock), queue.WithClockSkewTolerance(tolerance))
+	// A host whose clock is ahead creates a job to be Queued right away
+	createScheduled(t, c, "skewed", clock.Now().Add(ahead))
+	// and one whose clock is further ahead than the tolerance
+	createScheduled(t, c, "beyond", clock.Now().Add(2*tolerance))
+
+	checkState(t, c, "skewed", queue.Queued)
+	checkState(t, c, "beyond", queue.Scheduled)
+}
+
+func TestClockSkewToleranceFiresWithinTolerance(t *testing.T) {
+	const tolerance = 50 * time.Millisecond
+	clock := queue.NewFakeClock()
+	p, starts := timedFailures(1, clock.Now)
+	c, w := queue.New(p, queue.WithFakeClock(clock), queue.WithClockSkewTolerance(tolerance))
+	at := clock.Now().Add(4 * tolerance)
+	createScheduled(t, c, "a", at)
+	checkState(t, c, "a", queue.Scheduled)
+
+	if n := advanceAndProcess(t, clock, w, 3*tolerance-time.Nanosecond); n != 0 {
+		t.Errorf("got %d jobs processed earlier than the tolerance, want none", n)
+	}
+	advanceAndProcess(t, clock, w, time.Nanosecond)
+	checkState(t, c, "a", queue.Finished)
+	checkAttemptTimes(t, starts(), []time.Time{at.Add(-tolerance)})
+}
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Shard processes a job too heavy for a single worker in parts: it splits
+// data with split into the payloads of shards sub-jobs, usually that many,
+// which it creates in the default queue with the IDs of shardID, waits
+// for them to be processed in parallel, and merges their results with
+// merge into the payload of a job with the given ID, which it creates
+// Finished, without processing it, and returns. The sub-jobs are kept,
+// so that they can still be looked at. If split returns no payload, if
+// one of the sub-jobs fails or is cancelled, or if ctx gets done first,
+// Shard returns an error and creates no merged job.
+func (c *client) Shard(ctx context.Context, id string, shards int, data MarshalUnmarshaler, split func(data MarshalUnmarshaler, n int) []MarshalUnmarshaler, merge func(parts []MarshalUnmarshaler) MarshalUnmarshaler) (Job, error) {
+	if shards < 1 {
+		return nil, fmt.Errorf("cannot split job %q into %d shards", id, shards)
+	}
+	c.q.mu.RLock()
+	taken := c.q.taken(id)
+	c.q.mu.RUnlock()
+	if taken {
+		return nil, fmt.Errorf("cannot create job %q: %w", id, ErrDuplicateJob)
+	}
+	parts := split(data, shards)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("cannot split job %q: no shards to process", id)
+	}
+	specs := make([]JobSpec, len(parts))
+	ids := make([]string, len(parts))
+	for i, part := range parts {
+		ids[i] = shardID(id, i)
+		specs[i] = JobSpec{ID: ids[i], Data: part}
+	}
+	if err := c.CreateJobs(ctx, specs); err != nil {
+		return nil, err
+	}
+	jobs, err := c.WaitForJobs(ctx, ids, WithFailFast())
+	if err != nil {
+		return nil, err
+	}
+	for i, part := range parts {
+		j := jobs[ids[i]]
+		if j.State() != Finished {
+			return nil, fmt.Errorf("cannot merge shard %q of job %q, which is %s", ids[i], id, j.State())
+		}
+		if err := j.GetData(part); err != nil {
+			return nil, err
+		}
+	}
+	return c.createMerged(ctx, id, merge(parts))
+}
+
+// shardID returns the ID of the ith sub-job of the job with the given ID
+func shardID(id string, i int) string {
+	return fmt.Sprintf("%s/shard-%d", id, i)
+}
+
+// createMerged creates a Finished job in the default queue with the
+// given ID and result, as Shard does once its sub-jobs are processed,
+// and returns a snapshot of it. The job gets the events of one created
+// and then finished, without an attempt.
+func (c *client) createMerged(ctx context.Context, id string, result MarshalUnmarshaler) (Job, error) {
+	data, err := c.q.marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	if c.q.taken(id) {
+		return nil, fmt.Errorf("cannot create job %q: %w", id, ErrDuplicateJob)
+	}
+	r := c.newRecord(DefaultQueue, id, data, 1, nil)
+	r.State = Finished
+	if err := c.save(ctx, &r); err != nil {
+		return nil, err
+	}
+	c.q.lastSeq++
+	l := c.q.add(r)
+	c.q.metrics.Counter(MetricJobsCreated, 1)
+	c.q.emit(id, func(h EventHandler) { h.OnCreated(id) })
+	c.q.metrics.Counter(MetricJobsFinished, 1)
+	c.q.emit(id, func(h EventHandler) { h.OnFinished(id, r.Attempts) })
+	c.q.log(slog.LevelDebug, "job finished", r)
+	c.q.summarize(Finished)
+	close(l.done)
+	c.q.expireLater(l)
+	return &job{q: c.q, r: r}, nil
+}
@@ -0,0 +1,150 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// splitInt splits intData into n parts summing up to it
+func splitInt(data queue.MarshalUnmarshaler, n int) []queue.MarshalUnmarshaler {
+	total := data.(*intData).N
+	parts := make([]queue.MarshalUnmarshaler, n)
+	for i := range parts {
+		parts[i] = &intData{N: total / n}
+	}
+	parts[0].(*intData).N += total % n
+	return parts
+}
+
+// sumInts merges parts of intData by summing them up
+func sumInts(parts []queue.MarshalUnmarshaler) queue.MarshalUnmarshaler {
+	sum := &intData{}
+	for _, part := range parts {
+		sum.N += part.(*intData).N
+	}
+	return sum
+}
+
+// shard calls Shard on c in a goroutine, returning its error on the channel
+func shard(c queue.Client, id string, shards int, data queue.MarshalUnmarshaler) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.Shard(context.Background(), id, shards, data, splitInt, sumInts)
+		errs <- err
+	}()
+	return errs
+}
+
+func TestShardMergesResults(t *testing.T) {
+	c, w := queue.New(doubler)
+	stop := runWorker(t, w, 3)
+	defer stop()
+
+	j, err := c.Shard(context.Background(), "big", 3, &intData{N: 100}, splitInt, sumInts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got intData
+	if err := j.GetData(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.N != 200 {
+		t.Errorf("got %d merged, want %d as if processed at once", got.N, 200)
+	}
+	if j.State() != queue.Finished {
+		t.Errorf("got merged job %s, want it %s", j.State(), queue.Finished)
+	}
+	stored, err := c.GetJob(context.Background(), "big")
+	if err != nil || stored == nil || stored.State() != queue.Finished {
+		t.Fatalf("got job %v and error %v, want it %s", stored, err, queue.Finished)
+	}
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("big/shard-%d", i)
+		if sub, err := c.GetJob(context.Background(), id); err != nil || sub == nil || sub.State() != queue.Finished {
+			t.Errorf("got sub-job %q %v and error %v, want it %s", id, sub, err, queue.Finished)
+		}
+	}
+}
+
+func TestShardProcessesInParallel(t *testing.T) {
+	p, started, proceed := gated()
+	c, w := queue.New(p)
+	stop := runWorker(t, w, 3)
+	defer stop()
+
+	errs := shard(c, "big", 3, &intData{N: 3})
+	waitForStarts(t, started, 3)
+	close(proceed)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShardFailsWithShard(t *testing.T) {
+	c, w := queue.New(doubler)
+	stop := runWorker(t, w, 2)
+	defer stop()
+
+	_, err := c.Shard(context.Background(), "big", 2, &intData{N: -2}, splitInt, sumInts)
+	if !errors.Is(err, queue.ErrJobFailed) {
+		t.Fatalf("got error %v, want %v", err, queue.ErrJobFailed)
+	}
+	if j, err := c.GetJob(context.Background(), "big"); err != nil || j != nil {
+		t.Errorf("got merged job %v and error %v, want neither", j, err)
+	}
+}
+
+func TestShardDuplicateJob(t *testing.T) {
+	c, _ := queue.New(doubler)
+	createJobs(t, c, map[string]int{"big": 1})
+
+	_, err := c.Shard(context.Background(), "big", 2, &intData{N: 2}, splitInt, sumInts)
+	if !errors.Is(err, queue.ErrDuplicateJob) {
+		t.Fatalf("got error %v, want %v", err, queue.ErrDuplicateJob)
+	}
+	if j, err := c.GetJob(context.Background(), "big/shard-0"); err != nil || j != nil {
+		t.Errorf("got sub-job %v and error %v, want none created", j, err)
+	}
+}
+
+func TestShardEventsOfMergedJob(t *testing.T) {
+	rec := &eventRecorder{}
+	c, w := queue.New(doubler, queue.WithEventHandler(rec))
+	stop := runWorker(t, w, 2)
+	defer stop()
+
+	if _, err := c.Shard(context.Background(), "big", 2, &intData{N: 2}, splitInt, sumInts); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, event := range rec.recorded() {
+		if strings.HasSuffix(event, " big") || strings.Contains(event, " big ") {
+			got = append(got, event)
+		}
+	}
+	if want := []string{"created big", "finished big 0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q for the merged job, want %q", got, want)
+	}
+}
+
+func TestShardWithoutParts(t *testing.T) {
+	c, _ := queue.New(doubler)
+	none := func(queue.MarshalUnmarshaler, int) []queue.MarshalUnmarshaler { return nil }
+	merge := func(parts []queue.MarshalUnmarshaler) queue.MarshalUnmarshaler {
+		t.Errorf("merged %d parts, want no merge", len(parts))
+		return &intData{}
+	}
+
+	if _, err := c.Shard(context.Background(), "big", 2, &intData{N: 2}, none, merge); err == nil {
+		t.Error("got no error sharding a job split into no parts")
+	}
+	if j, err := c.GetJob(context.Background(), "big"); err != nil || j != nil {
+		t.Errorf("got merged job %v and error %v, want neither", j, err)
+	}
+}
@@ -0,0 +1,34 @@
+package queue
+
+import "sync"
+
+// Shutdown stops the worker running the processor gracefully, with err
+// as the error of the call to Run, ProcessOne or ProcessAll the job was
+// taken by, or ErrShutdown if it is nil. The job goes on as usual.
+func (pj *processingJob) Shutdown(err error) {
+	if err == nil {
+		err = ErrShutdown
+	}
+	pj.shutdown(err)
+}
+
+// fatalError is the error a processor shut down a call to ProcessOne
+// or ProcessAll with, if any. Only the first one is kept.
+type fatalError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (f *fatalError) set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *fatalError) get() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
@@ -0,0 +1,85 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// errCorrupt is the fatal error with which processors shut down their worker
+var errCorrupt = errors.New("shared resource corrupt")
+
+// shuttingDown returns a processor that shuts down its worker with err
+// when processing the job "fatal", and otherwise behaves like p
+func shuttingDown(p queue.Processor, err error) queue.Processor {
+	return processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		if j.ID() == "fatal" {
+			j.Shutdown(err)
+			return nil
+		}
+		return p.Process(ctx, j)
+	})
+}
+
+// checkState checks that the job of c with the given ID is in state
+func checkState(t *testing.T, c queue.Client, id string, state queue.State) {
+	t.Helper()
+	j, err := c.GetJob(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.State() != state {
+		t.Errorf("got job %q %s, want it %s", id, j.State(), state)
+	}
+}
+
+func TestShutdownFromProcessor(t *testing.T) {
+	gate, started, proceed := gated()
+	c, w := queue.New(shuttingDown(gate, errCorrupt))
+	createJobs(t, c, map[string]int{"slow": 1})
+	result := make(chan error, 1)
+	go func() {
+		result <- w.Run(context.Background(), 2)
+	}()
+	waitForStarts(t, started, 1)
+
+	createJobs(t, c, map[string]int{"fatal": 1})
+	waitForJob(t, c, "fatal")
+	createJobs(t, c, map[string]int{"later": 1})
+	checkNoStart(t, started)
+	select {
+	case err := <-result:
+		t.Fatalf("got Run returning %v while a job was being processed", err)
+	default:
+	}
+
+	proceed <- struct{}{}
+	select {
+	case err := <-result:
+		if !errors.Is(err, errCorrupt) {
+			t.Errorf("got Run returning %v, want %v", err, errCorrupt)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Run did not return after a processor shut the worker down")
+	}
+	checkState(t, c, "slow", queue.Finished)
+	checkState(t, c, "later", queue.Queued)
+}
+
+func TestShutdownProcessAll(t *testing.T) {
+	c, w := queue.New(shuttingDown(doubler, nil))
+	createJobs(t, c, map[string]int{"fatal": 1})
+	createJobs(t, c, map[string]int{"later": 1})
+	n, err := w.(queue.SyncWorker).ProcessAll(context.Background())
+	if !errors.Is(err, queue.ErrShutdown) {
+		t.Errorf("got error %v processing all jobs, want %v", err, queue.ErrShutdown)
+	}
+	if n != 1 {
+		t.Errorf("got %d jobs processed, want 1", n)
+	}
+	checkState(t, c, "fatal", queue.Finished)
+	checkState(t, c, "later", queue.Queued)
+}
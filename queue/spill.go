@@ -0,0 +1,195 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WithResultSpill caps the results of Finished jobs kept in the store of
+// the queue to maxInMemory: beyond that, the oldest results are written
+// to a file of their own in dir, and only the rest of their records is
+// kept in the store. They are read back from disk whenever their record
+// is loaded, so GetJob, and then GetData, give them as usual, and the
+// files are removed with their jobs. This keeps big batches of large
+// results from piling up in memory while their consumers lag behind.
+//
+// Listing jobs reads back all the spilled results of the jobs listed.
+// Results spilled to dir by an earlier queue, with a store that keeps
+// its records, are read back too. dir must exist.
+func WithResultSpill(dir string, maxInMemory int) Option {
+	return func(c *config) {
+		c.spillDir = dir
+		c.spillMax = maxInMemory
+	}
+}
+
+// spillStore is a Store that keeps the results of at most max Finished
+// jobs in the store it wraps, and those of the others in files in dir.
+// inMemory holds the IDs of the Finished jobs whose result is in the
+// wrapped store, in the order they finished, and is guarded by mu.
+type spillStore struct {
+	Store
+	dir string
+	max int
+
+	mu       sync.Mutex
+	inMemory []string
+}
+
+// spill returns s wrapped to spill the results of its Finished jobs to
+// disk, if WithResultSpill was given, and s itself otherwise.
+func (c config) spill(s Store) Store {
+	if c.spillDir == "" {
+		return s
+	}
+	return &spillStore{Store: s, dir: c.spillDir, max: max(c.spillMax, 0)}
+}
+
+// path returns the path of the file holding
+// the result of the job with the given ID
+func (s *spillStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+func (s *spillStore) Save(ctx context.Context, r JobRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.State != Finished || len(r.Data) == 0 {
+		s.forget(r.ID)
+		return s.Store.Save(ctx, r)
+	}
+	if s.remembered(r.ID) {
+		return s.Store.Save(ctx, r)
+	}
+	for len(s.inMemory) >= s.max && len(s.inMemory) > 0 {
+		if err := s.spillOldest(ctx); err != nil {
+			return err
+		}
+	}
+	if s.max == 0 {
+		if err := s.write(r); err != nil {
+			return err
+		}
+		r.Data = nil
+		return s.Store.Save(ctx, r)
+	}
+	if err := s.Store.Save(ctx, r); err != nil {
+		return err
+	}
+	s.inMemory = append(s.inMemory, r.ID)
+	return nil
+}
+
+// spillOldest writes the oldest result kept in the wrapped store
+// to disk, and removes it from there. mu must be held by the caller.
+func (s *spillStore) spillOldest(ctx context.Context) error {
+	id := s.inMemory[0]
+	r, err := s.Store.Load(ctx, id)
+	if err != nil && !errors.Is(err, ErrJobNotFound) {
+		return err
+	}
+	if err == nil && r.State == Finished && len(r.Data) > 0 {
+		if err := s.write(r); err != nil {
+			return err
+		}
+		r.Data = nil
+		if err := s.Store.Save(ctx, r); err != nil {
+			return err
+		}
+	}
+	s.inMemory[0] = ""
+	s.inMemory = s.inMemory[1:]
+	return nil
+}
+
+// write writes the result of r to its file
+func (s *spillStore) write(r JobRecord) error {
+	if err := os.WriteFile(s.path(r.ID), r.Data, 0o600); err != nil {
+		return fmt.Errorf("cannot spill the result of job %q: %w", r.ID, err)
+	}
+	return nil
+}
+
+// remembered tells whether the result of the job with the given
+// ID is kept in the wrapped store. mu must be held by the caller.
+func (s *spillStore) remembered(id string) bool {
+	for _, kept := range s.inMemory {
+		if kept == id {
+			return true
+		}
+	}
+	return false
+}
+
+// forget stops counting the result of the job with the given ID
+// among those kept in memory, if it was. mu must be held by the caller.
+func (s *spillStore) forget(id string) {
+	for i, kept := range s.inMemory {
+		if kept == id {
+			s.inMemory = append(s.inMemory[:i], s.inMemory[i+1:]...)
+			return
+		}
+	}
+}
+
+// readBack sets the Data of r to its spilled result,
+// if it is Finished and its result was spilled
+func (s *spillStore) readBack(r *JobRecord) error {
+	if r.State != Finished || len(r.Data) > 0 {
+		return nil
+	}
+	data, err := os.ReadFile(s.path(r.ID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read back the result of job %q: %w", r.ID, err)
+	}
+	r.Data = data
+	return nil
+}
+
+func (s *spillStore) Load(ctx context.Context, id string) (JobRecord, error) {
+	r, err := s.Store.Load(ctx, id)
+	if err != nil {
+		return JobRecord{}, err
+	}
+	if err := s.readBack(&r); err != nil {
+		return JobRecord{}, err
+	}
+	return r, nil
+}
+
+func (s *spillStore) List(ctx context.Context) ([]JobRecord, error) {
+	records, err := s.Store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		if err := s.readBack(&records[i]); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (s *spillStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.Store.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.forget(id)
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot remove the spilled result of job %q: %w", id, err)
+	}
+	return nil
+}
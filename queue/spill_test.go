@@ -0,0 +1,161 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// resultSize is the size of the results of bigResults
+const resultSize = 64 << 10
+
+// bigData is a job payload holding an integer and some padding
+type bigData struct {
+	N   int    `json:"n"`
+	Pad string `json:"pad"`
+}
+
+func (d *bigData) Marshal() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (d *bigData) Unmarshal(b []byte) error {
+	return json.Unmarshal(b, d)
+}
+
+// bigResults is a processor that doubles the payload of intData
+// jobs into a result padded to resultSize
+var bigResults = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+	var d intData
+	if err := j.GetData(&d); err != nil {
+		return err
+	}
+	return j.SetData(ctx, &bigData{N: 2 * d.N, Pad: strings.Repeat("x", resultSize)})
+})
+
+// resultCountingStore is a Store that keeps track of the most results
+// of Finished jobs it ever held at once
+type resultCountingStore struct {
+	queue.MemoryStore
+	mu   sync.Mutex
+	most int
+}
+
+func (s *resultCountingStore) Save(ctx context.Context, r queue.JobRecord) error {
+	if err := s.MemoryStore.Save(ctx, r); err != nil {
+		return err
+	}
+	records, err := s.MemoryStore.List(ctx)
+	if err != nil {
+		return err
+	}
+	held := 0
+	for _, r := range records {
+		if r.State == queue.Finished && len(r.Data) > 0 {
+			held++
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.most = max(s.most, held)
+	return nil
+}
+
+func (s *resultCountingStore) mostHeld() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.most
+}
+
+// spilledFiles returns the number of files in dir
+func spilledFiles(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestResultSpill(t *testing.T) {
+	s := &resultCountingStore{}
+	dir := t.TempDir()
+	c, w, err := queue.NewWithStore(context.Background(), s, bigResults, queue.WithResultSpill(dir, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		payloads[fmt.Sprint(i)] = i
+	}
+	createJobs(t, c, payloads)
+	defer runWorker(t, w, 4)()
+	for id := range payloads {
+		waitForJob(t, c, id)
+	}
+
+	if got := s.mostHeld(); got > 3 {
+		t.Errorf("got %d results held in the store at once, want at most 3", got)
+	}
+	if got := spilledFiles(t, dir); got != 17 {
+		t.Errorf("got %d results spilled, want 17", got)
+	}
+	for id, n := range payloads {
+		j := waitForJob(t, c, id)
+		var d bigData
+		if err := j.GetData(&d); err != nil {
+			t.Fatal(err)
+		}
+		if d.N != 2*n || len(d.Pad) != resultSize {
+			t.Errorf("got result %d with %d bytes of padding for job %q, want %d with %d", d.N, len(d.Pad), id, 2*n, resultSize)
+		}
+	}
+	jobs, err := c.ListJobs(context.Background(), queue.ListFilter{State: queue.Finished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, j := range jobs {
+		var d bigData
+		if err := j.GetData(&d); err != nil || len(d.Pad) != resultSize {
+			t.Errorf("got result with %d bytes of padding and error %v listing job %q, want %d", len(d.Pad), err, j.ID(), resultSize)
+		}
+	}
+
+	for id := range payloads {
+		if err := c.DeleteJob(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := spilledFiles(t, dir); got != 0 {
+		t.Errorf("got %d results spilled after deleting their jobs, want none", got)
+	}
+}
+
+func TestResultSpillReadBackByNextQueue(t *testing.T) {
+	s := &queue.MemoryStore{}
+	dir := t.TempDir()
+	c, w, err := queue.NewWithStore(context.Background(), s, bigResults, queue.WithResultSpill(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if r, err := s.Load(context.Background(), "a"); err != nil || len(r.Data) != 0 {
+		t.Fatalf("got %d bytes of result and error %v in the store, want none", len(r.Data), err)
+	}
+
+	c, _, err = queue.NewWithStore(context.Background(), s, bigResults, queue.WithResultSpill(dir, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d bigData
+	if err := waitForJob(t, c, "a").GetData(&d); err != nil || d.N != 2 {
+		t.Errorf("got result %d and error %v, want 2", d.N, err)
+	}
+}
@@ -0,0 +1,227 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// QueueStats is a snapshot of a queue, as returned by Stats.
+//
+// Jobs counts the jobs in each state, in all the named queues, leaving
+// out the states no job is in. Workers is the number of worker goroutines
+// of the calls to Run in progress, and OldestQueuedAge is how long the
+// job that has been pending dispatch for the longest has been waiting,
+// or 0 if no job is pending. Depths is the number of jobs pending
+// dispatch in each named queue, as returned by QueueDepthByType.
+// Quarantined is the number of jobs parked in QuarantineQueue, which are
+// left out of the Queued jobs and of OldestQueuedAge, as they are not
+// waiting to be processed.
+//
+// IdleFraction is the fraction of their time the worker goroutines of
+// the calls to Run, past and present, have spent waiting for jobs rather
+// than processing them, or 0 if there has been none. Close to 1, there
+// are more workers than jobs to keep them busy, and close to 0 the jobs
+// wait for workers. WorkerGoroutines breaks it down by worker goroutine
+// of the calls to Run in progress, sorted by queue and ID, so that a
+// goroutine starved by its queue stands out from the busy ones.
+type QueueStats struct {
+	Jobs            map[State]int
+	Depths          map[string]int
+	Workers         int
+	OldestQueuedAge time.Duration
+	Quarantined     int
+	IdleFraction    float64
+
+	WorkerGoroutines []WorkerGoroutineStats
+}
+
+// WorkerGoroutineStats is the time spent by a worker goroutine of a call
+// to Run in progress, as reported by Stats. Queue is the named queue it
+// takes its jobs from, and ID its ID among the goroutines of its worker,
+// as given to WorkerJob. IdleTime is the time it spent waiting for jobs
+// since it started, BusyTime the time it spent processing them, and
+// IdleFraction the fraction of its time it was idle.
+type WorkerGoroutineStats struct {
+	Queue        string
+	ID           int
+	IdleTime     time.Duration
+	BusyTime     time.Duration
+	IdleFraction float64
+}
+
+// Stats returns a snapshot of the queue. The jobs are counted as they
+// move from state to state, and the pending ones kept by named queue,
+// so Stats does not go through them, only through the pending jobs to
+// find the oldest one.
+func (c *client) Stats(ctx context.Context) (QueueStats, error) {
+	if err := ctx.Err(); err != nil {
+		return QueueStats{}, err
+	}
+	c.q.mu.RLock()
+	defer c.q.mu.RUnlock()
+	stats := QueueStats{
+		Jobs:         make(map[State]int),
+		Depths:       make(map[string]int),
+		Workers:      c.q.workers,
+		IdleFraction: c.q.idleFraction(),
+
+		WorkerGoroutines: c.q.workerGoroutines(),
+	}
+	stats.Quarantined = c.q.quarantined()
+	for state, n := range c.q.counts {
+		if state == Queued {
+			n -= stats.Quarantined
+		}
+		if n > 0 {
+			stats.Jobs[state] = n
+		}
+	}
+	now := c.q.clock.now()
+	for name, p := range c.q.pending {
+		if n := p.Len(); n > 0 {
+			stats.Depths[name] = n
+		}
+		if name == QuarantineQueue {
+			continue
+		}
+		p.each(func(pj pendingJob) {
+			if age := now.Sub(pj.since); age > stats.OldestQueuedAge {
+				stats.OldestQueuedAge = age
+			}
+		})
+	}
+	return stats, nil
+}
+
+// QueueDepthByType returns the number of jobs pending dispatch in each
+// named queue, leaving out the empty ones, like the queue_depth metric
+// reports them. As each type of job goes to the queue of its processor,
+// given to ForQueue, it tells which of them lag behind. The depths are
+// the lengths of the heaps of the pending jobs, which change as jobs are
+// pushed and popped, so it does not go through the jobs.
+func (c *client) QueueDepthByType() map[string]int {
+	c.q.mu.RLock()
+	defer c.q.mu.RUnlock()
+	depths := make(map[string]int)
+	for name, p := range c.q.pending {
+		if n := p.Len(); n > 0 {
+			depths[name] = n
+		}
+	}
+	return depths
+}
+
+// count counts the given job as having moved to the given state.
+// mu must be held by the caller.
+func (q *memoryQueue) count(l *liveJob, state State) {
+	if l.state != "" {
+		q.counts[l.state]--
+	}
+	q.counts[state]++
+	l.state = state
+}
+
+// workerClock times a worker goroutine, which has been busy processing
+// jobs since since if job, the ID of the one it is processing, is not
+// empty, and idle since then otherwise. idle and busy add up its time
+// before since. The goroutine has the given ID among those of its worker,
+// taking jobs from the named queue. It is guarded by the mu of the queue.
+type workerClock struct {
+	job   string
+	since time.Time
+	idle  time.Duration
+	busy  time.Duration
+	queue string
+	id    int
+}
+
+// startClock returns the clock of a worker goroutine that starts idle,
+// with the given ID, taking jobs from the named queue.
+// mu must be held by the caller.
+func (q *memoryQueue) startClock(queue string, id int) *workerClock {
+	c := &workerClock{since: q.clock.now(), queue: queue, id: id}
+	q.clocks[c] = struct{}{}
+	return c
+}
+
+// tick records that the worker goroutine timed by c is processing the
+// job with the given ID from now on, or idle if it is empty.
+// mu must be held by the caller.
+func (q *memoryQueue) tick(c *workerClock, job string) {
+	if (c.job != "") != (job != "") {
+		now := q.clock.now()
+		q.clocked(c, now)
+		c.since = now
+	}
+	c.job = job
+}
+
+// stopClock records the time of the worker goroutine timed by c,
+// which returns. mu must be held by the caller.
+func (q *memoryQueue) stopClock(c *workerClock) {
+	q.clocked(c, q.clock.now())
+	delete(q.clocks, c)
+}
+
+// clocked adds the time of the worker goroutine timed by c since
+// c.since until now to its busy or idle time, and to those of the queue.
+// mu must be held by the caller.
+func (q *memoryQueue) clocked(c *workerClock, now time.Time) {
+	idle, busy := c.until(now)
+	q.idleTime += idle - c.idle
+	q.busyTime += busy - c.busy
+	c.idle, c.busy = idle, busy
+}
+
+// until returns the idle and busy time of the worker
+// goroutine timed by c until now. mu must be held,
+// at least for reading, by the caller.
+func (c *workerClock) until(now time.Time) (idle, busy time.Duration) {
+	if c.job != "" {
+		return c.idle, c.busy + now.Sub(c.since)
+	}
+	return c.idle + now.Sub(c.since), c.busy
+}
+
+// idleFraction returns the fraction of the time of the worker goroutines
+// spent idle, counting that of the running ones up to now.
+// mu must be held, at least for reading, by the caller.
+func (q *memoryQueue) idleFraction() float64 {
+	idle, busy := q.idleTime, q.busyTime
+	now := q.clock.now()
+	for c := range q.clocks {
+		cIdle, cBusy := c.until(now)
+		idle += cIdle - c.idle
+		busy += cBusy - c.busy
+	}
+	return fraction(idle, busy)
+}
+
+// workerGoroutines returns the stats of the worker goroutines of
+// the calls to Run in progress, sorted by queue and ID.
+// mu must be held, at least for reading, by the caller.
+func (q *memoryQueue) workerGoroutines() []WorkerGoroutineStats {
+	now := q.clock.now()
+	stats := make([]WorkerGoroutineStats, 0, len(q.clocks))
+	for c := range q.clocks {
+		idle, busy := c.until(now)
+		stats = append(stats, WorkerGoroutineStats{Queue: c.queue, ID: c.id, IdleTime: idle, BusyTime: busy, IdleFraction: fraction(idle, busy)})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Queue != stats[j].Queue {
+			return stats[i].Queue < stats[j].Queue
+		}
+		return stats[i].ID < stats[j].ID
+	})
+	return stats
+}
+
+// fraction returns the fraction of idle+busy that is idle,
+// or 0 if there is no time at all
+func fraction(idle, busy time.Duration) float64 {
+	if idle+busy <= 0 {
+		return 0
+	}
+	return float64(idle) / float64(idle+busy)
+}
@@ -0,0 +1,258 @@
+package queue_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// stats returns the stats of the queue of c
+func stats(t *testing.T, c queue.Client) queue.QueueStats {
+	t.Helper()
+	s, err := c.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("getting stats: %v", err)
+	}
+	return s
+}
+
+// checkCounts checks that the stats of the queue of c count the jobs
+// as in want, and as listing all the jobs does.
+func checkCounts(t *testing.T, c queue.Client, want map[queue.State]int) {
+	t.Helper()
+	jobs, err := c.ListJobs(context.Background(), queue.ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := make(map[queue.State]int)
+	for _, j := range jobs {
+		listed[j.State()]++
+	}
+	got := stats(t, c).Jobs
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got counts %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(got, listed) {
+		t.Errorf("got counts %v, but listed jobs %v", got, listed)
+	}
+}
+
+func TestStatsCounts(t *testing.T) {
+	ctx := context.Background()
+	p, _ := flaky(1)
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}))
+	checkCounts(t, c, map[queue.State]int{})
+
+	createJobs(t, c, map[string]int{"a": 1, "b": 2, "c": 3})
+	if err := c.CreateJobAt(ctx, "later", &intData{N: 4}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(t, c, map[queue.State]int{queue.Queued: 3, queue.Scheduled: 1})
+
+	// Each job fails its first attempt, and is Queued again
+	processAll(t, w)
+	checkCounts(t, c, map[queue.State]int{queue.Queued: 3, queue.Scheduled: 1})
+
+	if err := c.CancelJob(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	checkCounts(t, c, map[queue.State]int{queue.Finished: 2, queue.Cancelled: 1, queue.Scheduled: 1})
+
+	if err := c.DeleteJob(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(t, c, map[queue.State]int{queue.Finished: 1, queue.Cancelled: 1, queue.Scheduled: 1})
+}
+
+func TestStatsWhileProcessing(t *testing.T) {
+	p, started, release, _ := stubborn()
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 1, "b": 2, "c": 3})
+	if got := stats(t, c).Workers; got != 0 {
+		t.Errorf("got %d workers before running any, want 0", got)
+	}
+	stop := runWorker(t, w, 2)
+	for i := 0; i < 2; i++ {
+		<-started
+	}
+	s := stats(t, c)
+	if want := map[queue.State]int{queue.Processing: 2, queue.Queued: 1}; !reflect.DeepEqual(s.Jobs, want) {
+		t.Errorf("got counts %v, want %v", s.Jobs, want)
+	}
+	if s.Workers != 2 {
+		t.Errorf("got %d workers, want 2", s.Workers)
+	}
+	close(release)
+	for _, id := range []string{"a", "b", "c"} {
+		waitForJob(t, c, id)
+	}
+	checkCounts(t, c, map[queue.State]int{queue.Finished: 3})
+	if err := stop(); err != context.Canceled {
+		t.Errorf("got error %v from Run, want %v", err, context.Canceled)
+	}
+	if got := stats(t, c).Workers; got != 0 {
+		t.Errorf("got %d workers after Run returned, want 0", got)
+	}
+}
+
+func TestStatsOldestQueuedAge(t *testing.T) {
+	const wait = 20 * time.Millisecond
+	c, w := queue.New(doubler)
+	if got := stats(t, c).OldestQueuedAge; got != 0 {
+		t.Errorf("got oldest queued age %v without jobs, want 0", got)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	time.Sleep(wait)
+	createJobs(t, c, map[string]int{"b": 2})
+	if got := stats(t, c).OldestQueuedAge; got < wait {
+		t.Errorf("got oldest queued age %v, want at least %v", got, wait)
+	}
+	processAll(t, w)
+	if got := stats(t, c).OldestQueuedAge; got != 0 {
+		t.Errorf("got oldest queued age %v once all jobs are processed, want 0", got)
+	}
+}
+
+func TestStatsCountsRestoredJobs(t *testing.T) {
+	ctx := context.Background()
+	s := &queue.MemoryStore{}
+	records := []queue.JobRecord{
+		{ID: "a", Seq: 1, Queue: queue.DefaultQueue, State: queue.Processing, Attempts: 1},
+		{ID: "b", Seq: 2, Queue: queue.DefaultQueue, State: queue.Queued},
+		{ID: "c", Seq: 3, Queue: queue.DefaultQueue, State: queue.Failed, Attempts: 1},
+	}
+	for _, r := range records {
+		if err := s.Save(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, _, err := queue.NewWithStore(ctx, s, doubler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCounts(t, c, map[queue.State]int{queue.Queued: 2, queue.Failed: 1})
+}
+
+func TestStatsIdleFraction(t *testing.T) {
+	p, started, proceed := gated()
+	clock := queue.NewFakeClock()
+	c, w := queue.New(p, queue.WithFakeClock(clock))
+	if got := stats(t, c).IdleFraction; got != 0 {
+		t.Errorf("got idle fraction %v before any worker ran, want 0", got)
+	}
+	defer runWorker(t, w, 2)()
+	waitForWorkers(t, c, 2)
+	clock.Advance(10 * time.Millisecond)
+	if got := stats(t, c).IdleFraction; got != 1 {
+		t.Errorf("got idle fraction %v with no job, want 1", got)
+	}
+
+	createJobs(t, c, map[string]int{"a": 1})
+	waitForStarts(t, started, 1)
+	clock.Advance(30 * time.Millisecond)
+	// 10ms idle each, then 30ms idle for one and 30ms busy for the other
+	if got, want := stats(t, c).IdleFraction, 50.0/80; got != want {
+		t.Errorf("got idle fraction %v with one of two workers busy for 30ms, want %v", got, want)
+	}
+	proceed <- struct{}{}
+	waitForJob(t, c, "a")
+}
+
+func TestStatsIdleFractionOfBusyWorker(t *testing.T) {
+	p, started, proceed := gated()
+	clock := queue.NewFakeClock()
+	c, w := queue.New(p, queue.WithFakeClock(clock))
+	createJobs(t, c, map[string]int{"a": 1})
+	defer runWorker(t, w, 1)()
+	waitForStarts(t, started, 1)
+	clock.Advance(20 * time.Millisecond)
+	if got := stats(t, c).IdleFraction; got != 0 {
+		t.Errorf("got idle fraction %v for a worker processing since it started, want 0", got)
+	}
+	proceed <- struct{}{}
+	waitForJob(t, c, "a")
+}
+
+func TestStatsWorkerGoroutines(t *testing.T) {
+	p, started, proceed := gated()
+	clock := queue.NewFakeClock()
+	c, w := queue.New(p, queue.WithFakeClock(clock))
+	images := w.(queue.MultiQueueWorker).ForQueue("images", p)
+	if got := stats(t, c).WorkerGoroutines; len(got) != 0 {
+		t.Errorf("got worker goroutines %v before any worker ran, want none", got)
+	}
+	defer runWorker(t, w, 2)()
+	defer runWorker(t, images, 1)()
+	waitForWorkers(t, c, 3)
+	clock.Advance(10 * time.Millisecond)
+
+	if err := c.CreateJobInQueue(context.Background(), "images", "a", &intData{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	waitForStarts(t, started, 1)
+	clock.Advance(30 * time.Millisecond)
+	createJobs(t, c, map[string]int{"b": 2})
+	waitForStarts(t, started, 1)
+	clock.Advance(20 * time.Millisecond)
+
+	got := stats(t, c).WorkerGoroutines
+	if len(got) != 3 {
+		t.Fatalf("got %d worker goroutines, want 3", len(got))
+	}
+	if got[0].Queue != queue.DefaultQueue || got[1].Queue != queue.DefaultQueue || got[0].ID >= got[1].ID {
+		t.Errorf("got worker goroutines %v, want those of the default queue first, sorted by ID", got)
+	}
+	// Either goroutine of the default queue may have started b.
+	if got[0].BusyTime == 0 {
+		got[0], got[1] = got[1], got[0]
+	}
+	want := []queue.WorkerGoroutineStats{
+		{Queue: queue.DefaultQueue, ID: got[0].ID, IdleTime: 40 * time.Millisecond, BusyTime: 20 * time.Millisecond, IdleFraction: 40.0 / 60},
+		{Queue: queue.DefaultQueue, ID: got[1].ID, IdleTime: 60 * time.Millisecond, IdleFraction: 1},
+		{Queue: "images", ID: got[2].ID, IdleTime: 10 * time.Millisecond, BusyTime: 50 * time.Millisecond, IdleFraction: 10.0 / 60},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got worker goroutines %v, want %v", got, want)
+	}
+
+	proceed <- struct{}{}
+	proceed <- struct{}{}
+	waitForJob(t, c, "a")
+	waitForJob(t, c, "b")
+}
+
+func TestStatsDepths(t *testing.T) {
+	ctx := context.Background()
+	c, w := queue.New(doubler)
+	images := w.(queue.MultiQueueWorker).ForQueue("images", doubler)
+	emails := w.(queue.MultiQueueWorker).ForQueue("emails", doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	for i, name := range []string{"images", "images", "images", "emails", "emails"} {
+		if err := c.CreateJobInQueue(ctx, name, fmt.Sprintf("%s-%d", name, i), &intData{N: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkDepths := func(want map[string]int) {
+		t.Helper()
+		if got := stats(t, c).Depths; !reflect.DeepEqual(got, want) {
+			t.Errorf("got depths %v in stats, want %v", got, want)
+		}
+		if got := c.QueueDepthByType(); !reflect.DeepEqual(got, want) {
+			t.Errorf("got depths %v by type, want %v", got, want)
+		}
+	}
+	checkDepths(map[string]int{queue.DefaultQueue: 1, "images": 3, "emails": 2})
+
+	processOne(t, emails)
+	checkDepths(map[string]int{queue.DefaultQueue: 1, "images": 3, "emails": 1})
+	processAll(t, images)
+	checkDepths(map[string]int{queue.DefaultQueue: 1, "emails": 1})
+	processAll(t, emails)
+	processAll(t, w)
+	checkDepths(map[string]int{})
+}
@@ -0,0 +1,157 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// JobRecord is a job as it is kept in a Store. Its payload is kept
+// marshaled in Data. Seq tells the order in which jobs were created,
+// Queue is the name of the queue the job was created in, Priority tells
+// which jobs are dispatched first and, for Scheduled jobs, RunAt is when
+// they are to be Queued. Error is the error with which the job failed,
+// or its last attempt did, and FailureCategory how. Attempts counts the
+// times the job has been processed, and Completed and Total are the
+// progress last reported by its processor. Reported tells whether it
+// reported any in the current attempt, or the one the job finished with,
+// so that a job whose progress is unknown can be told from one whose
+// processor reported no total. Trace, if not nil, is the trace context
+// the job was created in, Key is its concurrency key, if any, and
+// DedupKey its deduplication key, if any.
+// Heartbeat is the last time the worker processing the job reported
+// being alive, if there is a reaper, and Deadline, if not zero, the
+// time after which the job is no use. ClaimedBy is the identity of the
+// queue processing the job, while it is Processing. Schedule, if not
+// nil, holds the times at which the job is to be attempted, instead of
+// being retried as the retry policy allows.
+type JobRecord struct {
+	ID              string
+	Seq             uint64
+	Queue           string
+	Priority        int
+	State           State
+	RunAt           time.Time
+	Data            []byte
+	Error           string
+	Attempts        int
+	FailureCategory FailureCategory
+	Completed       uint64
+	Total           uint64
+	Reported        bool
+	Trace           map[string]string
+	Key             string
+	DedupKey        string
+	Heartbeat       time.Time
+	Deadline        time.Time
+	ClaimedBy       string
+	Schedule        []time.Time
+}
+
+// Store is the interface that wraps the methods used by a queue
+// to keep the records of its jobs, so that they can be kept in
+// a file or a database instead of in memory.
+//
+// The Save method stores r, replacing the record of the job
+// with the same ID, if any.
+//
+// The Load method returns the record of the job with the given ID,
+// or an error wrapping ErrJobNotFound if there is no such job.
+//
+// The UpdateState method sets the state of the job with the given ID,
+// leaving the rest of its record untouched. It returns an error wrapping
+// ErrJobNotFound if there is no such job.
+//
+// The List method returns the records of all the jobs, in any order.
+//
+// The Delete method removes the record of the job with the given ID.
+// It returns an error wrapping ErrJobNotFound if there is no such job.
+//
+// Implementations must be safe for concurrent use. The Data of the
+// records given to Save is not modified after the call, and that of
+// the records returned by Load and List is never modified, so they
+// can be shared.
+type Store interface {
+	Save(ctx context.Context, r JobRecord) error
+	Load(ctx context.Context, id string) (JobRecord, error)
+	UpdateState(ctx context.Context, id string, state State) error
+	List(ctx context.Context) ([]JobRecord, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// MemoryStore is a Store that keeps the records of the jobs in memory.
+// It is the one used by New. The zero value is ready to use.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]JobRecord
+}
+
+var _ Store = &MemoryStore{}
+
+func (s *MemoryStore) Save(ctx context.Context, r JobRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		s.records = make(map[string]JobRecord)
+	}
+	s.records[r.ID] = r
+	return nil
+}
+
+func (s *MemoryStore) Load(ctx context.Context, id string) (JobRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return JobRecord{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.records[id]
+	if !ok {
+		return JobRecord{}, fmt.Errorf("cannot load job %q: %w", id, ErrJobNotFound)
+	}
+	return r, nil
+}
+
+func (s *MemoryStore) UpdateState(ctx context.Context, id string, state State) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[id]
+	if !ok {
+		return fmt.Errorf("cannot update job %q: %w", id, ErrJobNotFound)
+	}
+	r.State = state
+	s.records[id] = r
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context) ([]JobRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := make([]JobRecord, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[id]; !ok {
+		return fmt.Errorf("cannot delete job %q: %w", id, ErrJobNotFound)
+	}
+	delete(s.records, id)
+	return nil
+}
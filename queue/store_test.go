@@ -0,0 +1,230 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestMemoryStoreRoundTrip(t *testing.T) {
+	s := &queue.MemoryStore{}
+	ctx := context.Background()
+	want := queue.JobRecord{ID: "a", Seq: 1, Queue: queue.DefaultQueue, State: queue.Queued, Data: []byte(`{"n":1}`)}
+	if err := s.Save(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Load(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got record %+v, want %+v", got, want)
+	}
+
+	if err := s.UpdateState(ctx, "a", queue.Processing); err != nil {
+		t.Fatal(err)
+	}
+	want.State = queue.Processing
+	if got, _ := s.Load(ctx, "a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got record %+v after updating its state, want %+v", got, want)
+	}
+
+	want.Attempts = 1
+	if err := s.Save(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	records, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, []queue.JobRecord{want}) {
+		t.Errorf("got records %+v, want only %+v", records, want)
+	}
+
+	if err := s.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load(ctx, "a"); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v loading a deleted job, want %v", err, queue.ErrJobNotFound)
+	}
+}
+
+func TestMemoryStoreMissingJob(t *testing.T) {
+	s := &queue.MemoryStore{}
+	ctx := context.Background()
+	if err := s.UpdateState(ctx, "missing", queue.Queued); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v updating a missing job, want %v", err, queue.ErrJobNotFound)
+	}
+	if err := s.Delete(ctx, "missing"); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v deleting a missing job, want %v", err, queue.ErrJobNotFound)
+	}
+}
+
+// recordingStore is a Store that records the writes made to it
+type recordingStore struct {
+	queue.MemoryStore
+	mu     sync.Mutex
+	writes []string
+}
+
+func (s *recordingStore) record(format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes = append(s.writes, fmt.Sprintf(format, args...))
+}
+
+func (s *recordingStore) Save(ctx context.Context, r queue.JobRecord) error {
+	s.record("save %s %s", r.ID, r.State)
+	return s.MemoryStore.Save(ctx, r)
+}
+
+func (s *recordingStore) UpdateState(ctx context.Context, id string, state queue.State) error {
+	s.record("update %s %s", id, state)
+	return s.MemoryStore.UpdateState(ctx, id, state)
+}
+
+func (s *recordingStore) Delete(ctx context.Context, id string) error {
+	s.record("delete %s", id)
+	return s.MemoryStore.Delete(ctx, id)
+}
+
+func (s *recordingStore) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.writes...)
+}
+
+func TestStoreWritesOfJobLifecycle(t *testing.T) {
+	s := &recordingStore{}
+	ctx := context.Background()
+	c, w, err := queue.NewWithStore(ctx, s, doubler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if err := c.DeleteJob(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"save a queued",
+		"save a processing",
+		"save a processing", // SetData
+		"save a finished",
+		"delete a",
+	}
+	if got := s.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got writes %q, want %q", got, want)
+	}
+}
+
+func TestNewWithStoreRestoresJobs(t *testing.T) {
+	s := &queue.MemoryStore{}
+	ctx := context.Background()
+	for i, state := range []queue.State{queue.Queued, queue.Processing, queue.Finished} {
+		r := queue.JobRecord{
+			ID:       string(state),
+			Seq:      uint64(i + 1),
+			Queue:    queue.DefaultQueue,
+			State:    state,
+			Data:     []byte(`{"n":1}`),
+			Attempts: 1,
+		}
+		if state == queue.Queued {
+			r.Attempts = 0
+		}
+		if err := s.Save(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := &recorder{}
+	c, w, err := queue.NewWithStore(ctx, s, rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The interrupted job is Queued again, and dispatched in creation order
+	if j, _ := c.GetJob(ctx, string(queue.Processing)); j.State() != queue.Queued {
+		t.Errorf("got interrupted job %s, want it %s", j.State(), queue.Queued)
+	}
+	processAll(t, w)
+	if got, want := rec.processed(), []string{"queued", "processing"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v processed, want %v", got, want)
+	}
+	if j := waitForJob(t, c, string(queue.Finished)); j.State() != queue.Finished {
+		t.Errorf("got finished job %s, want it %s", j.State(), queue.Finished)
+	}
+
+	// New jobs come after the restored ones
+	createJobs(t, c, map[string]int{"new": 1})
+	jobs, err := c.ListJobs(ctx, queue.ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(jobs), []string{"queued", "processing", "finished", "new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v, want %v", got, want)
+	}
+}
+
+// failingStore is a Store whose saves fail once it is told to fail,
+// and the next left saves have succeeded
+type failingStore struct {
+	queue.MemoryStore
+	mu      sync.Mutex
+	failing bool
+	left    int
+}
+
+var errStoreDown = errors.New("store down")
+
+func (s *failingStore) fail() {
+	s.failAfter(0)
+}
+
+// failAfter makes the saves fail after the next n ones
+func (s *failingStore) failAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing, s.left = true, n
+}
+
+func (s *failingStore) Save(ctx context.Context, r queue.JobRecord) error {
+	s.mu.Lock()
+	failing := s.failing && s.left == 0
+	if s.failing && s.left > 0 {
+		s.left--
+	}
+	s.mu.Unlock()
+	if failing {
+		return errStoreDown
+	}
+	return s.MemoryStore.Save(ctx, r)
+}
+
+func TestStoreFailureStopsWorker(t *testing.T) {
+	s := &failingStore{}
+	ctx := context.Background()
+	c, w, err := queue.NewWithStore(ctx, s, &recorder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	s.fail()
+	if err := c.CreateJob(ctx, "b", &intData{}); !errors.Is(err, errStoreDown) {
+		t.Errorf("got error %v creating a job, want %v", err, errStoreDown)
+	}
+	if err := w.Run(ctx, 2); !errors.Is(err, errStoreDown) {
+		t.Errorf("got Run error %v, want %v", err, errStoreDown)
+	}
+	jobs, err := c.ListJobs(ctx, queue.ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(jobs), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// CreateJobStream creates a Queued job in the default queue for each spec
+// received on jobs, until jobs is closed, like CreateJobs does, but in
+// chunks of at most chunkSize specs, so that a producer can feed in more
+// jobs than it could hold in memory at once. A chunk is created as soon
+// as it is full or no spec is ready to be received, and the next one is
+// only received once it is, so that a full queue, as limited by
+// WithMaxQueueDepth, holds the producer back a chunk at a time. Each
+// chunk is all or nothing, like a batch of CreateJobs, but the chunks
+// created before one fails are kept.
+// It returns the number of jobs created, or rather of specs whose chunk
+// was created, the deduplicated ones included. If a chunk fails, or ctx
+// gets done first, it stops there without receiving the specs left on
+// jobs, and the *BatchError it returns, if any, has the index of the spec
+// among all those received.
+func (c *client) CreateJobStream(ctx context.Context, jobs <-chan JobSpec, chunkSize int, opts ...JobOption) (created int, err error) {
+	if chunkSize < 1 {
+		return 0, fmt.Errorf("cannot create jobs in chunks of %d", chunkSize)
+	}
+	chunk := make([]JobSpec, 0, chunkSize)
+	for {
+		chunk = chunk[:0]
+		select {
+		case spec, ok := <-jobs:
+			if !ok {
+				return created, nil
+			}
+			chunk = append(chunk, spec)
+		case <-ctx.Done():
+			return created, ctx.Err()
+		}
+		closed := false
+	fill:
+		for len(chunk) < chunkSize {
+			select {
+			case spec, ok := <-jobs:
+				if !ok {
+					closed = true
+					break fill
+				}
+				chunk = append(chunk, spec)
+			default:
+				break fill
+			}
+		}
+		if err := c.CreateJobs(ctx, chunk, opts...); err != nil {
+			var batchErr *BatchError
+			if errors.As(err, &batchErr) {
+				return created, &BatchError{Index: created + batchErr.Index, Err: batchErr.Err}
+			}
+			return created, err
+		}
+		created += len(chunk)
+		// The specs are not needed anymore, so their
+		// data is not kept while waiting for the next
+		clear(chunk)
+		if closed {
+			return created, nil
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// streamSpecs sends on the returned channel the specs of n jobs with the
+// payloads 0 to n-1, counting in sent those received, and then closes it
+func streamSpecs(n int, sent *atomic.Int64) <-chan queue.JobSpec {
+	jobs := make(chan queue.JobSpec)
+	go func() {
+		defer close(jobs)
+		for i := 0; i < n; i++ {
+			jobs <- queue.JobSpec{ID: fmt.Sprint(i), Data: &intData{N: i}}
+			sent.Add(1)
+		}
+	}()
+	return jobs
+}
+
+func TestCreateJobStream(t *testing.T) {
+	c, w := queue.New(doubler)
+	var sent atomic.Int64
+	created, err := c.CreateJobStream(context.Background(), streamSpecs(100, &sent), 7)
+	if err != nil || created != 100 {
+		t.Fatalf("got %d jobs created and error %v, want 100 and nil", created, err)
+	}
+	processAll(t, w)
+	for i := 0; i < 100; i++ {
+		j := waitForJob(t, c, fmt.Sprint(i))
+		var d intData
+		if err := j.GetData(&d); err != nil {
+			t.Fatal(err)
+		}
+		if j.State() != queue.Finished || d.N != 2*i {
+			t.Errorf("got job %d %s with %d, want it %s with %d", i, j.State(), d.N, queue.Finished, 2*i)
+		}
+	}
+}
+
+func TestCreateJobStreamHoldsProducerBack(t *testing.T) {
+	c, w := queue.New(doubler, queue.WithMaxQueueDepth(4))
+	var sent atomic.Int64
+	result := make(chan error, 1)
+	go func() {
+		created, err := c.CreateJobStream(context.Background(), streamSpecs(50, &sent), 2)
+		if err == nil && created != 50 {
+			err = fmt.Errorf("%d jobs created, want 50", created)
+		}
+		result <- err
+	}()
+	checkBlocked(t, result, "CreateJobStream on a full queue")
+	// The queue holds 4 jobs, and a chunk of 2 more waits for room
+	if got := sent.Load(); got > 6 {
+		t.Errorf("got %d specs taken from the producer, want at most 6", got)
+	}
+	defer runWorker(t, w, 2)()
+	checkUnblocked(t, result, "CreateJobStream")
+	waitForJob(t, c, "49")
+}
+
+func TestCreateJobStreamFailingChunk(t *testing.T) {
+	c, _ := queue.New(doubler)
+	jobs := make(chan queue.JobSpec, 4)
+	for _, id := range []string{"a", "b", "c", "a"} {
+		jobs <- queue.JobSpec{ID: id, Data: &intData{}}
+	}
+	close(jobs)
+	created, err := c.CreateJobStream(context.Background(), jobs, 2)
+	var batchErr *queue.BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 3 || !errors.Is(err, queue.ErrDuplicateJob) {
+		t.Fatalf("got error %v, want a %v at the spec 3", err, queue.ErrDuplicateJob)
+	}
+	if created != 2 {
+		t.Errorf("got %d jobs created, want the 2 of the first chunk", created)
+	}
+	for id, want := range map[string]bool{"a": true, "b": true, "c": false} {
+		if j, _ := c.GetJob(context.Background(), id); (j != nil) != want {
+			t.Errorf("got job %q created: %t, want %t", id, j != nil, want)
+		}
+	}
+}
+
+func TestCreateJobStreamCanceled(t *testing.T) {
+	c, _ := queue.New(doubler)
+	ctx, cancel := context.WithTimeout(context.Background(), blockedFor)
+	defer cancel()
+	if _, err := c.CreateJobStream(ctx, make(chan queue.JobSpec), 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v waiting for specs, want %v", err, context.DeadlineExceeded)
+	}
+}
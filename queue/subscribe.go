@@ -0,0 +1,240 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy tells what a subscription does with the states of its job
+// when its subscriber falls behind by more states than the notification
+// buffer of the queue holds (see WithNotificationBuffer), so that the
+// subscriber picks which ones it can afford to lose.
+// Whatever the policy, the workers never wait for a subscriber.
+type DropPolicy int
+
+const (
+	// Block keeps every state for the subscriber, without bound, so that
+	// none is lost: only the delivery to the subscriber waits for it, in
+	// a goroutine of the subscription. It is the default.
+	Block DropPolicy = iota
+	// DropOldest drops the oldest state not handed to the subscriber
+	// yet to make room for the new one, so that it gets the latest ones.
+	DropOldest
+	// DropNewest drops the new state, unless it is terminal, in which
+	// case it takes the place of the newest one not handed to the
+	// subscriber yet, so that it always learns how the job ended.
+	DropNewest
+)
+
+// DefaultNotificationBuffer is the number of states a subscription
+// holds for its subscriber before its drop policy applies, by default.
+// See WithNotificationBuffer.
+const DefaultNotificationBuffer = 16
+
+// WithNotificationBuffer makes the subscriptions to jobs hold up to n
+// states for their subscribers, at least one, before their drop policy
+// applies, instead of DefaultNotificationBuffer. A larger buffer lets a
+// subscriber catch up after a longer burst of states without missing
+// any, but takes more memory per subscription and hides for longer that
+// the subscriber falls behind. It does not bound the subscriptions with
+// the Block policy, which hold every state.
+func WithNotificationBuffer(n int) Option {
+	return func(c *config) {
+		c.notificationBuffer = n
+	}
+}
+
+// SubscribeOption configures a call to Subscribe
+type SubscribeOption func(*subscribeOptions)
+
+// subscribeOptions holds the settings of a call to
+// Subscribe, as set by the options given to it
+type subscribeOptions struct {
+	policy  DropPolicy
+	dropped *atomic.Uint64
+}
+
+// WithDropPolicy sets what the subscription does with the states of its
+// job when its subscriber falls behind, which is Block by default
+func WithDropPolicy(policy DropPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.policy = policy
+	}
+}
+
+// WithDropCounter makes the subscription add to dropped each state
+// its drop policy drops, so that the subscriber can tell it missed some
+func WithDropCounter(dropped *atomic.Uint64) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.dropped = dropped
+	}
+}
+
+// subscription is a subscription to the state of a job. states holds
+// the states not handed to the subscriber yet, guarded by mu, and wake
+// gets a value when states are added, so that they are never waited for
+// by the subscriber while the lock of the queue is held. Unless policy
+// is Block, states holds at most buffer states, and dropped, if not
+// nil, counts those dropped.
+type subscription struct {
+	mu      sync.Mutex
+	states  []State
+	wake    chan struct{}
+	policy  DropPolicy
+	buffer  int
+	dropped *atomic.Uint64
+}
+
+func newSubscription(o subscribeOptions, buffer int) *subscription {
+	return &subscription{
+		wake:    make(chan struct{}, 1),
+		policy:  o.policy,
+		buffer:  max(buffer, 1),
+		dropped: o.dropped,
+	}
+}
+
+// add adds a state to be handed to the subscriber, dropping one
+// as told by the policy of the subscription if it is full
+func (s *subscription) add(state State) {
+	s.mu.Lock()
+	switch {
+	case s.policy == Block || len(s.states) < s.buffer:
+		s.states = append(s.states, state)
+	case s.policy == DropOldest:
+		s.states = append(s.states[1:], state)
+		s.drop()
+	case isTerminal(state):
+		s.states[len(s.states)-1] = state
+		s.drop()
+	default:
+		s.drop()
+	}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drop counts a dropped state. mu must be held by the caller.
+func (s *subscription) drop() {
+	if s.dropped != nil {
+		s.dropped.Add(1)
+	}
+}
+
+// next takes the next state to be handed to the
+// subscriber, and tells whether there was one
+func (s *subscription) next() (State, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.states) == 0 {
+		return "", false
+	}
+	state := s.states[0]
+	s.states = s.states[1:]
+	return state, true
+}
+
+// Subscribe returns a channel that gets the current state of the job with
+// the given ID or alias and then every state it moves to, until it reaches
+// a terminal state or ctx gets done. Then the channel is closed. The states
+// are handed over as told by the drop policy of the subscription, given
+// with WithDropPolicy.
+func (c *client) Subscribe(ctx context.Context, id string, opts ...SubscribeOption) (<-chan State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var o subscribeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	id = c.q.resolve(id)
+	l, ok := c.q.live[id]
+	if !ok {
+		return nil, fmt.Errorf("cannot subscribe to job %q: %w", id, ErrJobNotFound)
+	}
+	r, err := c.q.store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s := newSubscription(o, c.q.notificationBuffer)
+	s.add(r.State)
+	if !isTerminal(r.State) {
+		l.subs = append(l.subs, s)
+	}
+	states := make(chan State)
+	go c.q.forward(ctx, l, s, states)
+	return states, nil
+}
+
+// forward sends the states of the given subscription to the given
+// channel, one at a time, until it sends a terminal state or ctx gets
+// done. Then it closes the channel, and drops the subscription if it is
+// still there.
+func (q *memoryQueue) forward(ctx context.Context, l *liveJob, s *subscription, states chan<- State) {
+	defer close(states)
+	for {
+		state, ok := s.next()
+		if !ok {
+			select {
+			case <-s.wake:
+				continue
+			case <-ctx.Done():
+				q.unsubscribe(l, s)
+				return
+			}
+		}
+		select {
+		case states <- state:
+		case <-ctx.Done():
+			q.unsubscribe(l, s)
+			return
+		}
+		if isTerminal(state) {
+			return
+		}
+	}
+}
+
+// unsubscribe drops the given subscription of the given job
+func (q *memoryQueue) unsubscribe(l *liveJob, s *subscription) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, sub := range l.subs {
+		if sub == s {
+			l.subs = append(l.subs[:i], l.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// transitioned hands the state the given job just moved to to its
+// subscriptions. When the state is terminal, the subscriptions are
+// dropped, and so is the job from the deduplication index, as no job
+// can hold its key but the one that was not terminal.
+// mu must be held by the caller.
+func (q *memoryQueue) transitioned(l *liveJob, state State) {
+	q.count(l, state)
+	for _, s := range l.subs {
+		s.add(state)
+	}
+	if isTerminal(state) {
+		l.notifyWaiters(state)
+		l.subs = nil
+		if l.dedupKey != "" {
+			delete(q.dedup, l.dedupKey)
+		}
+		q.expireLater(l)
+		for _, jobs := range q.reservations {
+			delete(jobs, l.id)
+		}
+		q.watchDeadLetters()
+		q.summarize(state)
+	}
+}
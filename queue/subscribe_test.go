@@ -0,0 +1,222 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// collect receives states from ch until it is closed, failing
+// the test if that takes longer than testTimeout.
+func collect(t *testing.T, ch <-chan queue.State) []queue.State {
+	t.Helper()
+	var states []queue.State
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case state, ok := <-ch:
+			if !ok {
+				return states
+			}
+			states = append(states, state)
+		case <-timeout:
+			t.Fatalf("got states %v, and the subscription was not closed in time", states)
+			return nil
+		}
+	}
+}
+
+// subscribe subscribes to the job with the given ID
+func subscribe(t *testing.T, c queue.Client, id string) <-chan queue.State {
+	t.Helper()
+	ch, err := c.Subscribe(context.Background(), id)
+	if err != nil {
+		t.Fatalf("subscribing to job %q: %v", id, err)
+	}
+	return ch
+}
+
+func TestSubscribeToJob(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	ch := subscribe(t, c, "a")
+	defer runWorker(t, w, 2)()
+	want := []queue.State{queue.Queued, queue.Processing, queue.Finished}
+	if got := collect(t, ch); !reflect.DeepEqual(got, want) {
+		t.Errorf("got states %v, want %v", got, want)
+	}
+}
+
+func TestSubscribeWithoutReceiving(t *testing.T) {
+	p, _ := flaky(1)
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}))
+	createJobs(t, c, map[string]int{"a": 1})
+	ch := subscribe(t, c, "a")
+	// The subscriber does not hold the worker back
+	processAll(t, w)
+	processAll(t, w)
+	want := []queue.State{queue.Queued, queue.Processing, queue.Queued, queue.Processing, queue.Finished}
+	if got := collect(t, ch); !reflect.DeepEqual(got, want) {
+		t.Errorf("got states %v, want %v", got, want)
+	}
+}
+
+func TestSubscribeToScheduledJob(t *testing.T) {
+	c, w := queue.New(doubler)
+	ctx := context.Background()
+	if err := c.CreateJobAt(ctx, "a", &intData{N: 1}, time.Now().Add(20*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	ch := subscribe(t, c, "a")
+	defer runWorker(t, w, 1)()
+	want := []queue.State{queue.Scheduled, queue.Queued, queue.Processing, queue.Finished}
+	if got := collect(t, ch); !reflect.DeepEqual(got, want) {
+		t.Errorf("got states %v, want %v", got, want)
+	}
+}
+
+func TestSubscribeToCancelledJob(t *testing.T) {
+	c, _ := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	ch := subscribe(t, c, "a")
+	if err := c.CancelJob(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	want := []queue.State{queue.Queued, queue.Cancelled}
+	if got := collect(t, ch); !reflect.DeepEqual(got, want) {
+		t.Errorf("got states %v, want %v", got, want)
+	}
+}
+
+func TestSubscribeToTerminalJob(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	want := []queue.State{queue.Finished}
+	if got := collect(t, subscribe(t, c, "a")); !reflect.DeepEqual(got, want) {
+		t.Errorf("got states %v, want %v", got, want)
+	}
+}
+
+func TestSubscribeCanceled(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := c.Subscribe(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := <-ch; state != queue.Queued {
+		t.Errorf("got state %v, want %v", state, queue.Queued)
+	}
+	cancel()
+	collect(t, ch)
+	// The job goes on without the subscriber
+	processAll(t, w)
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Errorf("got job %s, want it %s", j.State(), queue.Finished)
+	}
+}
+
+func TestSubscribeToMissingJob(t *testing.T) {
+	c, _ := queue.New(doubler)
+	if _, err := c.Subscribe(context.Background(), "missing"); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v, want %v", err, queue.ErrJobNotFound)
+	}
+}
+
+// failures is the number of times the job of saturate
+// fails before it finishes
+const failures = 12
+
+// saturate subscribes to a job of a queue with the given options, with
+// the given subscription options, and has it fail failures times and
+// then finish while the subscriber does not receive. It returns the
+// states the subscriber gets then, and the number of states dropped.
+func saturate(t *testing.T, queueOpts []queue.Option, opts ...queue.SubscribeOption) (got []queue.State, dropped uint64) {
+	t.Helper()
+	p, _ := flaky(failures)
+	queueOpts = append(queueOpts, queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: failures + 1}))
+	c, w := queue.New(p, queueOpts...)
+	createJobs(t, c, map[string]int{"a": 1})
+	var counter atomic.Uint64
+	ch, err := c.Subscribe(context.Background(), "a", append(opts, queue.WithDropCounter(&counter))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The subscriber does not hold the worker back
+	for i := 0; i <= failures; i++ {
+		if n := processAll(t, w); n == 0 {
+			t.Fatalf("got no job processed after %d attempts", i)
+		}
+	}
+	return collect(t, ch), counter.Load()
+}
+
+// saturatedStates returns the states the job of saturate goes through
+func saturatedStates() []queue.State {
+	states := []queue.State{queue.Queued}
+	for i := 0; i < failures; i++ {
+		states = append(states, queue.Processing, queue.Queued)
+	}
+	return append(states, queue.Processing, queue.Finished)
+}
+
+func TestSaturatedSubscriberBlocks(t *testing.T) {
+	for name, opts := range map[string][]queue.SubscribeOption{
+		"default":  nil,
+		"explicit": {queue.WithDropPolicy(queue.Block)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, dropped := saturate(t, nil, opts...)
+			if want := saturatedStates(); !reflect.DeepEqual(got, want) || dropped != 0 {
+				t.Errorf("got states %v and %d dropped, want %v and none", got, dropped, want)
+			}
+		})
+	}
+}
+
+func TestSaturatedSubscriberDropsOldest(t *testing.T) {
+	got, dropped := saturate(t, nil, queue.WithDropPolicy(queue.DropOldest))
+	all := saturatedStates()
+	if dropped == 0 || len(got)+int(dropped) != len(all) {
+		t.Fatalf("got %d states and %d dropped, want some of the %d dropped", len(got), dropped, len(all))
+	}
+	// Besides the state the subscription may have been handing over
+	// when it got full, the subscriber gets the latest ones
+	if latest := all[len(all)-queue.DefaultNotificationBuffer:]; !reflect.DeepEqual(got[len(got)-len(latest):], latest) {
+		t.Errorf("got states %v, want them to end with %v", got, latest)
+	}
+}
+
+func TestSaturatedSubscriberDropsNewest(t *testing.T) {
+	got, dropped := saturate(t, nil, queue.WithDropPolicy(queue.DropNewest))
+	all := saturatedStates()
+	if dropped == 0 || len(got)+int(dropped) != len(all) {
+		t.Fatalf("got %d states and %d dropped, want some of the %d dropped", len(got), dropped, len(all))
+	}
+	// The subscriber gets the earliest states, and then how the job ended
+	last := len(got) - 1
+	if !reflect.DeepEqual(got[:last], all[:last]) || got[last] != queue.Finished {
+		t.Errorf("got states %v, want the first %d of %v and then %s", got, last, all, queue.Finished)
+	}
+}
+
+func TestNotificationBuffer(t *testing.T) {
+	got, dropped := saturate(t, []queue.Option{queue.WithNotificationBuffer(4)}, queue.WithDropPolicy(queue.DropNewest))
+	// The subscription holds 4 states, and may have been handing
+	// over one more when it got full
+	all := saturatedStates()
+	if len(got) < 4 || len(got) > 5 || len(got)+int(dropped) != len(all) {
+		t.Fatalf("got %d states and %d dropped, want 4 or 5 of the %d", len(got), dropped, len(all))
+	}
+	last := len(got) - 1
+	if !reflect.DeepEqual(got[:last], all[:last]) || got[last] != queue.Finished {
+		t.Errorf("got states %v, want the first %d of %v and then %s", got, last, all, queue.Finished)
+	}
+}
@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Summary counts the jobs that reached each terminal state between Since
+// and Until, for the subscribers that want to follow many jobs without
+// getting an update for each of them.
+type Summary struct {
+	Since     time.Time
+	Until     time.Time
+	Finished  int
+	Failed    int
+	Cancelled int
+}
+
+// merge adds the jobs counted by other to s, stretching its
+// period to cover both
+func (s *Summary) merge(other Summary) {
+	if s.Since.IsZero() || other.Since.Before(s.Since) {
+		s.Since = other.Since
+	}
+	if other.Until.After(s.Until) {
+		s.Until = other.Until
+	}
+	s.Finished += other.Finished
+	s.Failed += other.Failed
+	s.Cancelled += other.Cancelled
+}
+
+// count counts a job that reached the given terminal state
+func (s *Summary) count(state State) {
+	switch state {
+	case Finished:
+		s.Finished++
+	case Failed:
+		s.Failed++
+	case Cancelled:
+		s.Cancelled++
+	}
+}
+
+// WithCoalescedNotifications makes the queue count the jobs reaching a
+// terminal state over periods of window, starting with the first job
+// that does, and hand a Summary of each period to the subscribers of
+// SubscribeSummaries, rather than one update per job like Subscribe.
+func WithCoalescedNotifications(window time.Duration) Option {
+	return func(c *config) {
+		c.summaryWindow = window
+	}
+}
+
+// summarySubscription is a subscription to the summaries of the queue.
+// pending is the summary not handed to the subscriber yet, if any is,
+// guarded by mu, into which later summaries are merged until it is,
+// and wake gets a value when it changes, like for a subscription.
+type summarySubscription struct {
+	mu      sync.Mutex
+	pending *Summary
+	wake    chan struct{}
+}
+
+// add adds a summary to be handed to the subscriber
+func (s *summarySubscription) add(summary Summary) {
+	s.mu.Lock()
+	if s.pending == nil {
+		s.pending = &summary
+	} else {
+		s.pending.merge(summary)
+	}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take takes the summary to be handed to the subscriber, if any
+func (s *summarySubscription) take() (Summary, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		return Summary{}, false
+	}
+	summary := *s.pending
+	s.pending = nil
+	return summary, true
+}
+
+// SubscribeSummaries returns a channel that gets a Summary of the jobs
+// that reached a terminal state for each period they did, until ctx gets
+// done. Then the channel is closed. A subscriber that falls behind gets
+// the summaries it did not receive merged into one, so no job goes
+// uncounted. It fails if the queue has no WithCoalescedNotifications.
+func (c *client) SubscribeSummaries(ctx context.Context) (<-chan Summary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.q.summaryWindow <= 0 {
+		return nil, errors.New("cannot subscribe to summaries without WithCoalescedNotifications")
+	}
+	s := &summarySubscription{wake: make(chan struct{}, 1)}
+	c.q.mu.Lock()
+	c.q.summarySubs = append(c.q.summarySubs, s)
+	c.q.mu.Unlock()
+	summaries := make(chan Summary)
+	go c.q.forwardSummaries(ctx, s, summaries)
+	return summaries, nil
+}
+
+// forwardSummaries sends the summaries of the given subscription to the
+// given channel until ctx gets done. Then it closes the channel and drops
+// the subscription.
+func (q *memoryQueue) forwardSummaries(ctx context.Context, s *summarySubscription, summaries chan<- Summary) {
+	defer close(summaries)
+	defer q.unsubscribeSummaries(s)
+	for {
+		select {
+		case <-s.wake:
+		case <-ctx.Done():
+			return
+		}
+		summary, ok := s.take()
+		if !ok {
+			continue
+		}
+		select {
+		case summaries <- summary:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// unsubscribeSummaries drops the given subscription to the summaries
+func (q *memoryQueue) unsubscribeSummaries(s *summarySubscription) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, sub := range q.summarySubs {
+		if sub == s {
+			q.summarySubs = append(q.summarySubs[:i], q.summarySubs[i+1:]...)
+			return
+		}
+	}
+}
+
+// summarize counts a job that reached the given terminal state in the
+// summary of the current period, starting one if there is none, if the
+// queue coalesces notifications. mu must be held by the caller.
+func (q *memoryQueue) summarize(state State) {
+	if q.summaryWindow <= 0 {
+		return
+	}
+	if q.summary.Since.IsZero() {
+		q.summary.Since = q.clock.now()
+		if q.summaryTimer == nil {
+			q.summaryTimer = q.clock.afterFunc(q.summaryWindow, q.publishSummary)
+		} else {
+			q.summaryTimer.Reset(q.summaryWindow)
+		}
+	}
+	q.summary.count(state)
+}
+
+// publishSummary ends the current period, handing its summary
+// to the subscribers
+func (q *memoryQueue) publishSummary() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	summary := q.summary
+	summary.Until = q.clock.now()
+	q.summary = Summary{}
+	for _, s := range q.summarySubs {
+		s.add(summary)
+	}
+}
@@ -0,0 +1,101 @@
+package queue_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// subscribeSummaries subscribes to the summaries of c
+// until the test is done
+func subscribeSummaries(t *testing.T, c queue.Client) <-chan queue.Summary {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	summaries, err := c.SubscribeSummaries(ctx)
+	if err != nil {
+		t.Fatalf("subscribing to summaries: %v", err)
+	}
+	return summaries
+}
+
+// sumSummaries receives summaries until they count n jobs,
+// returning their sum and how many there were
+func sumSummaries(t *testing.T, summaries <-chan queue.Summary, n int) (queue.Summary, int) {
+	t.Helper()
+	var sum queue.Summary
+	var count int
+	for sum.Finished+sum.Failed+sum.Cancelled < n {
+		select {
+		case s := <-summaries:
+			if s.Until.Before(s.Since) {
+				t.Errorf("got summary until %v, before its start %v", s.Until, s.Since)
+			}
+			sum.Finished += s.Finished
+			sum.Failed += s.Failed
+			sum.Cancelled += s.Cancelled
+			count++
+		case <-time.After(testTimeout):
+			t.Fatalf("got summaries of %d jobs, want %d", sum.Finished+sum.Failed+sum.Cancelled, n)
+		}
+	}
+	return sum, count
+}
+
+func TestSummariesCoalesceJobs(t *testing.T) {
+	const window = 100 * time.Millisecond
+	clock := queue.NewFakeClock()
+	c, w := queue.New(doubler, queue.WithCoalescedNotifications(window), queue.WithFakeClock(clock))
+	summaries := subscribeSummaries(t, c)
+	jobs := map[string]int{"bad": -1}
+	for i := 0; i < 20; i++ {
+		jobs[fmt.Sprint(i)] = i
+	}
+	createJobs(t, c, jobs)
+	start := clock.Now()
+	if err := c.CancelJob(context.Background(), "0"); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	clock.Advance(window)
+
+	want := queue.Summary{Since: start, Until: start.Add(window), Finished: 19, Failed: 1, Cancelled: 1}
+	select {
+	case s := <-summaries:
+		if s != want {
+			t.Errorf("got summary %+v, want %+v", s, want)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("got no summary at the end of the window")
+	}
+}
+
+func TestSummariesMergeForSlowSubscriber(t *testing.T) {
+	const window = time.Millisecond
+	clock := queue.NewFakeClock()
+	c, w := queue.New(doubler, queue.WithCoalescedNotifications(window), queue.WithFakeClock(clock))
+	summaries := subscribeSummaries(t, c)
+	for i := 0; i < 5; i++ {
+		createJobs(t, c, map[string]int{fmt.Sprint(i): i})
+		processOne(t, w)
+		clock.Advance(window)
+	}
+
+	sum, count := sumSummaries(t, summaries, 5)
+	if sum.Finished != 5 {
+		t.Errorf("got summaries of %d finished jobs, want 5", sum.Finished)
+	}
+	if count > 2 {
+		t.Errorf("got %d summaries while not receiving them, want them merged", count)
+	}
+}
+
+func TestSubscribeSummariesWithoutCoalescing(t *testing.T) {
+	c, _ := queue.New(doubler)
+	if _, err := c.SubscribeSummaries(context.Background()); err == nil {
+		t.Error("subscribed to summaries without WithCoalescedNotifications")
+	}
+}
@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the name of the tracer the queue gets from its TracerProvider
+const tracerName = "github.com/ingrammicro/backend-test/queue"
+
+// Names of the attributes of the spans of a queue
+const (
+	AttributeJobID      = "job.id"
+	AttributeJobQueue   = "job.queue"
+	AttributeJobAttempt = "job.attempt"
+	AttributeJobState   = "job.state"
+)
+
+// tracePropagator encodes the trace context of jobs in their records
+var tracePropagator = propagation.TraceContext{}
+
+// WithTracerProvider makes the queue trace jobs with a tracer
+// from tp. By default, jobs are not traced.
+//
+// Creating a job starts a span, which is a child of the span in the
+// context given to the client, if any. Its span context is stored with
+// the job, so that every attempt to process the job gets a child span
+// of it, even if the queue was restarted in between. The time a job
+// spent queued is then the gap between those spans. The spans have
+// the job's ID and queue, and processing ones also the attempt and
+// the state the job was left in, as attributes.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// noopTracer is the tracer used by default
+var noopTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// startCreateSpan starts the span of the creation of the given job,
+// and stores its span context in the Trace of the job.
+func startCreateSpan(ctx context.Context, tracer trace.Tracer, r *JobRecord) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, "queue.CreateJob",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String(AttributeJobID, r.ID),
+			attribute.String(AttributeJobQueue, r.Queue),
+		),
+	)
+	if span.SpanContext().IsValid() {
+		r.Trace = make(map[string]string)
+		tracePropagator.Inject(ctx, propagation.MapCarrier(r.Trace))
+	}
+	return ctx, span
+}
+
+// startProcessSpan starts the span of an attempt to process a job,
+// as a child of the span of its creation.
+func startProcessSpan(ctx context.Context, tracer trace.Tracer, pj *processingJob) (context.Context, trace.Span) {
+	if pj.trace != nil {
+		ctx = tracePropagator.Extract(ctx, propagation.MapCarrier(pj.trace))
+	}
+	return tracer.Start(ctx, "queue.Process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String(AttributeJobID, pj.id),
+			attribute.String(AttributeJobQueue, pj.queue),
+			attribute.Int(AttributeJobAttempt, pj.attempt),
+		),
+	)
+}
+
+// endProcessSpan ends the span of an attempt to process a job,
+// recording the error of the processor and the state of the job.
+func endProcessSpan(span trace.Span, err error, state State) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attribute.String(AttributeJobState, string(state)))
+	span.End()
+}
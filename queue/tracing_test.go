@@ -0,0 +1,135 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// spanRecorder returns a tracer provider recording its spans in the returned recorder
+func spanRecorder() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	rec := tracetest.NewSpanRecorder()
+	return sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)), rec
+}
+
+// spansNamed returns the ended spans with the given name
+func spansNamed(rec *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, s := range rec.Ended() {
+		if s.Name() == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+// checkAttributes checks that span has the given attributes, among others
+func checkAttributes(t *testing.T, span sdktrace.ReadOnlySpan, want ...attribute.KeyValue) {
+	t.Helper()
+	got := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		got[kv.Key] = kv.Value
+	}
+	for _, kv := range want {
+		if v, ok := got[kv.Key]; !ok || v != kv.Value {
+			t.Errorf("got %s attribute %s=%q, want %q", span.Name(), kv.Key, v.Emit(), kv.Value.Emit())
+		}
+	}
+}
+
+func TestTraceJob(t *testing.T) {
+	tp, rec := spanRecorder()
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "request")
+	p, _ := flaky(1)
+	c, w := queue.New(p, queue.WithTracerProvider(tp), queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}))
+	if err := c.CreateJob(ctx, "a", &intData{N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+	processAll(t, w)
+	processAll(t, w)
+
+	created := spansNamed(rec, "queue.CreateJob")
+	if len(created) != 1 {
+		t.Fatalf("got %d creation spans, want 1", len(created))
+	}
+	if got, want := created[0].Parent().SpanID(), parent.SpanContext().SpanID(); got != want {
+		t.Errorf("got creation span with parent %s, want %s", got, want)
+	}
+	checkAttributes(t, created[0],
+		attribute.String(queue.AttributeJobID, "a"),
+		attribute.String(queue.AttributeJobQueue, queue.DefaultQueue),
+	)
+
+	processed := spansNamed(rec, "queue.Process")
+	if len(processed) != 2 {
+		t.Fatalf("got %d processing spans, want 2", len(processed))
+	}
+	for i, span := range processed {
+		if got, want := span.Parent().SpanID(), created[0].SpanContext().SpanID(); got != want {
+			t.Errorf("got processing span %d with parent %s, want the creation span %s", i, got, want)
+		}
+		if got, want := span.SpanContext().TraceID(), parent.SpanContext().TraceID(); got != want {
+			t.Errorf("got processing span %d in trace %s, want %s", i, got, want)
+		}
+	}
+	checkAttributes(t, processed[0],
+		attribute.String(queue.AttributeJobID, "a"),
+		attribute.Int(queue.AttributeJobAttempt, 1),
+		attribute.String(queue.AttributeJobState, string(queue.Queued)),
+	)
+	if got := processed[0].Status().Code; got != codes.Error {
+		t.Errorf("got status %v for the failed attempt, want %v", got, codes.Error)
+	}
+	checkAttributes(t, processed[1],
+		attribute.Int(queue.AttributeJobAttempt, 2),
+		attribute.String(queue.AttributeJobState, string(queue.Finished)),
+	)
+}
+
+func TestTraceContextSurvivesRestart(t *testing.T) {
+	tp, rec := spanRecorder()
+	s := &queue.MemoryStore{}
+	ctx := context.Background()
+	c, _, err := queue.NewWithStore(ctx, s, doubler, queue.WithTracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+
+	_, w, err := queue.NewWithStore(ctx, s, doubler, queue.WithTracerProvider(tp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	created, processed := spansNamed(rec, "queue.CreateJob"), spansNamed(rec, "queue.Process")
+	if len(created) != 1 || len(processed) != 1 {
+		t.Fatalf("got %d creation and %d processing spans, want 1 of each", len(created), len(processed))
+	}
+	if got, want := processed[0].Parent().SpanID(), created[0].SpanContext().SpanID(); got != want {
+		t.Errorf("got processing span with parent %s, want the creation span %s", got, want)
+	}
+}
+
+func TestNoTracerProvider(t *testing.T) {
+	s := &queue.MemoryStore{}
+	c, w, err := queue.NewWithStore(context.Background(), s, doubler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	r, err := s.Load(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Trace != nil {
+		t.Errorf("got trace context %v stored without a tracer provider, want none", r.Trace)
+	}
+}
@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// WithResultTTL makes the queue remove Finished, Failed and Cancelled jobs
+// from its store, with their aliases, once they have been terminal for
+// ttl, as if they had been deleted: GetJob then returns nil for them.
+// Jobs that are not terminal never expire, however long they take.
+// Terminal jobs restored from the store expire ttl after the queue starts.
+//
+// As all jobs expire after the same ttl, they expire in the order they
+// ended, so a single timer sweeps them, however many there are.
+func WithResultTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.resultTTL = ttl
+	}
+}
+
+// expirer removes terminal jobs once they have been terminal for ttl,
+// if it is not 0. jobs holds them in the order they ended, and timer
+// goes off when the first of them expires, as told by sweeping, to
+// remove those that did. It is guarded by the mu of the queue.
+type expirer struct {
+	ttl      time.Duration
+	jobs     []*liveJob
+	timer    timer
+	sweeping bool
+}
+
+// expireLater makes the given job, which just became terminal, expire
+// once it has been for the result TTL, if there is one, or for the
+// dead-letter retention if it is Failed and the queue has one.
+// mu must be held by the caller.
+func (q *memoryQueue) expireLater(l *liveJob) {
+	e := &q.results
+	if l.state == Failed && q.deadLetters.ttl > 0 {
+		e = &q.deadLetters
+	}
+	if e.ttl <= 0 {
+		return
+	}
+	l.ended = q.clock.now()
+	e.jobs = append(e.jobs, l)
+	q.armSweeper(e)
+}
+
+// armSweeper sets off the timer of e for the first of its jobs,
+// if there is one and the timer is not set off already.
+// mu must be held by the caller.
+func (q *memoryQueue) armSweeper(e *expirer) {
+	if len(e.jobs) == 0 || e.sweeping {
+		return
+	}
+	e.sweeping = true
+	d := e.jobs[0].ended.Add(e.ttl).Sub(q.clock.now())
+	if e.timer == nil {
+		e.timer = q.clock.afterFunc(d, func() { q.sweep(e) })
+		return
+	}
+	e.timer.Reset(d)
+}
+
+// sweep removes the jobs of e that have expired, skipping those deleted
+// in the meantime, and sets off its timer again for the next one. If
+// the store fails, the jobs are removed at the next sweep, a TTL later.
+func (q *memoryQueue) sweep(e *expirer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e.sweeping = false
+	now := q.clock.now()
+	for len(e.jobs) > 0 {
+		l := e.jobs[0]
+		if now.Before(l.ended.Add(e.ttl)) {
+			break
+		}
+		if q.live[l.id] == l {
+			if err := q.store.Delete(context.Background(), l.id); err != nil {
+				e.sweeping = true
+				e.timer.Reset(e.ttl)
+				return
+			}
+			q.forget(l.id)
+		}
+		e.jobs[0] = nil
+		e.jobs = e.jobs[1:]
+	}
+	q.armSweeper(e)
+}
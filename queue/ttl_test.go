@@ -0,0 +1,98 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// resultTTL is given to WithResultTTL in the tests
+const resultTTL = 50 * time.Millisecond
+
+// checkExpired checks whether the job with the given ID expired,
+// GetJob not finding it anymore
+func checkExpired(t *testing.T, c queue.Client, id string, want bool) {
+	t.Helper()
+	j, err := c.GetJob(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := j == nil; got != want {
+		t.Errorf("got job %q expired: %t, want %t", id, got, want)
+	}
+}
+
+func TestResultTTLExpiresFinishedJob(t *testing.T) {
+	s := &queue.MemoryStore{}
+	clock := queue.NewFakeClock()
+	c, w, err := queue.NewWithStore(context.Background(), s, doubler, queue.WithResultTTL(resultTTL), queue.WithFakeClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "alias"} {
+		if err := c.CreateJob(context.Background(), id, &intData{N: 1}, queue.WithDedupKey("k")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createJobs(t, c, map[string]int{"bad": -1})
+	processAll(t, w)
+	if j, err := c.GetJob(context.Background(), "a"); err != nil || j == nil || j.State() != queue.Finished {
+		t.Fatalf("got job %v and error %v right after it finished, want it %s", j, err, queue.Finished)
+	}
+
+	clock.Advance(resultTTL - time.Nanosecond)
+	checkExpired(t, c, "a", false)
+	clock.Advance(time.Nanosecond)
+	checkExpired(t, c, "a", true)
+	checkExpired(t, c, "bad", true)
+	if j, err := c.GetJob(context.Background(), "alias"); err != nil || j != nil {
+		t.Errorf("got job %v and error %v by alias, want neither", j, err)
+	}
+	if _, err := s.Load(context.Background(), "a"); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v loading the expired job from the store, want %v", err, queue.ErrJobNotFound)
+	}
+	createJobs(t, c, map[string]int{"a": 2})
+}
+
+func TestResultTTLSparesProcessingJob(t *testing.T) {
+	p, release := held()
+	clock := queue.NewFakeClock()
+	c, w := queue.New(p, queue.WithResultTTL(resultTTL), queue.WithFakeClock(clock))
+	createJobs(t, c, map[string]int{"slow": 1, "bad": -1})
+	defer runWorker(t, w, 2)()
+	waitForJob(t, c, "bad")
+	checkExpired(t, c, "bad", false)
+	clock.Advance(resultTTL)
+	checkExpired(t, c, "bad", true)
+
+	clock.Advance(5 * resultTTL)
+	j, err := c.GetJob(context.Background(), "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j == nil || j.State() != queue.Processing {
+		t.Fatalf("got job %v, want it still %s", j, queue.Processing)
+	}
+	close(release)
+	waitForJob(t, c, "slow")
+	checkExpired(t, c, "slow", false)
+	clock.Advance(resultTTL)
+	checkExpired(t, c, "slow", true)
+}
+
+func TestResultTTLSkipsDeletedJob(t *testing.T) {
+	clock := queue.NewFakeClock()
+	c, w := queue.New(doubler, queue.WithResultTTL(resultTTL), queue.WithFakeClock(clock))
+	createJobs(t, c, map[string]int{"a": 1})
+	processAll(t, w)
+	if err := c.DeleteJob(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"a": 2})
+
+	clock.Advance(2 * resultTTL)
+	checkExpired(t, c, "a", false)
+}
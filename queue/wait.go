@@ -0,0 +1,142 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+)
+
+// WaitOption configures a call to WaitForJobs
+type WaitOption func(*waitOptions)
+
+// waitOptions holds the settings of a call to
+// WaitForJobs, as set by the options given to it
+type waitOptions struct {
+	failFast bool
+}
+
+// WithFailFast makes WaitForJobs return as soon as one of the jobs is
+// Failed, with an error wrapping ErrJobFailed, instead of waiting for
+// the other ones.
+func WithFailFast() WaitOption {
+	return func(o *waitOptions) {
+		o.failFast = true
+	}
+}
+
+// jobsWaiter is a call to WaitForJobs waiting for jobs to be terminal.
+// ended gets each of them once it is, without ever blocking, as it
+// has room for all of them.
+type jobsWaiter struct {
+	ended chan endedJob
+}
+
+// endedJob is a job that reached the given terminal state
+type endedJob struct {
+	l     *liveJob
+	state State
+}
+
+// WaitForJobs waits until the jobs with the given IDs or aliases are all
+// terminal, and then returns snapshots of them by the IDs given. Rather
+// than waiting for each job in turn, it registers with all of them at once
+// and gets notified as they end, from a single goroutine, the caller's.
+// If ctx gets done first, or if a job fails WithFailFast, it returns the
+// jobs already terminal and ctx's error, or an error wrapping ErrJobFailed.
+func (c *client) WaitForJobs(ctx context.Context, ids []string, opts ...WaitOption) (map[string]Job, error) {
+	var o waitOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	w := &jobsWaiter{ended: make(chan endedJob, len(ids))}
+	// given holds the IDs given for each job, jobIDs their actual IDs,
+	// waiting the jobs that are not terminal yet, and failed the job
+	// that failed, if the wait is to fail fast and one did
+	given := make(map[*liveJob][]string, len(ids))
+	jobIDs := make(map[*liveJob]string, len(ids))
+	waiting := make(map[*liveJob]bool)
+	var failed *liveJob
+	c.q.mu.Lock()
+	for _, id := range ids {
+		jobID := c.q.resolve(id)
+		l, ok := c.q.live[jobID]
+		if !ok {
+			c.q.mu.Unlock()
+			return nil, fmt.Errorf("cannot wait for job %q: %w", id, ErrJobNotFound)
+		}
+		given[l] = append(given[l], id)
+		jobIDs[l] = jobID
+	}
+	for l := range given {
+		switch {
+		case !isTerminal(l.state):
+			l.waiters = append(l.waiters, w)
+			waiting[l] = true
+		case l.state == Failed && o.failFast:
+			failed = l
+		}
+	}
+	c.q.mu.Unlock()
+
+	var err error
+	for err == nil && failed == nil && len(waiting) > 0 {
+		select {
+		case e := <-w.ended:
+			delete(waiting, e.l)
+			if e.state == Failed && o.failFast {
+				failed = e.l
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	c.q.mu.Lock()
+	defer c.q.mu.Unlock()
+	for len(w.ended) > 0 {
+		delete(waiting, (<-w.ended).l)
+	}
+	for l := range waiting {
+		l.dropWaiter(w)
+	}
+	jobs := make(map[string]Job, len(ids))
+	for l, ids := range given {
+		if waiting[l] {
+			continue
+		}
+		r, loadErr := c.q.store.Load(context.Background(), jobIDs[l])
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		for _, id := range ids {
+			jobs[id] = &job{q: c.q, r: r}
+		}
+		if l == failed {
+			err = fmt.Errorf("job %q failed with %q: %w", r.ID, r.Error, ErrJobFailed)
+		}
+	}
+	return jobs, err
+}
+
+// notifyWaiters tells the calls to WaitForJobs waiting for the given
+// job that it reached the given terminal state, and drops them.
+// mu must be held by the caller.
+func (l *liveJob) notifyWaiters(state State) {
+	for _, w := range l.waiters {
+		w.ended <- endedJob{l: l, state: state}
+	}
+	l.waiters = nil
+}
+
+// dropWaiter drops the given call to WaitForJobs from the calls
+// waiting for the job. The lock of the queue must be held by the caller.
+func (l *liveJob) dropWaiter(w *jobsWaiter) {
+	for i, other := range l.waiters {
+		if other == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return
+		}
+	}
+}
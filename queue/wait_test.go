@@ -0,0 +1,147 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+// keys returns the keys of jobs, sorted
+func keys(jobs map[string]queue.Job) []string {
+	ids := make([]string, 0, len(jobs))
+	for id := range jobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// held returns a processor that fails jobs with negative payloads right
+// away, and finishes the other ones once release is closed
+func held() (p queue.Processor, release chan struct{}) {
+	release = make(chan struct{})
+	p = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		var d intData
+		if err := j.GetData(&d); err != nil {
+			return err
+		}
+		if d.N < 0 {
+			return errNegative
+		}
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	return p, release
+}
+
+func TestWaitForJobs(t *testing.T) {
+	const n = 100
+	c, w := queue.New(doubler)
+	if err := c.CreateJobs(context.Background(), specs(n)); err != nil {
+		t.Fatal(err)
+	}
+	defer runWorker(t, w, 4)()
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("j-%d", i)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	jobs, err := c.WaitForJobs(ctx, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != n {
+		t.Fatalf("got %d jobs, want %d", len(jobs), n)
+	}
+	for i, id := range ids {
+		var d intData
+		if err := jobs[id].GetData(&d); err != nil {
+			t.Fatal(err)
+		}
+		if jobs[id].State() != queue.Finished || d.N != 2*i {
+			t.Errorf("got job %q %s with %d, want it %s with %d", id, jobs[id].State(), d.N, queue.Finished, 2*i)
+		}
+	}
+}
+
+func TestWaitForJobsWithFailure(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1, "bad": -1})
+	processAll(t, w)
+	jobs, err := c.WaitForJobs(context.Background(), []string{"a", "bad", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := keys(jobs), []string{"a", "bad"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got jobs %v, want %v", got, want)
+	}
+	if jobs["bad"].State() != queue.Failed {
+		t.Errorf("got job bad %s, want %s", jobs["bad"].State(), queue.Failed)
+	}
+}
+
+func TestWaitForJobsFailFast(t *testing.T) {
+	p, release := held()
+	defer close(release)
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"slow": 1, "bad": -1})
+	defer runWorker(t, w, 2)()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	jobs, err := c.WaitForJobs(ctx, []string{"slow", "bad"}, queue.WithFailFast())
+	if !errors.Is(err, queue.ErrJobFailed) {
+		t.Fatalf("got error %v, want %v", err, queue.ErrJobFailed)
+	}
+	if got := keys(jobs); len(got) != 1 || got[0] != "bad" {
+		t.Errorf("got jobs %v, want only the failed one", got)
+	}
+}
+
+func TestWaitForJobsFailFastAlreadyFailed(t *testing.T) {
+	p, release := held()
+	defer close(release)
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"bad": -1})
+	processAll(t, w)
+	createJobs(t, c, map[string]int{"slow": 1})
+	if _, err := c.WaitForJobs(context.Background(), []string{"slow", "bad"}, queue.WithFailFast()); !errors.Is(err, queue.ErrJobFailed) {
+		t.Errorf("got error %v, want %v", err, queue.ErrJobFailed)
+	}
+}
+
+func TestWaitForJobsCanceled(t *testing.T) {
+	p, release := held()
+	defer close(release)
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"slow": 1, "bad": -1})
+	defer runWorker(t, w, 2)()
+	waitForJob(t, c, "bad")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	jobs, err := c.WaitForJobs(ctx, []string{"slow", "bad"})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := keys(jobs); len(got) != 1 || got[0] != "bad" {
+		t.Errorf("got jobs %v, want only the terminal one", got)
+	}
+}
+
+func TestWaitForJobsNotFound(t *testing.T) {
+	c, _ := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	if _, err := c.WaitForJobs(context.Background(), []string{"a", "missing"}); !errors.Is(err, queue.ErrJobNotFound) {
+		t.Errorf("got error %v, want %v", err, queue.ErrJobNotFound)
+	}
+}
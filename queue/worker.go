@@ -1,10 +1,529 @@
 package queue
 
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
 // New takes a processor and returns both
 // a client and a worker. The client allows
 // pushing jobs to the queue (with CreateJob)
 // and the worker can run those jobs using
-// the given Processor
-func New(p Processor) (Client, Worker) {
-	return nil, nil
+// the given Processor. The given options
+// can be used to tune their behavior.
+// The jobs are kept in a MemoryStore.
+func New(p Processor, opts ...Option) (Client, Worker) {
+	cfg := newConfig(opts)
+	q := newMemoryQueue(cfg, &MemoryStore{})
+	return &client{q: q}, &worker{q: q, queue: DefaultQueue, p: cfg.wrap(p), cfg: cfg}
+}
+
+// NewWithStore is like New, but keeps the jobs in the given store.
+// The jobs already in the store are picked up: those that were Processing,
+// which had their processing interrupted, are Queued again.
+func NewWithStore(ctx context.Context, s Store, p Processor, opts ...Option) (Client, Worker, error) {
+	cfg := newConfig(opts)
+	q := newMemoryQueue(cfg, s)
+	if err := q.restore(ctx); err != nil {
+		return nil, nil, err
+	}
+	return &client{q: q}, &worker{q: q, queue: DefaultQueue, p: cfg.wrap(p), cfg: cfg}, nil
+}
+
+// worker is the Worker returned by New, which processes the jobs
+// in the default queue, or one returned by its ForQueue method.
+// runs holds the calls to Run in progress, and goroutines the clocks
+// of their worker goroutines by their IDs, both guarded by mu.
+type worker struct {
+	q          *memoryQueue
+	queue      string
+	p          Processor
+	cfg        config
+	mu         sync.Mutex
+	runs       map[*run]struct{}
+	goroutines map[int]*workerClock
+}
+
+// run is a call to Run in progress. Drain calls stopDispatch
+// to stop it from dispatching new jobs, and cancelProcessing to
+// interrupt the jobs it is processing. done is closed when all
+// of its worker goroutines have returned.
+//
+// Its worker goroutines take jobs under dispatchCtx and process them
+// under processingCtx, and wg waits for them. retire holds a function
+// per goroutine processing the queue of the worker, besides those of the
+// reservations, that makes it return once it is done with its current
+// job, if any, reserved counts the goroutines set aside for reservations,
+// reservations holds those not released yet, and stopped tells whether
+// dispatching stopped, after which no goroutine is started anymore. They
+// are guarded by the mu of the worker. err is the error of the store, if
+// it failed, set once.
+type run struct {
+	stopDispatch     context.CancelFunc
+	cancelProcessing context.CancelFunc
+	done             chan struct{}
+
+	dispatchCtx   context.Context
+	processingCtx context.Context
+	wg            sync.WaitGroup
+	retire        []context.CancelFunc
+	reserved      int
+	reservations  map[*Reservation]struct{}
+	stopped       bool
+	errOnce       sync.Once
+	err           error
+}
+
+var (
+	_ SyncWorker        = &worker{}
+	_ MultiQueueWorker  = &worker{}
+	_ PausableWorker    = &worker{}
+	_ ScalableWorker    = &worker{}
+	_ ReservingWorker   = &worker{}
+	_ InspectableWorker = &worker{}
+)
+
+// fail stops the call to Run from dispatching new jobs,
+// making it return err, unless it failed already
+func (r *run) fail(err error) {
+	r.errOnce.Do(func() {
+		r.err = err
+		r.stopDispatch()
+	})
+}
+
+// ForQueue returns a worker like w that processes
+// the jobs in the named queue with p, through the same middleware.
+func (w *worker) ForQueue(name string, p Processor) Worker {
+	return &worker{q: w.q, queue: name, p: w.cfg.wrap(p), cfg: w.cfg}
+}
+
+// Run starts the given number of worker goroutines, which take the
+// queued jobs with the highest priority one after the other until ctx
+// is done or the worker is drained. Then it waits for them to finish
+// their current jobs and returns ctx's error. If the store of the queue
+// fails, Run stops likewise and returns the store's error, as it does
+// with the error a processor passes to Shutdown. If the processor is
+// CPU-bound and the other calls already run GOMAXPROCS goroutines, it
+// starts none, until SetConcurrency gives it some.
+func (w *worker) Run(ctx context.Context, workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("cannot run %d workers", workers)
+	}
+	processingCtx, cancelProcessing := context.WithCancel(ctx)
+	defer cancelProcessing()
+	dispatchCtx, stopDispatch := context.WithCancel(processingCtx)
+	defer stopDispatch()
+	r := &run{
+		stopDispatch:     stopDispatch,
+		cancelProcessing: cancelProcessing,
+		done:             make(chan struct{}),
+		dispatchCtx:      dispatchCtx,
+		processingCtx:    processingCtx,
+		reservations:     make(map[*Reservation]struct{}),
+	}
+	// wg also waits for dispatching to stop, so that Run does not
+	// return while its goroutines are all retired
+	r.wg.Add(1)
+	go func() {
+		<-dispatchCtx.Done()
+		w.mu.Lock()
+		r.stopped = true
+		w.mu.Unlock()
+		r.wg.Done()
+	}()
+	w.mu.Lock()
+	if w.runs == nil {
+		w.runs = make(map[*run]struct{})
+	}
+	w.runs[r] = struct{}{}
+	r.retire = w.spawn(r, w.queue, w.clamp(workers, w.running()))
+	w.mu.Unlock()
+	r.wg.Wait()
+
+	w.mu.Lock()
+	delete(w.runs, r)
+	w.mu.Unlock()
+	if err := w.releaseAll(r); err != nil {
+		r.fail(err)
+	}
+	close(r.done)
+	if r.err != nil {
+		return r.err
+	}
+	return ctx.Err()
+}
+
+// SetConcurrency changes the number of worker goroutines of the calls
+// to Run in progress to n, starting new ones or retiring some of them.
+// Retired goroutines finish processing their current job, if any,
+// before returning, like with Drain. With n set to 0, those calls stop
+// dispatching jobs until the concurrency is raised again, but they do not
+// return until their context is done or the worker is drained. If the
+// processor is CPU-bound, the calls get goroutines in no particular order,
+// as long as the worker runs at most GOMAXPROCS.
+func (w *worker) SetConcurrency(n int) error {
+	if n < 0 {
+		return fmt.Errorf("cannot run %d workers", n)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// Retiring goroutines first leaves room for the
+	// calls that start some in a CPU-bound worker.
+	for r := range w.runs {
+		if r.stopped || len(r.retire) <= n {
+			continue
+		}
+		for _, retire := range r.retire[n:] {
+			retire()
+		}
+		r.retire = r.retire[:n]
+	}
+	for r := range w.runs {
+		if running := len(r.retire); !r.stopped && n > running {
+			more := w.clamp(n-running, w.running())
+			r.retire = append(r.retire, w.spawn(r, w.queue, more)...)
+		}
+	}
+	return nil
+}
+
+// ActiveWorkers returns the number of worker goroutines of the calls to
+// Run in progress taking jobs from the queue of the worker, leaving out
+// those that were retired, those set aside for reservations and those of
+// the calls that stopped dispatching, like when the worker is drained.
+// It returns 0 while the queue is paused.
+func (w *worker) ActiveWorkers() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.q.mu.RLock()
+	paused := w.q.paused[w.queue]
+	w.q.mu.RUnlock()
+	if paused {
+		return 0
+	}
+	n := 0
+	for r := range w.runs {
+		if !r.stopped {
+			n += len(r.retire)
+		}
+	}
+	return n
+}
+
+// running returns the number of goroutines of the calls to Run in
+// progress, counting those set aside for reservations, as clamp limits
+// them. w.mu must be held by the caller.
+func (w *worker) running() int {
+	n := 0
+	for r := range w.runs {
+		if !r.stopped {
+			n += len(r.retire) + r.reserved
+		}
+	}
+	return n
+}
+
+// clamp returns the number of goroutines to start when asked for n more
+// while the worker runs the given number, which is at most as many as
+// leave it running GOMAXPROCS if the processor is CPU-bound.
+func (w *worker) clamp(n, running int) int {
+	max := runtime.GOMAXPROCS(0)
+	if !w.cfg.cpuBound[w.queue] || running+n <= max {
+		return n
+	}
+	w.cfg.logger.Warn("clamping the workers of a CPU-bound processor to GOMAXPROCS",
+		slog.String("queue", w.queue),
+		slog.Int("workers", running+n),
+		slog.Int("gomaxprocs", max))
+	if running >= max {
+		return 0
+	}
+	return max - running
+}
+
+// spawn starts n worker goroutines for the given call to Run, which
+// take the jobs queued in the named queue one after the other until they
+// are retired or r stops dispatching, and returns the functions retiring
+// them. w.mu must be held by the caller.
+func (w *worker) spawn(r *run, name string, n int) []context.CancelFunc {
+	if w.goroutines == nil {
+		w.goroutines = make(map[int]*workerClock)
+	}
+	ids := make([]int, n)
+	clocks := make([]*workerClock, n)
+	w.q.mu.Lock()
+	w.q.workers += n
+	w.q.armCPUSampler()
+	for i := range clocks {
+		ids[i] = w.freeGoroutineID()
+		clocks[i] = w.q.startClock(name, ids[i])
+		w.goroutines[ids[i]] = clocks[i]
+	}
+	w.q.mu.Unlock()
+	retire := make([]context.CancelFunc, n)
+	for i := range retire {
+		var ctx context.Context
+		ctx, retire[i] = context.WithCancel(r.dispatchCtx)
+		clock, id := clocks[i], ids[i]
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			defer func() {
+				w.mu.Lock()
+				delete(w.goroutines, id)
+				w.mu.Unlock()
+				w.q.mu.Lock()
+				w.q.workers--
+				w.q.stopClock(clock)
+				w.q.mu.Unlock()
+			}()
+			for {
+				id, ok := w.q.next(ctx, name, clock)
+				if !ok {
+					return
+				}
+				if _, err := w.process(r.processingCtx, id, r.fail); err != nil {
+					r.fail(err)
+					return
+				}
+			}
+		}()
+	}
+	return retire
+}
+
+// Drain stops the calls to Run in progress from dispatching new jobs,
+// and waits for them to finish processing their current jobs and return.
+// If ctx gets done first, the jobs still being processed are interrupted
+// and Drain returns ctx's error right away.
+func (w *worker) Drain(ctx context.Context) error {
+	w.mu.Lock()
+	runs := make([]*run, 0, len(w.runs))
+	for r := range w.runs {
+		r.stopDispatch()
+		runs = append(runs, r)
+	}
+	w.mu.Unlock()
+	for _, r := range runs {
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			for _, r := range runs {
+				r.cancelProcessing()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// ProcessAll processes the jobs queued at the time of the call one after
+// the other, in the order Run would dispatch them, on the calling goroutine.
+// It returns how many jobs it processed and, if ctx got done before
+// processing them all, ctx's error, or the store's error if it failed.
+// A processor calling Shutdown makes it return the given error once its
+// job is done, leaving the other jobs Queued, as does ProcessOne.
+func (w *worker) ProcessAll(ctx context.Context) (int, error) {
+	w.q.mu.RLock()
+	queued := w.q.depth(w.queue)
+	w.q.mu.RUnlock()
+	processed := 0
+	var fatal fatalError
+	for ; queued > 0; queued-- {
+		if err := ctx.Err(); err != nil {
+			return processed, err
+		}
+		id, ok := w.q.tryNext(w.queue)
+		if !ok {
+			break
+		}
+		ok, err := w.process(ctx, id, fatal.set)
+		if err != nil {
+			return processed, err
+		}
+		if ok {
+			processed++
+		}
+		if err := fatal.get(); err != nil {
+			return processed, err
+		}
+	}
+	return processed, nil
+}
+
+// ProcessOne processes the job Run would take next, if any, on the calling
+// goroutine, like ProcessAll does for every queued job, and returns its ID.
+// Jobs that are not processed once taken, like those past their deadline,
+// are skipped. It returns false if no job was processed because there was
+// none queued or ctx got done, with ctx's error then, and the store's
+// error if it failed.
+func (w *worker) ProcessOne(ctx context.Context) (string, bool, error) {
+	var fatal fatalError
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", false, err
+		}
+		id, ok := w.q.tryNext(w.queue)
+		if !ok {
+			return "", false, nil
+		}
+		ok, err := w.process(ctx, id, fatal.set)
+		if err == nil {
+			err = fatal.get()
+		}
+		if err != nil {
+			return id, ok, err
+		}
+		if ok {
+			return id, true, nil
+		}
+	}
+}
+
+// StepOnce is ProcessOne for tests that only care whether a job was
+// processed: it processes the job Run would take next, if any, and
+// tells whether there was one.
+func (w *worker) StepOnce(ctx context.Context) (bool, error) {
+	_, ok, err := w.ProcessOne(ctx)
+	return ok, err
+}
+
+// process runs the processor on the given job and records the outcome.
+// A job whose processing fails because the worker is being stopped is
+// put back in the queue rather than failed, so a later Run can process it.
+// A job whose processor panics is quarantined under PanicQuarantine.
+// Other failures are retried as long as the retry policy allows it,
+// or the schedule of the job, if it was created with one.
+// The processor runs under a context of its own, so that the job can
+// be cancelled by a client, capped at the deadline of the job, if any.
+// It returns false if the job could not be processed because it was not
+// Queued, and the store's error if recording the outcome failed. The
+// processor calling Shutdown calls shutdown.
+func (w *worker) process(ctx context.Context, id string, shutdown func(error)) (bool, error) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pj, ok, err := w.q.start(id, cancel)
+	if !ok {
+		return false, err
+	}
+	pj.shutdown = shutdown
+	if !pj.deadline.IsZero() {
+		var cancelAtDeadline context.CancelFunc
+		jobCtx, cancelAtDeadline = context.WithDeadline(jobCtx, pj.deadline)
+		defer cancelAtDeadline()
+	}
+	attempt := pj.attempt
+	jobCtx, span := startProcessSpan(jobCtx, w.q.tracer, pj)
+	started := w.q.clock.now()
+	stopHeartbeat := w.heartbeat(pj)
+	if w.cfg.onDispatch != nil {
+		w.cfg.onDispatch(id)
+	}
+	err = w.runProcessor(jobCtx, pj)
+	stopHeartbeat()
+	if err != nil && jobCtx.Err() == context.DeadlineExceeded && !pj.deadline.IsZero() && !w.q.clock.now().Before(pj.deadline) {
+		err = categorizedError{category: FailureLifetimeExceeded, err: err}
+	}
+	w.cfg.metrics.Observe(MetricProcessingSeconds, w.q.clock.now().Sub(started).Seconds())
+	retried, delay := nextAttempt(w.cfg.retryPolicy, pj.schedule, attempt, err, w.q.clock.now())
+	var storeErr error
+	switch {
+	case err != nil && ctx.Err() != nil:
+		storeErr = w.q.requeue(pj, err)
+	case w.cfg.panicPolicy == PanicQuarantine && failureCategory(err) == FailurePanic:
+		storeErr = w.q.quarantine(pj, err)
+	case err != nil && retried:
+		storeErr = w.q.retry(pj, err, delay)
+	default:
+		storeErr = w.q.finish(pj, err)
+	}
+	r, _ := pj.record()
+	endProcessSpan(span, err, r.State)
+	return true, storeErr
+}
+
+// runProcessor runs the processor on the given job and returns its error.
+// If there is a job timeout or a cancel grace, the processor runs in its
+// own goroutine, under a context with the timeout, if any. It is abandoned
+// when the timeout hits, or when ctx gets done and it does not return
+// within the grace, returning ctx's error then, so that a processor
+// ignoring cancellation cannot keep the worker from stopping.
+func (w *worker) runProcessor(ctx context.Context, pj *processingJob) error {
+	if w.cfg.jobTimeout <= 0 && w.cfg.cancelGrace <= 0 {
+		return w.callProcessor(ctx, pj)
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	if w.cfg.jobTimeout > 0 {
+		jobCtx, cancel = context.WithTimeout(ctx, w.cfg.jobTimeout)
+	}
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- w.callProcessor(jobCtx, pj)
+	}()
+	var err error
+	select {
+	case err = <-result:
+		if err == nil {
+			return nil
+		}
+	case <-jobCtx.Done():
+		if ctx.Err() != nil {
+			return w.awaitCanceled(ctx, result)
+		}
+	}
+	if ctx.Err() == nil && jobCtx.Err() == context.DeadlineExceeded {
+		return categorizedError{category: FailureTimeout, err: fmt.Errorf("job timed out after %s", w.cfg.jobTimeout)}
+	}
+	return err
+}
+
+// awaitCanceled waits for the processor, whose context ctx is done, to
+// return its error on result, within the cancel grace, if any. It returns
+// ctx's error as an abandonedError if the processor does not return.
+func (w *worker) awaitCanceled(ctx context.Context, result chan error) error {
+	if w.cfg.cancelGrace > 0 {
+		grace := time.NewTimer(w.cfg.cancelGrace)
+		defer grace.Stop()
+		select {
+		case err := <-result:
+			return err
+		case <-grace.C:
+		}
+	}
+	return abandonedError{err: ctx.Err()}
+}
+
+// callProcessor calls the processor on the given job under ctx, for
+// Done to reflect it, turning any panic into an error with the panic
+// value and stack trace, unless the panic policy is PanicCrash, and
+// guarding against goroutine leaks if there is a goroutine guard.
+func (w *worker) callProcessor(ctx context.Context, pj *processingJob) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			stack := debug.Stack()
+			if w.cfg.panicPolicy == PanicCrash {
+				w.cfg.logger.Error("processor panicked, crashing",
+					slog.String("job_id", pj.id),
+					slog.String("queue", pj.queue),
+					slog.Int("attempt", pj.attempt),
+					slog.Any("panic", v),
+					slog.String("stack", string(stack)))
+				panic(v)
+			}
+			err = categorizedError{category: FailurePanic, err: fmt.Errorf("processor panicked: %v\n%s", v, stack)}
+		}
+	}()
+	pj.done = ctx.Done()
+	if w.cfg.leakLimit < 0 {
+		return w.p.Process(ctx, pj)
+	}
+	before := runtime.NumGoroutine()
+	return w.guardGoroutines(pj, before, w.p.Process(ctx, pj))
 }
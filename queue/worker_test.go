@@ -0,0 +1,928 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ingrammicro/backend-test/queue"
+)
+
+func TestRunProcessesJobs(t *testing.T) {
+	c, w := queue.New(doubler)
+	payloads := map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "neg": -1}
+	createJobs(t, c, payloads)
+	stop := runWorker(t, w, 2)
+	for id, n := range payloads {
+		j := waitForJob(t, c, id)
+		if n < 0 {
+			if j.State() != queue.Failed || j.Error() != errNegative.Error() {
+				t.Errorf("job %q: got state %q and error %q, want %q and %q", id, j.State(), j.Error(), queue.Failed, errNegative)
+			}
+			continue
+		}
+		var d intData
+		if err := j.GetData(&d); err != nil {
+			t.Fatalf("job %q: getting data: %v", id, err)
+		}
+		if j.State() != queue.Finished || d.N != 2*n {
+			t.Errorf("job %q: got state %q and data %d, want %q and %d", id, j.State(), d.N, queue.Finished, 2*n)
+		}
+	}
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got Run error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPriorityOrder(t *testing.T) {
+	rec := &recorder{}
+	c, w := queue.New(rec)
+	ctx := context.Background()
+	for i, priority := range []int{0, 5, 1, 5, -1, 3, math.MaxInt32} {
+		if err := c.CreateJob(ctx, fmt.Sprint(i), &intData{}, queue.WithPriority(priority)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	processAll(t, w)
+	want := []string{"6", "1", "3", "5", "2", "0", "4"}
+	if got := rec.processed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got dispatch order %v, want %v", got, want)
+	}
+}
+
+func TestDispatchObserver(t *testing.T) {
+	var mu sync.Mutex
+	var observed []string
+	observe := func(id string) {
+		mu.Lock()
+		defer mu.Unlock()
+		observed = append(observed, id)
+	}
+	// The observer is called before the processor
+	var unobserved []string
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(observed) == 0 || observed[len(observed)-1] != j.ID() {
+			unobserved = append(unobserved, j.ID())
+		}
+		return nil
+	})
+	c, w := queue.New(p, queue.WithDispatchObserver(observe))
+	ctx := context.Background()
+	for i, priority := range []int{0, 5, 1, 5, -1, 3, math.MaxInt32} {
+		if err := c.CreateJob(ctx, fmt.Sprint(i), &intData{}, queue.WithPriority(priority)); err != nil {
+			t.Fatalf("creating job: %v", err)
+		}
+	}
+	stop := runWorker(t, w, 1)
+	for i := 0; i < 7; i++ {
+		waitForJob(t, c, fmt.Sprint(i))
+	}
+	stop()
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"6", "1", "3", "5", "2", "0", "4"}
+	if !reflect.DeepEqual(observed, want) {
+		t.Errorf("got dispatch order %v, want %v", observed, want)
+	}
+	if len(unobserved) > 0 {
+		t.Errorf("got jobs %v processed before being observed", unobserved)
+	}
+}
+
+func TestIntraPriorityOrder(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []queue.Option
+		want []string
+	}{
+		{name: "default", want: []string{"5", "0", "2", "4", "1", "3"}},
+		{name: "FIFO", opts: []queue.Option{queue.WithIntraPriorityOrder(queue.FIFO)}, want: []string{"5", "0", "2", "4", "1", "3"}},
+		{name: "LIFO", opts: []queue.Option{queue.WithIntraPriorityOrder(queue.LIFO)}, want: []string{"5", "4", "2", "0", "3", "1"}},
+		// Jobs would age past each other's priorities in no time otherwise
+		{name: "LIFO without aging", opts: []queue.Option{queue.WithPriorityAging(time.Nanosecond), queue.WithIntraPriorityOrder(queue.LIFO)}, want: []string{"5", "4", "2", "0", "3", "1"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			c, w := queue.New(rec, tt.opts...)
+			for i, priority := range []int{1, 0, 1, 0, 1, 2} {
+				if err := c.CreateJob(context.Background(), fmt.Sprint(i), &intData{}, queue.WithPriority(priority)); err != nil {
+					t.Fatalf("creating job: %v", err)
+				}
+			}
+			processAll(t, w)
+			if got := rec.processed(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got dispatch order %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLIFODropsPriorityAging(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts []queue.Option
+		want []string
+	}{
+		{name: "default aging", opts: []queue.Option{queue.WithIntraPriorityOrder(queue.LIFO)}},
+		{name: "no aging", opts: []queue.Option{queue.WithPriorityAging(0), queue.WithIntraPriorityOrder(queue.LIFO)}},
+		{name: "explicit aging", opts: []queue.Option{queue.WithPriorityAging(time.Second), queue.WithIntraPriorityOrder(queue.LIFO)}, want: []string{"WARN dropping the priority aging of a queue with LIFO order"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &logRecorder{}
+			queue.New(doubler, append(tt.opts, queue.WithLogger(slog.New(h)))...)
+			if got := h.logged(""); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got records %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriorityAging(t *testing.T) {
+	rec := &recorder{}
+	clock := queue.NewFakeClock()
+	c, w := queue.New(rec, queue.WithPriorityAging(10*time.Millisecond), queue.WithFakeClock(clock))
+	ctx := context.Background()
+	if err := c.CreateJob(ctx, "old", &intData{}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(50 * time.Millisecond) // More than 2 aging periods
+	if err := c.CreateJob(ctx, "new-2", &intData{}, queue.WithPriority(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateJob(ctx, "new-1000", &intData{}, queue.WithPriority(1000)); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	want := []string{"new-1000", "old", "new-2"}
+	if got := rec.processed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got dispatch order %v, want %v", got, want)
+	}
+}
+
+func TestStrictPriorityWithoutAging(t *testing.T) {
+	rec := &recorder{}
+	clock := queue.NewFakeClock()
+	c, w := queue.New(rec, queue.WithPriorityAging(0), queue.WithFakeClock(clock))
+	ctx := context.Background()
+	if err := c.CreateJob(ctx, "old", &intData{}); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if err := c.CreateJob(ctx, "new", &intData{}, queue.WithPriority(1)); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	want := []string{"new", "old"}
+	if got := rec.processed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got dispatch order %v, want %v", got, want)
+	}
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	// The processor checkpoints 5 steps of work in the payload, then
+	// "crashes", interrupted by the worker stopping, before finishing
+	started := make(chan int, 2)
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		var d intData
+		if err := j.GetData(&d); err != nil {
+			return err
+		}
+		started <- d.N
+		if d.N == 0 {
+			d.N = 5
+			if err := j.SetData(ctx, &d); err != nil {
+				return err
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		d.N++
+		return j.SetData(ctx, &d)
+	})
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	stop := runWorker(t, w, 1)
+	<-started
+	stop()
+	j, err := c.GetJob(context.Background(), "a")
+	if err != nil || j.State() != queue.Queued {
+		t.Fatalf("got job %v and error %v after the crash, want it Queued", j, err)
+	}
+
+	defer runWorker(t, w, 1)()
+	if n := <-started; n != 5 {
+		t.Errorf("got job redelivered with %d steps done, want 5", n)
+	}
+	var d intData
+	if err := waitForJob(t, c, "a").GetData(&d); err != nil {
+		t.Fatal(err)
+	}
+	if d.N != 6 {
+		t.Errorf("got %d steps done, want 6", d.N)
+	}
+}
+
+func TestProcessAll(t *testing.T) {
+	c, w := queue.New(doubler)
+	payloads := map[string]int{"a": 1, "b": 2, "c": 3}
+	createJobs(t, c, payloads)
+	if n := processAll(t, w); n != len(payloads) {
+		t.Errorf("got %d jobs processed, want %d", n, len(payloads))
+	}
+	for id := range payloads {
+		if j, _ := c.GetJob(context.Background(), id); j.State() != queue.Finished {
+			t.Errorf("job %q: got state %q, want %q", id, j.State(), queue.Finished)
+		}
+	}
+	if n := processAll(t, w); n != 0 {
+		t.Errorf("got %d jobs processed with an empty queue, want 0", n)
+	}
+}
+
+func TestProcessAllCanceled(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := w.(queue.SyncWorker).ProcessAll(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if j, _ := c.GetJob(context.Background(), "a"); j.State() != queue.Queued {
+		t.Errorf("got state %q, want %q", j.State(), queue.Queued)
+	}
+}
+
+// processOne processes synchronously the next job queued for w, if any
+func processOne(t *testing.T, w queue.Worker) (string, bool) {
+	t.Helper()
+	id, ok, err := w.(queue.SyncWorker).ProcessOne(context.Background())
+	if err != nil {
+		t.Fatalf("processing one job: %v", err)
+	}
+	return id, ok
+}
+
+func TestProcessOne(t *testing.T) {
+	c, w := queue.New(doubler)
+	ctx := context.Background()
+	for id, priority := range map[string]int{"low": 0, "high": 1} {
+		if err := c.CreateJob(ctx, id, &intData{N: 1}, queue.WithPriority(priority)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"high", "low"} {
+		id, ok := processOne(t, w)
+		if !ok || id != want {
+			t.Fatalf("got job %q processed (%v), want %q", id, ok, want)
+		}
+		states := make(map[string]queue.State)
+		for _, id := range []string{"high", "low"} {
+			j, _ := c.GetJob(ctx, id)
+			states[id] = j.State()
+		}
+		if states[want] != queue.Finished {
+			t.Errorf("got job %q %s after processing it, want %s", want, states[want], queue.Finished)
+		}
+		if want == "high" && states["low"] != queue.Queued {
+			t.Errorf("got job low %s after processing only high, want %s", states["low"], queue.Queued)
+		}
+	}
+	if id, ok := processOne(t, w); ok {
+		t.Errorf("got job %q processed from an empty queue, want none", id)
+	}
+}
+
+func TestProcessOneRetries(t *testing.T) {
+	p, attempts := flaky(1)
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}))
+	createJobs(t, c, map[string]int{"a": 1})
+	processOne(t, w)
+	if j, _ := c.GetJob(context.Background(), "a"); j.State() != queue.Queued || j.Error() == "" {
+		t.Errorf("got job %s with error %q after its failed attempt, want it %s again with the error", j.State(), j.Error(), queue.Queued)
+	}
+	processOne(t, w)
+	if j, _ := c.GetJob(context.Background(), "a"); j.State() != queue.Finished {
+		t.Errorf("got job %s after its second attempt, want %s", j.State(), queue.Finished)
+	}
+	if got := attempts(); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("got attempts %v, want [1 2]", got)
+	}
+}
+
+func TestProcessOneSkipsExpiredJobs(t *testing.T) {
+	c, w := queue.New(doubler)
+	ctx := context.Background()
+	if err := c.CreateJob(ctx, "late", &intData{N: 1}, queue.WithDeadline(time.Now().Add(-time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"on-time": 1})
+	if id, ok := processOne(t, w); !ok || id != "on-time" {
+		t.Errorf("got job %q processed (%v), want on-time", id, ok)
+	}
+	if j, _ := c.GetJob(ctx, "late"); j.State() != queue.Failed {
+		t.Errorf("got job late %s, want %s", j.State(), queue.Failed)
+	}
+}
+
+func TestProcessOneCanceled(t *testing.T) {
+	c, w := queue.New(doubler)
+	createJobs(t, c, map[string]int{"a": 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok, err := w.(queue.SyncWorker).ProcessOne(ctx); ok || !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, %v, want false, %v", ok, err, context.Canceled)
+	}
+	if j, _ := c.GetJob(context.Background(), "a"); j.State() != queue.Queued {
+		t.Errorf("got state %q, want %q", j.State(), queue.Queued)
+	}
+}
+
+func TestStepOnce(t *testing.T) {
+	r := &recorder{}
+	c, w := queue.New(r)
+	sw := w.(queue.SyncWorker)
+	ctx := context.Background()
+	for i, id := range []string{"a", "b", "c"} {
+		if err := c.CreateJob(ctx, id, &intData{N: i}, queue.WithPriority(-i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		processed, err := sw.StepOnce(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !processed {
+			t.Fatalf("got no job processed at step %d, want %q", i, id)
+		}
+		if got := r.processed(); len(got) != i+1 || got[i] != id {
+			t.Fatalf("got jobs %v processed after step %d, want %q last", got, i, id)
+		}
+	}
+	if processed, err := sw.StepOnce(ctx); processed || err != nil {
+		t.Errorf("got %v, %v stepping through an empty queue, want false, nil", processed, err)
+	}
+}
+
+func TestStepOnceRetriesAndTimesOut(t *testing.T) {
+	stuck := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	c, w := queue.New(stuck,
+		queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}),
+		queue.WithJobTimeout(10*time.Millisecond),
+	)
+	sw := w.(queue.SyncWorker)
+	createJobs(t, c, map[string]int{"a": 1})
+	for _, want := range []queue.State{queue.Queued, queue.Failed} {
+		if processed, err := sw.StepOnce(context.Background()); !processed || err != nil {
+			t.Fatalf("got %v, %v, want true, nil", processed, err)
+		}
+		j, err := c.GetJob(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.State() != want || !strings.Contains(j.Error(), "timed out") {
+			t.Errorf("got job %s with error %q, want it %s after timing out", j.State(), j.Error(), want)
+		}
+	}
+}
+
+// flaky returns a processor that fails the first failures attempts
+// to process a job, and records the attempt numbers it is given.
+func flaky(failures int) (queue.Processor, func() []int) {
+	var mu sync.Mutex
+	var attempts []int
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		mu.Lock()
+		attempts = append(attempts, j.Attempt())
+		mu.Unlock()
+		if j.Attempt() <= failures {
+			return fmt.Errorf("attempt %d failed", j.Attempt())
+		}
+		return nil
+	})
+	return p, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), attempts...)
+	}
+}
+
+func TestRetryUntilSuccess(t *testing.T) {
+	p, attempts := flaky(2)
+	var backoffs []int
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{
+		MaxAttempts: 3,
+		BackoffFor: func(attempt int) time.Duration {
+			backoffs = append(backoffs, attempt) // Called by the only worker goroutine
+			return time.Millisecond
+		},
+	}))
+	createJobs(t, c, map[string]int{"a": 0})
+	defer runWorker(t, w, 1)()
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Errorf("got state %q with error %q, want %q", j.State(), j.Error(), queue.Finished)
+	}
+	if got, want := attempts(), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got attempts %v, want %v", got, want)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(backoffs, want) {
+		t.Errorf("got backoffs for attempts %v, want %v", backoffs, want)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	p, attempts := flaky(10)
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 3}))
+	createJobs(t, c, map[string]int{"a": 0})
+	defer runWorker(t, w, 1)()
+	j := waitForJob(t, c, "a")
+	if j.State() != queue.Failed || j.Error() != "attempt 3 failed" {
+		t.Errorf("got state %q with error %q, want %q with the last error", j.State(), j.Error(), queue.Failed)
+	}
+	if got := len(attempts()); got != 3 {
+		t.Errorf("got %d attempts, want 3", got)
+	}
+}
+
+func TestNoRetryByDefault(t *testing.T) {
+	p, attempts := flaky(1)
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	processAll(t, w)
+	if j, _ := c.GetJob(context.Background(), "a"); j.State() != queue.Failed {
+		t.Errorf("got state %q, want %q", j.State(), queue.Failed)
+	}
+	if got := len(attempts()); got != 1 {
+		t.Errorf("got %d attempts, want 1", got)
+	}
+}
+
+// stubborn returns a processor that signals on started when it starts
+// processing a job, and then ignores cancellation until release is closed.
+// Then it tries to store in the job's payload how many times it was
+// called before, and sends what SetData returned on stored.
+func stubborn() (p queue.Processor, started chan string, release chan struct{}, stored chan error) {
+	started, release, stored = make(chan string, 10), make(chan struct{}), make(chan error, 10)
+	var mu sync.Mutex
+	calls := 0
+	p = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		mu.Lock()
+		call := calls
+		calls++
+		mu.Unlock()
+		started <- j.ID()
+		<-release
+		err := j.SetData(context.Background(), &intData{N: call})
+		stored <- err
+		return err
+	})
+	return p, started, release, stored
+}
+
+func TestJobTimeout(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	p, _, release, _ := stubborn()
+	defer close(release)
+	c, w := queue.New(p, queue.WithJobTimeout(timeout))
+	createJobs(t, c, map[string]int{"a": 0, "b": 0})
+	began := time.Now()
+	defer runWorker(t, w, 1)()
+	// The only worker slot is freed by the timeout of the first job, so both time out
+	for _, id := range []string{"a", "b"} {
+		j := waitForJob(t, c, id)
+		if j.State() != queue.Failed || !strings.Contains(j.Error(), "timed out") {
+			t.Errorf("job %q: got state %q with error %q, want it failed by a timeout", id, j.State(), j.Error())
+		}
+	}
+	if elapsed := time.Since(began); elapsed < 2*timeout {
+		t.Errorf("got both jobs timed out after %v, want at least %v", elapsed, 2*timeout)
+	}
+}
+
+func TestJobTimeoutCancelsContext(t *testing.T) {
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	c, w := queue.New(p, queue.WithJobTimeout(10*time.Millisecond))
+	createJobs(t, c, map[string]int{"a": 0})
+	processAll(t, w)
+	if j, _ := c.GetJob(context.Background(), "a"); j.State() != queue.Failed || !strings.Contains(j.Error(), "timed out") {
+		t.Errorf("got state %q with error %q, want it failed by a timeout", j.State(), j.Error())
+	}
+}
+
+func TestDeadlineCapsContext(t *testing.T) {
+	deadline := time.Now().Add(time.Hour)
+	var got time.Time
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		got, _ = ctx.Deadline()
+		return nil
+	})
+	c, w := queue.New(p)
+	if err := c.CreateJob(context.Background(), "a", &intData{N: 1}, queue.WithDeadline(deadline)); err != nil {
+		t.Fatal(err)
+	}
+	if n := processAll(t, w); n != 1 {
+		t.Fatalf("processed %d jobs, want 1", n)
+	}
+	if j := waitForJob(t, c, "a"); j.State() != queue.Finished {
+		t.Errorf("got job %s (%s), want %s", j.State(), j.Error(), queue.Finished)
+	}
+	if !got.Equal(deadline) {
+		t.Errorf("got processor context with deadline %v, want %v", got, deadline)
+	}
+}
+
+func TestDeadlineCancelsProcessing(t *testing.T) {
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	c, w := queue.New(p)
+	if err := c.CreateJob(context.Background(), "a", &intData{N: 1}, queue.WithDeadline(time.Now().Add(10*time.Millisecond))); err != nil {
+		t.Fatal(err)
+	}
+	processAll(t, w)
+	if j := waitForJob(t, c, "a"); j.State() != queue.Failed || j.Error() != context.DeadlineExceeded.Error() {
+		t.Errorf("got job %s with error %q, want it %s with error %q", j.State(), j.Error(), queue.Failed, context.DeadlineExceeded)
+	}
+}
+
+func TestDeadlinePassedBeforeStart(t *testing.T) {
+	rec, events := &recorder{}, &eventRecorder{}
+	c, w := queue.New(rec, queue.WithEventHandler(events))
+	if err := c.CreateJob(context.Background(), "late", &intData{N: 1}, queue.WithDeadline(time.Now().Add(-time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	createJobs(t, c, map[string]int{"on-time": 2})
+	if n := processAll(t, w); n != 1 {
+		t.Errorf("processed %d jobs, want only the one on time", n)
+	}
+	if got, want := rec.processed(), []string{"on-time"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got processed jobs %v, want %v", got, want)
+	}
+	j := waitForJob(t, c, "late")
+	if j.State() != queue.Failed || j.Error() != queue.ErrDeadlineBeforeStart.Error() {
+		t.Errorf("got job %s with error %q, want it %s with error %q", j.State(), j.Error(), queue.Failed, queue.ErrDeadlineBeforeStart)
+	}
+	want := []string{"created late", "created on-time", "failed late 0: deadline exceeded before start", "started on-time 1", "finished on-time 1"}
+	if got := events.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+func TestStopAbandonsStubbornProcessor(t *testing.T) {
+	p, started, release, stored := stubborn()
+	c, w := queue.New(p, queue.WithJobTimeout(time.Hour))
+	createJobs(t, c, map[string]int{"a": 0})
+	stop := runWorker(t, w, 1)
+	<-started
+	stop() // Fails the test if Run waits for the processor
+	j, _ := c.GetJob(context.Background(), "a")
+	if j.State() != queue.Queued {
+		t.Fatalf("got state %q after stopping the worker, want %q", j.State(), queue.Queued)
+	}
+
+	// The job is started again for the same attempt, and the
+	// abandoned processor cannot store anything in it anymore
+	defer runWorker(t, w, 1)()
+	<-started
+	close(release)
+	failed := 0
+	for i := 0; i < 2; i++ {
+		if err := <-stored; err != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("got %d processors failing to store data, want only the abandoned one", failed)
+	}
+	j = waitForJob(t, c, "a")
+	var d intData
+	if err := j.GetData(&d); err != nil {
+		t.Fatal(err)
+	}
+	if j.State() != queue.Finished || d.N != 1 {
+		t.Errorf("got state %q with data from call %d, want %q with data from call 1", j.State(), d.N, queue.Finished)
+	}
+}
+
+func TestWorkerForQueue(t *testing.T) {
+	defaultRec, otherRec := &recorder{}, &recorder{}
+	c, w := queue.New(defaultRec)
+	ctx := context.Background()
+	for _, id := range []string{"d1", "d2"} {
+		if err := c.CreateJob(ctx, id, &intData{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"o1", "o2"} {
+		if err := c.CreateJobInQueue(ctx, "other", id, &intData{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.CreateJobInQueue(ctx, "other", "d1", &intData{}); err == nil {
+		t.Error("got no error creating a job with the ID of a job in another queue")
+	}
+
+	ow := w.(queue.MultiQueueWorker).ForQueue("other", otherRec)
+	stop := runWorker(t, ow, 2)
+	for _, id := range []string{"o1", "o2"} {
+		waitForJob(t, c, id)
+	}
+	stop()
+	if got, want := otherRec.processed(), []string{"o1", "o2"}; !reflect.DeepEqual(sorted(got), want) {
+		t.Errorf("got jobs %v processed by the other queue's worker, want %v", got, want)
+	}
+	for _, id := range []string{"d1", "d2"} {
+		if j, _ := c.GetJob(ctx, id); j.State() != queue.Queued {
+			t.Errorf("job %q: got state %q, want it left %q by the other queue's worker", id, j.State(), queue.Queued)
+		}
+	}
+
+	processAll(t, w)
+	if got, want := defaultRec.processed(), []string{"d1", "d2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got jobs %v processed by the default worker, want %v", got, want)
+	}
+}
+
+func TestDrainLetsJobsFinish(t *testing.T) {
+	p, started, release, _ := stubborn()
+	c, w := queue.New(p)
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := c.CreateJob(ctx, id, &intData{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ran := make(chan error, 1)
+	go func() {
+		ran <- w.Run(ctx, 2)
+	}()
+	<-started
+	<-started
+	drained := make(chan error, 1)
+	go func() {
+		drained <- w.Drain(ctx)
+	}()
+	time.Sleep(20 * time.Millisecond) // Let Drain stop the dispatch of "c"
+	close(release)
+	if err := <-drained; err != nil {
+		t.Errorf("got Drain error %v, want nil", err)
+	}
+	if err := <-ran; err != nil {
+		t.Errorf("got Run error %v, want nil", err)
+	}
+	for id, want := range map[string]queue.State{"a": queue.Finished, "b": queue.Finished, "c": queue.Queued} {
+		if j, _ := c.GetJob(ctx, id); j.State() != want {
+			t.Errorf("job %q: got state %q, want %q", id, j.State(), want)
+		}
+	}
+}
+
+func TestDrainTimeoutInterruptsJobs(t *testing.T) {
+	started := make(chan struct{}, 1)
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	ran := make(chan error, 1)
+	go func() {
+		ran <- w.Run(context.Background(), 1)
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got Drain error %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-ran:
+	case <-time.After(testTimeout):
+		t.Fatal("Run did not return after Drain timed out")
+	}
+	if j, _ := c.GetJob(context.Background(), "a"); j.State() != queue.Queued {
+		t.Errorf("got state %q for the interrupted job, want %q", j.State(), queue.Queued)
+	}
+}
+
+// sorted returns a sorted copy of ids
+func sorted(ids []string) []string {
+	ids = append([]string(nil), ids...)
+	sort.Strings(ids)
+	return ids
+}
+
+// progresser returns a processor that reports 3 out of 10 units of work
+// done, signals on reported, and then waits for an error to return on result.
+func progresser() (p queue.Processor, reported chan struct{}, result chan error) {
+	reported, result = make(chan struct{}, 10), make(chan error)
+	p = processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		if err := j.SetProgress(ctx, 3, 10); err != nil {
+			return err
+		}
+		reported <- struct{}{}
+		select {
+		case err := <-result:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	return p, reported, result
+}
+
+// checkProgress checks the progress of the job with the given ID
+func checkProgress(t *testing.T, c queue.Client, id string, wantState queue.State, wantCompleted, wantTotal uint64) {
+	t.Helper()
+	j, err := c.GetJob(context.Background(), id)
+	if err != nil || j == nil {
+		t.Fatalf("got job %v and error %v, want the job", j, err)
+	}
+	if completed, total := j.Progress(); j.State() != wantState || completed != wantCompleted || total != wantTotal {
+		t.Errorf("got job %s with %d/%d done, want it %s with %d/%d done", j.State(), completed, total, wantState, wantCompleted, wantTotal)
+	}
+}
+
+func TestProgressWhileProcessing(t *testing.T) {
+	p, reported, result := progresser()
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	defer runWorker(t, w, 1)()
+	<-reported
+	checkProgress(t, c, "a", queue.Processing, 3, 10)
+	result <- nil
+	waitForJob(t, c, "a")
+	checkProgress(t, c, "a", queue.Finished, 10, 10)
+}
+
+func TestProgressClearedOnRetry(t *testing.T) {
+	p, reported, result := progresser()
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{
+		MaxAttempts: 2,
+		BackoffFor:  func(int) time.Duration { return time.Hour },
+	}))
+	createJobs(t, c, map[string]int{"a": 0})
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		w.(queue.SyncWorker).ProcessAll(context.Background())
+	}()
+	<-reported
+	result <- errors.New("failed")
+	<-processed
+	checkProgress(t, c, "a", queue.Queued, 0, 0)
+}
+
+func TestProgressClearedOnFailure(t *testing.T) {
+	p, reported, result := progresser()
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	defer runWorker(t, w, 1)()
+	<-reported
+	result <- errors.New("failed")
+	waitForJob(t, c, "a")
+	checkProgress(t, c, "a", queue.Failed, 0, 0)
+}
+
+func TestProgressClearedOnInterruption(t *testing.T) {
+	p, reported, _ := progresser()
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	stop := runWorker(t, w, 1)
+	<-reported
+	stop()
+	checkProgress(t, c, "a", queue.Queued, 0, 0)
+}
+
+// checkProgressReport checks the progress report of the job with the given ID
+func checkProgressReport(t *testing.T, c queue.Client, id string, want queue.ProgressReport) {
+	t.Helper()
+	j, err := c.GetJob(context.Background(), id)
+	if err != nil || j == nil {
+		t.Fatalf("got job %v and error %v, want the job", j, err)
+	}
+	if got := j.ProgressReport(); got != want {
+		t.Errorf("got progress %+v of job %s, want %+v", got, j.State(), want)
+	}
+}
+
+func TestDeterminateProgressReport(t *testing.T) {
+	p, reported, result := progresser()
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	checkProgressReport(t, c, "a", queue.ProgressReport{})
+	defer runWorker(t, w, 1)()
+	<-reported
+	checkProgressReport(t, c, "a", queue.ProgressReport{Reported: true, Determinate: true, Completed: 3, Total: 10, Fraction: 0.3})
+	result <- nil
+	waitForJob(t, c, "a")
+	checkProgressReport(t, c, "a", queue.ProgressReport{Reported: true, Determinate: true, Completed: 10, Total: 10, Fraction: 1})
+}
+
+func TestIndeterminateProgressReport(t *testing.T) {
+	var seen queue.ProgressReport
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		if err := j.SetProgress(ctx, 5, 0); err != nil {
+			return err
+		}
+		seen = j.ProgressReport()
+		return nil
+	})
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0})
+	processAll(t, w)
+	if want := (queue.ProgressReport{Reported: true, Completed: 5}); seen != want {
+		t.Errorf("got progress %+v while busy, want %+v", seen, want)
+	}
+	checkProgressReport(t, c, "a", queue.ProgressReport{Reported: true})
+}
+
+func TestUnreportedProgressReport(t *testing.T) {
+	var seen []queue.ProgressReport
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		seen = append(seen, j.ProgressReport())
+		if j.ID() == "explicit" {
+			if err := j.SetProgress(ctx, 0, 0); err != nil {
+				return err
+			}
+			seen = append(seen, j.ProgressReport())
+		}
+		return nil
+	})
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"never": 0})
+	processAll(t, w)
+	createJobs(t, c, map[string]int{"explicit": 0})
+	processAll(t, w)
+	want := []queue.ProgressReport{{}, {}, {Reported: true}}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("got progress %+v while busy, want %+v", seen, want)
+	}
+	checkProgressReport(t, c, "never", queue.ProgressReport{})
+	checkProgressReport(t, c, "explicit", queue.ProgressReport{Reported: true})
+}
+
+func TestProgressReportClearedOnRetry(t *testing.T) {
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		if j.ProgressReport().Reported {
+			return errors.New("got the progress of the previous attempt")
+		}
+		if err := j.SetProgress(ctx, 1, 0); err != nil {
+			return err
+		}
+		return errors.New("failing once reported")
+	})
+	c, w := queue.New(p, queue.WithRetryPolicy(queue.RetryPolicy{MaxAttempts: 2}))
+	createJobs(t, c, map[string]int{"a": 0})
+	processAll(t, w)
+	checkProgressReport(t, c, "a", queue.ProgressReport{})
+	processAll(t, w)
+	if j := waitForJob(t, c, "a"); j.Error() != "failing once reported" {
+		t.Errorf("got job %s with error %q, want it failed once reported again", j.State(), j.Error())
+	}
+}
+
+func TestPanickingProcessor(t *testing.T) {
+	p := processorFunc(func(ctx context.Context, j queue.JobProcessingAccess) error {
+		if j.ID() == "b" {
+			var m map[string]int
+			m["x"] = 1
+		}
+		return nil
+	})
+	c, w := queue.New(p)
+	createJobs(t, c, map[string]int{"a": 0, "b": 0, "c": 0, "d": 0})
+	defer runWorker(t, w, 1)() // A single worker goroutine, which must survive the panic
+	for _, id := range []string{"a", "c", "d"} {
+		if j := waitForJob(t, c, id); j.State() != queue.Finished {
+			t.Errorf("job %q: got state %q, want %q", id, j.State(), queue.Finished)
+		}
+	}
+	j := waitForJob(t, c, "b")
+	if j.State() != queue.Failed {
+		t.Errorf("got state %q for the job panicking, want %q", j.State(), queue.Failed)
+	}
+	for _, want := range []string{"panicked", "nil map", "goroutine"} {
+		if !strings.Contains(j.Error(), want) {
+			t.Errorf("got error %q, want it to contain %q", j.Error(), want)
+		}
+	}
+}